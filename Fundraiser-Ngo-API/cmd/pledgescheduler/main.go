@@ -0,0 +1,48 @@
+package main
+
+import (
+	"aws-lambda-api/pkg/pledge"
+	"context"
+	"os"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+var (
+	dynaClient dynamodbiface.DynamoDBAPI
+)
+
+func main() {
+	region := os.Getenv("AWS_REGION")
+	awsSession, err := session.NewSession(&aws.Config{
+		Region: aws.String(region)},
+	)
+	if err != nil {
+		return
+	}
+	dynaClient = dynamodb.New(awsSession)
+	lambda.Start(handler)
+}
+
+const tableName = "NGOdetails"
+
+type result struct {
+	Charged int `json:"charged"`
+}
+
+// handler is triggered by a scheduled CloudWatch event and raises a
+// pending charge for every pledge that is due at the time of the event
+func handler(ctx context.Context, event events.CloudWatchEvent) (*result, error) {
+	now := event.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+	charged, err := pledge.ChargeDuePledges(now, tableName, dynaClient)
+	return &result{Charged: charged}, err
+}
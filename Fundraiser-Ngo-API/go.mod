@@ -3,7 +3,8 @@ module aws-lambda-api
 go 1.16
 
 require (
-	github.com/aws/aws-lambda-go v1.23.0 // indirect
-	github.com/aws/aws-sdk-go v1.38.42 // indirect
+	github.com/aws/aws-lambda-go v1.23.0
+	github.com/aws/aws-sdk-go v1.38.42
 	github.com/go-sql-driver/mysql v1.5.0 // indirect
+	golang.org/x/crypto v0.0.0-20210513164829-c07d793c2f9a
 )
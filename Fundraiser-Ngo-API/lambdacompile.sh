@@ -2,4 +2,6 @@
 
 pwd
 GOOS=linux go build main.go
-zip function.zip main  
+zip function.zip main prices.json
+GOOS=linux go build -o pledgescheduler ./cmd/pledgescheduler
+zip pledgescheduler.zip pledgescheduler prices.json
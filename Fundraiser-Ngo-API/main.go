@@ -1,8 +1,21 @@
 package main
 
 import (
+	"aws-lambda-api/pkg/audit"
+	"aws-lambda-api/pkg/auth"
+	"aws-lambda-api/pkg/chain"
+	"aws-lambda-api/pkg/fundraiser"
 	"aws-lambda-api/pkg/handlers"
+	"aws-lambda-api/pkg/matching"
+	"aws-lambda-api/pkg/money"
+	"aws-lambda-api/pkg/ngo"
+	"aws-lambda-api/pkg/page"
+	"aws-lambda-api/pkg/pii"
+	"aws-lambda-api/pkg/ratelimit"
+	"aws-lambda-api/pkg/update"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
@@ -14,6 +27,15 @@ import (
 
 var (
 	dynaClient dynamodbiface.DynamoDBAPI
+	verifier   chain.Verifier
+	oracle     money.PriceOracle
+	siweDomain string
+	limits     *ratelimit.Limits
+
+	ngos        ngo.NgoRepository
+	fundraisers fundraiser.FundraiserRepository
+	updates     update.UpdateRepository
+	pools       matching.PoolRepository
 )
 
 func main() {
@@ -25,68 +47,290 @@ func main() {
 		return
 	}
 	dynaClient = dynamodb.New(awsSession)
+	ngos = ngo.NewDynamoNgoRepository(tableName, dynaClient)
+	fundraisers = fundraiser.NewDynamoFundraiserRepository(tableName, dynaClient)
+	updates = update.NewDynamoUpdateRepository(tableName, dynaClient)
+	pools = matching.NewDynamoPoolRepository(tableName, dynaClient)
+	verifier = newVerifier()
+
+	//PRICE_FILE defaults to the prices.json shipped next to the binary
+	priceFile := os.Getenv("PRICE_FILE")
+	if priceFile == "" {
+		priceFile = "prices.json"
+	}
+	oracle, err = money.LoadStaticOracle(priceFile)
+	if err != nil {
+		return
+	}
+
+	//Wallet sign-in is for SIWE_DOMAIN, and its sessions are signed with SESSION_SECRET
+	siweDomain = os.Getenv("SIWE_DOMAIN")
+	auth.SetSessionSecret([]byte(os.Getenv("SESSION_SECRET")))
+
+	//List cursors are signed with CURSOR_SECRET, so any instance can continue a list
+	if err := page.SetCursorSecret([]byte(os.Getenv("CURSOR_SECRET"))); err != nil {
+		return
+	}
+
+	//Emails in audit keys are pseudonymized with AUDIT_SECRET
+	audit.SetPseudonymSecret([]byte(os.Getenv("AUDIT_SECRET")))
+
+	//Personal data is encrypted with the master keys in PII_KEY_FILE
+	if keyFile := os.Getenv("PII_KEY_FILE"); keyFile != "" {
+		keys, err := pii.LoadLocalKeyFile(keyFile)
+		if err != nil {
+			return
+		}
+		pii.SetKeyProvider(keys)
+	}
+
+	//RATE_LIMITS overrides the limit of routes, formatted as "getNgos=60/60,createDonation=10/60"
+	limits = ratelimit.DefaultLimits()
+	if err := limits.Parse(os.Getenv("RATE_LIMITS")); err != nil {
+		return
+	}
 	lambda.Start(handler)
 }
 
+// newVerifier reads the EVM nodes from CHAIN_RPC_URLS, formatted as "ethereum=https://...,polygon=https://..."
+func newVerifier() chain.Verifier {
+	minConfirmations, err := strconv.ParseUint(os.Getenv("CHAIN_MIN_CONFIRMATIONS"), 10, 64)
+	if err != nil {
+		minConfirmations = 12
+	}
+	v := chain.NewEVMVerifier(minConfirmations)
+	for _, entry := range strings.Split(os.Getenv("CHAIN_RPC_URLS"), ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		asset, ok := chain.NativeAssets[parts[0]]
+		if !ok {
+			continue
+		}
+		v.AddChain(parts[0], asset, chain.NewRPCClient(parts[1]))
+	}
+	return v
+}
+
 const tableName = "NGOdetails"
 
 func handler(req events.APIGatewayProxyRequest) (*events.APIGatewayProxyResponse, error) {
+	//Partner requests carry an API key, which must hold the scope of the route.
+	//Source IPs that keep sending invalid keys are throttled before the lookup.
+	if resp := handlers.AuthorizeApiKey(req, req.PathParameters["method"], limits, tableName, dynaClient); resp != nil {
+		return resp, nil
+	}
+	//Each client, by API key, user or source IP, has a budget of calls per route
+	if resp := handlers.RateLimit(req, req.PathParameters["method"], limits, tableName, dynaClient); resp != nil {
+		return resp, nil
+	}
+	//Retried POSTs with the same Idempotency-Key get the first response again
+	return handlers.Idempotent(req, req.PathParameters["method"], tableName, dynaClient, route)
+}
+
+func route(req events.APIGatewayProxyRequest) (*events.APIGatewayProxyResponse, error) {
 	switch req.HTTPMethod + "|" + req.PathParameters["method"] {
+	//Handling request of Sign-In with Ethereum
+	//PartitionKey = Nonce
+	//SortKey = constant string of Nonce
+	case "GET" + "|" + "getSiweNonce":
+		return handlers.GetSiweNonce(req, tableName, dynaClient)
+	case "POST" + "|" + "verifySiwe":
+		return handlers.VerifySiwe(req, tableName, dynaClient, siweDomain)
+
+	//Handling request of API keys
+	//PartitionKey = constant string of ApiKey
+	//SortKey = KeyId
+	case "GET" + "|" + "getApiKeys":
+		return handlers.GetApiKeys(req, tableName, dynaClient)
+	case "POST" + "|" + "createApiKey":
+		return handlers.CreateApiKey(req, tableName, dynaClient)
+	case "POST" + "|" + "rotateApiKey":
+		return handlers.RotateApiKey(req, tableName, dynaClient)
+	case "POST" + "|" + "revokeApiKey":
+		return handlers.RevokeApiKey(req, tableName, dynaClient)
+
 	//Handling request of NGO's
 	//PartitionKey = constant string of DetailsNGO
 	//SortKey = NgoId
 	case "GET" + "|" + "getNgo":
-		return handlers.GetNgo(req, tableName, dynaClient)
+		return handlers.GetNgo(req, ngos)
 	case "POST" + "|" + "createNgo":
-		return handlers.CreateNgo(req, tableName, dynaClient)
+		return handlers.CreateNgo(req, ngos)
 	case "PUT" + "|" + "updateNgo":
-		return handlers.UpdateNgo(req, tableName, dynaClient)
+		return handlers.UpdateNgo(req, ngos)
+	case "PATCH" + "|" + "updateNgo":
+		return handlers.PatchNgo(req, ngos)
 	case "DELETE" + "|" + "deleteNgo":
-		return handlers.DeleteNgo(req, tableName, dynaClient)
+		return handlers.DeleteNgo(req, ngos)
 	case "GET" + "|" + "getNgos":
-		return handlers.GetNgos(req, tableName, dynaClient)
+		return handlers.GetNgos(req, ngos)
+	case "POST" + "|" + "addNgoWallet":
+		return handlers.AddNgoWallet(req, ngos)
+	case "DELETE" + "|" + "removeNgoWallet":
+		return handlers.RemoveNgoWallet(req, ngos)
+
+	//Handling request of Member -> NGO(s)
+	//PartitionKey = NgoId
+	//SortKey = User
+	case "GET" + "|" + "getNgoMembers":
+		return handlers.GetNgoMembers(req, ngos)
+	case "POST" + "|" + "addNgoMember":
+		return handlers.AddNgoMember(req, ngos)
+	case "PUT" + "|" + "updateNgoMember":
+		return handlers.UpdateNgoMember(req, ngos)
+	case "DELETE" + "|" + "removeNgoMember":
+		return handlers.RemoveNgoMember(req, ngos)
+
+	//Handling request of Verification -> NGO(s)
+	//PartitionKey = NgoId
+	//SortKey = constant string of Verification
+	case "GET" + "|" + "getNgoVerification":
+		return handlers.GetNgoVerification(req, ngos)
+	case "POST" + "|" + "submitNgoVerification":
+		return handlers.SubmitNgoVerification(req, ngos)
+	case "POST" + "|" + "reviewNgoVerification":
+		return handlers.ReviewNgoVerification(req, ngos)
 
 	//Handling request of Fundraiser -> NGO(s)
 	//PartitionKey = NgoId
 	//SortKey = FundraiserId
 	case "GET" + "|" + "getFundraiserNgo":
-		return handlers.GetFundraiserNgo(req, tableName, dynaClient)
+		return handlers.GetFundraiserNgo(req, fundraisers, pools)
 	case "POST" + "|" + "createFundraiserNgo":
-		return handlers.CreateFundraiserNgo(req, tableName, dynaClient)
+		return handlers.CreateFundraiserNgo(req, fundraisers, ngos)
 	case "PUT" + "|" + "updateFundraiserNgo":
-		return handlers.UpdateFundraiserNgo(req, tableName, dynaClient)
+		return handlers.UpdateFundraiserNgo(req, fundraisers, ngos)
+	case "PATCH" + "|" + "updateFundraiserNgo":
+		return handlers.PatchFundraiserNgo(req, fundraisers, ngos)
 	case "DELETE" + "|" + "deleteFundraiserNgo":
-		return handlers.DeleteFundraiserNgo(req, tableName, dynaClient)
+		return handlers.DeleteFundraiserNgo(req, fundraisers, ngos)
 	case "GET" + "|" + "getFundraisersNgo":
-		return handlers.GetFundraisersNgo(req, tableName, dynaClient)
+		return handlers.GetFundraisersNgo(req, fundraisers)
 
 	//Handling request of Fundraiser -> Individual(s)
 	//PartitionKey = IndividualEmailId
 	//SortKey = FundraiserId
 	case "GET" + "|" + "getFundraiserIndividual":
-		return handlers.GetFundraiserIndividual(req, tableName, dynaClient)
+		return handlers.GetFundraiserIndividual(req, fundraisers)
 	case "POST" + "|" + "createFundraiserIndividual":
-		return handlers.CreateFundraiserIndividual(req, tableName, dynaClient)
+		return handlers.CreateFundraiserIndividual(req, fundraisers)
 	case "PUT" + "|" + "updateFundraiserIndividual":
-		return handlers.UpdateFundraiserIndividual(req, tableName, dynaClient)
+		return handlers.UpdateFundraiserIndividual(req, fundraisers)
+	case "PATCH" + "|" + "updateFundraiserIndividual":
+		return handlers.PatchFundraiserIndividual(req, fundraisers)
 	case "DELETE" + "|" + "deleteFundraiserIndividual":
-		return handlers.DeleteFundraiserIndividual(req, tableName, dynaClient)
+		return handlers.DeleteFundraiserIndividual(req, fundraisers)
 	case "GET" + "|" + "getFundraisersIndividual":
-		return handlers.GetFundraisersIndividual(req, tableName, dynaClient)
+		return handlers.GetFundraisersIndividual(req, fundraisers)
+	case "POST" + "|" + "addFundraiserIndividualWallet":
+		return handlers.AddFundraiserIndividualWallet(req, fundraisers)
+	case "DELETE" + "|" + "removeFundraiserIndividualWallet":
+		return handlers.RemoveFundraiserIndividualWallet(req, fundraisers)
 
 	//Handling request of Update -> Fundraiser(s)
-	//PartitionKey = FundraiserId
+	//PartitionKey = NgoId or IndividualEmailId + FundraiserId
 	//SortKey = UpdateId
 	case "GET" + "|" + "getUpdate":
-		return handlers.GetUpdate(req, tableName, dynaClient)
+		return handlers.GetUpdate(req, updates)
 	case "POST" + "|" + "createUpdate":
-		return handlers.CreateUpdate(req, tableName, dynaClient)
+		return handlers.CreateUpdate(req, updates, fundraisers, ngos)
 	case "PUT" + "|" + "updateUpdate":
-		return handlers.UpdateUpdate(req, tableName, dynaClient)
+		return handlers.UpdateUpdate(req, updates, fundraisers, ngos)
+	case "PATCH" + "|" + "updateUpdate":
+		return handlers.PatchUpdate(req, updates, fundraisers, ngos)
 	case "DELETE" + "|" + "deleteUpdate":
-		return handlers.DeleteUpdate(req, tableName, dynaClient)
+		return handlers.DeleteUpdate(req, updates, fundraisers, ngos)
 	case "GET" + "|" + "getUpdates":
-		return handlers.GetUpdates(req, tableName, dynaClient)
+		return handlers.GetUpdates(req, updates)
+
+	//Handling request of Donation -> Fundraiser(s)
+	//PartitionKey = NgoId or IndividualEmailId + FundraiserId
+	//SortKey = DonationId
+	case "GET" + "|" + "getDonation":
+		return handlers.GetDonation(req, fundraisers, ngos, tableName, dynaClient)
+	case "POST" + "|" + "createDonation":
+		return handlers.CreateDonation(req, fundraisers, ngos, tableName, dynaClient, oracle)
+	case "GET" + "|" + "getDonations":
+		return handlers.GetDonations(req, fundraisers, ngos, tableName, dynaClient)
+	case "POST" + "|" + "verifyDonation":
+		return handlers.VerifyDonation(req, fundraisers, ngos, tableName, dynaClient, verifier, oracle)
+
+	//Handling request of Donor(s)
+	//PartitionKey = DonorEmail or first DonorWallet
+	//SortKey = constant string of Profile
+	//Donations are listed through the gsi1 donor index
+	case "GET" + "|" + "getDonor":
+		return handlers.GetDonor(req, tableName, dynaClient)
+	case "POST" + "|" + "createDonor":
+		return handlers.CreateDonor(req, tableName, dynaClient)
+	case "PUT" + "|" + "updateDonor":
+		return handlers.UpdateDonor(req, tableName, dynaClient)
+	case "GET" + "|" + "getDonorDonations":
+		return handlers.GetDonorDonations(req, tableName, dynaClient)
+
+	//Handling request of MatchingPool -> Fundraiser(s)
+	//PartitionKey = PoolId
+	//SortKey = constant string of Pool
+	case "GET" + "|" + "getMatchingPool":
+		return handlers.GetMatchingPool(req, tableName, dynaClient)
+	case "POST" + "|" + "createMatchingPool":
+		return handlers.CreateMatchingPool(req, fundraisers, ngos, tableName, dynaClient)
+	case "POST" + "|" + "attachMatchingPool":
+		return handlers.AttachMatchingPool(req, fundraisers, ngos, tableName, dynaClient)
+
+	//Handling request of Receipt -> NGO(s)
+	//PartitionKey = NgoId
+	//SortKey = ReceiptNumber
+	case "GET" + "|" + "getReceipt":
+		return handlers.GetReceipt(req, tableName, dynaClient)
+	case "POST" + "|" + "issueReceipt":
+		return handlers.IssueReceipt(req, ngos, tableName, dynaClient)
+	case "POST" + "|" + "reissueReceipt":
+		return handlers.ReissueReceipt(req, ngos, tableName, dynaClient)
+	case "POST" + "|" + "voidReceipt":
+		return handlers.VoidReceipt(req, ngos, tableName, dynaClient)
+
+	//Handling request of Payout -> Fundraiser(s)
+	//PartitionKey = NgoId or IndividualEmailId + FundraiserId
+	//SortKey = PayoutId
+	case "GET" + "|" + "getPayout":
+		return handlers.GetPayout(req, fundraisers, ngos, tableName, dynaClient)
+	case "GET" + "|" + "getPayouts":
+		return handlers.GetPayouts(req, fundraisers, ngos, tableName, dynaClient)
+	case "POST" + "|" + "requestPayout":
+		return handlers.RequestPayout(req, fundraisers, ngos, tableName, dynaClient)
+	case "POST" + "|" + "reviewPayout":
+		return handlers.ReviewPayout(req, tableName, dynaClient)
+
+	//Handling request of Pledge -> NGO(s)
+	//PartitionKey = NgoId
+	//SortKey = PledgeId
+	case "GET" + "|" + "getPledge":
+		return handlers.GetPledge(req, ngos, tableName, dynaClient)
+	case "GET" + "|" + "getPledges":
+		return handlers.GetPledges(req, ngos, tableName, dynaClient)
+	case "POST" + "|" + "createPledge":
+		return handlers.CreatePledge(req, fundraisers, ngos, tableName, dynaClient)
+	case "POST" + "|" + "pausePledge":
+		return handlers.PausePledge(req, ngos, tableName, dynaClient)
+	case "POST" + "|" + "resumePledge":
+		return handlers.ResumePledge(req, ngos, tableName, dynaClient)
+	case "POST" + "|" + "cancelPledge":
+		return handlers.CancelPledge(req, ngos, tableName, dynaClient)
+	//SortKey = Charge + PledgeId-DueDate
+	case "GET" + "|" + "getPledgeCharges":
+		return handlers.GetPledgeCharges(req, ngos, tableName, dynaClient)
+	case "POST" + "|" + "payPledgeCharge":
+		return handlers.PayPledgeCharge(req, fundraisers, ngos, tableName, dynaClient, verifier, oracle)
+
+	//Handling request of Audit log
+	//PartitionKey = Audit + EntityType + EntityId
+	//SortKey = Audit + Timestamp
+	//Entries are listed by actor through the gsi1 actor index
+	case "GET" + "|" + "getAuditLog":
+		return handlers.GetAuditLog(req, tableName, dynaClient)
 	default:
 		return handlers.UnhandledMethod()
 	}
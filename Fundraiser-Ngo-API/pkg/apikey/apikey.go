@@ -0,0 +1,541 @@
+package apikey
+
+import (
+	"aws-lambda-api/pkg/audit"
+	"aws-lambda-api/pkg/auth"
+	"aws-lambda-api/pkg/page"
+	"aws-lambda-api/pkg/storage"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+var (
+	ErrorFailedToUnmarshalRecord = "failed to unmarshal record"
+	ErrorFailedToFetchRecord     = "failed to fetch record"
+	ErrorInvalidUserData         = "invalid user data"
+	ErrorCouldNotMarshalItem     = "could not marshal item"
+	ErrorCouldNotDynamoPutItem   = "could not dynamo put item error"
+	ErrorApiKeyDoesNotExist      = "api key does not exist"
+	ErrorApiKeyChanged           = "api key was changed by another request, try again"
+	ErrorApiKeyNotActive         = "api key is revoked"
+	ErrorInvalidApiKey           = "invalid api key"
+	ErrorInvalidScope            = "invalid scope"
+	ErrorScopesRequired          = "an api key needs at least one scope"
+	ErrorScopeNotGranted         = "api key does not have the scope for this request"
+)
+
+const (
+	StatusActive  = "active"
+	StatusRevoked = "revoked"
+)
+
+// Header carries the API key of partner requests
+const Header = "X-Api-Key"
+
+// RotationGrace is how long the secret replaced by a rotation keeps working
+const RotationGrace = time.Hour
+
+const keyPrefix = "fk_"
+
+// routeResources maps each route a partner may call to the resource its
+// scope covers. GET routes need resource:read, the others resource:write.
+// Routes with an empty resource only need a valid key; routes missing
+// here cannot be called with an API key at all.
+var routeResources = map[string]string{
+	"getSiweNonce": "",
+	"verifySiwe":   "",
+
+	"getNgo":          "ngo",
+	"getNgos":         "ngo",
+	"createNgo":       "ngo",
+	"updateNgo":       "ngo",
+	"deleteNgo":       "ngo",
+	"addNgoWallet":    "ngo",
+	"removeNgoWallet": "ngo",
+	"getNgoMembers":   "ngo",
+	"addNgoMember":    "ngo",
+	"updateNgoMember": "ngo",
+	"removeNgoMember": "ngo",
+
+	"getNgoVerification":    "ngo",
+	"submitNgoVerification": "ngo",
+
+	"getFundraiserNgo":                 "fundraiser",
+	"getFundraisersNgo":                "fundraiser",
+	"createFundraiserNgo":              "fundraiser",
+	"updateFundraiserNgo":              "fundraiser",
+	"deleteFundraiserNgo":              "fundraiser",
+	"getFundraiserIndividual":          "fundraiser",
+	"getFundraisersIndividual":         "fundraiser",
+	"createFundraiserIndividual":       "fundraiser",
+	"updateFundraiserIndividual":       "fundraiser",
+	"deleteFundraiserIndividual":       "fundraiser",
+	"addFundraiserIndividualWallet":    "fundraiser",
+	"removeFundraiserIndividualWallet": "fundraiser",
+
+	"getUpdate":    "update",
+	"getUpdates":   "update",
+	"createUpdate": "update",
+	"updateUpdate": "update",
+	"deleteUpdate": "update",
+
+	"getDonation":    "donation",
+	"getDonations":   "donation",
+	"createDonation": "donation",
+	"verifyDonation": "donation",
+
+	"getDonor":          "donor",
+	"getDonorDonations": "donor",
+	"createDonor":       "donor",
+	"updateDonor":       "donor",
+
+	"getMatchingPool":    "matching",
+	"createMatchingPool": "matching",
+	"attachMatchingPool": "matching",
+
+	"getReceipt":     "receipt",
+	"issueReceipt":   "receipt",
+	"reissueReceipt": "receipt",
+	"voidReceipt":    "receipt",
+
+	"getPayout":     "payout",
+	"getPayouts":    "payout",
+	"requestPayout": "payout",
+	"reviewPayout":  "payout",
+
+	"getPledge":    "pledge",
+	"getPledges":   "pledge",
+	"createPledge": "pledge",
+	"pausePledge":  "pledge",
+	"resumePledge": "pledge",
+	"cancelPledge": "pledge",
+}
+
+// ApiKey is a partner's key. Only a hash of the secret is stored; the key
+// itself is returned once, when it is issued or rotated.
+type ApiKey struct {
+	ApiKeys            string   `json:"pk"`
+	ApiKeyId           string   `json:"sk"`
+	KeyId              string   `json:"keyId"`
+	Name               string   `json:"name"`
+	Partner            string   `json:"partner"`
+	Scopes             []string `json:"scopes"`
+	SecretHash         string   `json:"secretHash,omitempty"`
+	PreviousSecretHash string   `json:"previousSecretHash,omitempty"`
+	PreviousExpiresAt  string   `json:"previousExpiresAt,omitempty"`
+	Status             string   `json:"status"`
+	CreatedBy          string   `json:"createdBy"`
+	CreatedAt          string   `json:"createdAt"`
+	RotatedAt          string   `json:"rotatedAt,omitempty"`
+	RevokedAt          string   `json:"revokedAt,omitempty"`
+}
+
+// IssuedKey is an API key together with its plaintext secret
+type IssuedKey struct {
+	*ApiKey
+	Key string `json:"key"`
+}
+
+func ApiKeyKey(keyId string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"pk": {
+			S: aws.String("ApiKey"),
+		},
+		"sk": {
+			S: aws.String("Key" + keyId),
+		},
+	}
+}
+
+// Scope is the scope a call of route with method needs, and whether the
+// route may be called with an API key
+func Scope(method string, route string) (string, bool) {
+	resource, ok := routeResources[route]
+	if !ok || resource == "" {
+		return "", ok
+	}
+	if method == "GET" {
+		return resource + ":read", true
+	}
+	return resource + ":write", true
+}
+
+// ValidScope reports whether scope is resource:read or resource:write for
+// a resource partners can be given
+func ValidScope(scope string) bool {
+	parts := strings.SplitN(scope, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || (parts[1] != "read" && parts[1] != "write") {
+		return false
+	}
+	for _, resource := range routeResources {
+		if resource == parts[0] {
+			return true
+		}
+	}
+	return false
+}
+
+func FetchApiKey(keyId string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*ApiKey, error) {
+	//Macking Call for DynamoDB
+	input := &dynamodb.GetItemInput{
+		Key:       ApiKeyKey(keyId),
+		TableName: aws.String(tableName),
+	}
+	result, err := dynaClient.GetItem(input)
+	if err != nil {
+		return nil, errors.New(ErrorFailedToFetchRecord)
+	}
+
+	//Sending the Get Request
+	item := new(ApiKey)
+	err = dynamodbattribute.UnmarshalMap(result.Item, item)
+	if err != nil {
+		return nil, errors.New(ErrorFailedToUnmarshalRecord)
+	}
+	return item, nil
+}
+
+// FetchApiKeys lists a page of API keys, without their hashes, for an
+// admin
+func FetchApiKeys(req events.APIGatewayProxyRequest, p page.Request, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*[]ApiKey, *storage.Key, error) {
+	if _, err := auth.RequireAdmin(req); err != nil {
+		return nil, nil, err
+	}
+	//Macking Call for DynamoDB
+	input := &dynamodb.QueryInput{
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {
+				S: aws.String("ApiKey"),
+			},
+		},
+		KeyConditionExpression: aws.String("pk = :pk"),
+		TableName:              aws.String(tableName),
+	}
+	items := []ApiKey{}
+	next, err := page.Query(input, "ApiKey", p, &items, dynaClient)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range items {
+		items[i] = *items[i].withoutSecrets()
+	}
+	return &items, next, nil
+}
+
+// CreateApiKey issues a key for a partner. Only admins issue keys.
+func CreateApiKey(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*IssuedKey,
+	error,
+) {
+	caller, err := auth.RequireAdmin(req)
+	if err != nil {
+		return nil, err
+	}
+	//Checking if the correct request
+	var u ApiKey
+	if err := json.Unmarshal([]byte(req.Body), &u); err != nil {
+		return nil, errors.New(ErrorInvalidUserData)
+	}
+	if strings.TrimSpace(u.Name) == "" || strings.TrimSpace(u.Partner) == "" {
+		return nil, errors.New(ErrorInvalidUserData)
+	}
+	if err := u.normalizeScopes(); err != nil {
+		return nil, err
+	}
+
+	keyId, key, hash, err := newKey("")
+	if err != nil {
+		return nil, err
+	}
+	//Modifying the key for DynamoDB Storage
+	dbKey := ApiKeyKey(keyId)
+	u.ApiKeys = aws.StringValue(dbKey["pk"].S)
+	u.ApiKeyId = aws.StringValue(dbKey["sk"].S)
+	u.KeyId = keyId
+	u.SecretHash = hash
+	u.PreviousSecretHash = ""
+	u.PreviousExpiresAt = ""
+	u.Status = StatusActive
+	u.CreatedBy = caller.Name()
+	u.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	u.RotatedAt = ""
+	u.RevokedAt = ""
+
+	//Marshaling the data
+	av, err := dynamodbattribute.MarshalMap(u)
+	if err != nil {
+		return nil, errors.New(ErrorCouldNotMarshalItem)
+	}
+	write := &dynamodb.TransactWriteItem{
+		Put: &dynamodb.Put{
+			Item:                av,
+			TableName:           aws.String(tableName),
+			ConditionExpression: aws.String("attribute_not_exists(sk)"),
+		},
+	}
+	entry := audit.NewEntry("ApiKey", keyId, "create", caller.Name(), nil, u.withoutSecrets())
+	if err := writeApiKey(write, entry, ErrorApiKeyChanged, tableName, dynaClient); err != nil {
+		return nil, err
+	}
+	return &IssuedKey{ApiKey: u.withoutSecrets(), Key: key}, nil
+}
+
+// RotateApiKey replaces the secret of a key. The old secret keeps working
+// for RotationGrace so partners can roll out the new one.
+func RotateApiKey(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*IssuedKey,
+	error,
+) {
+	caller, current, err := changeableKey(req, tableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	_, key, hash, err := newKey(current.KeyId)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	updated := *current
+	updated.PreviousSecretHash = current.SecretHash
+	updated.PreviousExpiresAt = now.Add(RotationGrace).Format(time.RFC3339)
+	updated.SecretHash = hash
+	updated.RotatedAt = now.Format(time.RFC3339)
+
+	write := &dynamodb.TransactWriteItem{
+		Update: &dynamodb.Update{
+			Key:                 ApiKeyKey(current.KeyId),
+			TableName:           aws.String(tableName),
+			ConditionExpression: aws.String("#status = :active AND secretHash = :from"),
+			UpdateExpression:    aws.String("SET secretHash = :to, previousSecretHash = :from, previousExpiresAt = :grace, rotatedAt = :at"),
+			ExpressionAttributeNames: map[string]*string{
+				"#status": aws.String("status"),
+			},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":active": {S: aws.String(StatusActive)},
+				":from":   {S: aws.String(current.SecretHash)},
+				":to":     {S: aws.String(updated.SecretHash)},
+				":grace":  {S: aws.String(updated.PreviousExpiresAt)},
+				":at":     {S: aws.String(updated.RotatedAt)},
+			},
+		},
+	}
+	entry := audit.NewEntry("ApiKey", current.KeyId, "rotate", caller.Name(), current.withoutSecrets(), updated.withoutSecrets())
+	if err := writeApiKey(write, entry, ErrorApiKeyChanged, tableName, dynaClient); err != nil {
+		return nil, err
+	}
+	return &IssuedKey{ApiKey: updated.withoutSecrets(), Key: key}, nil
+}
+
+// RevokeApiKey stops a key, including a secret still in its rotation grace
+func RevokeApiKey(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*ApiKey,
+	error,
+) {
+	caller, current, err := changeableKey(req, tableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	updated := *current
+	updated.Status = StatusRevoked
+	updated.RevokedAt = time.Now().UTC().Format(time.RFC3339)
+	updated.PreviousSecretHash = ""
+	updated.PreviousExpiresAt = ""
+
+	write := &dynamodb.TransactWriteItem{
+		Update: &dynamodb.Update{
+			Key:                 ApiKeyKey(current.KeyId),
+			TableName:           aws.String(tableName),
+			ConditionExpression: aws.String("#status = :active"),
+			UpdateExpression:    aws.String("SET #status = :revoked, revokedAt = :at REMOVE previousSecretHash, previousExpiresAt"),
+			ExpressionAttributeNames: map[string]*string{
+				"#status": aws.String("status"),
+			},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":active":  {S: aws.String(StatusActive)},
+				":revoked": {S: aws.String(StatusRevoked)},
+				":at":      {S: aws.String(updated.RevokedAt)},
+			},
+		},
+	}
+	entry := audit.NewEntry("ApiKey", current.KeyId, "revoke", caller.Name(), current.withoutSecrets(), updated.withoutSecrets())
+	if err := writeApiKey(write, entry, ErrorApiKeyChanged, tableName, dynaClient); err != nil {
+		return nil, err
+	}
+	return updated.withoutSecrets(), nil
+}
+
+// Authorize checks the API key of req, if it has one, against the scope
+// of route. Requests without a key are left to the usual caller checks.
+func Authorize(req events.APIGatewayProxyRequest, route string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*ApiKey, error) {
+	key := headerKey(req)
+	if key == "" {
+		return nil, nil
+	}
+	parts := strings.Split(strings.TrimPrefix(key, keyPrefix), "_")
+	if !strings.HasPrefix(key, keyPrefix) || len(parts) != 2 {
+		return nil, errors.New(ErrorInvalidApiKey)
+	}
+	current, err := FetchApiKey(parts[0], tableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	if len(current.KeyId) == 0 || current.Status != StatusActive || !current.matches(key) {
+		return nil, errors.New(ErrorInvalidApiKey)
+	}
+
+	scope, ok := Scope(req.HTTPMethod, route)
+	if !ok || (scope != "" && !current.hasScope(scope)) {
+		return nil, errors.New(ErrorScopeNotGranted)
+	}
+	return current.withoutSecrets(), nil
+}
+
+// KeyId is the id of the API key req was sent with, if it has one. The key
+// itself is not checked.
+func KeyId(req events.APIGatewayProxyRequest) string {
+	key := headerKey(req)
+	parts := strings.Split(strings.TrimPrefix(key, keyPrefix), "_")
+	if !strings.HasPrefix(key, keyPrefix) || len(parts) != 2 {
+		return ""
+	}
+	return parts[0]
+}
+
+func headerKey(req events.APIGatewayProxyRequest) string {
+	if key := req.Headers[Header]; key != "" {
+		return key
+	}
+	return req.Headers[strings.ToLower(Header)]
+}
+
+// changeableKey loads the active key named in the body of an admin's
+// request
+func changeableKey(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*auth.Identity, *ApiKey, error) {
+	caller, err := auth.RequireAdmin(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	//Checking if the correct request
+	var u ApiKey
+	if err := json.Unmarshal([]byte(req.Body), &u); err != nil {
+		return nil, nil, errors.New(ErrorInvalidUserData)
+	}
+	current, err := FetchApiKey(u.KeyId, tableName, dynaClient)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(current.KeyId) == 0 {
+		return nil, nil, errors.New(ErrorApiKeyDoesNotExist)
+	}
+	if current.Status != StatusActive {
+		return nil, nil, errors.New(ErrorApiKeyNotActive)
+	}
+	return caller, current, nil
+}
+
+// matches compares key with the current secret, or with the previous one
+// while it is in its rotation grace
+func (u *ApiKey) matches(key string) bool {
+	hash := hashKey(key)
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(u.SecretHash)) == 1 {
+		return true
+	}
+	if u.PreviousSecretHash == "" || subtle.ConstantTimeCompare([]byte(hash), []byte(u.PreviousSecretHash)) != 1 {
+		return false
+	}
+	grace, err := time.Parse(time.RFC3339, u.PreviousExpiresAt)
+	return err == nil && time.Now().Before(grace)
+}
+
+func (u *ApiKey) hasScope(scope string) bool {
+	for _, s := range u.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *ApiKey) normalizeScopes() error {
+	seen := map[string]bool{}
+	var scopes []string
+	for _, s := range u.Scopes {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if !ValidScope(s) {
+			return errors.New(ErrorInvalidScope)
+		}
+		if !seen[s] {
+			seen[s] = true
+			scopes = append(scopes, s)
+		}
+	}
+	if len(scopes) == 0 {
+		return errors.New(ErrorScopesRequired)
+	}
+	sort.Strings(scopes)
+	u.Scopes = scopes
+	return nil
+}
+
+func (u *ApiKey) withoutSecrets() *ApiKey {
+	out := *u
+	out.SecretHash = ""
+	out.PreviousSecretHash = ""
+	return &out
+}
+
+// newKey makes a key for keyId, or for a new id when keyId is empty, and
+// returns the id, the key and the hash to store
+func newKey(keyId string) (string, string, string, error) {
+	if keyId == "" {
+		id := make([]byte, 8)
+		if _, err := rand.Read(id); err != nil {
+			return "", "", "", err
+		}
+		keyId = hex.EncodeToString(id)
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", "", err
+	}
+	key := keyPrefix + keyId + "_" + hex.EncodeToString(secret)
+	return keyId, key, hashKey(key), nil
+}
+
+// hashKey hashes a key for storage. Keys are random, so a plain hash is
+// enough to keep them from being read back out of the table.
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func writeApiKey(write *dynamodb.TransactWriteItem, entry *audit.Entry, conflict string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	auditItem, err := audit.TransactItem(entry, tableName)
+	if err != nil {
+		return err
+	}
+	input := &dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{write, auditItem},
+	}
+	_, err = dynaClient.TransactWriteItems(input)
+	if err != nil {
+		if _, ok := err.(*dynamodb.TransactionCanceledException); ok {
+			return errors.New(conflict)
+		}
+		return errors.New(ErrorCouldNotDynamoPutItem)
+	}
+	return nil
+}
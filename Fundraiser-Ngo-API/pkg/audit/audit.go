@@ -0,0 +1,418 @@
+package audit
+
+import (
+	"aws-lambda-api/pkg/auth"
+	"aws-lambda-api/pkg/page"
+	"aws-lambda-api/pkg/pii"
+	"aws-lambda-api/pkg/storage"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+var (
+	ErrorCouldNotMarshalItem     = "could not marshal item"
+	ErrorFailedToUnmarshalRecord = "failed to unmarshal record"
+	ErrorFailedToFetchRecord     = "failed to fetch record"
+	ErrorAuditFilterRequired     = "filter by entityType and entityId, or by actor"
+	ErrorFailedToRevealRecord    = "failed to reveal record"
+)
+
+// ActorIndex is the sparse index listing every entry of an actor
+const ActorIndex = "gsi1"
+
+// Entry is an append-only record of a change to an entity. Entries live
+// under the audited entity's own partition, newest last, and are indexed
+// by actor in ActorIndex. Personal data is never kept in the clear: ids
+// and snapshots are masked, emails in keys are replaced by a pseudonym,
+// and the unmasked snapshots are sealed in Sealed.
+type Entry struct {
+	EntityKey  string            `json:"pk"`
+	AuditId    string            `json:"sk"`
+	EntityType string            `json:"entityType"`
+	EntityId   string            `json:"entityId"`
+	Action     string            `json:"action"`
+	Actor      string            `json:"actor"`
+	Timestamp  string            `json:"timestamp"`
+	Before     interface{}       `json:"before,omitempty"`
+	After      interface{}       `json:"after,omitempty"`
+	Changes    map[string]Change `json:"changes,omitempty"`
+	Sealed     *pii.Envelope     `json:"sealed,omitempty"`
+	ActorKey   string            `json:"gsi1pk"`
+	ActorSort  string            `json:"gsi1sk"`
+}
+
+// snapshots is what an entry seals
+type snapshots struct {
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// Change is the value of one attribute before and after a change
+type Change struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+func NewEntry(entityType string, entityId string, action string, actor string, before interface{}, after interface{}) *Entry {
+	now := time.Now().UTC()
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	id := now.Format(time.RFC3339Nano) + "-" + hex.EncodeToString(suffix)
+	e := &Entry{
+		EntityKey:  EntityKey(entityType, entityId),
+		AuditId:    "Audit" + id,
+		EntityType: entityType,
+		EntityId:   maskEmails(entityId),
+		Action:     action,
+		Actor:      maskEmails(actor),
+		Timestamp:  now.Format(time.RFC3339Nano),
+		Before:     redact("", snapshot(before)),
+		After:      redact("", snapshot(after)),
+		Changes:    diff(before, after),
+		ActorKey:   ActorKey(actor),
+		ActorSort:  id,
+	}
+	for name, c := range e.Changes {
+		e.Changes[name] = Change{Before: redact(name, c.Before), After: redact(name, c.After)}
+	}
+
+	//Without a key provider the unmasked snapshots are not kept at all
+	if plaintext, err := json.Marshal(snapshots{Before: before, After: after}); err == nil {
+		e.Sealed, _ = pii.Seal(plaintext, e.EntityKey+"|"+e.AuditId)
+	}
+	return e
+}
+
+// Reveal opens the unmasked snapshots sealed in e
+func (e *Entry) Reveal() error {
+	if e.Sealed == nil {
+		return nil
+	}
+	plaintext, err := pii.Open(e.Sealed, e.EntityKey+"|"+e.AuditId)
+	if err != nil {
+		return err
+	}
+	var s snapshots
+	if err := json.Unmarshal(plaintext, &s); err != nil {
+		return errors.New(ErrorFailedToUnmarshalRecord)
+	}
+	e.Before, e.After = s.Before, s.After
+	e.Changes = diff(s.Before, s.After)
+	return nil
+}
+
+func EntityKey(entityType string, entityId string) string {
+	return "Audit" + entityType + pseudonymize(entityId)
+}
+
+func ActorKey(actor string) string {
+	return "AuditActor" + pseudonymize(strings.ToLower(actor))
+}
+
+// pseudonymSecret keys the pseudonyms of emails in entry keys, so the log
+// can still be filtered by an email without storing it
+var pseudonymSecret []byte
+
+func SetPseudonymSecret(secret []byte) {
+	pseudonymSecret = secret
+}
+
+var emailPattern = regexp.MustCompile(`[^\s#|@]+@[^\s#|@]+`)
+
+// pseudonymize replaces every email in id by the same pseudonym each time
+func pseudonymize(id string) string {
+	return emailPattern.ReplaceAllStringFunc(id, func(email string) string {
+		mac := hmac.New(sha256.New, pseudonymSecret)
+		mac.Write([]byte(strings.ToLower(email)))
+		return "Email" + hex.EncodeToString(mac.Sum(nil))[:32]
+	})
+}
+
+func maskEmails(s string) string {
+	return emailPattern.ReplaceAllStringFunc(s, pii.MaskEmail)
+}
+
+// snapshot is the JSON form of a snapshot, so it can be masked whatever
+// type it was given as
+func snapshot(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var out interface{}
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil
+	}
+	return out
+}
+
+// redact masks the emails and phone numbers in a JSON value, whose
+// attribute is name
+func redact(name string, v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(value))
+		for k, item := range value {
+			out[k] = redact(k, item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(value))
+		for i, item := range value {
+			out[i] = redact(name, item)
+		}
+		return out
+	case string:
+		if strings.Contains(strings.ToLower(name), "phone") {
+			return pii.MaskPhone(value)
+		}
+		return maskEmails(value)
+	}
+	return v
+}
+
+// ActorFromRequest names the caller of req for the audit trail.
+func ActorFromRequest(req events.APIGatewayProxyRequest) string {
+	if caller, err := auth.FromRequest(req); err == nil {
+		return caller.Name()
+	}
+	return "anonymous@" + req.RequestContext.Identity.SourceIP
+}
+
+// TransactItem is the write for entry, for use inside the transaction
+// that makes the audited change so neither can happen without the other.
+func TransactItem(entry *Entry, tableName string) (*dynamodb.TransactWriteItem, error) {
+	av, err := dynamodbattribute.MarshalMap(entry)
+	if err != nil {
+		return nil, errors.New(ErrorCouldNotMarshalItem)
+	}
+	return &dynamodb.TransactWriteItem{
+		Put: &dynamodb.Put{
+			Item:                av,
+			TableName:           aws.String(tableName),
+			ConditionExpression: aws.String("attribute_not_exists(sk)"),
+		},
+	}, nil
+}
+
+// PutItem makes the put of input and records entry in one transaction
+func PutItem(input *dynamodb.PutItemInput, entry *Entry, dynaClient dynamodbiface.DynamoDBAPI) error {
+	return write(&dynamodb.TransactWriteItem{
+		Put: &dynamodb.Put{
+			Item:                      input.Item,
+			TableName:                 input.TableName,
+			ConditionExpression:       input.ConditionExpression,
+			ExpressionAttributeNames:  input.ExpressionAttributeNames,
+			ExpressionAttributeValues: input.ExpressionAttributeValues,
+			//Lets ItemExisted tell a missing item from a changed one
+			ReturnValuesOnConditionCheckFailure: aws.String(dynamodb.ReturnValuesOnConditionCheckFailureAllOld),
+		},
+	}, entry, aws.StringValue(input.TableName), dynaClient)
+}
+
+// UpdateItem makes the update of input and records entry in one transaction
+func UpdateItem(input *dynamodb.UpdateItemInput, entry *Entry, dynaClient dynamodbiface.DynamoDBAPI) error {
+	return write(&dynamodb.TransactWriteItem{
+		Update: &dynamodb.Update{
+			Key:                                 input.Key,
+			TableName:                           input.TableName,
+			UpdateExpression:                    input.UpdateExpression,
+			ConditionExpression:                 input.ConditionExpression,
+			ExpressionAttributeNames:            input.ExpressionAttributeNames,
+			ExpressionAttributeValues:           input.ExpressionAttributeValues,
+			ReturnValuesOnConditionCheckFailure: aws.String(dynamodb.ReturnValuesOnConditionCheckFailureAllOld),
+		},
+	}, entry, aws.StringValue(input.TableName), dynaClient)
+}
+
+// DeleteItem makes the delete of input and records entry in one transaction
+func DeleteItem(input *dynamodb.DeleteItemInput, entry *Entry, dynaClient dynamodbiface.DynamoDBAPI) error {
+	return write(&dynamodb.TransactWriteItem{
+		Delete: &dynamodb.Delete{
+			Key:                                 input.Key,
+			TableName:                           input.TableName,
+			ConditionExpression:                 input.ConditionExpression,
+			ExpressionAttributeNames:            input.ExpressionAttributeNames,
+			ExpressionAttributeValues:           input.ExpressionAttributeValues,
+			ReturnValuesOnConditionCheckFailure: aws.String(dynamodb.ReturnValuesOnConditionCheckFailureAllOld),
+		},
+	}, entry, aws.StringValue(input.TableName), dynaClient)
+}
+
+// ConditionFailed reports whether a write made by PutItem, UpdateItem or
+// DeleteItem was refused by its own condition
+func ConditionFailed(err error) bool {
+	tce, ok := err.(*dynamodb.TransactionCanceledException)
+	if !ok {
+		return false
+	}
+	reasons := tce.CancellationReasons
+	return len(reasons) > 0 && aws.StringValue(reasons[0].Code) == "ConditionalCheckFailed"
+}
+
+// ItemExisted reports whether the item of a write refused by its own
+// condition was there at the time
+func ItemExisted(err error) bool {
+	tce, ok := err.(*dynamodb.TransactionCanceledException)
+	return ok && ConditionFailed(err) && len(tce.CancellationReasons[0].Item) > 0
+}
+
+func write(item *dynamodb.TransactWriteItem, entry *Entry, tableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	auditItem, err := TransactItem(entry, tableName)
+	if err != nil {
+		return err
+	}
+	input := &dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{item, auditItem},
+	}
+	_, err = dynaClient.TransactWriteItems(input)
+	return err
+}
+
+// FetchAuditLog lists a page of entries newest first, for one entity
+// given by the entityType and entityId query parameters or for one
+// actor. Only admins may read the log, so entries are revealed to them.
+func FetchAuditLog(req events.APIGatewayProxyRequest, p page.Request, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*[]Entry, *storage.Key, error) {
+	if _, err := auth.RequireAdmin(req); err != nil {
+		return nil, nil, err
+	}
+	entityType := req.QueryStringParameters["entityType"]
+	entityId := req.QueryStringParameters["entityId"]
+	actor := req.QueryStringParameters["actor"]
+
+	//Macking Call for DynamoDB
+	input := &dynamodb.QueryInput{
+		ScanIndexForward: aws.Bool(false),
+		TableName:        aws.String(tableName),
+	}
+	var items *[]Entry
+	var next *storage.Key
+	var err error
+	switch {
+	case entityType != "" && entityId != "" && actor == "":
+		pk := EntityKey(entityType, entityId)
+		input.KeyConditionExpression = aws.String("pk = :pk AND begins_with(sk, :sk)")
+		input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":pk": {S: aws.String(pk)},
+			":sk": {S: aws.String("Audit")},
+		}
+		items = &[]Entry{}
+		next, err = page.Query(input, pk, p, items, dynaClient)
+	case actor != "" && entityType == "" && entityId == "":
+		items, next, err = queryActorIndex(input, ActorKey(actor), p, dynaClient)
+	default:
+		return nil, nil, errors.New(ErrorAuditFilterRequired)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	//The sealed snapshots are opened rather than passed on
+	for i := range *items {
+		e := &(*items)[i]
+		if err := e.Reveal(); err != nil {
+			return nil, nil, errors.New(ErrorFailedToRevealRecord)
+		}
+		e.Sealed = nil
+	}
+	return items, next, nil
+}
+
+// queryActorIndex reads a page of the entries of the actor index
+// partition key. Index pages start after the sort key of the last entry
+// shown, which is unique to it.
+func queryActorIndex(input *dynamodb.QueryInput, key string, p page.Request, dynaClient dynamodbiface.DynamoDBAPI) (*[]Entry, *storage.Key, error) {
+	before, err := p.Start(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	input.IndexName = aws.String(ActorIndex)
+	input.KeyConditionExpression = aws.String("gsi1pk = :pk")
+	input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+		":pk": {S: aws.String(key)},
+	}
+	if before != "" {
+		input.ExpressionAttributeValues[":before"] = &dynamodb.AttributeValue{S: aws.String(before)}
+		input.KeyConditionExpression = aws.String("gsi1pk = :pk AND gsi1sk < :before")
+	}
+
+	//One entry more than the page tells whether there is a next page
+	items := []Entry{}
+	for int64(len(items)) <= p.Limit {
+		input.Limit = aws.Int64(p.Limit + 1 - int64(len(items)))
+		result, err := dynaClient.Query(input)
+		if err != nil {
+			return nil, nil, errors.New(ErrorFailedToFetchRecord)
+		}
+		var found []Entry
+		err = dynamodbattribute.UnmarshalListOfMaps(result.Items, &found)
+		if err != nil {
+			return nil, nil, errors.New(ErrorFailedToUnmarshalRecord)
+		}
+		items = append(items, found...)
+		if len(result.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+	if int64(len(items)) <= p.Limit {
+		return &items, nil, nil
+	}
+	items = items[:p.Limit]
+	return &items, &storage.Key{PK: key, SK: items[len(items)-1].ActorSort}, nil
+}
+
+// diff lists the attributes that differ between two snapshots of an
+// entity. Snapshots that are not objects, such as wallet lists, have no
+// attributes to compare and are only kept whole.
+func diff(before interface{}, after interface{}) map[string]Change {
+	b, okBefore := attributes(before)
+	a, okAfter := attributes(after)
+	if !okBefore && !okAfter {
+		return nil
+	}
+	changes := map[string]Change{}
+	for name, value := range b {
+		if !reflect.DeepEqual(value, a[name]) {
+			changes[name] = Change{Before: value, After: a[name]}
+		}
+	}
+	for name, value := range a {
+		if _, ok := b[name]; !ok {
+			changes[name] = Change{Before: nil, After: value}
+		}
+	}
+	return changes
+}
+
+func attributes(snapshot interface{}) (map[string]interface{}, bool) {
+	if snapshot == nil {
+		return nil, false
+	}
+	raw, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, false
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(raw, &m); err != nil || m == nil {
+		return nil, false
+	}
+	return m, true
+}
@@ -0,0 +1,121 @@
+package audit
+
+import (
+	"aws-lambda-api/pkg/page"
+	"aws-lambda-api/pkg/pii"
+	"encoding/base64"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// logTable answers every query with the stored entries. Any other call
+// panics on the nil DynamoDBAPI.
+type logTable struct {
+	dynamodbiface.DynamoDBAPI
+	items []map[string]*dynamodb.AttributeValue
+}
+
+func (t *logTable) Query(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	return &dynamodb.QueryOutput{Items: t.items}, nil
+}
+
+func (t *logTable) store(tb *testing.T, e *Entry) {
+	item, err := dynamodbattribute.MarshalMap(e)
+	if err != nil {
+		tb.Fatalf("MarshalMap: %v", err)
+	}
+	t.items = append(t.items, item)
+}
+
+func useTestKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	key := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	if err := ioutil.WriteFile(path, []byte(`{"current": "k1", "keys": {"k1": "`+key+`"}}`), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	provider, err := pii.LoadLocalKeyFile(path)
+	if err != nil {
+		t.Fatalf("LoadLocalKeyFile: %v", err)
+	}
+	pii.SetKeyProvider(provider)
+	t.Cleanup(func() { pii.SetKeyProvider(nil) })
+}
+
+func logRequest(groups string) events.APIGatewayProxyRequest {
+	req := events.APIGatewayProxyRequest{
+		QueryStringParameters: map[string]string{"entityType": "Donor", "entityId": "dana@example.org"},
+	}
+	req.RequestContext.Authorizer = map[string]interface{}{
+		"claims": map[string]interface{}{"sub": "admin", "email": "admin@example.org", "cognito:groups": groups},
+	}
+	return req
+}
+
+func TestFetchAuditLogReveals(t *testing.T) {
+	useTestKey(t)
+	before := map[string]interface{}{"donorEmail": "dana@example.org", "donorPhone": "+441234567890"}
+	after := map[string]interface{}{"donorEmail": "dana@example.com", "donorPhone": "+441234567890"}
+	e := NewEntry("Donor", "dana@example.org", "updateDonor", "dana@example.org", before, after)
+
+	//What is stored keeps no personal data in the clear
+	if e.Sealed == nil {
+		t.Fatalf("NewEntry did not seal the snapshots")
+	}
+	stored := e.Changes["donorEmail"]
+	if strings.Contains(stored.Before.(string), "dana@") || strings.Contains(stored.After.(string), "dana@") {
+		t.Fatalf("NewEntry stored changes %+v in the clear", stored)
+	}
+	table := &logTable{}
+	table.store(t, e)
+
+	p, _ := page.FromRequest(logRequest(""))
+	if _, _, err := FetchAuditLog(logRequest(""), p, "NGOdetails", table); err == nil {
+		t.Fatalf("FetchAuditLog served the log to a caller who is not an admin")
+	}
+	items, _, err := FetchAuditLog(logRequest("admin"), p, "NGOdetails", table)
+	if err != nil {
+		t.Fatalf("FetchAuditLog: %v", err)
+	}
+	if len(*items) != 1 {
+		t.Fatalf("FetchAuditLog listed %d entries, want 1", len(*items))
+	}
+	got := (*items)[0]
+	if got.Sealed != nil {
+		t.Fatalf("FetchAuditLog passed the sealed snapshots on")
+	}
+	change, ok := got.Changes["donorEmail"]
+	if !ok || change.Before != "dana@example.org" || change.After != "dana@example.com" {
+		t.Fatalf("FetchAuditLog changes = %+v", got.Changes)
+	}
+	if _, ok := got.Changes["donorPhone"]; ok {
+		t.Fatalf("FetchAuditLog listed an unchanged attribute")
+	}
+	if got.Before.(map[string]interface{})["donorPhone"] != "+441234567890" {
+		t.Fatalf("FetchAuditLog before = %+v", got.Before)
+	}
+}
+
+func TestRevealCopiedEnvelope(t *testing.T) {
+	useTestKey(t)
+	e := NewEntry("Donor", "dana@example.org", "createDonor", "dana@example.org", nil, map[string]interface{}{"donorEmail": "dana@example.org"})
+	other := NewEntry("Donor", "eve@example.org", "createDonor", "eve@example.org", nil, map[string]interface{}{"donorEmail": "eve@example.org"})
+
+	//Sealed snapshots are bound to their entry
+	other.Sealed = e.Sealed
+	if err := other.Reveal(); err == nil {
+		t.Fatalf("Reveal opened the snapshots of another entry")
+	}
+	table := &logTable{}
+	table.store(t, other)
+	p, _ := page.FromRequest(logRequest("admin"))
+	if _, _, err := FetchAuditLog(logRequest("admin"), p, "NGOdetails", table); err == nil || err.Error() != ErrorFailedToRevealRecord {
+		t.Fatalf("FetchAuditLog error = %v, want %s", err, ErrorFailedToRevealRecord)
+	}
+}
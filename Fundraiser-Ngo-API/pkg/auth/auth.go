@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+var (
+	ErrorUnauthenticated = "authentication required"
+	ErrorForbidden       = "not allowed to change this resource"
+)
+
+// AdminGroup is the authorizer group whose members may change anything
+const AdminGroup = "admin"
+
+// Identity is the caller as described by the claims of the API Gateway
+// authorizer. Owners are matched on Name.
+type Identity struct {
+	Subject string
+	Email   string
+	Groups  []string
+}
+
+// FromRequest reads the caller from the JWT claims of a Cognito
+// authorizer, or from the context of a Lambda authorizer, which carries
+// the same claims at the top level. Without either it falls back to a
+// session token issued by IssueSession.
+func FromRequest(req events.APIGatewayProxyRequest) (*Identity, error) {
+	claims, ok := req.RequestContext.Authorizer["claims"].(map[string]interface{})
+	if !ok {
+		claims = req.RequestContext.Authorizer
+	}
+	i := &Identity{
+		Subject: claimString(claims, "sub"),
+		Email:   strings.ToLower(claimString(claims, "email")),
+	}
+	if i.Subject == "" {
+		i.Subject = claimString(claims, "principalId")
+	}
+	if i.Subject == "" && i.Email == "" {
+		//Wallet logins carry a session token instead of authorizer claims
+		if s := sessionFromRequest(req); s != nil {
+			return &Identity{Subject: s.Subject}, nil
+		}
+		return nil, errors.New(ErrorUnauthenticated)
+	}
+	i.Groups = claimList(claims, "cognito:groups")
+	if len(i.Groups) == 0 {
+		i.Groups = claimList(claims, "groups")
+	}
+	return i, nil
+}
+
+// Name identifies the caller in stored records
+func (i *Identity) Name() string {
+	if i.Email != "" {
+		return i.Email
+	}
+	return i.Subject
+}
+
+func (i *Identity) IsAdmin() bool {
+	for _, g := range i.Groups {
+		if g == AdminGroup {
+			return true
+		}
+	}
+	return false
+}
+
+// Owns reports whether the caller may change a resource owned by owner,
+// which is the Name of the identity that created it
+func (i *Identity) Owns(owner string) bool {
+	if i.IsAdmin() {
+		return true
+	}
+	return owner != "" && strings.EqualFold(i.Name(), owner)
+}
+
+// RequireOwner returns the caller if they own resources of owner
+func RequireOwner(req events.APIGatewayProxyRequest, owner string) (*Identity, error) {
+	i, err := FromRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	if !i.Owns(owner) {
+		return nil, errors.New(ErrorForbidden)
+	}
+	return i, nil
+}
+
+// RequireAdmin returns the caller if they are an admin
+func RequireAdmin(req events.APIGatewayProxyRequest) (*Identity, error) {
+	i, err := FromRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	if !i.IsAdmin() {
+		return nil, errors.New(ErrorForbidden)
+	}
+	return i, nil
+}
+
+func claimString(claims map[string]interface{}, name string) string {
+	s, _ := claims[name].(string)
+	return s
+}
+
+// claimList reads a list claim, which authorizers pass either as a JSON
+// array or flattened to a string such as "a,b" or "[a b]"
+func claimList(claims map[string]interface{}, name string) []string {
+	var list []string
+	switch v := claims[name].(type) {
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok && s != "" {
+				list = append(list, s)
+			}
+		}
+	case string:
+		v = strings.Trim(v, "[]")
+		for _, s := range strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == ' ' }) {
+			list = append(list, s)
+		}
+	}
+	return list
+}
@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+var (
+	ErrorSessionsDisabled = "sessions are not configured"
+)
+
+var sessionSecret []byte
+
+// SetSessionSecret sets the key that signs session tokens. Bearer tokens
+// are ignored while no key is set.
+func SetSessionSecret(secret []byte) {
+	sessionSecret = secret
+}
+
+// Session is the payload of a session token
+type Session struct {
+	Subject   string `json:"sub"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// IssueSession returns a token for subject that FromRequest accepts as a
+// bearer token until expiresAt
+func IssueSession(subject string, expiresAt time.Time) (string, error) {
+	if len(sessionSecret) == 0 {
+		return "", errors.New(ErrorSessionsDisabled)
+	}
+	payload, err := json.Marshal(Session{
+		Subject:   subject,
+		IssuedAt:  time.Now().Unix(),
+		ExpiresAt: expiresAt.Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + sign(encoded), nil
+}
+
+// sessionFromRequest reads the bearer token of req, if it is a session
+// token that is signed and not expired
+func sessionFromRequest(req events.APIGatewayProxyRequest) *Session {
+	if len(sessionSecret) == 0 {
+		return nil
+	}
+	header := req.Headers["Authorization"]
+	if header == "" {
+		header = req.Headers["authorization"]
+	}
+	if !strings.HasPrefix(header, "Bearer ") {
+		return nil
+	}
+	parts := strings.Split(strings.TrimPrefix(header, "Bearer "), ".")
+	if len(parts) != 2 || !hmac.Equal([]byte(sign(parts[0])), []byte(parts[1])) {
+		return nil
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil
+	}
+	s := new(Session)
+	if err := json.Unmarshal(payload, s); err != nil {
+		return nil
+	}
+	if s.Subject == "" || time.Now().Unix() >= s.ExpiresAt {
+		return nil
+	}
+	return s
+}
+
+func sign(payload string) string {
+	mac := hmac.New(sha256.New, sessionSecret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
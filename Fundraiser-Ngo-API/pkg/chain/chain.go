@@ -0,0 +1,172 @@
+package chain
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+)
+
+var (
+	ErrorUnsupportedChain       = "unsupported chain"
+	ErrorInvalidTxHash          = "invalid transaction hash"
+	ErrorTransactionNotFound    = "transaction not found"
+	ErrorTransactionFailed      = "transaction failed on chain"
+	ErrorTransactionPending     = "transaction is not mined yet"
+	ErrorWrongRecipient         = "transaction was not sent to the fundraiser wallet"
+	ErrorEmptyTransfer          = "transaction did not transfer any value"
+	ErrorNotEnoughConfirmations = "transaction does not have enough confirmations"
+	ErrorNodeUnavailable        = "could not reach chain node"
+)
+
+// NativeDecimals is the number of decimals of every EVM native coin.
+const NativeDecimals = 18
+
+// NativeAssets maps the supported EVM chain ids to their native coin.
+var NativeAssets = map[string]string{
+	"ethereum": "ETH",
+	"arbitrum": "ETH",
+	"optimism": "ETH",
+	"base":     "ETH",
+	"polygon":  "MATIC",
+	"bsc":      "BNB",
+}
+
+// Transfer is a value transfer that has been confirmed on chain.
+// Value is in the asset's base units (wei for ETH).
+type Transfer struct {
+	ChainId       string   `json:"chainId"`
+	TxHash        string   `json:"txHash"`
+	From          string   `json:"from"`
+	To            string   `json:"to"`
+	Asset         string   `json:"asset"`
+	Value         *big.Int `json:"value"`
+	BlockNumber   uint64   `json:"blockNumber"`
+	Confirmations uint64   `json:"confirmations"`
+}
+
+// Verifier confirms that txHash on chainId paid the wallet at to.
+type Verifier interface {
+	VerifyTransfer(chainId string, txHash string, to string) (*Transfer, error)
+}
+
+// Transaction and Receipt hold the fields of the EVM JSON-RPC objects
+// the verifier relies on. BlockNumber is zero for pending transactions.
+type Transaction struct {
+	Hash        string
+	From        string
+	To          string
+	Value       *big.Int
+	BlockNumber uint64
+}
+
+type Receipt struct {
+	Status      uint64
+	BlockNumber uint64
+}
+
+// Node is the part of an EVM node's JSON-RPC API used for verification.
+type Node interface {
+	BlockNumber() (uint64, error)
+	TransactionByHash(txHash string) (*Transaction, error)
+	TransactionReceipt(txHash string) (*Receipt, error)
+}
+
+type evmChain struct {
+	asset string
+	node  Node
+}
+
+// EVMVerifier verifies native coin transfers on EVM chains.
+type EVMVerifier struct {
+	MinConfirmations uint64
+	chains           map[string]evmChain
+}
+
+func NewEVMVerifier(minConfirmations uint64) *EVMVerifier {
+	return &EVMVerifier{
+		MinConfirmations: minConfirmations,
+		chains:           map[string]evmChain{},
+	}
+}
+
+// AddChain registers the node used for chainId and its native asset code.
+func (v *EVMVerifier) AddChain(chainId string, asset string, node Node) {
+	v.chains[chainId] = evmChain{asset: asset, node: node}
+}
+
+func (v *EVMVerifier) VerifyTransfer(chainId string, txHash string, to string) (*Transfer, error) {
+	c, ok := v.chains[chainId]
+	if !ok {
+		return nil, errors.New(ErrorUnsupportedChain)
+	}
+	txHash = strings.ToLower(txHash)
+	if !isTxHash(txHash) {
+		return nil, errors.New(ErrorInvalidTxHash)
+	}
+
+	//Checking the transaction itself
+	tx, err := c.node.TransactionByHash(txHash)
+	if err != nil {
+		return nil, err
+	}
+	if tx == nil {
+		return nil, errors.New(ErrorTransactionNotFound)
+	}
+	if tx.BlockNumber == 0 {
+		return nil, errors.New(ErrorTransactionPending)
+	}
+	if !strings.EqualFold(tx.To, to) {
+		return nil, errors.New(ErrorWrongRecipient)
+	}
+	if tx.Value == nil || tx.Value.Sign() <= 0 {
+		return nil, errors.New(ErrorEmptyTransfer)
+	}
+
+	//Checking the transaction did not revert
+	receipt, err := c.node.TransactionReceipt(txHash)
+	if err != nil {
+		return nil, err
+	}
+	if receipt == nil {
+		return nil, errors.New(ErrorTransactionPending)
+	}
+	if receipt.Status != 1 {
+		return nil, errors.New(ErrorTransactionFailed)
+	}
+
+	//Checking the transaction is buried deep enough
+	head, err := c.node.BlockNumber()
+	if err != nil {
+		return nil, err
+	}
+	var confirmations uint64
+	if head >= receipt.BlockNumber {
+		confirmations = head - receipt.BlockNumber + 1
+	}
+	if confirmations < v.MinConfirmations {
+		return nil, errors.New(ErrorNotEnoughConfirmations)
+	}
+
+	return &Transfer{
+		ChainId:       chainId,
+		TxHash:        txHash,
+		From:          tx.From,
+		To:            tx.To,
+		Asset:         c.asset,
+		Value:         new(big.Int).Set(tx.Value),
+		BlockNumber:   receipt.BlockNumber,
+		Confirmations: confirmations,
+	}, nil
+}
+
+func isTxHash(txHash string) bool {
+	if len(txHash) != 66 || !strings.HasPrefix(txHash, "0x") {
+		return false
+	}
+	for _, c := range txHash[2:] {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,119 @@
+package chain
+
+import (
+	"math/big"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const (
+	fundraiserWallet = "0x7E5F4552091A69125d5DfCb7b8C2659029395Bdf"
+	otherWallet      = "0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266"
+	donorWallet      = "0x70997970C51812dc3A010C7d01b50e0d17dc79C8"
+)
+
+func txHash(n int) string {
+	return "0x" + strings.Repeat("0", 63) + string(rune('0'+n))
+}
+
+func oneEther() *big.Int {
+	v, _ := new(big.Int).SetString("1000000000000000000", 10)
+	return v
+}
+
+// newFakeChain mines the transfers the tests verify, then buries them
+// under two more blocks
+func newFakeChain() *FakeNode {
+	node := NewFakeNode()
+	node.AddTransfer(txHash(1), donorWallet, fundraiserWallet, oneEther(), true)
+	node.AddTransfer(txHash(2), donorWallet, otherWallet, oneEther(), true)
+	node.AddTransfer(txHash(3), donorWallet, fundraiserWallet, oneEther(), false)
+	node.AddTransfer(txHash(4), donorWallet, fundraiserWallet, big.NewInt(0), true)
+	node.AddPendingTransfer(txHash(5), donorWallet, fundraiserWallet, oneEther())
+	node.Mine(2)
+	//Mined last, so it has a single confirmation
+	node.AddTransfer(txHash(6), donorWallet, fundraiserWallet, oneEther(), true)
+	return node
+}
+
+func TestVerifyTransfer(t *testing.T) {
+	tests := []struct {
+		name    string
+		chainId string
+		txHash  string
+		to      string
+		wantErr string
+	}{
+		{"verified", "ethereum", txHash(1), fundraiserWallet, ""},
+		{"recipient in another case", "ethereum", txHash(1), strings.ToLower(fundraiserWallet), ""},
+		{"wrong recipient", "ethereum", txHash(2), fundraiserWallet, ErrorWrongRecipient},
+		{"reverted", "ethereum", txHash(3), fundraiserWallet, ErrorTransactionFailed},
+		{"empty transfer", "ethereum", txHash(4), fundraiserWallet, ErrorEmptyTransfer},
+		{"pending", "ethereum", txHash(5), fundraiserWallet, ErrorTransactionPending},
+		{"not enough confirmations", "ethereum", txHash(6), fundraiserWallet, ErrorNotEnoughConfirmations},
+		{"unknown transaction", "ethereum", txHash(9), fundraiserWallet, ErrorTransactionNotFound},
+		{"invalid hash", "ethereum", "0x1234", fundraiserWallet, ErrorInvalidTxHash},
+		{"unsupported chain", "solana", txHash(1), fundraiserWallet, ErrorUnsupportedChain},
+	}
+
+	node := newFakeChain()
+	server := httptest.NewServer(node)
+	defer server.Close()
+	nodes := map[string]Node{
+		"fake node":  node,
+		"rpc client": NewRPCClient(server.URL),
+	}
+	for nodeName, n := range nodes {
+		v := NewEVMVerifier(3)
+		v.AddChain("ethereum", "ETH", n)
+		for _, tt := range tests {
+			t.Run(nodeName+"/"+tt.name, func(t *testing.T) {
+				transfer, err := v.VerifyTransfer(tt.chainId, tt.txHash, tt.to)
+				if tt.wantErr != "" {
+					if err == nil || err.Error() != tt.wantErr {
+						t.Fatalf("VerifyTransfer error = %v, want %s", err, tt.wantErr)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("VerifyTransfer: %v", err)
+				}
+				if transfer.Asset != "ETH" || transfer.From != donorWallet || transfer.Confirmations < 3 {
+					t.Fatalf("VerifyTransfer = %+v", transfer)
+				}
+			})
+		}
+	}
+}
+
+func TestVerifyTransferAmount(t *testing.T) {
+	//The verified amount is what was mined, not what a donor claims
+	node := NewFakeNode()
+	value := big.NewInt(123456789)
+	node.AddTransfer(txHash(1), donorWallet, fundraiserWallet, value, true)
+	node.Mine(5)
+	v := NewEVMVerifier(1)
+	v.AddChain("ethereum", "ETH", node)
+
+	transfer, err := v.VerifyTransfer("ethereum", "0x"+strings.ToUpper(txHash(1)[2:]), fundraiserWallet)
+	if err != nil {
+		t.Fatalf("VerifyTransfer: %v", err)
+	}
+	if transfer.Value.Cmp(value) != 0 {
+		t.Fatalf("VerifyTransfer value = %s, want %s", transfer.Value, value)
+	}
+	if transfer.TxHash != txHash(1) {
+		t.Fatalf("VerifyTransfer hash = %s, want it lowercased", transfer.TxHash)
+	}
+	if transfer.Confirmations != 6 || transfer.BlockNumber != 1 {
+		t.Fatalf("VerifyTransfer confirmations = %d at block %d, want 6 at block 1", transfer.Confirmations, transfer.BlockNumber)
+	}
+
+	//Later blocks add confirmations
+	node.Mine(4)
+	transfer, err = v.VerifyTransfer("ethereum", txHash(1), fundraiserWallet)
+	if err != nil || transfer.Confirmations != 10 {
+		t.Fatalf("VerifyTransfer = %+v, %v, want 10 confirmations", transfer, err)
+	}
+}
@@ -0,0 +1,148 @@
+package chain
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RPCClient is a Node backed by an Ethereum JSON-RPC endpoint.
+type RPCClient struct {
+	URL        string
+	HTTPClient *http.Client
+}
+
+func NewRPCClient(url string) *RPCClient {
+	return &RPCClient{
+		URL:        url,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type rpcRequest struct {
+	JSONRPC string        `json:"jsonrpc"`
+	Id      int           `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type rpcResponse struct {
+	Id     int             `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+type rpcTransaction struct {
+	Hash        string  `json:"hash"`
+	From        string  `json:"from"`
+	To          *string `json:"to"`
+	Value       string  `json:"value"`
+	BlockNumber *string `json:"blockNumber"`
+}
+
+type rpcReceipt struct {
+	Status      string `json:"status"`
+	BlockNumber string `json:"blockNumber"`
+}
+
+func (c *RPCClient) call(method string, params []interface{}, result interface{}) error {
+	body, err := json.Marshal(rpcRequest{JSONRPC: "2.0", Id: 1, Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+	resp, err := c.HTTPClient.Post(c.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return errors.New(ErrorNodeUnavailable)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(ErrorNodeUnavailable)
+	}
+
+	var r rpcResponse
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return errors.New(ErrorNodeUnavailable)
+	}
+	if r.Error != nil {
+		return errors.New(ErrorNodeUnavailable + ": " + r.Error.Message)
+	}
+	return json.Unmarshal(r.Result, result)
+}
+
+func (c *RPCClient) BlockNumber() (uint64, error) {
+	var head string
+	if err := c.call("eth_blockNumber", []interface{}{}, &head); err != nil {
+		return 0, err
+	}
+	return parseQuantity(head)
+}
+
+func (c *RPCClient) TransactionByHash(txHash string) (*Transaction, error) {
+	var raw *rpcTransaction
+	if err := c.call("eth_getTransactionByHash", []interface{}{txHash}, &raw); err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		return nil, nil
+	}
+
+	tx := &Transaction{Hash: raw.Hash, From: raw.From}
+	//Contract creations have no recipient
+	if raw.To != nil {
+		tx.To = *raw.To
+	}
+	value, ok := new(big.Int).SetString(strings.TrimPrefix(raw.Value, "0x"), 16)
+	if !ok {
+		return nil, errors.New(ErrorNodeUnavailable)
+	}
+	tx.Value = value
+	if raw.BlockNumber != nil {
+		n, err := parseQuantity(*raw.BlockNumber)
+		if err != nil {
+			return nil, err
+		}
+		tx.BlockNumber = n
+	}
+	return tx, nil
+}
+
+func (c *RPCClient) TransactionReceipt(txHash string) (*Receipt, error) {
+	var raw *rpcReceipt
+	if err := c.call("eth_getTransactionReceipt", []interface{}{txHash}, &raw); err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		return nil, nil
+	}
+	status, err := parseQuantity(raw.Status)
+	if err != nil {
+		return nil, err
+	}
+	block, err := parseQuantity(raw.BlockNumber)
+	if err != nil {
+		return nil, err
+	}
+	return &Receipt{Status: status, BlockNumber: block}, nil
+}
+
+func parseQuantity(s string) (uint64, error) {
+	n, err := strconv.ParseUint(strings.TrimPrefix(s, "0x"), 16, 64)
+	if err != nil {
+		return 0, errors.New(ErrorNodeUnavailable)
+	}
+	return n, nil
+}
+
+func formatQuantity(n uint64) string {
+	return "0x" + strconv.FormatUint(n, 16)
+}
@@ -0,0 +1,149 @@
+package chain
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// FakeNode is an in-memory EVM node for offline testing. It can be used
+// directly as a Node, or served over HTTP and reached through an RPCClient.
+type FakeNode struct {
+	mu       sync.Mutex
+	head     uint64
+	txs      map[string]*Transaction
+	receipts map[string]*Receipt
+}
+
+func NewFakeNode() *FakeNode {
+	return &FakeNode{
+		txs:      map[string]*Transaction{},
+		receipts: map[string]*Receipt{},
+	}
+}
+
+// AddTransfer mines a transfer in the next block. Failed transfers are
+// mined with a reverted receipt.
+func (n *FakeNode) AddTransfer(txHash string, from string, to string, value *big.Int, success bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.head++
+	txHash = strings.ToLower(txHash)
+	n.txs[txHash] = &Transaction{
+		Hash:        txHash,
+		From:        from,
+		To:          to,
+		Value:       new(big.Int).Set(value),
+		BlockNumber: n.head,
+	}
+	var status uint64
+	if success {
+		status = 1
+	}
+	n.receipts[txHash] = &Receipt{Status: status, BlockNumber: n.head}
+}
+
+// AddPendingTransfer adds a transfer that has not been mined.
+func (n *FakeNode) AddPendingTransfer(txHash string, from string, to string, value *big.Int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	txHash = strings.ToLower(txHash)
+	n.txs[txHash] = &Transaction{
+		Hash:  txHash,
+		From:  from,
+		To:    to,
+		Value: new(big.Int).Set(value),
+	}
+}
+
+// Mine advances the chain head by blocks empty blocks.
+func (n *FakeNode) Mine(blocks uint64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.head += blocks
+}
+
+func (n *FakeNode) BlockNumber() (uint64, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.head, nil
+}
+
+func (n *FakeNode) TransactionByHash(txHash string) (*Transaction, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	tx, ok := n.txs[strings.ToLower(txHash)]
+	if !ok {
+		return nil, nil
+	}
+	copied := *tx
+	copied.Value = new(big.Int).Set(tx.Value)
+	return &copied, nil
+}
+
+func (n *FakeNode) TransactionReceipt(txHash string) (*Receipt, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	receipt, ok := n.receipts[strings.ToLower(txHash)]
+	if !ok {
+		return nil, nil
+	}
+	copied := *receipt
+	return &copied, nil
+}
+
+// ServeHTTP answers the JSON-RPC methods used by RPCClient.
+func (n *FakeNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req rpcRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp := map[string]interface{}{"jsonrpc": "2.0", "id": req.Id}
+
+	txHash := ""
+	if len(req.Params) > 0 {
+		txHash, _ = req.Params[0].(string)
+	}
+	switch req.Method {
+	case "eth_blockNumber":
+		head, _ := n.BlockNumber()
+		resp["result"] = formatQuantity(head)
+	case "eth_getTransactionByHash":
+		tx, _ := n.TransactionByHash(txHash)
+		if tx == nil {
+			resp["result"] = nil
+			break
+		}
+		raw := rpcTransaction{
+			Hash:  tx.Hash,
+			From:  tx.From,
+			To:    &tx.To,
+			Value: "0x" + tx.Value.Text(16),
+		}
+		if tx.BlockNumber != 0 {
+			block := formatQuantity(tx.BlockNumber)
+			raw.BlockNumber = &block
+		}
+		resp["result"] = raw
+	case "eth_getTransactionReceipt":
+		receipt, _ := n.TransactionReceipt(txHash)
+		if receipt == nil {
+			resp["result"] = nil
+			break
+		}
+		resp["result"] = rpcReceipt{
+			Status:      formatQuantity(receipt.Status),
+			BlockNumber: formatQuantity(receipt.BlockNumber),
+		}
+	default:
+		resp["error"] = rpcError{Code: -32601, Message: "method not found"}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
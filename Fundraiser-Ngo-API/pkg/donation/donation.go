@@ -0,0 +1,638 @@
+package donation
+
+import (
+	"aws-lambda-api/pkg/chain"
+	"aws-lambda-api/pkg/fundraiser"
+	"aws-lambda-api/pkg/matching"
+	"aws-lambda-api/pkg/money"
+	"aws-lambda-api/pkg/ngo"
+	"aws-lambda-api/pkg/page"
+	"aws-lambda-api/pkg/storage"
+	"aws-lambda-api/pkg/wallet"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+var (
+	ErrorFailedToUnmarshalRecord = "failed to unmarshal record"
+	ErrorFailedToFetchRecord     = "failed to fetch record"
+	ErrorInvalidUserData         = "invalid user data"
+	ErrorCouldNotMarshalItem     = "could not marshal item"
+	ErrorCouldNotDynamoPutItem   = "could not dynamo put item error"
+	ErrorDonationAlreadyExists   = "donation already exists"
+	ErrorDonationDoesNotExist    = "donation does not exist"
+	ErrorInvalidDonationAmount   = "donation amount must be positive"
+	ErrorFundraiserNotSpecified  = fundraiser.ErrorFundraiserNotSpecified
+	ErrorFundraiserDoesNotExist  = fundraiser.ErrorFundraiserDoesNotExist
+	ErrorNoWalletRegistered      = "fundraiser has no wallet registered for chain"
+	ErrorTransactionAlreadyUsed  = "transaction has already been recorded as a donation"
+	ErrorNgoNotSpecified         = "ngoId is required"
+	ErrorTransferWrongAsset      = "transfer is not in the asset due"
+	ErrorTransferTooSmall        = "transfer is worth less than the amount due"
+)
+
+const (
+	//StatusUnverified donations were reported by the client and are kept
+	//in the ledger without counting towards the fundraiser's progress
+	StatusUnverified = "unverified"
+	//StatusVerified donations were seen on chain
+	StatusVerified = "verified"
+)
+
+// Donation is kept in the ledger of its fundraiser, see
+// fundraiser.LedgerKey
+type Donation struct {
+	FundraiserId string `json:"pk"`
+	DonationId   string `json:"sk"`
+	//Exactly one of NgoId or IndividualEmailId identifies the fundraiser's owner
+	NgoId             string      `json:"ngoId,omitempty"`
+	IndividualEmailId string      `json:"emailId,omitempty"`
+	DonorName         string      `json:"donorName"`
+	DonorEmail        string      `json:"donorEmail"`
+	DonationAmount    money.Money `json:"donationAmount"`
+	//ConvertedAmount is DonationAmount in the fundraiser's currency at
+	//ConversionRate, and is what counts towards raisedAmount
+	ConvertedAmount money.Money `json:"convertedAmount"`
+	ConversionRate  string      `json:"conversionRate"`
+	RateAsOf        string      `json:"rateAsOf,omitempty"`
+	DonationMessage string      `json:"donationMessage"`
+	DonatedAt       string      `json:"donatedAt"`
+	Status          string      `json:"status"`
+	//Set only for donations verified on chain; VerifiedAmount is the exact
+	//transferred value in the chain's base units (e.g. wei)
+	ChainId        string `json:"chainId,omitempty"`
+	TxHash         string `json:"txHash,omitempty"`
+	DonorWallet    string `json:"donorWallet,omitempty"`
+	VerifiedAmount string `json:"verifiedAmount,omitempty"`
+	Confirmations  uint64 `json:"confirmations,omitempty"`
+	//Set once a receipt has been issued for the donation
+	ReceiptNumber int64 `json:"receiptNumber,omitempty"`
+	//Set for donations charged from a recurring pledge
+	PledgeId string `json:"pledgeId,omitempty"`
+	//Set when a matching pool matched the donation. The match is recorded
+	//as its own donation, with MatchOf naming the donation it matched
+	MatchedAmount  *money.Money `json:"matchedAmount,omitempty"`
+	MatchingPoolId string       `json:"matchingPoolId,omitempty"`
+	MatchOf        string       `json:"matchOf,omitempty"`
+	//Donor index, see DonorIndexKey
+	DonorKey  string `json:"gsi1pk,omitempty"`
+	DonorSort string `json:"gsi1sk,omitempty"`
+}
+
+// Verified reports whether the donation was seen on chain. Donations from
+// before the status was kept are verified when they carry a transaction.
+func (u *Donation) Verified() bool {
+	return u.Status == StatusVerified || (u.Status == "" && u.TxHash != "")
+}
+
+// DonorIndex is the sparse index listing each donor's donations by date
+const DonorIndex = "gsi1"
+
+// DonorIndexKey is the donor index partition for an email or wallet
+// address. Addresses are lowercased so EIP-55 checksums do not matter.
+func DonorIndexKey(emailOrAddress string) string {
+	return "Donor" + strings.ToLower(strings.TrimSpace(emailOrAddress))
+}
+
+// indexByDonor files the donation under the donor's email, or under the
+// sending wallet for anonymous on-chain donations
+func (u *Donation) indexByDonor() {
+	u.DonorKey = ""
+	u.DonorSort = ""
+	switch {
+	case u.DonorEmail != "":
+		u.DonorKey = DonorIndexKey(u.DonorEmail)
+	case u.DonorWallet != "":
+		u.DonorKey = DonorIndexKey(u.DonorWallet)
+	default:
+		return
+	}
+	u.DonorSort = u.DonatedAt + "#" + u.FundraiserId + "#" + u.DonationId
+}
+
+// DonationKey is the key of a donation in the ledger partition
+func DonationKey(ledger string, donationId string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"pk": {
+			S: aws.String(ledger),
+		},
+		"sk": {
+			S: aws.String("Donation" + donationId),
+		},
+	}
+}
+
+// FetchDonation loads a donation to the fundraiser owned by exactly one
+// of ngoId or emailId
+func FetchDonation(ngoId string, emailId string, fundraiserId string, donationId string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Donation, error) {
+	ledger, err := fundraiser.LedgerKey(ngoId, emailId, fundraiserId)
+	if err != nil {
+		return nil, err
+	}
+
+	//Macking Call for DynamoDB
+	input := &dynamodb.GetItemInput{
+		Key:       DonationKey(ledger, donationId),
+		TableName: aws.String(tableName),
+	}
+	result, err := dynaClient.GetItem(input)
+	if err != nil {
+		return nil, errors.New(ErrorFailedToFetchRecord)
+	}
+	if len(result.Item) == 0 {
+		return nil, errors.New(ErrorDonationDoesNotExist)
+	}
+
+	//Sending the Get Request
+	item := new(Donation)
+	err = dynamodbattribute.UnmarshalMap(result.Item, item)
+	if err != nil {
+		return nil, errors.New(ErrorFailedToUnmarshalRecord)
+	}
+	return item, nil
+}
+
+// FetchDonations lists a page of the donations to the fundraiser owned by
+// exactly one of ngoId or emailId
+func FetchDonations(ngoId string, emailId string, fundraiserId string, p page.Request, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*[]Donation, *storage.Key, error) {
+	ledger, err := fundraiser.LedgerKey(ngoId, emailId, fundraiserId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	//Macking Call for DynamoDB
+	input := &dynamodb.QueryInput{
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {
+				S: aws.String(ledger),
+			},
+			":sk": {
+				S: aws.String("Donation"),
+			},
+		},
+		KeyConditionExpression: aws.String("pk = :pk AND begins_with(sk, :sk)"),
+		TableName:              aws.String(tableName),
+	}
+	items := []Donation{}
+	next, err := page.Query(input, ledger, p, &items, dynaClient)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &items, next, nil
+}
+
+// newDonationId is a random id for a donation the client reports itself
+func newDonationId() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", errors.New(ErrorCouldNotDynamoPutItem)
+	}
+	return hex.EncodeToString(id), nil
+}
+
+func CreateDonation(req events.APIGatewayProxyRequest, fundraisers fundraiser.FundraiserRepository, ngos ngo.NgoRepository, tableName string, dynaClient dynamodbiface.DynamoDBAPI, oracle money.PriceOracle) (
+	*Donation,
+	error,
+) {
+	//Checking if the correct request
+	var u Donation
+	if err := json.Unmarshal([]byte(req.Body), &u); err != nil {
+		return nil, errors.New(ErrorInvalidUserData)
+	}
+	if err := u.DonationAmount.Normalize(); err != nil {
+		return nil, err
+	}
+	if u.DonationAmount.Amount == 0 {
+		return nil, errors.New(ErrorInvalidDonationAmount)
+	}
+
+	//Donation ids are ours to give, so clients cannot pick or reuse one
+	donationId, err := newDonationId()
+	if err != nil {
+		return nil, err
+	}
+	u.DonationId = donationId
+
+	//On-chain details can only be set by VerifyDonation
+	u.ChainId = ""
+	u.TxHash = ""
+	u.DonorWallet = ""
+	u.VerifiedAmount = ""
+	u.Confirmations = 0
+	u.ReceiptNumber = 0
+	u.PledgeId = ""
+	u.Status = StatusUnverified
+
+	t, err := fundraiser.FetchTarget(u.NgoId, u.IndividualEmailId, u.FundraiserId, false, fundraisers, ngos)
+	if err != nil {
+		return nil, err
+	}
+	return recordDonation(&u, t, oracle, nil, nil, tableName, dynaClient)
+}
+
+func VerifyDonation(req events.APIGatewayProxyRequest, fundraisers fundraiser.FundraiserRepository, ngos ngo.NgoRepository, tableName string, dynaClient dynamodbiface.DynamoDBAPI, verifier chain.Verifier, oracle money.PriceOracle) (
+	*Donation,
+	error,
+) {
+	//Checking if the correct request
+	var u Donation
+	if err := json.Unmarshal([]byte(req.Body), &u); err != nil {
+		return nil, errors.New(ErrorInvalidUserData)
+	}
+	if u.FundraiserId == "" {
+		return nil, errors.New(fundraiser.ErrorFundraiserNotSpecified)
+	}
+	u.ReceiptNumber = 0
+	u.PledgeId = ""
+	return RecordTransfer(&u, nil, fundraisers, ngos, tableName, dynaClient, verifier, oracle, nil, nil)
+}
+
+// RecordTransfer checks the transfer u.TxHash on u.ChainId against the
+// wallets of the fundraiser, or of the NGO's general fund when u has no
+// FundraiserId, and records it as a verified donation. A transfer that
+// pays due must be worth at least due. The extra items are written in
+// the same transaction, and extraErrors[i] is reported if the condition
+// of extra[i] fails.
+func RecordTransfer(u *Donation, due *money.Money, fundraisers fundraiser.FundraiserRepository, ngos ngo.NgoRepository, tableName string, dynaClient dynamodbiface.DynamoDBAPI, verifier chain.Verifier, oracle money.PriceOracle, extra []*dynamodb.TransactWriteItem, extraErrors []string) (
+	*Donation,
+	error,
+) {
+	if u.ChainId == "" || u.TxHash == "" {
+		return nil, errors.New(ErrorInvalidUserData)
+	}
+
+	//Finding the wallet registered for the fundraiser
+	var t *fundraiser.Target
+	var wallets []wallet.Wallet
+	if u.FundraiserId == "" {
+		if u.NgoId == "" {
+			return nil, errors.New(ErrorNgoNotSpecified)
+		}
+		n, err := ngos.GetNgo(u.NgoId)
+		if err != nil {
+			return nil, err
+		}
+		wallets = n.NgoWallets
+	} else {
+		var err error
+		t, err = fundraiser.FetchTarget(u.NgoId, u.IndividualEmailId, u.FundraiserId, true, fundraisers, ngos)
+		if err != nil {
+			return nil, err
+		}
+		wallets = t.Wallets
+	}
+	address := wallet.ForChain(wallets, u.ChainId)
+	if address == "" {
+		return nil, errors.New(ErrorNoWalletRegistered)
+	}
+	transfer, err := verifier.VerifyTransfer(u.ChainId, u.TxHash, address)
+	if err != nil {
+		return nil, err
+	}
+
+	//Recording what the chain says rather than what the client sent
+	amount, err := money.FromBaseUnits(transfer.Value, chain.NativeDecimals, transfer.Asset)
+	if err != nil {
+		return nil, err
+	}
+	if amount.Amount == 0 {
+		return nil, errors.New(ErrorInvalidDonationAmount)
+	}
+	if due != nil {
+		if err := covers(amount, *due, oracle); err != nil {
+			return nil, err
+		}
+	}
+	u.DonationId = transfer.ChainId + "-" + transfer.TxHash
+	u.DonationAmount = amount
+	u.TxHash = transfer.TxHash
+	u.DonorWallet = transfer.From
+	u.VerifiedAmount = transfer.Value.String()
+	u.Confirmations = transfer.Confirmations
+	u.Status = StatusVerified
+
+	//A transaction can only ever count towards one fundraiser
+	claim := &dynamodb.TransactWriteItem{
+		Put: &dynamodb.Put{
+			Item: map[string]*dynamodb.AttributeValue{
+				"pk": {
+					S: aws.String("ChainTx" + transfer.ChainId + "-" + transfer.TxHash),
+				},
+				"sk": {
+					S: aws.String("ChainTx"),
+				},
+				"fundraiserId": {
+					S: aws.String(u.FundraiserId),
+				},
+			},
+			TableName:           aws.String(tableName),
+			ConditionExpression: aws.String("attribute_not_exists(pk)"),
+		},
+	}
+	extra = append([]*dynamodb.TransactWriteItem{claim}, extra...)
+	extraErrors = append([]string{ErrorTransactionAlreadyUsed}, extraErrors...)
+	if t == nil {
+		return recordNgoDonation(u, extra, extraErrors, tableName, dynaClient)
+	}
+	return recordDonation(u, t, oracle, extra, extraErrors, tableName, dynaClient)
+}
+
+// recordNgoDonation stores a general fund donation under the NGO's partition
+func recordNgoDonation(u *Donation, extra []*dynamodb.TransactWriteItem, extraErrors []string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Donation, error) {
+	if u.NgoId == "" {
+		return nil, errors.New(ErrorNgoNotSpecified)
+	}
+	u.ConvertedAmount = u.DonationAmount
+	u.ConversionRate = "1"
+
+	//Modifying the key for DynamoDB Storage
+	ledger, err := fundraiser.LedgerKey(u.NgoId, "", "")
+	if err != nil {
+		return nil, err
+	}
+	u.FundraiserId = ledger
+	u.DonationId = "Donation" + u.DonationId
+	u.DonatedAt = time.Now().UTC().Format(time.RFC3339)
+	if u.Status != StatusVerified {
+		u.Status = StatusUnverified
+	}
+	u.MatchedAmount = nil
+	u.MatchingPoolId = ""
+	u.MatchOf = ""
+	u.indexByDonor()
+	return putDonation(u, extra, extraErrors, tableName, dynaClient)
+}
+
+// putDonation writes a donation that does not change any progress,
+// together with the extra items
+func putDonation(u *Donation, extra []*dynamodb.TransactWriteItem, extraErrors []string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Donation, error) {
+	av, err := dynamodbattribute.MarshalMap(u)
+	if err != nil {
+		return nil, errors.New(ErrorCouldNotMarshalItem)
+	}
+	input := &dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{
+				Put: &dynamodb.Put{
+					Item:                av,
+					TableName:           aws.String(tableName),
+					ConditionExpression: aws.String("attribute_not_exists(sk)"),
+				},
+			},
+		},
+	}
+	input.TransactItems = append(input.TransactItems, extra...)
+	_, err = dynaClient.TransactWriteItems(input)
+	if err != nil {
+		return nil, transactionError(err, 1, extraErrors)
+	}
+	return u, nil
+}
+
+// covers fails unless amount is worth at least due, in due's currency at
+// the oracle's rate. Amounts due in a crypto asset must be paid in it.
+func covers(amount money.Money, due money.Money, oracle money.PriceOracle) error {
+	if amount.Currency != due.Currency {
+		if money.IsCrypto(due.Currency) {
+			return errors.New(ErrorTransferWrongAsset)
+		}
+		rate, err := oracle.Rate(amount.Currency, due.Currency)
+		if err != nil {
+			return err
+		}
+		amount, err = money.Convert(amount, due.Currency, rate.Value)
+		if err != nil {
+			return err
+		}
+	}
+	if amount.Amount < due.Amount {
+		return errors.New(ErrorTransferTooSmall)
+	}
+	return nil
+}
+
+// recordDonation writes the donation to the ledger. Verified donations
+// bump the fundraiser's progress in the same transaction, so raisedAmount
+// and availableAmount always equal the ledger sum of verified converted
+// amounts and their matches, and donorCount the number of donor markers.
+// Progress is not part of the fundraiser's version, so donations do not
+// change its ETag. Any extra items are written in the same transaction.
+func recordDonation(u *Donation, t *fundraiser.Target, oracle money.PriceOracle, extra []*dynamodb.TransactWriteItem, extraErrors []string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Donation, error) {
+	//Converting to the fundraiser's currency at today's rate
+	rate, err := oracle.Rate(u.DonationAmount.Currency, t.Currency)
+	if err != nil {
+		return nil, err
+	}
+	converted, err := money.Convert(u.DonationAmount, t.Currency, rate.Value)
+	if err != nil {
+		return nil, err
+	}
+	u.ConvertedAmount = converted
+	u.ConversionRate = rate.String()
+	u.RateAsOf = rate.AsOf
+
+	//Modifying the key for DynamoDB Storage
+	donationId := u.DonationId
+	u.FundraiserId = t.Ledger
+	u.DonationId = "Donation" + u.DonationId
+	u.DonatedAt = time.Now().UTC().Format(time.RFC3339)
+	u.MatchOf = ""
+	u.indexByDonor()
+
+	//Only money seen on chain counts towards the fundraiser and is matched;
+	//what clients report themselves stays in the ledger as unverified
+	if u.Status != StatusVerified {
+		u.Status = StatusUnverified
+		u.MatchedAmount = nil
+		u.MatchingPoolId = ""
+		return putDonation(u, extra, extraErrors, tableName, dynaClient)
+	}
+
+	//A pool that changed since we planned the match is planned again; once
+	//out of attempts the donation is recorded without a match. A donor
+	//only counts once, so the count is left alone when the marker exists.
+	newDonor := u.DonorKey != ""
+	for attempt := 0; ; {
+		var pool *matching.MatchingPool
+		var match money.Money
+		if attempt < matchAttempts && len(t.MatchingPoolIds) > 0 {
+			pool, match, err = matching.Plan(t.MatchingPoolIds, converted, tableName, dynaClient)
+			if err != nil {
+				return nil, err
+			}
+		}
+		u.MatchedAmount = nil
+		u.MatchingPoolId = ""
+		var matchItems []*dynamodb.TransactWriteItem
+		if pool != nil {
+			u.MatchedAmount = &match
+			u.MatchingPoolId = pool.PoolId
+			matchItems, err = matchedContribution(u, donationId, pool, match, tableName)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		//Marshaling the data
+		av, err := dynamodbattribute.MarshalMap(u)
+		if err != nil {
+			return nil, errors.New(ErrorCouldNotMarshalItem)
+		}
+		progress := "SET raisedAmount.amount = raisedAmount.amount + :amount, availableAmount.amount = availableAmount.amount + :amount"
+		values := map[string]*dynamodb.AttributeValue{
+			":amount": {
+				N: aws.String(strconv.FormatInt(converted.Amount+match.Amount, 10)),
+			},
+			":currency": {
+				S: aws.String(converted.Currency),
+			},
+		}
+		var donorItems []*dynamodb.TransactWriteItem
+		if newDonor {
+			progress += " ADD donorCount :one"
+			values[":one"] = &dynamodb.AttributeValue{N: aws.String("1")}
+			donorItems = append(donorItems, donorMarker(u, tableName))
+		}
+
+		//Donations are a ledger so an existing record is never overwritten,
+		//and the fundraiser must still be raising in the converted currency
+		input := &dynamodb.TransactWriteItemsInput{
+			TransactItems: []*dynamodb.TransactWriteItem{
+				{
+					Put: &dynamodb.Put{
+						Item:                av,
+						TableName:           aws.String(tableName),
+						ConditionExpression: aws.String("attribute_not_exists(sk)"),
+					},
+				},
+				{
+					Update: &dynamodb.Update{
+						Key:                       t.Key,
+						TableName:                 aws.String(tableName),
+						ConditionExpression:       aws.String("attribute_exists(sk) AND raisedAmount.currency = :currency"),
+						UpdateExpression:          aws.String(progress),
+						ExpressionAttributeValues: values,
+					},
+				},
+			},
+		}
+		input.TransactItems = append(input.TransactItems, matchItems...)
+		input.TransactItems = append(input.TransactItems, donorItems...)
+		input.TransactItems = append(input.TransactItems, extra...)
+		_, err = dynaClient.TransactWriteItems(input)
+		if err == nil {
+			return u, nil
+		}
+		if newDonor && conditionFailed(err, 2+len(matchItems)) {
+			newDonor = false
+			continue
+		}
+		if pool != nil && conditionFailed(err, 2) {
+			attempt++
+			continue
+		}
+		return nil, transactionError(err, 2+len(matchItems)+len(donorItems), extraErrors)
+	}
+}
+
+// donorMarker is put once per donor in the ledger partition, and only
+// succeeds for a donor that has not given to the fundraiser before
+func donorMarker(u *Donation, tableName string) *dynamodb.TransactWriteItem {
+	return &dynamodb.TransactWriteItem{
+		Put: &dynamodb.Put{
+			Item: map[string]*dynamodb.AttributeValue{
+				"pk": {
+					S: aws.String(u.FundraiserId),
+				},
+				"sk": {
+					S: aws.String(u.DonorKey),
+				},
+				"firstDonationId": {
+					S: aws.String(u.DonationId),
+				},
+			},
+			TableName:           aws.String(tableName),
+			ConditionExpression: aws.String("attribute_not_exists(sk)"),
+		},
+	}
+}
+
+const matchAttempts = 3
+
+// matchedContribution records the sponsor's match as its own donation
+// next to the donor's, and takes it out of the pool.
+func matchedContribution(u *Donation, donationId string, pool *matching.MatchingPool, match money.Money, tableName string) ([]*dynamodb.TransactWriteItem, error) {
+	m := Donation{
+		FundraiserId:      u.FundraiserId,
+		DonationId:        u.DonationId + "-match",
+		NgoId:             u.NgoId,
+		IndividualEmailId: u.IndividualEmailId,
+		DonorName:         pool.SponsorName,
+		DonationAmount:    match,
+		ConvertedAmount:   match,
+		ConversionRate:    "1",
+		DonatedAt:         u.DonatedAt,
+		Status:            StatusVerified,
+		MatchingPoolId:    pool.PoolId,
+		MatchOf:           donationId,
+	}
+	av, err := dynamodbattribute.MarshalMap(m)
+	if err != nil {
+		return nil, errors.New(ErrorCouldNotMarshalItem)
+	}
+	return []*dynamodb.TransactWriteItem{
+		matching.ReserveItem(pool, match, tableName),
+		{
+			Put: &dynamodb.Put{
+				Item:                av,
+				TableName:           aws.String(tableName),
+				ConditionExpression: aws.String("attribute_not_exists(sk)"),
+			},
+		},
+	}, nil
+}
+
+// conditionFailed reports whether the item at index i of a cancelled
+// transaction failed its condition
+func conditionFailed(err error, i int) bool {
+	tce, ok := err.(*dynamodb.TransactionCanceledException)
+	if !ok || len(tce.CancellationReasons) <= i || tce.CancellationReasons[i] == nil {
+		return false
+	}
+	return aws.StringValue(tce.CancellationReasons[i].Code) == "ConditionalCheckFailed"
+}
+
+// transactionError maps a cancelled donation transaction to the failing
+// condition. Items from index extraFrom on are the caller's extra items,
+// each with its own error.
+func transactionError(err error, extraFrom int, extraErrors []string) error {
+	if tce, ok := err.(*dynamodb.TransactionCanceledException); ok {
+		for i, reason := range tce.CancellationReasons {
+			if reason == nil || aws.StringValue(reason.Code) != "ConditionalCheckFailed" {
+				continue
+			}
+			switch {
+			case i == 0:
+				return errors.New(ErrorDonationAlreadyExists)
+			case i == 1 && extraFrom > 1:
+				return errors.New(ErrorFundraiserDoesNotExist)
+			case i < extraFrom:
+				return errors.New(ErrorDonationAlreadyExists)
+			case i-extraFrom < len(extraErrors):
+				return errors.New(extraErrors[i-extraFrom])
+			}
+		}
+	}
+	return errors.New(ErrorCouldNotDynamoPutItem)
+}
@@ -0,0 +1,56 @@
+package donation
+
+import (
+	"aws-lambda-api/pkg/auth"
+	"aws-lambda-api/pkg/fundraiser"
+	"aws-lambda-api/pkg/ngo"
+	"aws-lambda-api/pkg/pii"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Mask replaces the emails of the donor and of the fundraiser's owner,
+// including those in the keys, with masked values
+func (u *Donation) Mask() {
+	u.maskOwner()
+	u.DonorEmail = pii.MaskEmail(u.DonorEmail)
+}
+
+func (u *Donation) maskOwner() {
+	if u.IndividualEmailId != "" {
+		masked := pii.MaskEmail(u.IndividualEmailId)
+		u.FundraiserId = strings.Replace(u.FundraiserId, u.IndividualEmailId, masked, 1)
+		u.IndividualEmailId = masked
+	}
+	u.DonorKey = ""
+	u.DonorSort = ""
+}
+
+// ForCaller shows donations of the fundraiser owned by exactly one of
+// ngoId or emailId in full to the NGO's money managers, the individual
+// who owns the fundraiser and admins. Everyone else only sees their own
+// email, and the rest masked.
+func ForCaller(req events.APIGatewayProxyRequest, ngoId string, emailId string, fundraiserId string, items []Donation, fundraisers fundraiser.FundraiserRepository, ngos ngo.NgoRepository) {
+	caller, err := auth.FromRequest(req)
+	if err == nil && canManage(req, ngoId, emailId, fundraiserId, fundraisers, ngos) {
+		return
+	}
+	for i := range items {
+		if caller != nil && items[i].DonorEmail != "" && caller.Owns(items[i].DonorEmail) {
+			items[i].maskOwner()
+			continue
+		}
+		items[i].Mask()
+	}
+}
+
+func canManage(req events.APIGatewayProxyRequest, ngoId string, emailId string, fundraiserId string, fundraisers fundraiser.FundraiserRepository, ngos ngo.NgoRepository) bool {
+	//The general fund belongs to the NGO alone
+	if fundraiserId == "" && emailId == "" {
+		_, _, err := ngo.CheckPermission(req, ngoId, ngo.PermissionManageMoney, ngos)
+		return err == nil
+	}
+	_, err := fundraiser.CheckPermission(req, ngoId, emailId, fundraiserId, ngo.PermissionManageMoney, false, fundraisers, ngos)
+	return err == nil
+}
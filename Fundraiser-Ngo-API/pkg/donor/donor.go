@@ -0,0 +1,349 @@
+package donor
+
+import (
+	"aws-lambda-api/pkg/auth"
+	"aws-lambda-api/pkg/donation"
+	"aws-lambda-api/pkg/etag"
+	"aws-lambda-api/pkg/page"
+	"aws-lambda-api/pkg/storage"
+	"aws-lambda-api/pkg/wallet"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+var (
+	ErrorFailedToUnmarshalRecord = "failed to unmarshal record"
+	ErrorFailedToFetchRecord     = "failed to fetch record"
+	ErrorInvalidUserData         = "invalid user data"
+	ErrorCouldNotMarshalItem     = "could not marshal item"
+	ErrorCouldNotDynamoPutItem   = "could not dynamo put item error"
+	ErrorDonorAlreadyExists      = "donor already exists"
+	ErrorDonorDoesNotExist       = "donor does not exist"
+	ErrorDonorNotIdentified      = "a donor needs an email or at least one wallet"
+)
+
+// Donor is the profile of someone giving to fundraisers. A donor is
+// identified by their email, or by their first wallet when they give
+// anonymously, and their giving history is every donation made from
+// that email or any of their wallets.
+type Donor struct {
+	DonorId      string          `json:"pk"`
+	Profile      string          `json:"sk"`
+	DonorName    string          `json:"donorName"`
+	DonorEmail   string          `json:"donorEmail"`
+	DonorPhoneNo string          `json:"donorPhoneNo"`
+	DonorWallets []wallet.Wallet `json:"donorWallets,omitempty"`
+	CreatedAt    string          `json:"createdAt"`
+	UpdatedAt    string          `json:"updatedAt"`
+	//Version counts every change to the profile and is sent as its ETag
+	Version int64 `json:"version"`
+}
+
+func DonorKey(donorId string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"pk": {
+			S: aws.String("Donor" + strings.ToLower(donorId)),
+		},
+		"sk": {
+			S: aws.String("Profile"),
+		},
+	}
+}
+
+// FetchDonor loads a donor's profile for the donor themselves and admins
+func FetchDonor(req events.APIGatewayProxyRequest, donorId string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Donor, error) {
+	caller, err := auth.FromRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	item, err := fetchDonor(donorId, tableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	if len(item.DonorId) == 0 {
+		if !caller.Owns(donorId) {
+			return nil, errors.New(auth.ErrorForbidden)
+		}
+		return nil, errors.New(ErrorDonorDoesNotExist)
+	}
+	if !item.ownedBy(caller) {
+		return nil, errors.New(auth.ErrorForbidden)
+	}
+	return item, nil
+}
+
+func fetchDonor(donorId string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Donor, error) {
+	//Macking Call for DynamoDB
+	input := &dynamodb.GetItemInput{
+		Key:       DonorKey(donorId),
+		TableName: aws.String(tableName),
+	}
+	result, err := dynaClient.GetItem(input)
+	if err != nil {
+		return nil, errors.New(ErrorFailedToFetchRecord)
+	}
+
+	//Sending the Get Request
+	item := new(Donor)
+	err = dynamodbattribute.UnmarshalMap(result.Item, item)
+	if err != nil {
+		return nil, errors.New(ErrorFailedToUnmarshalRecord)
+	}
+	return item, nil
+}
+
+// ownedBy reports whether caller is the donor, signed in with the
+// donor's email or one of their wallets, or an admin
+func (u *Donor) ownedBy(caller *auth.Identity) bool {
+	if caller.Owns(strings.TrimPrefix(u.DonorId, "Donor")) || caller.Owns(u.DonorEmail) {
+		return true
+	}
+	for _, w := range u.DonorWallets {
+		if caller.Owns(w.Address) {
+			return true
+		}
+	}
+	return false
+}
+
+// FetchDonorDonations lists a page of the donations made from the donor's
+// email and wallets, newest first, for the donor and admins. Ids without
+// a profile are looked up as a bare email or wallet address.
+func FetchDonorDonations(req events.APIGatewayProxyRequest, donorId string, p page.Request, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*[]donation.Donation, *storage.Key, error) {
+	caller, err := auth.FromRequest(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	current, err := fetchDonor(donorId, tableName, dynaClient)
+	if err != nil {
+		return nil, nil, err
+	}
+	identities := []string{donorId}
+	if len(current.DonorId) != 0 {
+		if !current.ownedBy(caller) {
+			return nil, nil, errors.New(auth.ErrorForbidden)
+		}
+		identities = nil
+		if current.DonorEmail != "" {
+			identities = append(identities, current.DonorEmail)
+		}
+		for _, w := range current.DonorWallets {
+			identities = append(identities, w.Address)
+		}
+	} else if !caller.Owns(donorId) {
+		return nil, nil, errors.New(auth.ErrorForbidden)
+	}
+
+	//The history is merged from the index partitions of every identity,
+	//so a page starts after the sort key of the last donation shown
+	listKey := donation.DonorIndexKey(donorId)
+	before, err := p.Start(listKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	seen := map[string]bool{}
+	items := []donation.Donation{}
+	for _, identity := range identities {
+		key := donation.DonorIndexKey(identity)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		found, err := queryDonorIndex(key, before, p.Limit+1, tableName, dynaClient)
+		if err != nil {
+			return nil, nil, err
+		}
+		items = append(items, found...)
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].DonorSort > items[j].DonorSort
+	})
+	if int64(len(items)) <= p.Limit {
+		return &items, nil, nil
+	}
+	items = items[:p.Limit]
+	next := &storage.Key{PK: listKey, SK: items[len(items)-1].DonorSort}
+	return &items, next, nil
+}
+
+// queryDonorIndex reads at most limit donations of the donor index
+// partition key, newest first, that sort before before
+func queryDonorIndex(key string, before string, limit int64, tableName string, dynaClient dynamodbiface.DynamoDBAPI) ([]donation.Donation, error) {
+	//Macking Call for DynamoDB
+	input := &dynamodb.QueryInput{
+		IndexName: aws.String(donation.DonorIndex),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {
+				S: aws.String(key),
+			},
+		},
+		KeyConditionExpression: aws.String("gsi1pk = :pk"),
+		ScanIndexForward:       aws.Bool(false),
+		TableName:              aws.String(tableName),
+	}
+	if before != "" {
+		input.ExpressionAttributeValues[":before"] = &dynamodb.AttributeValue{S: aws.String(before)}
+		input.KeyConditionExpression = aws.String("gsi1pk = :pk AND gsi1sk < :before")
+	}
+	var items []donation.Donation
+	for int64(len(items)) < limit {
+		input.Limit = aws.Int64(limit - int64(len(items)))
+		result, err := dynaClient.Query(input)
+		if err != nil {
+			return nil, errors.New(ErrorFailedToFetchRecord)
+		}
+		var page []donation.Donation
+		err = dynamodbattribute.UnmarshalListOfMaps(result.Items, &page)
+		if err != nil {
+			return nil, errors.New(ErrorFailedToUnmarshalRecord)
+		}
+		items = append(items, page...)
+		if len(result.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+	return items, nil
+}
+
+func CreateDonor(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*Donor,
+	error,
+) {
+	//Checking if the correct request
+	var u Donor
+	if err := json.Unmarshal([]byte(req.Body), &u); err != nil {
+		return nil, errors.New(ErrorInvalidUserData)
+	}
+	if err := u.validate(); err != nil {
+		return nil, err
+	}
+
+	//The donor is known by their email, or their first wallet, and only
+	//creates the profile for themselves
+	donorId := u.DonorEmail
+	if donorId == "" {
+		donorId = u.DonorWallets[0].Address
+	}
+	if _, err := auth.RequireOwner(req, donorId); err != nil {
+		return nil, err
+	}
+	key := DonorKey(donorId)
+	u.DonorId = aws.StringValue(key["pk"].S)
+	u.Profile = aws.StringValue(key["sk"].S)
+	u.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	u.UpdatedAt = u.CreatedAt
+	u.Version = 1
+
+	//Marshaling the data
+	av, err := dynamodbattribute.MarshalMap(u)
+	if err != nil {
+		return nil, errors.New(ErrorCouldNotMarshalItem)
+	}
+	//Puting it to DynamoDB
+	input := &dynamodb.PutItemInput{
+		Item:                av,
+		TableName:           aws.String(tableName),
+		ConditionExpression: aws.String("attribute_not_exists(sk)"),
+	}
+	_, err = dynaClient.PutItem(input)
+	if err != nil {
+		if _, ok := err.(*dynamodb.ConditionalCheckFailedException); ok {
+			return nil, errors.New(ErrorDonorAlreadyExists)
+		}
+		return nil, errors.New(ErrorCouldNotDynamoPutItem)
+	}
+	return &u, nil
+}
+
+func UpdateDonor(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*Donor,
+	error,
+) {
+	var u Donor
+	//Checking if the correct request
+	if err := json.Unmarshal([]byte(req.Body), &u); err != nil {
+		return nil, errors.New(ErrorInvalidUserData)
+	}
+
+	//Check if donor exists
+	currentDonor, err := FetchDonor(req, u.DonorId, tableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	if err := etag.Check(req, currentDonor.Version); err != nil {
+		return nil, err
+	}
+
+	//The email is part of the key, so it stays what the donor was created with
+	u.DonorId = currentDonor.DonorId
+	u.Profile = currentDonor.Profile
+	u.DonorEmail = currentDonor.DonorEmail
+	u.CreatedAt = currentDonor.CreatedAt
+	u.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	u.Version = currentDonor.Version + 1
+	if err := u.validate(); err != nil {
+		return nil, err
+	}
+	wallets, err := dynamodbattribute.Marshal(u.DonorWallets)
+	if err != nil {
+		return nil, errors.New(ErrorCouldNotMarshalItem)
+	}
+
+	//Only the profile fields change, and only from the version read
+	condition, values := etag.Condition(currentDonor.Version)
+	if values == nil {
+		values = map[string]*dynamodb.AttributeValue{}
+	}
+	values[":name"] = &dynamodb.AttributeValue{S: aws.String(u.DonorName)}
+	values[":phone"] = &dynamodb.AttributeValue{S: aws.String(u.DonorPhoneNo)}
+	values[":wallets"] = wallets
+	values[":at"] = &dynamodb.AttributeValue{S: aws.String(u.UpdatedAt)}
+	values[":next"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(u.Version, 10))}
+	input := &dynamodb.UpdateItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {S: aws.String(u.DonorId)},
+			"sk": {S: aws.String(u.Profile)},
+		},
+		TableName:                 aws.String(tableName),
+		ConditionExpression:       aws.String(condition),
+		UpdateExpression:          aws.String("SET donorName = :name, donorPhoneNo = :phone, donorWallets = :wallets, updatedAt = :at, version = :next"),
+		ExpressionAttributeValues: values,
+	}
+	_, err = dynaClient.UpdateItem(input)
+	if err != nil {
+		if _, ok := err.(*dynamodb.ConditionalCheckFailedException); ok {
+			return nil, errors.New(etag.ErrorPreconditionFailed)
+		}
+		return nil, errors.New(ErrorCouldNotDynamoPutItem)
+	}
+	return &u, nil
+}
+
+func (u *Donor) validate() error {
+	u.DonorEmail = strings.ToLower(strings.TrimSpace(u.DonorEmail))
+	if u.DonorEmail == "" && len(u.DonorWallets) == 0 {
+		return errors.New(ErrorDonorNotIdentified)
+	}
+	var wallets []wallet.Wallet
+	for _, w := range u.DonorWallets {
+		if err := w.Validate(); err != nil {
+			return err
+		}
+		if !wallet.Contains(wallets, w) {
+			wallets = append(wallets, w)
+		}
+	}
+	u.DonorWallets = wallets
+	return nil
+}
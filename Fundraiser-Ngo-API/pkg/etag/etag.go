@@ -0,0 +1,60 @@
+package etag
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+var (
+	ErrorPreconditionRequired = "If-Match header is required"
+	ErrorPreconditionFailed   = "item was changed since it was read"
+)
+
+// Header is sent with every versioned item, and IfMatchHeader must carry
+// it back on changes so they do not overwrite a newer version
+const (
+	Header        = "ETag"
+	IfMatchHeader = "If-Match"
+)
+
+// Format is the ETag of an item at version
+func Format(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// Check fails unless the If-Match header of req matches current, the
+// version of the item req changes. "*" matches any version.
+func Check(req events.APIGatewayProxyRequest, current int64) error {
+	ifMatch := req.Headers[IfMatchHeader]
+	if ifMatch == "" {
+		ifMatch = req.Headers[strings.ToLower(IfMatchHeader)]
+	}
+	if strings.TrimSpace(ifMatch) == "" {
+		return errors.New(ErrorPreconditionRequired)
+	}
+	//Weak tags never match, as If-Match compares strongly
+	for _, tag := range strings.Split(ifMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == Format(current) {
+			return nil
+		}
+	}
+	return errors.New(ErrorPreconditionFailed)
+}
+
+// Condition is the condition expression, with its values, of a write to
+// an item that must still be at previous. Items from before versioning
+// have no version and are at 0.
+func Condition(previous int64) (string, map[string]*dynamodb.AttributeValue) {
+	if previous == 0 {
+		return "attribute_exists(sk) AND attribute_not_exists(version)", nil
+	}
+	return "attribute_exists(sk) AND version = :version", map[string]*dynamodb.AttributeValue{
+		":version": {N: aws.String(strconv.FormatInt(previous, 10))},
+	}
+}
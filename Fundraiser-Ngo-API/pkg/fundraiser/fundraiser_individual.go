@@ -1,95 +1,63 @@
 package fundraiser
 
 import (
+	"aws-lambda-api/pkg/audit"
+	"aws-lambda-api/pkg/auth"
+	"aws-lambda-api/pkg/etag"
+	"aws-lambda-api/pkg/money"
+	"aws-lambda-api/pkg/patch"
+	"aws-lambda-api/pkg/pii"
+	"aws-lambda-api/pkg/validate"
+	"aws-lambda-api/pkg/wallet"
 	"encoding/json"
 	"errors"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
-	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
-	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 )
 
 type FundraiserIndividual struct {
-	IndividualEmailId                string `json:"pk"`
-	IndividualFundraiserId           string `json:"sk"`
-	IndividualFirstname              string `json:"firstname"`
-	IndividualLastname               string `json:"lastname"`
-	IndividualPhoneNo                string `json:"phoneNo"`
-	IndividualFundraiserTitle        string `json:"fundraiserTitle"`
-	IndividualFundraiserCause        string `json:"fundraiserCause"`
-	IndividualFundraiserLocation     string `json:"fundraiserLocation"`
-	IndividualFundraiserDescription  string `json:"fundraiserDescription"`
-	IndividualFundraiserPhoto        string `json:"fundraiserPhoto"`
-	IndividualFundraiserTargetAmount string `json:"fundraiserTargetAmount"`
+	IndividualEmailId                string      `json:"pk" validate:"required,email,max=254"`
+	IndividualFundraiserId           string      `json:"sk" validate:"required,id,max=64"`
+	IndividualFirstname              string      `json:"firstname" validate:"required,max=100"`
+	IndividualLastname               string      `json:"lastname" validate:"required,max=100"`
+	IndividualPhoneNo                string      `json:"phoneNo" dynamodbav:"-" validate:"phone"`
+	IndividualFundraiserTitle        string      `json:"fundraiserTitle" validate:"required,max=200"`
+	IndividualFundraiserCause        string      `json:"fundraiserCause" validate:"required,max=200"`
+	IndividualFundraiserLocation     string      `json:"fundraiserLocation" validate:"max=200"`
+	IndividualFundraiserDescription  string      `json:"fundraiserDescription" validate:"max=5000"`
+	IndividualFundraiserPhoto        string      `json:"fundraiserPhoto" validate:"url,max=2048"`
+	IndividualFundraiserTargetAmount money.Money `json:"fundraiserTargetAmount" validate:"required,currency,positive"`
+	IndividualRaisedAmount           money.Money `json:"raisedAmount"`
+	IndividualAvailableAmount        money.Money `json:"availableAmount"`
+	IndividualDonorCount             int64       `json:"donorCount"`
+	//Wallets are only changed through AddFundraiserIndividualWallet and RemoveFundraiserIndividualWallet
+	IndividualWallets []wallet.Wallet `json:"wallets,omitempty"`
+	//Pools are only attached through the matching pool endpoints
+	IndividualMatchingPoolIds []string `json:"matchingPoolIds,omitempty"`
+	//Personal data is only stored encrypted, see sealPii; phoneNo holds
+	//the plaintext of fundraisers from before encryption
+	IndividualPii           *pii.Envelope `json:"-" dynamodbav:"pii,omitempty"`
+	IndividualPhoneNoMasked string        `json:"-" dynamodbav:"phoneNoMasked,omitempty"`
+	IndividualLegacyPhoneNo string        `json:"-" dynamodbav:"phoneNo,omitempty"`
+	//Version counts every edit of the fundraiser and is sent as its ETag.
+	//Donations and payouts only change progress, which is not versioned.
+	IndividualVersion int64 `json:"version"`
 }
 
-func FetchFundraiserIndividual(emailId string, fundraiserId string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*FundraiserIndividual, error) {
-	//Modifying the key for DynamoDB
-	emailId = "Individual" + emailId
-	fundraiserId = "Fundraiser" + fundraiserId
-
-	//Macking Call for DynamoDB
-	input := &dynamodb.GetItemInput{
-		Key: map[string]*dynamodb.AttributeValue{
-			"pk": {
-				S: aws.String(emailId),
-			},
-			"sk": {
-				S: aws.String(fundraiserId),
-			},
+func IndividualFundraiserKey(emailId string, fundraiserId string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"pk": {
+			S: aws.String("Individual" + emailId),
 		},
-		TableName: aws.String(tableName),
-	}
-
-	result, err := dynaClient.GetItem(input)
-	if err != nil {
-		return nil, errors.New(ErrorFailedToFetchRecord)
-
-	}
-
-	//Sending the Get Request
-	item := new(FundraiserIndividual)
-	err = dynamodbattribute.UnmarshalMap(result.Item, item)
-	if err != nil {
-		return nil, errors.New(ErrorFailedToUnmarshalRecord)
-	}
-	return item, nil
-}
-func FetchFundraisersIndividual(emailId string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*[]FundraiserIndividual, error) {
-	//Modifying the key for DynamoDB Storage
-	emailId = "Individual" + emailId
-
-	//Macking Call for DynamoDB
-	input := &dynamodb.QueryInput{
-		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-			":pk": {
-				S: aws.String(emailId),
-			},
-			":sk": {
-				S: aws.String("Fundraiser"),
-			},
+		"sk": {
+			S: aws.String("Fundraiser" + fundraiserId),
 		},
-		KeyConditionExpression: aws.String("pk = :pk AND begins_with(sk, :sk)"),
-		TableName:              aws.String(tableName),
 	}
-
-	result, err := dynaClient.Query(input)
-	if err != nil {
-		return nil, errors.New(ErrorFailedToFetchRecord)
-
-	}
-
-	//Sending the Get Request
-	var items *[]FundraiserIndividual
-	err = dynamodbattribute.UnmarshalListOfMaps(result.Items, &items)
-	if err != nil {
-		return nil, errors.New(ErrorFailedToUnmarshalRecord)
-	}
-	return items, nil
 }
-func CreateFundraiserIndividual(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+
+func CreateFundraiserIndividual(req events.APIGatewayProxyRequest, fundraisers FundraiserRepository) (
 	*FundraiserIndividual,
 	error,
 ) {
@@ -98,29 +66,42 @@ func CreateFundraiserIndividual(req events.APIGatewayProxyRequest, tableName str
 	if err := json.Unmarshal([]byte(req.Body), &u); err != nil {
 		return nil, errors.New(ErrorInvalidUserData)
 	}
+	if err := validate.Struct(u); err != nil {
+		return nil, err
+	}
+	if _, err := auth.RequireOwner(req, u.IndividualEmailId); err != nil {
+		return nil, err
+	}
+
+	if err := u.IndividualFundraiserTargetAmount.Normalize(); err != nil {
+		return nil, err
+	}
 
 	//Modifying the key for DynamoDB Storage
+	entityId := u.IndividualEmailId + "#" + u.IndividualFundraiserId
 	u.IndividualEmailId = "Individual" + u.IndividualEmailId
 	u.IndividualFundraiserId = "Fundraiser" + u.IndividualFundraiserId
 
-	//Marshaling the data
-	av, err := dynamodbattribute.MarshalMap(u)
-	if err != nil {
-		return nil, errors.New(ErrorCouldNotMarshalItem)
+	//Progress is only ever changed by donations
+	u.IndividualRaisedAmount, u.IndividualAvailableAmount, _ = progressFor(u.IndividualFundraiserTargetAmount, nil, nil)
+	u.IndividualDonorCount = 0
+	u.IndividualVersion = 1
+	u.IndividualWallets = nil
+	u.IndividualMatchingPoolIds = nil
+	if err := u.sealPii(); err != nil {
+		return nil, err
 	}
+
 	//Puting it to DynamoDB
-	input := &dynamodb.PutItemInput{
-		Item:      av,
-		TableName: aws.String(tableName),
-	}
-	_, err = dynaClient.PutItem(input)
+	entry := audit.NewEntry("FundraiserIndividual", entityId, "createFundraiser", audit.ActorFromRequest(req), nil, u.redacted())
+	err := fundraisers.CreateIndividualFundraiser(&u, entry)
 	if err != nil {
-		return nil, errors.New(ErrorCouldNotDynamoPutItem)
+		return nil, err
 	}
 	return &u, nil
 }
 
-func UpdateFundraiserIndividual(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+func UpdateFundraiserIndividual(req events.APIGatewayProxyRequest, fundraisers FundraiserRepository) (
 	*FundraiserIndividual,
 	error,
 ) {
@@ -129,56 +110,202 @@ func UpdateFundraiserIndividual(req events.APIGatewayProxyRequest, tableName str
 	if err := json.Unmarshal([]byte(req.Body), &u); err != nil {
 		return nil, errors.New(ErrorInvalidUserData)
 	}
+	if err := validate.Struct(u); err != nil {
+		return nil, err
+	}
+	currentFundraiser, err := changeableIndividualFundraiser(req, u.IndividualEmailId, u.IndividualFundraiserId, fundraisers)
+	if err != nil {
+		return nil, err
+	}
+	return saveFundraiserIndividual(req, u, currentFundraiser, fundraisers)
+}
+
+// PatchFundraiserIndividual changes only the fields of the fundraiser
+// given by the emailId and fundraiserId query parameters that the JSON
+// Merge Patch body of req names
+func PatchFundraiserIndividual(req events.APIGatewayProxyRequest, fundraisers FundraiserRepository) (
+	*FundraiserIndividual,
+	error,
+) {
+	emailId := req.QueryStringParameters["emailId"]
+	fundraiserId := req.QueryStringParameters["fundraiserId"]
+	currentFundraiser, err := changeableIndividualFundraiser(req, emailId, fundraiserId, fundraisers)
+	if err != nil {
+		return nil, err
+	}
+
+	//Patching the fundraiser as its owner sees it, whose key is not theirs
+	//to change
+	target := *currentFundraiser
+	if err := target.Reveal(); err != nil {
+		return nil, err
+	}
+	target.IndividualEmailId = emailId
+	target.IndividualFundraiserId = fundraiserId
+	var u FundraiserIndividual
+	if err := patch.Merge(target, req.Body, &u); err != nil {
+		return nil, err
+	}
+	u.IndividualEmailId = emailId
+	u.IndividualFundraiserId = fundraiserId
+	if err := validate.Struct(u); err != nil {
+		return nil, err
+	}
+	return saveFundraiserIndividual(req, u, currentFundraiser, fundraisers)
+}
+
+// changeableIndividualFundraiser loads the fundraiser if the caller of req
+// owns it
+func changeableIndividualFundraiser(req events.APIGatewayProxyRequest, emailId string, fundraiserId string, fundraisers FundraiserRepository) (*FundraiserIndividual, error) {
+	if _, err := auth.RequireOwner(req, emailId); err != nil {
+		return nil, err
+	}
 
 	// Check if Fundraiser exists
-	currentFundraiser, _ := FetchFundraiserIndividual(u.IndividualEmailId, u.IndividualFundraiserId, tableName, dynaClient)
-	if currentFundraiser != nil && len(currentFundraiser.IndividualFundraiserId) == 0 {
+	currentFundraiser, err := fundraisers.GetIndividualFundraiser(emailId, fundraiserId)
+	if err != nil {
+		return nil, err
+	}
+	if len(currentFundraiser.IndividualFundraiserId) == 0 {
 		return nil, errors.New(ErrorUserDoesNotExists)
 	}
+	return currentFundraiser, nil
+}
+
+// saveFundraiserIndividual stores u over currentFundraiser, keeping the
+// progress, wallets and pools only other endpoints may change
+func saveFundraiserIndividual(req events.APIGatewayProxyRequest, u FundraiserIndividual, currentFundraiser *FundraiserIndividual, fundraisers FundraiserRepository) (*FundraiserIndividual, error) {
+	if err := etag.Check(req, currentFundraiser.IndividualVersion); err != nil {
+		return nil, err
+	}
+	if err := u.IndividualFundraiserTargetAmount.Normalize(); err != nil {
+		return nil, err
+	}
+	entityId := u.IndividualEmailId + "#" + u.IndividualFundraiserId
 	u.IndividualEmailId = "Individual" + u.IndividualEmailId
 	u.IndividualFundraiserId = "Fundraiser" + u.IndividualFundraiserId
 
-	// Saving it to DynamoDB
-	av, err := dynamodbattribute.MarshalMap(u)
+	//Progress is only ever changed by donations and payouts
+	u.IndividualDonorCount = currentFundraiser.IndividualDonorCount
+	u.IndividualVersion = currentFundraiser.IndividualVersion + 1
+	u.IndividualWallets = currentFundraiser.IndividualWallets
+	u.IndividualMatchingPoolIds = currentFundraiser.IndividualMatchingPoolIds
+	raised, available, err := progressFor(u.IndividualFundraiserTargetAmount, &currentFundraiser.IndividualRaisedAmount, &currentFundraiser.IndividualAvailableAmount)
 	if err != nil {
-		return nil, errors.New(ErrorCouldNotMarshalItem)
+		return nil, err
 	}
-	input := &dynamodb.PutItemInput{
-		Item:      av,
-		TableName: aws.String(tableName),
+	u.IndividualRaisedAmount = raised
+	u.IndividualAvailableAmount = available
+	if err := u.sealPii(); err != nil {
+		return nil, err
 	}
-	_, err = dynaClient.PutItem(input)
+
+	// Saving it to DynamoDB
+	entry := audit.NewEntry("FundraiserIndividual", entityId, "updateFundraiser", audit.ActorFromRequest(req), currentFundraiser.redacted(), u.redacted())
+	err = fundraisers.UpdateIndividualFundraiser(&u, currentFundraiser, entry)
 	if err != nil {
-		return nil, errors.New(ErrorCouldNotDynamoPutItem)
+		return nil, err
 	}
 	return &u, nil
 }
 
-func DeleteFundraiserIndividual(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+func DeleteFundraiserIndividual(req events.APIGatewayProxyRequest, fundraisers FundraiserRepository) error {
 	//emailId and fundraiserId from req
 	emailId := req.QueryStringParameters["emailId"]
 	fundraiserId := req.QueryStringParameters["fundraiserId"]
-
-	//Modifying the key for DynamoDB
-	emailId = "Individual" + emailId
-	fundraiserId = "Fundraiser" + fundraiserId
+	if _, err := auth.RequireOwner(req, emailId); err != nil {
+		return err
+	}
+	currentFundraiser, err := fundraisers.GetIndividualFundraiser(emailId, fundraiserId)
+	if err != nil {
+		return err
+	}
+	if len(currentFundraiser.IndividualFundraiserId) == 0 {
+		return errors.New(ErrorUserDoesNotExists)
+	}
+	if err := etag.Check(req, currentFundraiser.IndividualVersion); err != nil {
+		return err
+	}
+	entry := audit.NewEntry("FundraiserIndividual", emailId+"#"+fundraiserId, "deleteFundraiser", audit.ActorFromRequest(req), currentFundraiser.redacted(), nil)
 
 	//Deleting the Fundraiser
-	input := &dynamodb.DeleteItemInput{
-		Key: map[string]*dynamodb.AttributeValue{
-			"pk": {
-				S: aws.String(emailId),
-			},
-			"sk": {
-				S: aws.String(fundraiserId),
-			},
+	err = fundraisers.DeleteIndividualFundraiser(emailId, fundraiserId, currentFundraiser.IndividualVersion, entry)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+type FundraiserIndividualWalletRequest struct {
+	IndividualEmailId      string        `json:"emailId"`
+	IndividualFundraiserId string        `json:"fundraiserId"`
+	Wallet                 wallet.Wallet `json:"wallet"`
+}
+
+func AddFundraiserIndividualWallet(req events.APIGatewayProxyRequest, fundraisers FundraiserRepository) (
+	*FundraiserIndividual,
+	error,
+) {
+	//Checking if the correct request
+	var u FundraiserIndividualWalletRequest
+	if err := json.Unmarshal([]byte(req.Body), &u); err != nil {
+		return nil, errors.New(ErrorInvalidUserData)
+	}
+	return changeFundraiserIndividualWallets(req, u, "addWallet", wallet.Add, fundraisers)
+}
+
+func RemoveFundraiserIndividualWallet(req events.APIGatewayProxyRequest, fundraisers FundraiserRepository) (
+	*FundraiserIndividual,
+	error,
+) {
+	//emailId, fundraiserId and wallet from req
+	u := FundraiserIndividualWalletRequest{
+		IndividualEmailId:      req.QueryStringParameters["emailId"],
+		IndividualFundraiserId: req.QueryStringParameters["fundraiserId"],
+		Wallet: wallet.Wallet{
+			Chain:   req.QueryStringParameters["chain"],
+			Asset:   req.QueryStringParameters["asset"],
+			Address: req.QueryStringParameters["address"],
 		},
-		TableName: aws.String(tableName),
 	}
-	_, err := dynaClient.DeleteItem(input)
+	return changeFundraiserIndividualWallets(req, u, "removeWallet", wallet.Remove, fundraisers)
+}
+
+func changeFundraiserIndividualWallets(req events.APIGatewayProxyRequest, u FundraiserIndividualWalletRequest, action string, change func([]wallet.Wallet, wallet.Wallet) ([]wallet.Wallet, error), fundraisers FundraiserRepository) (
+	*FundraiserIndividual,
+	error,
+) {
+	if err := u.Wallet.Validate(); err != nil {
+		return nil, err
+	}
+	if _, err := auth.RequireOwner(req, u.IndividualEmailId); err != nil {
+		return nil, err
+	}
+
+	// Check if Fundraiser exists
+	currentFundraiser, err := fundraisers.GetIndividualFundraiser(u.IndividualEmailId, u.IndividualFundraiserId)
 	if err != nil {
-		return errors.New(ErrorCouldNotDeleteItem)
+		return nil, err
+	}
+	if len(currentFundraiser.IndividualFundraiserId) == 0 {
+		return nil, errors.New(ErrorUserDoesNotExists)
 	}
 
-	return nil
+	// Save wallets together with their audit entry
+	wallets, err := change(currentFundraiser.IndividualWallets, u.Wallet)
+	if err != nil {
+		return nil, err
+	}
+	entityId := u.IndividualEmailId + "#" + u.IndividualFundraiserId
+	entry := audit.NewEntry("FundraiserIndividual", entityId, action, audit.ActorFromRequest(req), currentFundraiser.IndividualWallets, wallets)
+	err = fundraisers.SaveIndividualWallets(u.IndividualEmailId, u.IndividualFundraiserId, currentFundraiser.IndividualWallets, wallets, entry)
+	if err != nil {
+		return nil, err
+	}
+	currentFundraiser.IndividualWallets = wallets
+	if err := currentFundraiser.Reveal(); err != nil {
+		return nil, err
+	}
+	return currentFundraiser, nil
 }
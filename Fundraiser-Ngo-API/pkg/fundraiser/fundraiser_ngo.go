@@ -1,14 +1,18 @@
 package fundraiser
 
 import (
+	"aws-lambda-api/pkg/audit"
+	"aws-lambda-api/pkg/etag"
+	"aws-lambda-api/pkg/money"
+	"aws-lambda-api/pkg/ngo"
+	"aws-lambda-api/pkg/patch"
+	"aws-lambda-api/pkg/validate"
 	"encoding/json"
 	"errors"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
-	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
-	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 )
 
 var (
@@ -20,82 +24,53 @@ var (
 	ErrorCouldNotDynamoPutItem   = "could not dynamo put item error"
 	ErrorUserAlreadyExists       = "user.User already exists"
 	ErrorUserDoesNotExists       = "user.User does not exist"
+	ErrorTargetCurrencyChange    = "target currency cannot change once donations are received"
 )
 
 type FundraiserNgo struct {
-	NgoId                  string `json:"pk"`
-	FundraiserId           string `json:"sk"`
-	FundraiserTitle        string `json:"fundraiserTitle"`
-	FundraiserCause        string `json:"fundraiserCause"`
-	FundraiserLocation     string `json:"fundraiserLocation"`
-	FundraiserDescription  string `json:"fundraiserDescription"`
-	FundraiserPhoto        string `json:"fundraiserPhoto"`
-	FundraiserTargetAmount string `json:"fundraiserTargetAmount"`
+	NgoId                  string      `json:"pk" validate:"required,id,max=64"`
+	FundraiserId           string      `json:"sk" validate:"required,id,max=64"`
+	FundraiserTitle        string      `json:"fundraiserTitle" validate:"required,max=200"`
+	FundraiserCause        string      `json:"fundraiserCause" validate:"required,max=200"`
+	FundraiserLocation     string      `json:"fundraiserLocation" validate:"max=200"`
+	FundraiserDescription  string      `json:"fundraiserDescription" validate:"max=5000"`
+	FundraiserPhoto        string      `json:"fundraiserPhoto" validate:"url,max=2048"`
+	FundraiserTargetAmount money.Money `json:"fundraiserTargetAmount" validate:"required,currency,positive"`
+	RaisedAmount           money.Money `json:"raisedAmount"`
+	AvailableAmount        money.Money `json:"availableAmount"`
+	DonorCount             int64       `json:"donorCount"`
+	//Pools are only attached through the matching pool endpoints
+	MatchingPoolIds []string `json:"matchingPoolIds,omitempty"`
+	//Version counts every edit of the fundraiser and is sent as its ETag.
+	//Donations and payouts only change progress, which is not versioned.
+	Version int64 `json:"version"`
 }
 
-func FetchFundraiserNgo(ngoId string, fundraiserId string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*FundraiserNgo, error) {
-	//Modifying the key for DynamoDB
-	ngoId = "Ngo" + ngoId
-	fundraiserId = "Fundraiser" + fundraiserId
-
-	//Macking Call for DynamoDB
-	input := &dynamodb.GetItemInput{
-		Key: map[string]*dynamodb.AttributeValue{
-			"pk": {
-				S: aws.String(ngoId),
-			},
-			"sk": {
-				S: aws.String(fundraiserId),
-			},
+func NgoFundraiserKey(ngoId string, fundraiserId string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"pk": {
+			S: aws.String("Ngo" + ngoId),
 		},
-		TableName: aws.String(tableName),
-	}
-
-	result, err := dynaClient.GetItem(input)
-	if err != nil {
-		return nil, errors.New(ErrorFailedToFetchRecord)
-
-	}
-
-	//Sending the Get Request
-	item := new(FundraiserNgo)
-	err = dynamodbattribute.UnmarshalMap(result.Item, item)
-	if err != nil {
-		return nil, errors.New(ErrorFailedToUnmarshalRecord)
-	}
-	return item, nil
-}
-func FetchFundraisersNgo(ngoId string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*[]FundraiserNgo, error) {
-	//Modifying the key for DynamoDB Storage
-	ngoId = "Ngo" + ngoId
-
-	//Macking Call for DynamoDB
-	input := &dynamodb.QueryInput{
-		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-			":pk": {
-				S: aws.String(ngoId),
-			},
-			":sk": {
-				S: aws.String("Fundraiser"),
-			},
+		"sk": {
+			S: aws.String("Fundraiser" + fundraiserId),
 		},
-		KeyConditionExpression: aws.String("pk = :pk AND begins_with(sk, :sk)"),
-		TableName:              aws.String(tableName),
 	}
+}
 
-	result, err := dynaClient.Query(input)
-	if err != nil {
-		return nil, errors.New(ErrorFailedToFetchRecord)
+// progressFor keeps the raised and available amounts in the target's
+// currency. The currency may only change while nothing has been raised.
+func progressFor(target money.Money, raised *money.Money, available *money.Money) (money.Money, money.Money, error) {
+	if raised == nil || raised.Amount == 0 {
+		zero := money.Money{Amount: 0, Currency: target.Currency}
+		return zero, zero, nil
 	}
-
-	var items *[]FundraiserNgo
-	err = dynamodbattribute.UnmarshalListOfMaps(result.Items, &items)
-	if err != nil {
-		return nil, errors.New(ErrorFailedToUnmarshalRecord)
+	if raised.Currency != target.Currency {
+		return money.Money{}, money.Money{}, errors.New(ErrorTargetCurrencyChange)
 	}
-	return items, nil
+	return *raised, *available, nil
 }
-func CreateFundraiserNgo(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+
+func CreateFundraiserNgo(req events.APIGatewayProxyRequest, fundraisers FundraiserRepository, ngos ngo.NgoRepository) (
 	*FundraiserNgo,
 	error,
 ) {
@@ -104,29 +79,38 @@ func CreateFundraiserNgo(req events.APIGatewayProxyRequest, tableName string, dy
 	if err := json.Unmarshal([]byte(req.Body), &u); err != nil {
 		return nil, errors.New(ErrorInvalidUserData)
 	}
+	if err := validate.Struct(u); err != nil {
+		return nil, err
+	}
+	if _, _, err := ngo.CheckPermission(req, u.NgoId, ngo.PermissionEditFundraisers, ngos); err != nil {
+		return nil, err
+	}
+
+	if err := u.FundraiserTargetAmount.Normalize(); err != nil {
+		return nil, err
+	}
 
 	//Modifying the key for DynamoDB Storage
+	entityId := u.NgoId + "#" + u.FundraiserId
 	u.NgoId = "Ngo" + u.NgoId
 	u.FundraiserId = "Fundraiser" + u.FundraiserId
 
-	//Marshaling the data
-	av, err := dynamodbattribute.MarshalMap(u)
-	if err != nil {
-		return nil, errors.New(ErrorCouldNotMarshalItem)
-	}
+	//Progress is only ever changed by donations
+	u.RaisedAmount, u.AvailableAmount, _ = progressFor(u.FundraiserTargetAmount, nil, nil)
+	u.DonorCount = 0
+	u.Version = 1
+	u.MatchingPoolIds = nil
+
 	//Puting it to DynamoDB
-	input := &dynamodb.PutItemInput{
-		Item:      av,
-		TableName: aws.String(tableName),
-	}
-	_, err = dynaClient.PutItem(input)
+	entry := audit.NewEntry("FundraiserNgo", entityId, "createFundraiser", audit.ActorFromRequest(req), nil, u)
+	err := fundraisers.CreateNgoFundraiser(&u, entry)
 	if err != nil {
-		return nil, errors.New(ErrorCouldNotDynamoPutItem)
+		return nil, err
 	}
 	return &u, nil
 }
 
-func UpdateFundraiserNgo(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+func UpdateFundraiserNgo(req events.APIGatewayProxyRequest, fundraisers FundraiserRepository, ngos ngo.NgoRepository) (
 	*FundraiserNgo,
 	error,
 ) {
@@ -135,52 +119,120 @@ func UpdateFundraiserNgo(req events.APIGatewayProxyRequest, tableName string, dy
 	if err := json.Unmarshal([]byte(req.Body), &u); err != nil {
 		return nil, errors.New(ErrorInvalidUserData)
 	}
+	if err := validate.Struct(u); err != nil {
+		return nil, err
+	}
+	currentFundraiser, err := changeableNgoFundraiser(req, u.NgoId, u.FundraiserId, fundraisers, ngos)
+	if err != nil {
+		return nil, err
+	}
+	return saveFundraiserNgo(req, u, currentFundraiser, fundraisers)
+}
+
+// PatchFundraiserNgo changes only the fields of the fundraiser given by the
+// ngoId and fundraiserId query parameters that the JSON Merge Patch body
+// of req names
+func PatchFundraiserNgo(req events.APIGatewayProxyRequest, fundraisers FundraiserRepository, ngos ngo.NgoRepository) (
+	*FundraiserNgo,
+	error,
+) {
+	ngoId := req.QueryStringParameters["ngoId"]
+	fundraiserId := req.QueryStringParameters["fundraiserId"]
+	currentFundraiser, err := changeableNgoFundraiser(req, ngoId, fundraiserId, fundraisers, ngos)
+	if err != nil {
+		return nil, err
+	}
+
+	//Patching the fundraiser as clients send it, whose key is not theirs to change
+	var u FundraiserNgo
+	target := *currentFundraiser
+	target.NgoId = ngoId
+	target.FundraiserId = fundraiserId
+	if err := patch.Merge(target, req.Body, &u); err != nil {
+		return nil, err
+	}
+	u.NgoId = ngoId
+	u.FundraiserId = fundraiserId
+	if err := validate.Struct(u); err != nil {
+		return nil, err
+	}
+	return saveFundraiserNgo(req, u, currentFundraiser, fundraisers)
+}
+
+// changeableNgoFundraiser loads the fundraiser if the caller of req may
+// change it
+func changeableNgoFundraiser(req events.APIGatewayProxyRequest, ngoId string, fundraiserId string, fundraisers FundraiserRepository, ngos ngo.NgoRepository) (*FundraiserNgo, error) {
+	if _, _, err := ngo.CheckPermission(req, ngoId, ngo.PermissionEditFundraisers, ngos); err != nil {
+		return nil, err
+	}
 
 	// Check if Fundraiser exists
-	currentFundraiser, _ := FetchFundraiserNgo(u.NgoId, u.FundraiserId, tableName, dynaClient)
-	if currentFundraiser != nil && len(currentFundraiser.FundraiserId) == 0 {
+	currentFundraiser, err := fundraisers.GetNgoFundraiser(ngoId, fundraiserId)
+	if err != nil {
+		return nil, err
+	}
+	if len(currentFundraiser.FundraiserId) == 0 {
 		return nil, errors.New(ErrorUserDoesNotExists)
 	}
-	// Saveing it DynamoDB
-	av, err := dynamodbattribute.MarshalMap(u)
-	if err != nil {
-		return nil, errors.New(ErrorCouldNotMarshalItem)
+	return currentFundraiser, nil
+}
+
+// saveFundraiserNgo stores u over currentFundraiser, keeping the progress
+// and pools only donations, payouts and matching may change
+func saveFundraiserNgo(req events.APIGatewayProxyRequest, u FundraiserNgo, currentFundraiser *FundraiserNgo, fundraisers FundraiserRepository) (*FundraiserNgo, error) {
+	if err := etag.Check(req, currentFundraiser.Version); err != nil {
+		return nil, err
 	}
-	input := &dynamodb.PutItemInput{
-		Item:      av,
-		TableName: aws.String(tableName),
+	if err := u.FundraiserTargetAmount.Normalize(); err != nil {
+		return nil, err
 	}
-	_, err = dynaClient.PutItem(input)
+	entityId := u.NgoId + "#" + u.FundraiserId
+	u.NgoId = "Ngo" + u.NgoId
+	u.FundraiserId = "Fundraiser" + u.FundraiserId
+
+	//Progress is only ever changed by donations and payouts
+	u.DonorCount = currentFundraiser.DonorCount
+	u.Version = currentFundraiser.Version + 1
+	u.MatchingPoolIds = currentFundraiser.MatchingPoolIds
+	raised, available, err := progressFor(u.FundraiserTargetAmount, &currentFundraiser.RaisedAmount, &currentFundraiser.AvailableAmount)
+	if err != nil {
+		return nil, err
+	}
+	u.RaisedAmount = raised
+	u.AvailableAmount = available
+
+	// Saveing it DynamoDB
+	entry := audit.NewEntry("FundraiserNgo", entityId, "updateFundraiser", audit.ActorFromRequest(req), *currentFundraiser, u)
+	err = fundraisers.UpdateNgoFundraiser(&u, currentFundraiser, entry)
 	if err != nil {
-		return nil, errors.New(ErrorCouldNotDynamoPutItem)
+		return nil, err
 	}
 	return &u, nil
 }
 
-func DeleteFundraiserNgo(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+func DeleteFundraiserNgo(req events.APIGatewayProxyRequest, fundraisers FundraiserRepository, ngos ngo.NgoRepository) error {
 	//ngoId and fundraiserId from req
 	ngoId := req.QueryStringParameters["ngoId"]
 	fundraiserId := req.QueryStringParameters["fundraiserId"]
-
-	//Modifying the key for DynamoDB
-	ngoId = "Ngo" + ngoId
-	fundraiserId = "Fundraiser" + fundraiserId
+	if _, _, err := ngo.CheckPermission(req, ngoId, ngo.PermissionDeleteFundraisers, ngos); err != nil {
+		return err
+	}
+	currentFundraiser, err := fundraisers.GetNgoFundraiser(ngoId, fundraiserId)
+	if err != nil {
+		return err
+	}
+	if len(currentFundraiser.FundraiserId) == 0 {
+		return errors.New(ErrorUserDoesNotExists)
+	}
+	if err := etag.Check(req, currentFundraiser.Version); err != nil {
+		return err
+	}
+	entry := audit.NewEntry("FundraiserNgo", ngoId+"#"+fundraiserId, "deleteFundraiser", audit.ActorFromRequest(req), *currentFundraiser, nil)
 
 	//Deleting the Fundraiser
-	input := &dynamodb.DeleteItemInput{
-		Key: map[string]*dynamodb.AttributeValue{
-			"pk": {
-				S: aws.String(ngoId),
-			},
-			"sk": {
-				S: aws.String(fundraiserId),
-			},
-		},
-		TableName: aws.String(tableName),
-	}
-	_, err := dynaClient.DeleteItem(input)
+	err = fundraisers.DeleteNgoFundraiser(ngoId, fundraiserId, currentFundraiser.Version, entry)
 	if err != nil {
-		return errors.New(ErrorCouldNotDeleteItem)
+		return err
 	}
 
 	return nil
@@ -0,0 +1,92 @@
+package fundraiser
+
+import (
+	"aws-lambda-api/pkg/auth"
+	"aws-lambda-api/pkg/pii"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// individualPii is the personal data sealed in a fundraiser's envelope
+type individualPii struct {
+	PhoneNo string `json:"phoneNo"`
+}
+
+// piiContext binds an envelope to the item it is stored in
+func (u *FundraiserIndividual) piiContext() string {
+	return u.IndividualEmailId + "|" + u.IndividualFundraiserId
+}
+
+// sealPii encrypts the personal data of u, which must already have its
+// storage keys, and keeps a masked copy for public responses
+func (u *FundraiserIndividual) sealPii() error {
+	u.IndividualPii = nil
+	u.IndividualPhoneNoMasked = ""
+	u.IndividualLegacyPhoneNo = ""
+	if u.IndividualPhoneNo == "" {
+		return nil
+	}
+	plaintext, err := json.Marshal(individualPii{PhoneNo: u.IndividualPhoneNo})
+	if err != nil {
+		return errors.New(ErrorCouldNotMarshalItem)
+	}
+	u.IndividualPii, err = pii.Seal(plaintext, u.piiContext())
+	if err != nil {
+		return err
+	}
+	u.IndividualPhoneNoMasked = pii.MaskPhone(u.IndividualPhoneNo)
+	return nil
+}
+
+// Reveal decrypts the personal data of a fetched fundraiser
+func (u *FundraiserIndividual) Reveal() error {
+	if u.IndividualPii == nil {
+		u.IndividualPhoneNo = u.IndividualLegacyPhoneNo
+		return nil
+	}
+	plaintext, err := pii.Open(u.IndividualPii, u.piiContext())
+	if err != nil {
+		return err
+	}
+	var data individualPii
+	if err := json.Unmarshal(plaintext, &data); err != nil {
+		return errors.New(ErrorFailedToUnmarshalRecord)
+	}
+	u.IndividualPhoneNo = data.PhoneNo
+	return nil
+}
+
+// Mask replaces the personal data of a fetched fundraiser, including the
+// email in its key, with masked values
+func (u *FundraiserIndividual) Mask() {
+	u.IndividualPhoneNo = u.maskedPhoneNo()
+	u.IndividualEmailId = "Individual" + pii.MaskEmail(strings.TrimPrefix(u.IndividualEmailId, "Individual"))
+}
+
+// ForCaller reveals the personal data of a fetched fundraiser to its owner
+// and admins, and masks it for everyone else
+func (u *FundraiserIndividual) ForCaller(req events.APIGatewayProxyRequest) error {
+	caller, err := auth.FromRequest(req)
+	if err == nil && caller.Owns(strings.TrimPrefix(u.IndividualEmailId, "Individual")) {
+		return u.Reveal()
+	}
+	u.Mask()
+	return nil
+}
+
+// redacted is a copy of u safe to keep in the audit log
+func (u FundraiserIndividual) redacted() FundraiserIndividual {
+	u.IndividualPhoneNo = u.maskedPhoneNo()
+	u.IndividualLegacyPhoneNo = ""
+	return u
+}
+
+func (u *FundraiserIndividual) maskedPhoneNo() string {
+	if u.IndividualPii == nil {
+		return pii.MaskPhone(u.IndividualLegacyPhoneNo)
+	}
+	return u.IndividualPhoneNoMasked
+}
@@ -0,0 +1,110 @@
+package fundraiser
+
+import (
+	"aws-lambda-api/pkg/auth"
+	"aws-lambda-api/pkg/ngo"
+	"aws-lambda-api/pkg/wallet"
+	"errors"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+var (
+	ErrorFundraiserNotSpecified = "exactly one of ngoId or emailId is required"
+	ErrorFundraiserDoesNotExist = "fundraiser does not exist"
+)
+
+// Target is the money-related view of a fundraiser of either kind, used
+// by the packages that move money in or out of it.
+type Target struct {
+	Key map[string]*dynamodb.AttributeValue
+	//Ledger is the partition of the fundraiser's donations and payouts
+	Ledger   string
+	Currency string
+	//Wallets receiving the fundraiser's money; NGO fundraisers are paid
+	//to the NGO's own wallets
+	Wallets []wallet.Wallet
+	//Matching pools in the order they were attached
+	MatchingPoolIds []string
+}
+
+// LedgerKey is the partition holding the donations and payouts of the
+// fundraiser owned by exactly one of ngoId or emailId. Fundraisers of
+// different owners may share an id, so the owner is part of it. Without
+// a fundraiserId it is the general fund of the NGO.
+func LedgerKey(ngoId string, emailId string, fundraiserId string) (string, error) {
+	switch {
+	case ngoId != "" && emailId == "" && fundraiserId == "":
+		return "Ngo" + ngoId, nil
+	case ngoId != "" && emailId == "":
+		return "Ngo" + ngoId + "#Fundraiser" + fundraiserId, nil
+	case emailId != "" && ngoId == "" && fundraiserId != "":
+		return "Individual" + emailId + "#Fundraiser" + fundraiserId, nil
+	}
+	return "", errors.New(ErrorFundraiserNotSpecified)
+}
+
+// FetchTarget loads the fundraiser owned by exactly one of ngoId or
+// emailId. Wallets are only loaded when withWallets is set.
+func FetchTarget(ngoId string, emailId string, fundraiserId string, withWallets bool, fundraisers FundraiserRepository, ngos ngo.NgoRepository) (*Target, error) {
+	ledger, err := LedgerKey(ngoId, emailId, fundraiserId)
+	if err != nil || fundraiserId == "" {
+		return nil, errors.New(ErrorFundraiserNotSpecified)
+	}
+	t := &Target{Ledger: ledger}
+	switch {
+	case ngoId != "" && emailId == "":
+		f, err := fundraisers.GetNgoFundraiser(ngoId, fundraiserId)
+		if err != nil {
+			return nil, err
+		}
+		if len(f.FundraiserId) == 0 {
+			return nil, errors.New(ErrorFundraiserDoesNotExist)
+		}
+		t.Key = NgoFundraiserKey(ngoId, fundraiserId)
+		t.Currency = f.RaisedAmount.Currency
+		t.MatchingPoolIds = f.MatchingPoolIds
+		if withWallets {
+			n, err := ngos.GetNgo(ngoId)
+			if err != nil {
+				return nil, err
+			}
+			t.Wallets = n.NgoWallets
+		}
+	case emailId != "" && ngoId == "":
+		f, err := fundraisers.GetIndividualFundraiser(emailId, fundraiserId)
+		if err != nil {
+			return nil, err
+		}
+		if len(f.IndividualFundraiserId) == 0 {
+			return nil, errors.New(ErrorFundraiserDoesNotExist)
+		}
+		t.Key = IndividualFundraiserKey(emailId, fundraiserId)
+		t.Currency = f.IndividualRaisedAmount.Currency
+		t.Wallets = f.IndividualWallets
+		t.MatchingPoolIds = f.IndividualMatchingPoolIds
+	default:
+		return nil, errors.New(ErrorFundraiserNotSpecified)
+	}
+	return t, nil
+}
+
+// CheckPermission loads the fundraiser like FetchTarget if the caller of
+// req may change it. NGO fundraisers need the NGO permission; individual
+// fundraisers may only be changed by their owner.
+func CheckPermission(req events.APIGatewayProxyRequest, ngoId string, emailId string, fundraiserId string, permission string, withWallets bool, fundraisers FundraiserRepository, ngos ngo.NgoRepository) (*Target, error) {
+	switch {
+	case ngoId != "" && emailId == "":
+		if _, _, err := ngo.CheckPermission(req, ngoId, permission, ngos); err != nil {
+			return nil, err
+		}
+	case emailId != "" && ngoId == "":
+		if _, err := auth.RequireOwner(req, emailId); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New(ErrorFundraiserNotSpecified)
+	}
+	return FetchTarget(ngoId, emailId, fundraiserId, withWallets, fundraisers, ngos)
+}
@@ -0,0 +1,358 @@
+package fundraiser
+
+import (
+	"aws-lambda-api/pkg/audit"
+	"aws-lambda-api/pkg/etag"
+	"aws-lambda-api/pkg/page"
+	"aws-lambda-api/pkg/patch"
+	"aws-lambda-api/pkg/storage"
+	"aws-lambda-api/pkg/wallet"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// FundraiserRepository stores the fundraisers of NGOs and individuals.
+// Fundraisers are passed with their stored keys, and each write records
+// its audit entry together with the change. Creating a fundraiser that
+// exists fails with ErrorUserAlreadyExists, and changing one that does not
+// with ErrorUserDoesNotExists. Changes are only made to a fundraiser still
+// at the version of current, and fail with etag.ErrorPreconditionFailed
+// otherwise. Updates only write the attributes that differ from current,
+// so attributes the fundraiser types do not know are kept. Lists return
+// the page asked for and the key the next page starts after, nil on the
+// last page. Wallets are only replaced while they are still previous, and
+// fail with wallet.ErrorWalletsChangedOrGone otherwise.
+type FundraiserRepository interface {
+	// GetNgoFundraiser returns an empty fundraiser when there is none
+	GetNgoFundraiser(ngoId string, fundraiserId string) (*FundraiserNgo, error)
+	ListNgoFundraisers(ngoId string, p page.Request) (*[]FundraiserNgo, *storage.Key, error)
+	CreateNgoFundraiser(u *FundraiserNgo, entry *audit.Entry) error
+	UpdateNgoFundraiser(u *FundraiserNgo, current *FundraiserNgo, entry *audit.Entry) error
+	DeleteNgoFundraiser(ngoId string, fundraiserId string, previous int64, entry *audit.Entry) error
+
+	// GetIndividualFundraiser returns an empty fundraiser when there is none
+	GetIndividualFundraiser(emailId string, fundraiserId string) (*FundraiserIndividual, error)
+	ListIndividualFundraisers(emailId string, p page.Request) (*[]FundraiserIndividual, *storage.Key, error)
+	CreateIndividualFundraiser(u *FundraiserIndividual, entry *audit.Entry) error
+	UpdateIndividualFundraiser(u *FundraiserIndividual, current *FundraiserIndividual, entry *audit.Entry) error
+	DeleteIndividualFundraiser(emailId string, fundraiserId string, previous int64, entry *audit.Entry) error
+	// SaveIndividualWallets replaces the wallets of the fundraiser and
+	// counts its version up
+	SaveIndividualWallets(emailId string, fundraiserId string, previous []wallet.Wallet, wallets []wallet.Wallet, entry *audit.Entry) error
+}
+
+// DynamoFundraiserRepository keeps fundraisers in the table
+type DynamoFundraiserRepository struct {
+	tableName  string
+	dynaClient dynamodbiface.DynamoDBAPI
+}
+
+func NewDynamoFundraiserRepository(tableName string, dynaClient dynamodbiface.DynamoDBAPI) *DynamoFundraiserRepository {
+	return &DynamoFundraiserRepository{tableName: tableName, dynaClient: dynaClient}
+}
+
+func (r *DynamoFundraiserRepository) GetNgoFundraiser(ngoId string, fundraiserId string) (*FundraiserNgo, error) {
+	item := new(FundraiserNgo)
+	if err := r.get(NgoFundraiserKey(ngoId, fundraiserId), item); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func (r *DynamoFundraiserRepository) ListNgoFundraisers(ngoId string, p page.Request) (*[]FundraiserNgo, *storage.Key, error) {
+	items := []FundraiserNgo{}
+	next, err := r.query("Ngo"+ngoId, p, &items)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &items, next, nil
+}
+
+func (r *DynamoFundraiserRepository) CreateNgoFundraiser(u *FundraiserNgo, entry *audit.Entry) error {
+	return r.put(u, entry)
+}
+
+func (r *DynamoFundraiserRepository) UpdateNgoFundraiser(u *FundraiserNgo, current *FundraiserNgo, entry *audit.Entry) error {
+	return r.update(u, current, current.Version, entry)
+}
+
+func (r *DynamoFundraiserRepository) DeleteNgoFundraiser(ngoId string, fundraiserId string, previous int64, entry *audit.Entry) error {
+	return r.delete(NgoFundraiserKey(ngoId, fundraiserId), previous, entry)
+}
+
+func (r *DynamoFundraiserRepository) GetIndividualFundraiser(emailId string, fundraiserId string) (*FundraiserIndividual, error) {
+	item := new(FundraiserIndividual)
+	if err := r.get(IndividualFundraiserKey(emailId, fundraiserId), item); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func (r *DynamoFundraiserRepository) ListIndividualFundraisers(emailId string, p page.Request) (*[]FundraiserIndividual, *storage.Key, error) {
+	items := []FundraiserIndividual{}
+	next, err := r.query("Individual"+emailId, p, &items)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &items, next, nil
+}
+
+func (r *DynamoFundraiserRepository) CreateIndividualFundraiser(u *FundraiserIndividual, entry *audit.Entry) error {
+	return r.put(u, entry)
+}
+
+func (r *DynamoFundraiserRepository) UpdateIndividualFundraiser(u *FundraiserIndividual, current *FundraiserIndividual, entry *audit.Entry) error {
+	return r.update(u, current, current.IndividualVersion, entry)
+}
+
+func (r *DynamoFundraiserRepository) DeleteIndividualFundraiser(emailId string, fundraiserId string, previous int64, entry *audit.Entry) error {
+	return r.delete(IndividualFundraiserKey(emailId, fundraiserId), previous, entry)
+}
+
+func (r *DynamoFundraiserRepository) SaveIndividualWallets(emailId string, fundraiserId string, previous []wallet.Wallet, wallets []wallet.Wallet, entry *audit.Entry) error {
+	return wallet.Save(IndividualFundraiserKey(emailId, fundraiserId), "wallets", previous, wallets, entry, r.tableName, r.dynaClient)
+}
+
+func (r *DynamoFundraiserRepository) get(key map[string]*dynamodb.AttributeValue, item interface{}) error {
+	//Macking Call for DynamoDB
+	input := &dynamodb.GetItemInput{
+		Key:       key,
+		TableName: aws.String(r.tableName),
+	}
+
+	result, err := r.dynaClient.GetItem(input)
+	if err != nil {
+		return errors.New(ErrorFailedToFetchRecord)
+	}
+
+	//Sending the Get Request
+	err = dynamodbattribute.UnmarshalMap(result.Item, item)
+	if err != nil {
+		return errors.New(ErrorFailedToUnmarshalRecord)
+	}
+	return nil
+}
+
+func (r *DynamoFundraiserRepository) query(pk string, p page.Request, items interface{}) (*storage.Key, error) {
+	//Macking Call for DynamoDB
+	input := &dynamodb.QueryInput{
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {
+				S: aws.String(pk),
+			},
+			":sk": {
+				S: aws.String("Fundraiser"),
+			},
+		},
+		KeyConditionExpression: aws.String("pk = :pk AND begins_with(sk, :sk)"),
+		TableName:              aws.String(r.tableName),
+	}
+	return page.Query(input, pk, p, items, r.dynaClient)
+}
+
+func (r *DynamoFundraiserRepository) put(item interface{}, entry *audit.Entry) error {
+	//Marshaling the data
+	av, err := dynamodbattribute.MarshalMap(item)
+	if err != nil {
+		return errors.New(ErrorCouldNotMarshalItem)
+	}
+	//Puting it to DynamoDB
+	input := &dynamodb.PutItemInput{
+		Item:                av,
+		TableName:           aws.String(r.tableName),
+		ConditionExpression: aws.String("attribute_not_exists(sk)"),
+	}
+	err = audit.PutItem(input, entry, r.dynaClient)
+	if audit.ConditionFailed(err) {
+		return conditionError(audit.ItemExisted(err), ErrorUserAlreadyExists)
+	}
+	if err != nil {
+		return errors.New(ErrorCouldNotDynamoPutItem)
+	}
+	return nil
+}
+
+func (r *DynamoFundraiserRepository) update(item interface{}, current interface{}, previous int64, entry *audit.Entry) error {
+	changes, err := patch.Diff(current, item)
+	if err != nil {
+		return err
+	}
+	condition, values := etag.Condition(previous)
+	if resetsProgress(changes) {
+		//Donations do not change the version, so a reset must not lose one
+		if values == nil {
+			values = map[string]*dynamodb.AttributeValue{}
+		}
+		condition += " AND (attribute_not_exists(raisedAmount.amount) OR raisedAmount.amount = :zero)"
+		values[":zero"] = &dynamodb.AttributeValue{N: aws.String("0")}
+	}
+	err = audit.UpdateItem(changes.Input(r.tableName, condition, values), entry, r.dynaClient)
+	if audit.ConditionFailed(err) {
+		return conditionError(audit.ItemExisted(err), etag.ErrorPreconditionFailed)
+	}
+	if err != nil {
+		return errors.New(ErrorCouldNotDynamoPutItem)
+	}
+	return nil
+}
+
+func (r *DynamoFundraiserRepository) delete(key map[string]*dynamodb.AttributeValue, previous int64, entry *audit.Entry) error {
+	condition, values := etag.Condition(previous)
+	input := &dynamodb.DeleteItemInput{
+		Key:                       key,
+		TableName:                 aws.String(r.tableName),
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeValues: values,
+	}
+	err := audit.DeleteItem(input, entry, r.dynaClient)
+	if audit.ConditionFailed(err) {
+		return conditionError(audit.ItemExisted(err), etag.ErrorPreconditionFailed)
+	}
+	if err != nil {
+		return errors.New(ErrorCouldNotDeleteItem)
+	}
+	return nil
+}
+
+// MemoryFundraiserRepository keeps fundraisers in a storage.MemoryTable
+type MemoryFundraiserRepository struct {
+	table *storage.MemoryTable
+}
+
+func NewMemoryFundraiserRepository(table *storage.MemoryTable) *MemoryFundraiserRepository {
+	return &MemoryFundraiserRepository{table: table}
+}
+
+func (r *MemoryFundraiserRepository) GetNgoFundraiser(ngoId string, fundraiserId string) (*FundraiserNgo, error) {
+	item := new(FundraiserNgo)
+	if err := r.table.Get(storage.Key{PK: "Ngo" + ngoId, SK: "Fundraiser" + fundraiserId}, item); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func (r *MemoryFundraiserRepository) ListNgoFundraisers(ngoId string, p page.Request) (*[]FundraiserNgo, *storage.Key, error) {
+	items := []FundraiserNgo{}
+	next, err := r.query("Ngo"+ngoId, p, &items)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &items, next, nil
+}
+
+func (r *MemoryFundraiserRepository) CreateNgoFundraiser(u *FundraiserNgo, entry *audit.Entry) error {
+	return r.write(storage.Write{Put: u, Condition: storage.NotExists}, entry, ErrorUserAlreadyExists)
+}
+
+func (r *MemoryFundraiserRepository) UpdateNgoFundraiser(u *FundraiserNgo, current *FundraiserNgo, entry *audit.Entry) error {
+	return r.update(storage.Key{PK: u.NgoId, SK: u.FundraiserId}, u, current, current.Version, entry)
+}
+
+func (r *MemoryFundraiserRepository) DeleteNgoFundraiser(ngoId string, fundraiserId string, previous int64, entry *audit.Entry) error {
+	key := storage.Key{PK: "Ngo" + ngoId, SK: "Fundraiser" + fundraiserId}
+	return r.write(storage.Write{Delete: &key, Condition: storage.Version(previous)}, entry, etag.ErrorPreconditionFailed)
+}
+
+func (r *MemoryFundraiserRepository) GetIndividualFundraiser(emailId string, fundraiserId string) (*FundraiserIndividual, error) {
+	item := new(FundraiserIndividual)
+	if err := r.table.Get(storage.Key{PK: "Individual" + emailId, SK: "Fundraiser" + fundraiserId}, item); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func (r *MemoryFundraiserRepository) ListIndividualFundraisers(emailId string, p page.Request) (*[]FundraiserIndividual, *storage.Key, error) {
+	items := []FundraiserIndividual{}
+	next, err := r.query("Individual"+emailId, p, &items)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &items, next, nil
+}
+
+func (r *MemoryFundraiserRepository) CreateIndividualFundraiser(u *FundraiserIndividual, entry *audit.Entry) error {
+	return r.write(storage.Write{Put: u, Condition: storage.NotExists}, entry, ErrorUserAlreadyExists)
+}
+
+func (r *MemoryFundraiserRepository) UpdateIndividualFundraiser(u *FundraiserIndividual, current *FundraiserIndividual, entry *audit.Entry) error {
+	return r.update(storage.Key{PK: u.IndividualEmailId, SK: u.IndividualFundraiserId}, u, current, current.IndividualVersion, entry)
+}
+
+func (r *MemoryFundraiserRepository) DeleteIndividualFundraiser(emailId string, fundraiserId string, previous int64, entry *audit.Entry) error {
+	key := storage.Key{PK: "Individual" + emailId, SK: "Fundraiser" + fundraiserId}
+	return r.write(storage.Write{Delete: &key, Condition: storage.Version(previous)}, entry, etag.ErrorPreconditionFailed)
+}
+
+func (r *MemoryFundraiserRepository) SaveIndividualWallets(emailId string, fundraiserId string, previous []wallet.Wallet, wallets []wallet.Wallet, entry *audit.Entry) error {
+	key := storage.Key{PK: "Individual" + emailId, SK: "Fundraiser" + fundraiserId}
+	write, err := wallet.MemoryWrite(key, "wallets", previous, wallets)
+	if err != nil {
+		return err
+	}
+	err = r.table.Transact(write, storage.Write{Put: entry, Condition: storage.NotExists})
+	if _, ok := err.(*storage.ConditionError); ok {
+		return errors.New(wallet.ErrorWalletsChangedOrGone)
+	}
+	return err
+}
+
+func (r *MemoryFundraiserRepository) query(pk string, p page.Request, items interface{}) (*storage.Key, error) {
+	after, err := p.Start(pk)
+	if err != nil {
+		return nil, err
+	}
+	return r.table.QueryPage(pk, "Fundraiser", after, p.Limit, items)
+}
+
+func (r *MemoryFundraiserRepository) update(key storage.Key, item interface{}, current interface{}, previous int64, entry *audit.Entry) error {
+	changes, err := patch.Diff(current, item)
+	if err != nil {
+		return err
+	}
+	condition := storage.Version(previous)
+	if resetsProgress(changes) {
+		condition = func(item map[string]*dynamodb.AttributeValue) bool {
+			return storage.Version(previous)(item) && unraised(item)
+		}
+	}
+	write := storage.Write{Update: &key, Set: changes.Set, Remove: changes.Remove, Condition: condition}
+	return r.write(write, entry, etag.ErrorPreconditionFailed)
+}
+
+// resetsProgress reports whether changes set the progress, which edits
+// only do to move a fundraiser that has raised nothing to a new currency
+func resetsProgress(changes *patch.Changes) bool {
+	_, raised := changes.Set["raisedAmount"]
+	_, available := changes.Set["availableAmount"]
+	return raised || available
+}
+
+// unraised holds for a stored fundraiser that has raised nothing
+func unraised(item map[string]*dynamodb.AttributeValue) bool {
+	raised := item["raisedAmount"]
+	if raised == nil || raised.M == nil || raised.M["amount"] == nil {
+		return true
+	}
+	return aws.StringValue(raised.M["amount"].N) == "0"
+}
+
+func (r *MemoryFundraiserRepository) write(write storage.Write, entry *audit.Entry, conflict string) error {
+	err := r.table.Transact(write, storage.Write{Put: entry, Condition: storage.NotExists})
+	if cerr, ok := err.(*storage.ConditionError); ok {
+		return conditionError(cerr.Item != nil, conflict)
+	}
+	return err
+}
+
+// conditionError is conflict when a write was refused for the item that is
+// there, and ErrorUserDoesNotExists when there is none
+func conditionError(existed bool, conflict string) error {
+	if !existed {
+		return errors.New(ErrorUserDoesNotExists)
+	}
+	return errors.New(conflict)
+}
@@ -1,11 +1,24 @@
 package handlers
 
 import (
+	"aws-lambda-api/pkg/auth"
+	"aws-lambda-api/pkg/etag"
+	"aws-lambda-api/pkg/ngo"
+	"aws-lambda-api/pkg/validate"
+	"encoding/base64"
 	"encoding/json"
+	"net/http"
 
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
 )
 
+// ValidationErrorBody lists every rule a request body broke
+type ValidationErrorBody struct {
+	ErrorMsg *string         `json:"error,omitempty"`
+	Errors   validate.Errors `json:"errors"`
+}
+
 func apiResponse(status int, body interface{}) (*events.APIGatewayProxyResponse, error) {
 	resp := events.APIGatewayProxyResponse{Headers: map[string]string{"Content-Type": "application/json"}}
 	resp.StatusCode = status
@@ -13,3 +26,37 @@ func apiResponse(status int, body interface{}) (*events.APIGatewayProxyResponse,
 	resp.Body = string(stringBody)
 	return &resp, nil
 }
+
+// versionedResponse is apiResponse for an item at version, sent as its
+// ETag for the If-Match of later changes
+func versionedResponse(status int, body interface{}, version int64) (*events.APIGatewayProxyResponse, error) {
+	resp, err := apiResponse(status, body)
+	resp.Headers[etag.Header] = etag.Format(version)
+	return resp, err
+}
+
+func binaryResponse(status int, contentType string, body []byte) (*events.APIGatewayProxyResponse, error) {
+	resp := events.APIGatewayProxyResponse{Headers: map[string]string{"Content-Type": contentType}}
+	resp.StatusCode = status
+	resp.Body = base64.StdEncoding.EncodeToString(body)
+	resp.IsBase64Encoded = true
+	return &resp, nil
+}
+
+// errorResponse answers errors that have no more specific status
+func errorResponse(err error) (*events.APIGatewayProxyResponse, error) {
+	if errs, ok := err.(validate.Errors); ok {
+		return apiResponse(http.StatusUnprocessableEntity, ValidationErrorBody{aws.String(err.Error()), errs})
+	}
+	switch err.Error() {
+	case auth.ErrorUnauthenticated:
+		return apiResponse(http.StatusUnauthorized, ErrorBody{aws.String(err.Error())})
+	case auth.ErrorForbidden, ngo.ErrorNgoNotVerified:
+		return apiResponse(http.StatusForbidden, ErrorBody{aws.String(err.Error())})
+	case etag.ErrorPreconditionFailed:
+		return apiResponse(http.StatusPreconditionFailed, ErrorBody{aws.String(err.Error())})
+	case etag.ErrorPreconditionRequired:
+		return apiResponse(http.StatusPreconditionRequired, ErrorBody{aws.String(err.Error())})
+	}
+	return apiResponse(http.StatusBadRequest, ErrorBody{aws.String(err.Error())})
+}
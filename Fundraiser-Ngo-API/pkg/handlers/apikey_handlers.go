@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"aws-lambda-api/pkg/apikey"
+	"aws-lambda-api/pkg/page"
+	"aws-lambda-api/pkg/ratelimit"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// AuthorizeApiKey answers requests whose API key is invalid or lacks the
+// scope of route, and returns nil for requests that may go on. Keys are
+// only looked up while their source IP has failed key checks left, so
+// made-up keys cannot cost a read each.
+func AuthorizeApiKey(req events.APIGatewayProxyRequest, route string, limits *ratelimit.Limits, tableName string, dynaClient dynamodbiface.DynamoDBAPI) *events.APIGatewayProxyResponse {
+	if apikey.KeyId(req) != "" {
+		if allowed, retryAfter := ratelimit.AllowKeyCheck(req, limits, tableName, dynaClient); !allowed {
+			return tooManyRequests(retryAfter)
+		}
+	}
+	_, err := apikey.Authorize(req, route, tableName, dynaClient)
+	if err != nil {
+		if err.Error() == apikey.ErrorInvalidApiKey && apikey.KeyId(req) != "" {
+			ratelimit.FailKeyCheck(req, limits, tableName, dynaClient)
+		}
+		resp, _ := apiKeyErrorResponse(err)
+		return resp
+	}
+	return nil
+}
+
+func GetApiKeys(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	p, err := page.FromRequest(req)
+	if err != nil {
+		return apiResponse(http.StatusBadRequest, ErrorBody{aws.String(err.Error())})
+	}
+	result, next, err := apikey.FetchApiKeys(req, p, tableName, dynaClient)
+	if err != nil {
+		return apiKeyErrorResponse(err)
+	}
+	return apiResponse(http.StatusOK, page.NewList(result, next))
+}
+
+func CreateApiKey(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	result, err := apikey.CreateApiKey(req, tableName, dynaClient)
+	if err != nil {
+		return apiKeyErrorResponse(err)
+	}
+	return apiResponse(http.StatusCreated, result)
+}
+
+func RotateApiKey(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	result, err := apikey.RotateApiKey(req, tableName, dynaClient)
+	if err != nil {
+		return apiKeyErrorResponse(err)
+	}
+	return apiResponse(http.StatusOK, result)
+}
+
+func RevokeApiKey(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	result, err := apikey.RevokeApiKey(req, tableName, dynaClient)
+	if err != nil {
+		return apiKeyErrorResponse(err)
+	}
+	return apiResponse(http.StatusOK, result)
+}
+
+func apiKeyErrorResponse(err error) (*events.APIGatewayProxyResponse, error) {
+	switch err.Error() {
+	case apikey.ErrorInvalidApiKey:
+		return apiResponse(http.StatusUnauthorized, ErrorBody{aws.String(err.Error())})
+	case apikey.ErrorScopeNotGranted:
+		return apiResponse(http.StatusForbidden, ErrorBody{aws.String(err.Error())})
+	case apikey.ErrorApiKeyDoesNotExist:
+		return apiResponse(http.StatusNotFound, ErrorBody{aws.String(err.Error())})
+	case apikey.ErrorApiKeyChanged, apikey.ErrorApiKeyNotActive:
+		return apiResponse(http.StatusConflict, ErrorBody{aws.String(err.Error())})
+	}
+	return errorResponse(err)
+}
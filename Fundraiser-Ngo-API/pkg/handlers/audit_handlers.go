@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"aws-lambda-api/pkg/audit"
+	"aws-lambda-api/pkg/page"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+func GetAuditLog(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	p, err := page.FromRequest(req)
+	if err != nil {
+		return apiResponse(http.StatusBadRequest, ErrorBody{aws.String(err.Error())})
+	}
+	result, next, err := audit.FetchAuditLog(req, p, tableName, dynaClient)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return apiResponse(http.StatusOK, page.NewList(result, next))
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"aws-lambda-api/pkg/chain"
+	"aws-lambda-api/pkg/donation"
+	"aws-lambda-api/pkg/fundraiser"
+	"aws-lambda-api/pkg/money"
+	"aws-lambda-api/pkg/ngo"
+	"aws-lambda-api/pkg/page"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+func GetDonation(req events.APIGatewayProxyRequest, fundraisers fundraiser.FundraiserRepository, ngos ngo.NgoRepository, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	ngoId := req.QueryStringParameters["ngoId"]
+	emailId := req.QueryStringParameters["emailId"]
+	fundraiserId := req.QueryStringParameters["fundraiserId"]
+	donationId := req.QueryStringParameters["donationId"]
+	result, err := donation.FetchDonation(ngoId, emailId, fundraiserId, donationId, tableName, dynaClient)
+	if err != nil {
+		return donationErrorResponse(err)
+	}
+	items := []donation.Donation{*result}
+	donation.ForCaller(req, ngoId, emailId, fundraiserId, items, fundraisers, ngos)
+	return apiResponse(http.StatusOK, items[0])
+}
+func GetDonations(req events.APIGatewayProxyRequest, fundraisers fundraiser.FundraiserRepository, ngos ngo.NgoRepository, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	ngoId := req.QueryStringParameters["ngoId"]
+	emailId := req.QueryStringParameters["emailId"]
+	fundraiserId := req.QueryStringParameters["fundraiserId"]
+	p, err := page.FromRequest(req)
+	if err != nil {
+		return apiResponse(http.StatusBadRequest, ErrorBody{aws.String(err.Error())})
+	}
+	result, next, err := donation.FetchDonations(ngoId, emailId, fundraiserId, p, tableName, dynaClient)
+	if err != nil {
+		return donationErrorResponse(err)
+	}
+	donation.ForCaller(req, ngoId, emailId, fundraiserId, *result, fundraisers, ngos)
+	return apiResponse(http.StatusOK, page.NewList(result, next))
+}
+
+func CreateDonation(req events.APIGatewayProxyRequest, fundraisers fundraiser.FundraiserRepository, ngos ngo.NgoRepository, tableName string, dynaClient dynamodbiface.DynamoDBAPI, oracle money.PriceOracle) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	result, err := donation.CreateDonation(req, fundraisers, ngos, tableName, dynaClient, oracle)
+	if err != nil {
+		return donationErrorResponse(err)
+	}
+	return apiResponse(http.StatusCreated, result)
+}
+
+func VerifyDonation(req events.APIGatewayProxyRequest, fundraisers fundraiser.FundraiserRepository, ngos ngo.NgoRepository, tableName string, dynaClient dynamodbiface.DynamoDBAPI, verifier chain.Verifier, oracle money.PriceOracle) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	result, err := donation.VerifyDonation(req, fundraisers, ngos, tableName, dynaClient, verifier, oracle)
+	if err != nil {
+		if strings.HasPrefix(err.Error(), chain.ErrorNodeUnavailable) {
+			return apiResponse(http.StatusBadGateway, ErrorBody{aws.String(err.Error())})
+		}
+		return donationErrorResponse(err)
+	}
+	return apiResponse(http.StatusCreated, result)
+}
+
+func donationErrorResponse(err error) (*events.APIGatewayProxyResponse, error) {
+	switch err.Error() {
+	case donation.ErrorDonationDoesNotExist, donation.ErrorFundraiserDoesNotExist:
+		return apiResponse(http.StatusNotFound, ErrorBody{aws.String(err.Error())})
+	case donation.ErrorDonationAlreadyExists, donation.ErrorTransactionAlreadyUsed:
+		return apiResponse(http.StatusConflict, ErrorBody{aws.String(err.Error())})
+	}
+	return errorResponse(err)
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"aws-lambda-api/pkg/donor"
+	"aws-lambda-api/pkg/page"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+func GetDonor(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	donorId := req.QueryStringParameters["donorId"]
+	result, err := donor.FetchDonor(req, donorId, tableName, dynaClient)
+	if err != nil {
+		return donorErrorResponse(err)
+	}
+	return versionedResponse(http.StatusOK, result, result.Version)
+}
+
+func GetDonorDonations(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	donorId := req.QueryStringParameters["donorId"]
+	p, err := page.FromRequest(req)
+	if err != nil {
+		return apiResponse(http.StatusBadRequest, ErrorBody{aws.String(err.Error())})
+	}
+	result, next, err := donor.FetchDonorDonations(req, donorId, p, tableName, dynaClient)
+	if err != nil {
+		return donorErrorResponse(err)
+	}
+	return apiResponse(http.StatusOK, page.NewList(result, next))
+}
+
+func CreateDonor(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	result, err := donor.CreateDonor(req, tableName, dynaClient)
+	if err != nil {
+		return donorErrorResponse(err)
+	}
+	return versionedResponse(http.StatusCreated, result, result.Version)
+}
+
+func UpdateDonor(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	result, err := donor.UpdateDonor(req, tableName, dynaClient)
+	if err != nil {
+		return donorErrorResponse(err)
+	}
+	return versionedResponse(http.StatusOK, result, result.Version)
+}
+
+func donorErrorResponse(err error) (*events.APIGatewayProxyResponse, error) {
+	switch err.Error() {
+	case donor.ErrorDonorDoesNotExist:
+		return apiResponse(http.StatusNotFound, ErrorBody{aws.String(err.Error())})
+	case donor.ErrorDonorAlreadyExists:
+		return apiResponse(http.StatusConflict, ErrorBody{aws.String(err.Error())})
+	}
+	return errorResponse(err)
+}
@@ -2,72 +2,119 @@ package handlers
 
 import (
 	"aws-lambda-api/pkg/fundraiser"
+	"aws-lambda-api/pkg/page"
+	"errors"
 	"net/http"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 )
 
-func GetFundraiserIndividual(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+func GetFundraiserIndividual(req events.APIGatewayProxyRequest, fundraisers fundraiser.FundraiserRepository) (
 	*events.APIGatewayProxyResponse,
 	error,
 ) {
 	emailId := req.QueryStringParameters["emailId"]
 	fundraiserId := req.QueryStringParameters["fundraiserId"]
-	result, err := fundraiser.FetchFundraiserIndividual(emailId, fundraiserId, tableName, dynaClient)
+	result, err := fundraisers.GetIndividualFundraiser(emailId, fundraiserId)
 	if err != nil {
-		return apiResponse(http.StatusBadRequest, ErrorBody{aws.String(err.Error())})
+		return fundraiserErrorResponse(err)
 	}
-	return apiResponse(http.StatusOK, result)
+	if len(result.IndividualFundraiserId) == 0 {
+		return fundraiserErrorResponse(errors.New(fundraiser.ErrorUserDoesNotExists))
+	}
+	//Personal data is only shown in full to the owner and admins
+	if err := result.ForCaller(req); err != nil {
+		return apiResponse(http.StatusInternalServerError, ErrorBody{aws.String(err.Error())})
+	}
+	return versionedResponse(http.StatusOK, result, result.IndividualVersion)
 }
-func GetFundraisersIndividual(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+func GetFundraisersIndividual(req events.APIGatewayProxyRequest, fundraisers fundraiser.FundraiserRepository) (
 	*events.APIGatewayProxyResponse,
 	error,
 ) {
 	emailId := req.QueryStringParameters["emailId"]
-	result, err := fundraiser.FetchFundraisersIndividual(emailId, tableName, dynaClient)
+	p, err := page.FromRequest(req)
 	if err != nil {
 		return apiResponse(http.StatusBadRequest, ErrorBody{aws.String(err.Error())})
 	}
-	return apiResponse(http.StatusOK, result)
+	result, next, err := fundraisers.ListIndividualFundraisers(emailId, p)
+	if err != nil {
+		return apiResponse(http.StatusBadRequest, ErrorBody{aws.String(err.Error())})
+	}
+	//Personal data is only shown in full to the owner and admins
+	if result != nil {
+		for i := range *result {
+			if err := (*result)[i].ForCaller(req); err != nil {
+				return apiResponse(http.StatusInternalServerError, ErrorBody{aws.String(err.Error())})
+			}
+		}
+	}
+	return apiResponse(http.StatusOK, page.NewList(result, next))
 }
 
-func CreateFundraiserIndividual(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+func CreateFundraiserIndividual(req events.APIGatewayProxyRequest, fundraisers fundraiser.FundraiserRepository) (
 	*events.APIGatewayProxyResponse,
 	error,
 ) {
-	result, err := fundraiser.CreateFundraiserIndividual(req, tableName, dynaClient)
+	result, err := fundraiser.CreateFundraiserIndividual(req, fundraisers)
 	if err != nil {
-		return apiResponse(http.StatusBadRequest, ErrorBody{
-			aws.String(err.Error()),
-		})
+		return fundraiserErrorResponse(err)
 	}
-	return apiResponse(http.StatusCreated, result)
+	return versionedResponse(http.StatusCreated, result, result.IndividualVersion)
 }
 
-func UpdateFundraiserIndividual(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+func UpdateFundraiserIndividual(req events.APIGatewayProxyRequest, fundraisers fundraiser.FundraiserRepository) (
 	*events.APIGatewayProxyResponse,
 	error,
 ) {
-	result, err := fundraiser.UpdateFundraiserIndividual(req, tableName, dynaClient)
+	result, err := fundraiser.UpdateFundraiserIndividual(req, fundraisers)
 	if err != nil {
-		return apiResponse(http.StatusBadRequest, ErrorBody{
-			aws.String(err.Error()),
-		})
+		return fundraiserErrorResponse(err)
 	}
-	return apiResponse(http.StatusOK, result)
+	return versionedResponse(http.StatusOK, result, result.IndividualVersion)
+}
+
+func PatchFundraiserIndividual(req events.APIGatewayProxyRequest, fundraisers fundraiser.FundraiserRepository) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	result, err := fundraiser.PatchFundraiserIndividual(req, fundraisers)
+	if err != nil {
+		return fundraiserErrorResponse(err)
+	}
+	return versionedResponse(http.StatusOK, result, result.IndividualVersion)
 }
 
-func DeleteFundraiserIndividual(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+func DeleteFundraiserIndividual(req events.APIGatewayProxyRequest, fundraisers fundraiser.FundraiserRepository) (
 	*events.APIGatewayProxyResponse,
 	error,
 ) {
-	err := fundraiser.DeleteFundraiserIndividual(req, tableName, dynaClient)
+	err := fundraiser.DeleteFundraiserIndividual(req, fundraisers)
 	if err != nil {
-		return apiResponse(http.StatusBadRequest, ErrorBody{
-			aws.String(err.Error()),
-		})
+		return fundraiserErrorResponse(err)
 	}
 	return apiResponse(http.StatusOK, nil)
 }
+
+func AddFundraiserIndividualWallet(req events.APIGatewayProxyRequest, fundraisers fundraiser.FundraiserRepository) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	result, err := fundraiser.AddFundraiserIndividualWallet(req, fundraisers)
+	if err != nil {
+		return walletErrorResponse(err, fundraiser.ErrorUserDoesNotExists)
+	}
+	return apiResponse(http.StatusOK, result)
+}
+
+func RemoveFundraiserIndividualWallet(req events.APIGatewayProxyRequest, fundraisers fundraiser.FundraiserRepository) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	result, err := fundraiser.RemoveFundraiserIndividualWallet(req, fundraisers)
+	if err != nil {
+		return walletErrorResponse(err, fundraiser.ErrorUserDoesNotExists)
+	}
+	return apiResponse(http.StatusOK, result)
+}
@@ -2,11 +2,14 @@ package handlers
 
 import (
 	"aws-lambda-api/pkg/fundraiser"
+	"aws-lambda-api/pkg/matching"
+	"aws-lambda-api/pkg/ngo"
+	"aws-lambda-api/pkg/page"
+	"errors"
 	"net/http"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 )
 
 var ErrorMethodNotAllowed = "method Not allowed"
@@ -15,69 +18,98 @@ type ErrorBody struct {
 	ErrorMsg *string `json:"error,omitempty"`
 }
 
-func GetFundraiserNgo(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+func GetFundraiserNgo(req events.APIGatewayProxyRequest, fundraisers fundraiser.FundraiserRepository, pools matching.PoolRepository) (
 	*events.APIGatewayProxyResponse,
 	error,
 ) {
 	ngoId := req.QueryStringParameters["ngoId"]
 	fundraiserId := req.QueryStringParameters["fundraiserId"]
-	result, err := fundraiser.FetchFundraiserNgo(ngoId, fundraiserId, tableName, dynaClient)
+	result, err := fundraisers.GetNgoFundraiser(ngoId, fundraiserId)
 	if err != nil {
-		return apiResponse(http.StatusBadRequest, ErrorBody{aws.String(err.Error())})
+		return fundraiserErrorResponse(err)
+	}
+	if len(result.FundraiserId) == 0 {
+		return fundraiserErrorResponse(errors.New(fundraiser.ErrorUserDoesNotExists))
+	}
+
+	//Showing what is left to match next to the fundraiser
+	matched, err := matching.FetchPools(result.MatchingPoolIds, pools)
+	if err != nil {
+		return errorResponse(err)
 	}
-	return apiResponse(http.StatusOK, result)
+	return versionedResponse(http.StatusOK, fundraiserNgoResponse{result, poolBalances(matched)}, result.Version)
 }
-func GetFundraisersNgo(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+func GetFundraisersNgo(req events.APIGatewayProxyRequest, fundraisers fundraiser.FundraiserRepository) (
 	*events.APIGatewayProxyResponse,
 	error,
 ) {
 	ngoId := req.QueryStringParameters["ngoId"]
-	result, err := fundraiser.FetchFundraisersNgo(ngoId, tableName, dynaClient)
+	p, err := page.FromRequest(req)
 	if err != nil {
 		return apiResponse(http.StatusBadRequest, ErrorBody{aws.String(err.Error())})
 	}
-	return apiResponse(http.StatusOK, result)
+	result, next, err := fundraisers.ListNgoFundraisers(ngoId, p)
+	if err != nil {
+		return apiResponse(http.StatusBadRequest, ErrorBody{aws.String(err.Error())})
+	}
+	return apiResponse(http.StatusOK, page.NewList(result, next))
+}
+
+func CreateFundraiserNgo(req events.APIGatewayProxyRequest, fundraisers fundraiser.FundraiserRepository, ngos ngo.NgoRepository) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	result, err := fundraiser.CreateFundraiserNgo(req, fundraisers, ngos)
+	if err != nil {
+		return fundraiserErrorResponse(err)
+	}
+	return versionedResponse(http.StatusCreated, result, result.Version)
 }
 
-func CreateFundraiserNgo(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+func UpdateFundraiserNgo(req events.APIGatewayProxyRequest, fundraisers fundraiser.FundraiserRepository, ngos ngo.NgoRepository) (
 	*events.APIGatewayProxyResponse,
 	error,
 ) {
-	result, err := fundraiser.CreateFundraiserNgo(req, tableName, dynaClient)
+	result, err := fundraiser.UpdateFundraiserNgo(req, fundraisers, ngos)
 	if err != nil {
-		return apiResponse(http.StatusBadRequest, ErrorBody{
-			aws.String(err.Error()),
-		})
+		return fundraiserErrorResponse(err)
 	}
-	return apiResponse(http.StatusCreated, result)
+	return versionedResponse(http.StatusOK, result, result.Version)
 }
 
-func UpdateFundraiserNgo(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+func PatchFundraiserNgo(req events.APIGatewayProxyRequest, fundraisers fundraiser.FundraiserRepository, ngos ngo.NgoRepository) (
 	*events.APIGatewayProxyResponse,
 	error,
 ) {
-	result, err := fundraiser.UpdateFundraiserNgo(req, tableName, dynaClient)
+	result, err := fundraiser.PatchFundraiserNgo(req, fundraisers, ngos)
 	if err != nil {
-		return apiResponse(http.StatusBadRequest, ErrorBody{
-			aws.String(err.Error()),
-		})
+		return fundraiserErrorResponse(err)
 	}
-	return apiResponse(http.StatusOK, result)
+	return versionedResponse(http.StatusOK, result, result.Version)
 }
 
-func DeleteFundraiserNgo(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+func DeleteFundraiserNgo(req events.APIGatewayProxyRequest, fundraisers fundraiser.FundraiserRepository, ngos ngo.NgoRepository) (
 	*events.APIGatewayProxyResponse,
 	error,
 ) {
-	err := fundraiser.DeleteFundraiserNgo(req, tableName, dynaClient)
+	err := fundraiser.DeleteFundraiserNgo(req, fundraisers, ngos)
 	if err != nil {
-		return apiResponse(http.StatusBadRequest, ErrorBody{
-			aws.String(err.Error()),
-		})
+		return fundraiserErrorResponse(err)
 	}
 	return apiResponse(http.StatusOK, nil)
 }
 
+// fundraiserErrorResponse serves fundraisers of both kinds
+func fundraiserErrorResponse(err error) (*events.APIGatewayProxyResponse, error) {
+	switch err.Error() {
+	case fundraiser.ErrorUserDoesNotExists, fundraiser.ErrorFundraiserDoesNotExist:
+		return apiResponse(http.StatusNotFound, ErrorBody{aws.String(err.Error())})
+	case fundraiser.ErrorUserAlreadyExists:
+		return apiResponse(http.StatusConflict, ErrorBody{aws.String(err.Error())})
+	}
+	return errorResponse(err)
+}
+
 func UnhandledMethod() (*events.APIGatewayProxyResponse, error) {
 	return apiResponse(http.StatusMethodNotAllowed, ErrorMethodNotAllowed)
 }
@@ -0,0 +1,461 @@
+package handlers
+
+import (
+	"aws-lambda-api/pkg/etag"
+	"aws-lambda-api/pkg/fundraiser"
+	"aws-lambda-api/pkg/ngo"
+	"aws-lambda-api/pkg/storage"
+	"aws-lambda-api/pkg/update"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// repos are the memory repositories of one test, sharing a table
+type repos struct {
+	ngos        ngo.NgoRepository
+	fundraisers fundraiser.FundraiserRepository
+	updates     update.UpdateRepository
+}
+
+func newRepos() *repos {
+	table := storage.NewMemoryTable()
+	return &repos{
+		ngos:        ngo.NewMemoryNgoRepository(table),
+		fundraisers: fundraiser.NewMemoryFundraiserRepository(table),
+		updates:     update.NewMemoryUpdateRepository(table),
+	}
+}
+
+// request is a call by the user with email, or an anonymous one when
+// email is empty
+func request(email string, body interface{}, query map[string]string) events.APIGatewayProxyRequest {
+	req := events.APIGatewayProxyRequest{
+		Headers:               map[string]string{},
+		QueryStringParameters: query,
+	}
+	if body != nil {
+		raw, _ := json.Marshal(body)
+		req.Body = string(raw)
+	}
+	if email != "" {
+		req.RequestContext.Authorizer = map[string]interface{}{
+			"claims": map[string]interface{}{"sub": email, "email": email},
+		}
+	}
+	return req
+}
+
+func ifMatch(req events.APIGatewayProxyRequest, tag string) events.APIGatewayProxyRequest {
+	req.Headers[etag.IfMatchHeader] = tag
+	return req
+}
+
+func expectStatus(t *testing.T, resp *events.APIGatewayProxyResponse, err error, status int) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("handler error: %v", err)
+	}
+	if resp.StatusCode != status {
+		t.Fatalf("status = %d, want %d: %s", resp.StatusCode, status, resp.Body)
+	}
+}
+
+func decode(t *testing.T, resp *events.APIGatewayProxyResponse, out interface{}) {
+	t.Helper()
+	if err := json.Unmarshal([]byte(resp.Body), out); err != nil {
+		t.Fatalf("decoding %s: %v", resp.Body, err)
+	}
+}
+
+func ngoBody(ngoId string, name string) map[string]interface{} {
+	return map[string]interface{}{
+		"sk":          ngoId,
+		"ngoName":     name,
+		"ngoCountry":  "IN",
+		"ngoCategory": "education",
+		"ngoPhoto":    "https://example.org/logo.png",
+	}
+}
+
+func TestNgoLifecycle(t *testing.T) {
+	r := newRepos()
+	owner := "owner@example.org"
+
+	resp, err := CreateNgo(request(owner, ngoBody("helpers", "Helpers"), nil), r.ngos)
+	expectStatus(t, resp, err, http.StatusCreated)
+	if resp.Headers[etag.Header] != etag.Format(1) {
+		t.Fatalf("ETag = %q, want %q", resp.Headers[etag.Header], etag.Format(1))
+	}
+
+	resp, err = CreateNgo(request(owner, ngoBody("helpers", "Helpers"), nil), r.ngos)
+	expectStatus(t, resp, err, http.StatusConflict)
+
+	resp, err = GetNgo(request("", nil, map[string]string{"ngoId": "helpers"}), r.ngos)
+	expectStatus(t, resp, err, http.StatusOK)
+	var got ngo.Ngo
+	decode(t, resp, &got)
+	if got.NgoName != "Helpers" || got.NgoOwner != owner || got.VerificationStatus != ngo.VerificationUnverified {
+		t.Fatalf("GetNgo = %+v", got)
+	}
+
+	//Changes need the caller to own the NGO and the current version
+	changed := ngoBody("helpers", "Helpers United")
+	resp, err = UpdateNgo(request(owner, changed, nil), r.ngos)
+	expectStatus(t, resp, err, http.StatusPreconditionRequired)
+	resp, err = UpdateNgo(ifMatch(request("someone@example.org", changed, nil), etag.Format(1)), r.ngos)
+	expectStatus(t, resp, err, http.StatusForbidden)
+	resp, err = UpdateNgo(ifMatch(request(owner, changed, nil), etag.Format(1)), r.ngos)
+	expectStatus(t, resp, err, http.StatusOK)
+	if resp.Headers[etag.Header] != etag.Format(2) {
+		t.Fatalf("ETag = %q, want %q", resp.Headers[etag.Header], etag.Format(2))
+	}
+	resp, err = UpdateNgo(ifMatch(request(owner, changed, nil), etag.Format(1)), r.ngos)
+	expectStatus(t, resp, err, http.StatusPreconditionFailed)
+
+	resp, err = GetNgo(request("", nil, map[string]string{"ngoId": "helpers"}), r.ngos)
+	expectStatus(t, resp, err, http.StatusOK)
+	decode(t, resp, &got)
+	if got.NgoName != "Helpers United" || got.Version != 2 {
+		t.Fatalf("GetNgo after update = %+v", got)
+	}
+}
+
+func TestCreateNgoRequiresCaller(t *testing.T) {
+	r := newRepos()
+	resp, err := CreateNgo(request("", ngoBody("helpers", "Helpers"), nil), r.ngos)
+	expectStatus(t, resp, err, http.StatusUnauthorized)
+
+	invalid := ngoBody("helpers", "")
+	resp, err = CreateNgo(request("owner@example.org", invalid, nil), r.ngos)
+	expectStatus(t, resp, err, http.StatusUnprocessableEntity)
+
+	resp, err = GetNgo(request("", nil, map[string]string{"ngoId": "helpers"}), r.ngos)
+	expectStatus(t, resp, err, http.StatusNotFound)
+}
+
+func TestGetNgosHidesUnverified(t *testing.T) {
+	r := newRepos()
+	for _, id := range []string{"alpha", "beta"} {
+		resp, err := CreateNgo(request("owner@example.org", ngoBody(id, id), nil), r.ngos)
+		expectStatus(t, resp, err, http.StatusCreated)
+	}
+
+	var list struct {
+		Items []ngo.Ngo `json:"items"`
+	}
+	resp, err := GetNgos(request("", nil, map[string]string{}), r.ngos)
+	expectStatus(t, resp, err, http.StatusOK)
+	decode(t, resp, &list)
+	if len(list.Items) != 0 {
+		t.Fatalf("GetNgos listed %d unverified NGOs", len(list.Items))
+	}
+	resp, err = GetNgos(request("", nil, map[string]string{"includeUnverified": "true"}), r.ngos)
+	expectStatus(t, resp, err, http.StatusOK)
+	decode(t, resp, &list)
+	if len(list.Items) != 2 {
+		t.Fatalf("GetNgos listed %d NGOs, want 2", len(list.Items))
+	}
+}
+
+func fundraiserBody(email string, fundraiserId string) map[string]interface{} {
+	return map[string]interface{}{
+		"pk":                     email,
+		"sk":                     fundraiserId,
+		"firstname":              "Ada",
+		"lastname":               "Lovelace",
+		"fundraiserTitle":        "School books",
+		"fundraiserCause":        "education",
+		"fundraiserPhoto":        "https://example.org/books.png",
+		"fundraiserTargetAmount": map[string]interface{}{"amount": 100000, "currency": "USD"},
+	}
+}
+
+func TestFundraiserIndividualLifecycle(t *testing.T) {
+	r := newRepos()
+	owner := "ada@example.org"
+
+	//Individuals may only raise funds under their own email
+	resp, err := CreateFundraiserIndividual(request("eve@example.org", fundraiserBody(owner, "books"), nil), r.fundraisers)
+	expectStatus(t, resp, err, http.StatusForbidden)
+	resp, err = CreateFundraiserIndividual(request(owner, fundraiserBody(owner, "books"), nil), r.fundraisers)
+	expectStatus(t, resp, err, http.StatusCreated)
+
+	query := map[string]string{"emailId": owner, "fundraiserId": "books"}
+	resp, err = GetFundraiserIndividual(request("", nil, query), r.fundraisers)
+	expectStatus(t, resp, err, http.StatusOK)
+	var got fundraiser.FundraiserIndividual
+	decode(t, resp, &got)
+	if got.IndividualFundraiserTitle != "School books" || got.IndividualRaisedAmount.Amount != 0 || got.IndividualVersion != 1 {
+		t.Fatalf("GetFundraiserIndividual = %+v", got)
+	}
+
+	//Progress cannot be set by the owner
+	changed := fundraiserBody(owner, "books")
+	changed["fundraiserTitle"] = "Library books"
+	changed["raisedAmount"] = map[string]interface{}{"amount": 100000, "currency": "USD"}
+	resp, err = UpdateFundraiserIndividual(ifMatch(request(owner, changed, nil), etag.Format(1)), r.fundraisers)
+	expectStatus(t, resp, err, http.StatusOK)
+	resp, err = GetFundraiserIndividual(request("", nil, query), r.fundraisers)
+	expectStatus(t, resp, err, http.StatusOK)
+	decode(t, resp, &got)
+	if got.IndividualFundraiserTitle != "Library books" || got.IndividualRaisedAmount.Amount != 0 {
+		t.Fatalf("GetFundraiserIndividual after update = %+v", got)
+	}
+
+	resp, err = DeleteFundraiserIndividual(request("eve@example.org", nil, query), r.fundraisers)
+	expectStatus(t, resp, err, http.StatusForbidden)
+}
+
+func TestUpdateLifecycle(t *testing.T) {
+	r := newRepos()
+	owner := "ada@example.org"
+	resp, err := CreateFundraiserIndividual(request(owner, fundraiserBody(owner, "books"), nil), r.fundraisers)
+	expectStatus(t, resp, err, http.StatusCreated)
+
+	body := map[string]interface{}{
+		"pk":          "books",
+		"sk":          "first",
+		"updateTitle": "Books ordered",
+		"updatePhoto": "https://example.org/order.png",
+		"emailId":     owner,
+	}
+	//Only the fundraiser's owner posts updates
+	resp, err = CreateUpdate(request("eve@example.org", body, nil), r.updates, r.fundraisers, r.ngos)
+	expectStatus(t, resp, err, http.StatusForbidden)
+	resp, err = CreateUpdate(request(owner, body, nil), r.updates, r.fundraisers, r.ngos)
+	expectStatus(t, resp, err, http.StatusCreated)
+
+	resp, err = GetUpdate(request("", nil, map[string]string{"emailId": owner, "fundraiserId": "books", "updateId": "first"}), r.updates)
+	expectStatus(t, resp, err, http.StatusOK)
+	var got update.Update
+	decode(t, resp, &got)
+	if got.UpdateTitle != "Books ordered" || got.Version != 1 {
+		t.Fatalf("GetUpdate = %+v", got)
+	}
+
+	var list struct {
+		Items []update.Update `json:"items"`
+	}
+	resp, err = GetUpdates(request("", nil, map[string]string{"emailId": owner, "fundraiserId": "books"}), r.updates)
+	expectStatus(t, resp, err, http.StatusOK)
+	decode(t, resp, &list)
+	if len(list.Items) != 1 {
+		t.Fatalf("GetUpdates listed %d updates, want 1", len(list.Items))
+	}
+
+	resp, err = GetUpdate(request("", nil, map[string]string{"emailId": owner, "fundraiserId": "books", "updateId": "second"}), r.updates)
+	expectStatus(t, resp, err, http.StatusNotFound)
+
+	//Fundraiser ids are only unique to their owner
+	resp, err = GetUpdates(request("", nil, map[string]string{"fundraiserId": "books"}), r.updates)
+	expectStatus(t, resp, err, http.StatusBadRequest)
+}
+
+func TestUpdatesOfFundraisersSharingAnId(t *testing.T) {
+	r := newRepos()
+	ada := "ada@example.org"
+	eve := "eve@example.org"
+	for _, owner := range []string{ada, eve} {
+		resp, err := CreateFundraiserIndividual(request(owner, fundraiserBody(owner, "books"), nil), r.fundraisers)
+		expectStatus(t, resp, err, http.StatusCreated)
+	}
+	post := func(owner string, caller string, title string) (*events.APIGatewayProxyResponse, error) {
+		body := map[string]interface{}{
+			"pk":          "books",
+			"sk":          "first",
+			"updateTitle": title,
+			"updatePhoto": "https://example.org/update.png",
+			"emailId":     owner,
+		}
+		return CreateUpdate(request(caller, body, nil), r.updates, r.fundraisers, r.ngos)
+	}
+
+	//Eve's update of her own fundraiser does not show up under Ada's, nor
+	//take the update id Ada uses
+	resp, err := post(eve, eve, "Send ETH to 0xevil")
+	expectStatus(t, resp, err, http.StatusCreated)
+	resp, err = post(ada, ada, "Books ordered")
+	expectStatus(t, resp, err, http.StatusCreated)
+	resp, err = post(ada, eve, "Send ETH to 0xevil")
+	expectStatus(t, resp, err, http.StatusForbidden)
+
+	var list struct {
+		Items []update.Update `json:"items"`
+	}
+	resp, err = GetUpdates(request("", nil, map[string]string{"emailId": ada, "fundraiserId": "books"}), r.updates)
+	expectStatus(t, resp, err, http.StatusOK)
+	decode(t, resp, &list)
+	if len(list.Items) != 1 || list.Items[0].UpdateTitle != "Books ordered" {
+		t.Fatalf("GetUpdates of Ada's fundraiser = %+v", list.Items)
+	}
+
+	//Eve cannot change or delete Ada's update through her own fundraiser
+	adaQuery := map[string]string{"emailId": ada, "fundraiserId": "books", "updateId": "first"}
+	resp, err = PatchUpdate(ifMatch(request(eve, map[string]interface{}{"updateTitle": "Send ETH to 0xevil"}, adaQuery), "*"), r.updates, r.fundraisers, r.ngos)
+	expectStatus(t, resp, err, http.StatusForbidden)
+	resp, err = DeleteUpdate(ifMatch(request(eve, nil, adaQuery), "*"), r.updates, r.fundraisers, r.ngos)
+	expectStatus(t, resp, err, http.StatusForbidden)
+	eveQuery := map[string]string{"emailId": eve, "fundraiserId": "books", "updateId": "first"}
+	resp, err = DeleteUpdate(ifMatch(request(eve, nil, eveQuery), "*"), r.updates, r.fundraisers, r.ngos)
+	expectStatus(t, resp, err, http.StatusOK)
+
+	resp, err = GetUpdate(request("", nil, adaQuery), r.updates)
+	expectStatus(t, resp, err, http.StatusOK)
+	var got update.Update
+	decode(t, resp, &got)
+	if got.UpdateTitle != "Books ordered" || got.IndividualEmailId != ada {
+		t.Fatalf("GetUpdate of Ada's update = %+v", got)
+	}
+}
+
+// adminRequest is a call by a platform admin
+func adminRequest(body interface{}, query map[string]string) events.APIGatewayProxyRequest {
+	req := request("admin@example.org", body, query)
+	req.RequestContext.Authorizer["claims"].(map[string]interface{})["cognito:groups"] = "admin"
+	return req
+}
+
+func TestNgoMembers(t *testing.T) {
+	r := newRepos()
+	owner := "owner@example.org"
+	editor := "editor@example.org"
+	resp, err := CreateNgo(request(owner, ngoBody("helpers", "Helpers"), nil), r.ngos)
+	expectStatus(t, resp, err, http.StatusCreated)
+
+	member := map[string]interface{}{"ngoId": "helpers", "user": "Editor@Example.org", "role": ngo.RoleEditor}
+	resp, err = AddNgoMember(request(editor, member, nil), r.ngos)
+	expectStatus(t, resp, err, http.StatusForbidden)
+	resp, err = AddNgoMember(request(owner, member, nil), r.ngos)
+	expectStatus(t, resp, err, http.StatusCreated)
+	resp, err = AddNgoMember(request(owner, member, nil), r.ngos)
+	expectStatus(t, resp, err, http.StatusConflict)
+
+	//Editors see the members but cannot manage them
+	var list struct {
+		Items []ngo.Member `json:"items"`
+	}
+	resp, err = GetNgoMembers(request(editor, nil, map[string]string{"ngoId": "helpers"}), r.ngos)
+	expectStatus(t, resp, err, http.StatusOK)
+	decode(t, resp, &list)
+	if len(list.Items) != 1 || list.Items[0].User != editor || list.Items[0].Role != ngo.RoleEditor {
+		t.Fatalf("GetNgoMembers = %+v", list.Items)
+	}
+	resp, err = GetNgoMembers(request("someone@example.org", nil, map[string]string{"ngoId": "helpers"}), r.ngos)
+	expectStatus(t, resp, err, http.StatusForbidden)
+
+	member["role"] = ngo.RoleAdmin
+	resp, err = UpdateNgoMember(request(editor, member, nil), r.ngos)
+	expectStatus(t, resp, err, http.StatusForbidden)
+	resp, err = UpdateNgoMember(request(owner, member, nil), r.ngos)
+	expectStatus(t, resp, err, http.StatusOK)
+	var got ngo.Member
+	decode(t, resp, &got)
+	if got.Role != ngo.RoleAdmin {
+		t.Fatalf("UpdateNgoMember = %+v", got)
+	}
+
+	query := map[string]string{"ngoId": "helpers", "user": editor}
+	resp, err = RemoveNgoMember(request(owner, nil, query), r.ngos)
+	expectStatus(t, resp, err, http.StatusOK)
+	resp, err = RemoveNgoMember(request(owner, nil, query), r.ngos)
+	expectStatus(t, resp, err, http.StatusNotFound)
+	resp, err = GetNgoMembers(request(owner, nil, map[string]string{"ngoId": "helpers"}), r.ngos)
+	expectStatus(t, resp, err, http.StatusOK)
+	decode(t, resp, &list)
+	if len(list.Items) != 0 {
+		t.Fatalf("GetNgoMembers listed %d members after the removal", len(list.Items))
+	}
+}
+
+func TestNgoVerification(t *testing.T) {
+	r := newRepos()
+	owner := "owner@example.org"
+	resp, err := CreateNgo(request(owner, ngoBody("helpers", "Helpers"), nil), r.ngos)
+	expectStatus(t, resp, err, http.StatusCreated)
+
+	submission := map[string]interface{}{
+		"ngoId": "helpers",
+		"documents": []map[string]interface{}{
+			{"documentType": "registration_certificate", "documentUrl": "https://example.org/certificate.pdf"},
+		},
+	}
+	resp, err = SubmitNgoVerification(request("someone@example.org", submission, nil), r.ngos)
+	expectStatus(t, resp, err, http.StatusForbidden)
+	resp, err = SubmitNgoVerification(request(owner, submission, nil), r.ngos)
+	expectStatus(t, resp, err, http.StatusOK)
+
+	//Only platform admins review, and only NGOs pending review
+	review := map[string]interface{}{"ngoId": "helpers", "decision": "verify"}
+	resp, err = ReviewNgoVerification(request(owner, review, nil), r.ngos)
+	expectStatus(t, resp, err, http.StatusForbidden)
+	resp, err = ReviewNgoVerification(adminRequest(review, nil), r.ngos)
+	expectStatus(t, resp, err, http.StatusOK)
+	resp, err = ReviewNgoVerification(adminRequest(review, nil), r.ngos)
+	expectStatus(t, resp, err, http.StatusConflict)
+
+	resp, err = GetNgoVerification(request(owner, nil, map[string]string{"ngoId": "helpers"}), r.ngos)
+	expectStatus(t, resp, err, http.StatusOK)
+	var v ngo.Verification
+	decode(t, resp, &v)
+	if v.Status != ngo.VerificationVerified || len(v.Documents) != 1 || len(v.Notes) != 2 {
+		t.Fatalf("GetNgoVerification = %+v", v)
+	}
+
+	//Each status change counts the NGO's version up
+	resp, err = GetNgo(request("", nil, map[string]string{"ngoId": "helpers"}), r.ngos)
+	expectStatus(t, resp, err, http.StatusOK)
+	var got ngo.Ngo
+	decode(t, resp, &got)
+	if got.VerificationStatus != ngo.VerificationVerified || got.Version != 3 {
+		t.Fatalf("GetNgo after verification = %+v", got)
+	}
+}
+
+func TestWallets(t *testing.T) {
+	r := newRepos()
+	owner := "ada@example.org"
+	address := "0x7E5F4552091A69125d5DfCb7b8C2659029395Bdf"
+	resp, err := CreateNgo(request(owner, ngoBody("helpers", "Helpers"), nil), r.ngos)
+	expectStatus(t, resp, err, http.StatusCreated)
+	resp, err = CreateFundraiserIndividual(request(owner, fundraiserBody(owner, "books"), nil), r.fundraisers)
+	expectStatus(t, resp, err, http.StatusCreated)
+
+	w := map[string]interface{}{"chain": "ethereum", "address": address}
+	ngoWallet := map[string]interface{}{"ngoId": "helpers", "wallet": w}
+	resp, err = AddNgoWallet(request("eve@example.org", ngoWallet, nil), r.ngos)
+	expectStatus(t, resp, err, http.StatusForbidden)
+	resp, err = AddNgoWallet(request(owner, ngoWallet, nil), r.ngos)
+	expectStatus(t, resp, err, http.StatusOK)
+	resp, err = AddNgoWallet(request(owner, ngoWallet, nil), r.ngos)
+	expectStatus(t, resp, err, http.StatusConflict)
+
+	fundraiserWallet := map[string]interface{}{"emailId": owner, "fundraiserId": "books", "wallet": w}
+	resp, err = AddFundraiserIndividualWallet(request("eve@example.org", fundraiserWallet, nil), r.fundraisers)
+	expectStatus(t, resp, err, http.StatusForbidden)
+	resp, err = AddFundraiserIndividualWallet(request(owner, fundraiserWallet, nil), r.fundraisers)
+	expectStatus(t, resp, err, http.StatusOK)
+
+	resp, err = GetNgo(request("", nil, map[string]string{"ngoId": "helpers"}), r.ngos)
+	expectStatus(t, resp, err, http.StatusOK)
+	var gotNgo ngo.Ngo
+	decode(t, resp, &gotNgo)
+	if len(gotNgo.NgoWallets) != 1 || gotNgo.Version != 2 {
+		t.Fatalf("GetNgo after AddNgoWallet = %+v", gotNgo)
+	}
+
+	query := map[string]string{"emailId": owner, "fundraiserId": "books", "chain": "ethereum", "asset": "ETH", "address": address}
+	resp, err = RemoveFundraiserIndividualWallet(request(owner, nil, query), r.fundraisers)
+	expectStatus(t, resp, err, http.StatusOK)
+	resp, err = RemoveFundraiserIndividualWallet(request(owner, nil, query), r.fundraisers)
+	expectStatus(t, resp, err, http.StatusNotFound)
+	resp, err = GetFundraiserIndividual(request("", nil, map[string]string{"emailId": owner, "fundraiserId": "books"}), r.fundraisers)
+	expectStatus(t, resp, err, http.StatusOK)
+	var gotFundraiser fundraiser.FundraiserIndividual
+	decode(t, resp, &gotFundraiser)
+	if len(gotFundraiser.IndividualWallets) != 0 || gotFundraiser.IndividualVersion != 3 {
+		t.Fatalf("GetFundraiserIndividual after the wallet changes = %+v", gotFundraiser)
+	}
+}
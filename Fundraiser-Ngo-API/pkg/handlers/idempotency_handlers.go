@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"aws-lambda-api/pkg/idempotency"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// Idempotent answers req with next, unless req carries an idempotency
+// key that was already used: then the first response is replayed, or the
+// request is refused if the key was used for a different one
+func Idempotent(req events.APIGatewayProxyRequest, route string, tableName string, dynaClient dynamodbiface.DynamoDBAPI, next func(events.APIGatewayProxyRequest) (*events.APIGatewayProxyResponse, error)) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	if idempotency.Key(req) == "" {
+		return next(req)
+	}
+	replay, err := idempotency.Begin(req, route, tableName, dynaClient)
+	if err != nil {
+		return idempotencyErrorResponse(err)
+	}
+	if replay != nil {
+		headers := map[string]string{}
+		for name, value := range replay.Headers {
+			headers[name] = value
+		}
+		headers["Idempotent-Replayed"] = "true"
+		return &events.APIGatewayProxyResponse{
+			StatusCode:      replay.StatusCode,
+			Headers:         headers,
+			Body:            replay.Body,
+			IsBase64Encoded: replay.IsBase64Encoded,
+		}, nil
+	}
+
+	resp, err := next(req)
+	idempotency.Complete(req, route, resp, tableName, dynaClient)
+	return resp, err
+}
+
+func idempotencyErrorResponse(err error) (*events.APIGatewayProxyResponse, error) {
+	switch err.Error() {
+	case idempotency.ErrorKeyReused, idempotency.ErrorRequestInProgress:
+		return apiResponse(http.StatusConflict, ErrorBody{aws.String(err.Error())})
+	}
+	return errorResponse(err)
+}
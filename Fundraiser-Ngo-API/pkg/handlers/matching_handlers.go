@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"aws-lambda-api/pkg/fundraiser"
+	"aws-lambda-api/pkg/matching"
+	"aws-lambda-api/pkg/money"
+	"aws-lambda-api/pkg/ngo"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+type poolBalance struct {
+	PoolId      string      `json:"poolId"`
+	SponsorName string      `json:"sponsorName"`
+	MatchRatio  string      `json:"matchRatio"`
+	Cap         money.Money `json:"cap"`
+	Remaining   money.Money `json:"remaining"`
+	Status      string      `json:"status"`
+}
+
+type fundraiserNgoResponse struct {
+	*fundraiser.FundraiserNgo
+	MatchingPools []poolBalance `json:"matchingPools"`
+}
+
+func poolBalances(pools []matching.MatchingPool) []poolBalance {
+	balances := []poolBalance{}
+	for _, p := range pools {
+		balances = append(balances, poolBalance{
+			PoolId:      p.PoolId,
+			SponsorName: p.SponsorName,
+			MatchRatio:  p.MatchRatio,
+			Cap:         p.Cap,
+			Remaining:   p.Remaining,
+			Status:      p.Status,
+		})
+	}
+	return balances
+}
+
+func GetMatchingPool(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	poolId := req.QueryStringParameters["poolId"]
+	result, err := matching.FetchPool(poolId, tableName, dynaClient)
+	if err != nil {
+		return apiResponse(http.StatusBadRequest, ErrorBody{aws.String(err.Error())})
+	}
+	return apiResponse(http.StatusOK, result)
+}
+
+func CreateMatchingPool(req events.APIGatewayProxyRequest, fundraisers fundraiser.FundraiserRepository, ngos ngo.NgoRepository, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	result, err := matching.CreatePool(req, fundraisers, ngos, tableName, dynaClient)
+	if err != nil {
+		return matchingErrorResponse(err)
+	}
+	return apiResponse(http.StatusCreated, result)
+}
+
+func AttachMatchingPool(req events.APIGatewayProxyRequest, fundraisers fundraiser.FundraiserRepository, ngos ngo.NgoRepository, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	result, err := matching.AttachPool(req, fundraisers, ngos, tableName, dynaClient)
+	if err != nil {
+		return matchingErrorResponse(err)
+	}
+	return apiResponse(http.StatusOK, result)
+}
+
+func matchingErrorResponse(err error) (*events.APIGatewayProxyResponse, error) {
+	switch err.Error() {
+	case matching.ErrorPoolDoesNotExist, fundraiser.ErrorFundraiserDoesNotExist:
+		return apiResponse(http.StatusNotFound, ErrorBody{aws.String(err.Error())})
+	case matching.ErrorPoolAlreadyExists, matching.ErrorPoolAlreadyAttached, matching.ErrorPoolChanged:
+		return apiResponse(http.StatusConflict, ErrorBody{aws.String(err.Error())})
+	}
+	return errorResponse(err)
+}
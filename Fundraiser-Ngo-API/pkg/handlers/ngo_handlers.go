@@ -2,72 +2,235 @@ package handlers
 
 import (
 	"aws-lambda-api/pkg/ngo"
+	"aws-lambda-api/pkg/page"
+	"aws-lambda-api/pkg/wallet"
+	"errors"
 	"net/http"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 )
 
-func GetNgo(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+func GetNgo(req events.APIGatewayProxyRequest, ngos ngo.NgoRepository) (
 	*events.APIGatewayProxyResponse,
 	error,
 ) {
 	ngoId := req.QueryStringParameters["ngoId"]
-	result, err := ngo.FetchNgo(ngoId, tableName, dynaClient)
+	result, err := ngos.GetNgo(ngoId)
 	if err != nil {
-		return apiResponse(http.StatusBadRequest, ErrorBody{aws.String(err.Error())})
+		return ngoErrorResponse(err)
 	}
-	return apiResponse(http.StatusOK, result)
+	if len(result.NgoId) == 0 {
+		return ngoErrorResponse(errors.New(ngo.ErrorUserDoesNotExists))
+	}
+	return versionedResponse(http.StatusOK, result, result.Version)
 }
 
-func GetNgos(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+func GetNgos(req events.APIGatewayProxyRequest, ngos ngo.NgoRepository) (
 	*events.APIGatewayProxyResponse,
 	error,
 ) {
 	countries := req.QueryStringParameters["countries"]
 	categories := req.QueryStringParameters["categories"]
-	result, err := ngo.FetchNgos(countries,categories,tableName, dynaClient)
+	//Unverified NGOs are hidden unless asked for; each NGO carries its verificationStatus
+	includeUnverified := req.QueryStringParameters["includeUnverified"] == "true"
+	p, err := page.FromRequest(req)
 	if err != nil {
 		return apiResponse(http.StatusBadRequest, ErrorBody{aws.String(err.Error())})
 	}
+	result, next, err := ngos.ListNgos(ngo.NgoFilter{Countries: countries, Categories: categories, IncludeUnverified: includeUnverified}, p)
+	if err != nil {
+		return apiResponse(http.StatusBadRequest, ErrorBody{aws.String(err.Error())})
+	}
+	return apiResponse(http.StatusOK, page.NewList(result, next))
+}
+func CreateNgo(req events.APIGatewayProxyRequest, ngos ngo.NgoRepository) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	result, err := ngo.CreateNgo(req, ngos)
+	if err != nil {
+		return ngoErrorResponse(err)
+	}
+	return versionedResponse(http.StatusCreated, result, result.Version)
+}
+
+func UpdateNgo(req events.APIGatewayProxyRequest, ngos ngo.NgoRepository) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	result, err := ngo.UpdateNgo(req, ngos)
+	if err != nil {
+		return ngoErrorResponse(err)
+	}
+	return versionedResponse(http.StatusOK, result, result.Version)
+}
+
+func PatchNgo(req events.APIGatewayProxyRequest, ngos ngo.NgoRepository) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	result, err := ngo.PatchNgo(req, ngos)
+	if err != nil {
+		return ngoErrorResponse(err)
+	}
+	return versionedResponse(http.StatusOK, result, result.Version)
+}
+
+func DeleteNgo(req events.APIGatewayProxyRequest, ngos ngo.NgoRepository) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	err := ngo.DeleteNgo(req, ngos)
+	if err != nil {
+		return ngoErrorResponse(err)
+	}
+	return apiResponse(http.StatusOK, nil)
+}
+
+func ngoErrorResponse(err error) (*events.APIGatewayProxyResponse, error) {
+	switch err.Error() {
+	case ngo.ErrorUserDoesNotExists:
+		return apiResponse(http.StatusNotFound, ErrorBody{aws.String(err.Error())})
+	case ngo.ErrorUserAlreadyExists:
+		return apiResponse(http.StatusConflict, ErrorBody{aws.String(err.Error())})
+	}
+	return errorResponse(err)
+}
+
+func AddNgoWallet(req events.APIGatewayProxyRequest, ngos ngo.NgoRepository) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	result, err := ngo.AddNgoWallet(req, ngos)
+	if err != nil {
+		return walletErrorResponse(err, ngo.ErrorUserDoesNotExists)
+	}
+	return apiResponse(http.StatusOK, result)
+}
+
+func RemoveNgoWallet(req events.APIGatewayProxyRequest, ngos ngo.NgoRepository) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	result, err := ngo.RemoveNgoWallet(req, ngos)
+	if err != nil {
+		return walletErrorResponse(err, ngo.ErrorUserDoesNotExists)
+	}
 	return apiResponse(http.StatusOK, result)
 }
-func CreateNgo(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+
+func walletErrorResponse(err error, notFound string) (*events.APIGatewayProxyResponse, error) {
+	switch err.Error() {
+	case notFound, wallet.ErrorWalletDoesNotExist:
+		return apiResponse(http.StatusNotFound, ErrorBody{aws.String(err.Error())})
+	case wallet.ErrorWalletAlreadyExists, wallet.ErrorWalletsChangedOrGone:
+		return apiResponse(http.StatusConflict, ErrorBody{aws.String(err.Error())})
+	}
+	return errorResponse(err)
+}
+
+func GetNgoMembers(req events.APIGatewayProxyRequest, ngos ngo.NgoRepository) (
 	*events.APIGatewayProxyResponse,
 	error,
 ) {
-	result, err := ngo.CreateNgo(req, tableName, dynaClient)
+	ngoId := req.QueryStringParameters["ngoId"]
+	p, err := page.FromRequest(req)
+	if err != nil {
+		return apiResponse(http.StatusBadRequest, ErrorBody{aws.String(err.Error())})
+	}
+	result, next, err := ngo.FetchMembers(req, ngoId, p, ngos)
 	if err != nil {
-		return apiResponse(http.StatusBadRequest, ErrorBody{
-			aws.String(err.Error()),
-		})
+		return memberErrorResponse(err)
+	}
+	return apiResponse(http.StatusOK, page.NewList(result, next))
+}
+
+func AddNgoMember(req events.APIGatewayProxyRequest, ngos ngo.NgoRepository) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	result, err := ngo.AddMember(req, ngos)
+	if err != nil {
+		return memberErrorResponse(err)
 	}
 	return apiResponse(http.StatusCreated, result)
 }
 
-func UpdateNgo(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+func UpdateNgoMember(req events.APIGatewayProxyRequest, ngos ngo.NgoRepository) (
 	*events.APIGatewayProxyResponse,
 	error,
 ) {
-	result, err := ngo.UpdateNgo(req, tableName, dynaClient)
+	result, err := ngo.UpdateMember(req, ngos)
 	if err != nil {
-		return apiResponse(http.StatusBadRequest, ErrorBody{
-			aws.String(err.Error()),
-		})
+		return memberErrorResponse(err)
 	}
 	return apiResponse(http.StatusOK, result)
 }
 
-func DeleteNgo(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+func RemoveNgoMember(req events.APIGatewayProxyRequest, ngos ngo.NgoRepository) (
 	*events.APIGatewayProxyResponse,
 	error,
 ) {
-	err := ngo.DeleteNgo(req, tableName, dynaClient)
+	err := ngo.RemoveMember(req, ngos)
 	if err != nil {
-		return apiResponse(http.StatusBadRequest, ErrorBody{
-			aws.String(err.Error()),
-		})
+		return memberErrorResponse(err)
 	}
 	return apiResponse(http.StatusOK, nil)
 }
+
+func memberErrorResponse(err error) (*events.APIGatewayProxyResponse, error) {
+	switch err.Error() {
+	case ngo.ErrorUserDoesNotExists, ngo.ErrorMemberDoesNotExist:
+		return apiResponse(http.StatusNotFound, ErrorBody{aws.String(err.Error())})
+	case ngo.ErrorMemberAlreadyExists, ngo.ErrorMemberChanged, ngo.ErrorMemberIsNgoOwner:
+		return apiResponse(http.StatusConflict, ErrorBody{aws.String(err.Error())})
+	case ngo.ErrorCannotManageRole:
+		return apiResponse(http.StatusForbidden, ErrorBody{aws.String(err.Error())})
+	}
+	return errorResponse(err)
+}
+
+func GetNgoVerification(req events.APIGatewayProxyRequest, ngos ngo.NgoRepository) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	ngoId := req.QueryStringParameters["ngoId"]
+	result, err := ngo.FetchVerification(req, ngoId, ngos)
+	if err != nil {
+		return verificationErrorResponse(err)
+	}
+	return apiResponse(http.StatusOK, result)
+}
+
+func SubmitNgoVerification(req events.APIGatewayProxyRequest, ngos ngo.NgoRepository) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	result, err := ngo.SubmitVerification(req, ngos)
+	if err != nil {
+		return verificationErrorResponse(err)
+	}
+	return apiResponse(http.StatusOK, result)
+}
+
+func ReviewNgoVerification(req events.APIGatewayProxyRequest, ngos ngo.NgoRepository) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	result, err := ngo.ReviewVerification(req, ngos)
+	if err != nil {
+		return verificationErrorResponse(err)
+	}
+	return apiResponse(http.StatusOK, result)
+}
+
+func verificationErrorResponse(err error) (*events.APIGatewayProxyResponse, error) {
+	switch err.Error() {
+	case ngo.ErrorUserDoesNotExists:
+		return apiResponse(http.StatusNotFound, ErrorBody{aws.String(err.Error())})
+	case ngo.ErrorInvalidVerification, ngo.ErrorVerificationChanged:
+		return apiResponse(http.StatusConflict, ErrorBody{aws.String(err.Error())})
+	}
+	return errorResponse(err)
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"aws-lambda-api/pkg/fundraiser"
+	"aws-lambda-api/pkg/ngo"
+	"aws-lambda-api/pkg/page"
+	"aws-lambda-api/pkg/payout"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+func GetPayout(req events.APIGatewayProxyRequest, fundraisers fundraiser.FundraiserRepository, ngos ngo.NgoRepository, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	ngoId := req.QueryStringParameters["ngoId"]
+	emailId := req.QueryStringParameters["emailId"]
+	fundraiserId := req.QueryStringParameters["fundraiserId"]
+	payoutId := req.QueryStringParameters["payoutId"]
+	result, err := payout.FetchPayout(req, ngoId, emailId, fundraiserId, payoutId, fundraisers, ngos, tableName, dynaClient)
+	if err != nil {
+		return payoutErrorResponse(err)
+	}
+	return apiResponse(http.StatusOK, result)
+}
+func GetPayouts(req events.APIGatewayProxyRequest, fundraisers fundraiser.FundraiserRepository, ngos ngo.NgoRepository, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	ngoId := req.QueryStringParameters["ngoId"]
+	emailId := req.QueryStringParameters["emailId"]
+	fundraiserId := req.QueryStringParameters["fundraiserId"]
+	p, err := page.FromRequest(req)
+	if err != nil {
+		return apiResponse(http.StatusBadRequest, ErrorBody{aws.String(err.Error())})
+	}
+	result, next, err := payout.FetchPayouts(req, ngoId, emailId, fundraiserId, p, fundraisers, ngos, tableName, dynaClient)
+	if err != nil {
+		return payoutErrorResponse(err)
+	}
+	return apiResponse(http.StatusOK, page.NewList(result, next))
+}
+
+func RequestPayout(req events.APIGatewayProxyRequest, fundraisers fundraiser.FundraiserRepository, ngos ngo.NgoRepository, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	result, err := payout.RequestPayout(req, fundraisers, ngos, tableName, dynaClient)
+	if err != nil {
+		return payoutErrorResponse(err)
+	}
+	return apiResponse(http.StatusCreated, result)
+}
+
+func ReviewPayout(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	result, err := payout.ReviewPayout(req, tableName, dynaClient)
+	if err != nil {
+		return payoutErrorResponse(err)
+	}
+	return apiResponse(http.StatusOK, result)
+}
+
+func payoutErrorResponse(err error) (*events.APIGatewayProxyResponse, error) {
+	switch err.Error() {
+	case payout.ErrorPayoutDoesNotExist, fundraiser.ErrorFundraiserDoesNotExist:
+		return apiResponse(http.StatusNotFound, ErrorBody{aws.String(err.Error())})
+	case payout.ErrorPayoutAlreadyExists, payout.ErrorInvalidTransition, payout.ErrorPayoutChanged:
+		return apiResponse(http.StatusConflict, ErrorBody{aws.String(err.Error())})
+	case payout.ErrorInsufficientBalance:
+		return apiResponse(http.StatusUnprocessableEntity, ErrorBody{aws.String(err.Error())})
+	}
+	return errorResponse(err)
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"aws-lambda-api/pkg/chain"
+	"aws-lambda-api/pkg/fundraiser"
+	"aws-lambda-api/pkg/money"
+	"aws-lambda-api/pkg/ngo"
+	"aws-lambda-api/pkg/page"
+	"aws-lambda-api/pkg/pledge"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+func GetPledge(req events.APIGatewayProxyRequest, ngos ngo.NgoRepository, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	ngoId := req.QueryStringParameters["ngoId"]
+	pledgeId := req.QueryStringParameters["pledgeId"]
+	result, err := pledge.FetchPledge(req, ngoId, pledgeId, ngos, tableName, dynaClient)
+	if err != nil {
+		return pledgeErrorResponse(err)
+	}
+	return apiResponse(http.StatusOK, result)
+}
+func GetPledges(req events.APIGatewayProxyRequest, ngos ngo.NgoRepository, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	ngoId := req.QueryStringParameters["ngoId"]
+	p, err := page.FromRequest(req)
+	if err != nil {
+		return apiResponse(http.StatusBadRequest, ErrorBody{aws.String(err.Error())})
+	}
+	result, next, err := pledge.FetchPledges(req, ngoId, p, ngos, tableName, dynaClient)
+	if err != nil {
+		return pledgeErrorResponse(err)
+	}
+	return apiResponse(http.StatusOK, page.NewList(result, next))
+}
+
+func GetPledgeCharges(req events.APIGatewayProxyRequest, ngos ngo.NgoRepository, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	ngoId := req.QueryStringParameters["ngoId"]
+	pledgeId := req.QueryStringParameters["pledgeId"]
+	p, err := page.FromRequest(req)
+	if err != nil {
+		return apiResponse(http.StatusBadRequest, ErrorBody{aws.String(err.Error())})
+	}
+	result, next, err := pledge.FetchCharges(req, ngoId, pledgeId, p, ngos, tableName, dynaClient)
+	if err != nil {
+		return pledgeErrorResponse(err)
+	}
+	return apiResponse(http.StatusOK, page.NewList(result, next))
+}
+
+func PayPledgeCharge(req events.APIGatewayProxyRequest, fundraisers fundraiser.FundraiserRepository, ngos ngo.NgoRepository, tableName string, dynaClient dynamodbiface.DynamoDBAPI, verifier chain.Verifier, oracle money.PriceOracle) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	result, err := pledge.PayCharge(req, fundraisers, ngos, tableName, dynaClient, verifier, oracle)
+	if err != nil {
+		if strings.HasPrefix(err.Error(), chain.ErrorNodeUnavailable) {
+			return apiResponse(http.StatusBadGateway, ErrorBody{aws.String(err.Error())})
+		}
+		return pledgeErrorResponse(err)
+	}
+	return apiResponse(http.StatusOK, result)
+}
+
+func CreatePledge(req events.APIGatewayProxyRequest, fundraisers fundraiser.FundraiserRepository, ngos ngo.NgoRepository, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	result, err := pledge.CreatePledge(req, fundraisers, ngos, tableName, dynaClient)
+	if err != nil {
+		return pledgeErrorResponse(err)
+	}
+	return apiResponse(http.StatusCreated, result)
+}
+
+func PausePledge(req events.APIGatewayProxyRequest, ngos ngo.NgoRepository, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	result, err := pledge.PausePledge(req, ngos, tableName, dynaClient)
+	if err != nil {
+		return pledgeErrorResponse(err)
+	}
+	return apiResponse(http.StatusOK, result)
+}
+
+func ResumePledge(req events.APIGatewayProxyRequest, ngos ngo.NgoRepository, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	result, err := pledge.ResumePledge(req, ngos, tableName, dynaClient)
+	if err != nil {
+		return pledgeErrorResponse(err)
+	}
+	return apiResponse(http.StatusOK, result)
+}
+
+func CancelPledge(req events.APIGatewayProxyRequest, ngos ngo.NgoRepository, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	result, err := pledge.CancelPledge(req, ngos, tableName, dynaClient)
+	if err != nil {
+		return pledgeErrorResponse(err)
+	}
+	return apiResponse(http.StatusOK, result)
+}
+
+func pledgeErrorResponse(err error) (*events.APIGatewayProxyResponse, error) {
+	switch err.Error() {
+	case pledge.ErrorPledgeDoesNotExist, pledge.ErrorChargeDoesNotExist, pledge.ErrorNgoDoesNotExist, fundraiser.ErrorFundraiserDoesNotExist:
+		return apiResponse(http.StatusNotFound, ErrorBody{aws.String(err.Error())})
+	case pledge.ErrorPledgeAlreadyExists, pledge.ErrorInvalidTransition, pledge.ErrorPledgeChanged, pledge.ErrorChargeNotPending:
+		return apiResponse(http.StatusConflict, ErrorBody{aws.String(err.Error())})
+	}
+	return errorResponse(err)
+}
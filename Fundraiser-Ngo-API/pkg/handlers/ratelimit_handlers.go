@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"aws-lambda-api/pkg/ratelimit"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+var ErrorTooManyRequests = "too many requests"
+
+// RateLimit answers requests whose client has used up its calls to route
+// with 429, and returns nil for requests that may go on
+func RateLimit(req events.APIGatewayProxyRequest, route string, limits *ratelimit.Limits, tableName string, dynaClient dynamodbiface.DynamoDBAPI) *events.APIGatewayProxyResponse {
+	allowed, retryAfter := ratelimit.Allow(req, route, limits, tableName, dynaClient)
+	if allowed {
+		return nil
+	}
+	return tooManyRequests(retryAfter)
+}
+
+func tooManyRequests(retryAfter time.Duration) *events.APIGatewayProxyResponse {
+	resp, _ := apiResponse(http.StatusTooManyRequests, ErrorBody{aws.String(ErrorTooManyRequests)})
+	resp.Headers["Retry-After"] = strconv.Itoa(int(retryAfter.Seconds()))
+	return resp
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"aws-lambda-api/pkg/ngo"
+	"aws-lambda-api/pkg/receipt"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+func GetReceipt(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	ngoId := req.QueryStringParameters["ngoId"]
+	receiptNumber := req.QueryStringParameters["receiptNumber"]
+	result, err := receipt.FetchReceipt(ngoId, receiptNumber, tableName, dynaClient)
+	if err != nil {
+		return receiptErrorResponse(err)
+	}
+	switch req.QueryStringParameters["format"] {
+	case "", "json":
+		return apiResponse(http.StatusOK, result)
+	case "pdf":
+		return binaryResponse(http.StatusOK, "application/pdf", result.PDF())
+	}
+	return apiResponse(http.StatusBadRequest, ErrorBody{aws.String(receipt.ErrorUnsupportedReceiptFormat)})
+}
+
+func IssueReceipt(req events.APIGatewayProxyRequest, ngos ngo.NgoRepository, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	result, err := receipt.IssueReceipt(req, ngos, tableName, dynaClient)
+	if err != nil {
+		return receiptErrorResponse(err)
+	}
+	return apiResponse(http.StatusCreated, result)
+}
+
+func ReissueReceipt(req events.APIGatewayProxyRequest, ngos ngo.NgoRepository, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	result, err := receipt.ReissueReceipt(req, ngos, tableName, dynaClient)
+	if err != nil {
+		return receiptErrorResponse(err)
+	}
+	return apiResponse(http.StatusCreated, result)
+}
+
+func VoidReceipt(req events.APIGatewayProxyRequest, ngos ngo.NgoRepository, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	result, err := receipt.VoidReceipt(req, ngos, tableName, dynaClient)
+	if err != nil {
+		return receiptErrorResponse(err)
+	}
+	return apiResponse(http.StatusOK, result)
+}
+
+func receiptErrorResponse(err error) (*events.APIGatewayProxyResponse, error) {
+	switch err.Error() {
+	case receipt.ErrorReceiptDoesNotExist, receipt.ErrorDonationDoesNotExist, receipt.ErrorNgoDoesNotExist:
+		return apiResponse(http.StatusNotFound, ErrorBody{aws.String(err.Error())})
+	case receipt.ErrorReceiptAlreadyIssued, receipt.ErrorReceiptNotIssued, receipt.ErrorReceiptNumberingConflict, receipt.ErrorDonationNotVerified:
+		return apiResponse(http.StatusConflict, ErrorBody{aws.String(err.Error())})
+	}
+	return errorResponse(err)
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"aws-lambda-api/pkg/siwe"
+	"aws-lambda-api/pkg/wallet"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+func GetSiweNonce(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	result, err := siwe.CreateNonce(tableName, dynaClient)
+	if err != nil {
+		return apiResponse(http.StatusBadRequest, ErrorBody{aws.String(err.Error())})
+	}
+	return apiResponse(http.StatusCreated, result)
+}
+
+func VerifySiwe(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI, domain string) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	result, err := siwe.SignIn(req, domain, tableName, dynaClient)
+	if err != nil {
+		return siweErrorResponse(err)
+	}
+	return apiResponse(http.StatusOK, result)
+}
+
+func siweErrorResponse(err error) (*events.APIGatewayProxyResponse, error) {
+	switch err.Error() {
+	case siwe.ErrorWrongDomain, siwe.ErrorMessageExpired, siwe.ErrorMessageNotYetValid,
+		siwe.ErrorSignerMismatch, siwe.ErrorInvalidNonce, wallet.ErrorInvalidSignature:
+		return apiResponse(http.StatusUnauthorized, ErrorBody{aws.String(err.Error())})
+	}
+	return errorResponse(err)
+}
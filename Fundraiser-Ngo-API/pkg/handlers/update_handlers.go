@@ -1,73 +1,106 @@
 package handlers
 
 import (
+	"aws-lambda-api/pkg/fundraiser"
+	"aws-lambda-api/pkg/ngo"
+	"aws-lambda-api/pkg/page"
 	"aws-lambda-api/pkg/update"
+	"errors"
 	"net/http"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 )
 
-func GetUpdate(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+func GetUpdate(req events.APIGatewayProxyRequest, updates update.UpdateRepository) (
 	*events.APIGatewayProxyResponse,
 	error,
 ) {
 	fundraiserId := req.QueryStringParameters["fundraiserId"]
 	updateId := req.QueryStringParameters["updateId"]
-	result, err := update.FetchUpdate(fundraiserId, updateId, tableName, dynaClient)
+	key, err := update.FundraiserKey(req.QueryStringParameters["ngoId"], req.QueryStringParameters["emailId"], fundraiserId)
 	if err != nil {
-		return apiResponse(http.StatusBadRequest, ErrorBody{aws.String(err.Error())})
+		return updateErrorResponse(err)
+	}
+	result, err := updates.GetUpdate(key, updateId)
+	if err != nil {
+		return updateErrorResponse(err)
 	}
-	return apiResponse(http.StatusOK, result)
+	if len(result.UpdateId) == 0 {
+		return updateErrorResponse(errors.New(update.ErrorUserDoesNotExists))
+	}
+	return versionedResponse(http.StatusOK, result, result.Version)
 }
-func GetUpdates(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+func GetUpdates(req events.APIGatewayProxyRequest, updates update.UpdateRepository) (
 	*events.APIGatewayProxyResponse,
 	error,
 ) {
 	fundraiserId := req.QueryStringParameters["fundraiserId"]
-	result, err := update.FetchUpdates(fundraiserId, tableName, dynaClient)
+	key, err := update.FundraiserKey(req.QueryStringParameters["ngoId"], req.QueryStringParameters["emailId"], fundraiserId)
+	if err != nil {
+		return updateErrorResponse(err)
+	}
+	p, err := page.FromRequest(req)
 	if err != nil {
 		return apiResponse(http.StatusBadRequest, ErrorBody{aws.String(err.Error())})
 	}
-	return apiResponse(http.StatusOK, result)
+	result, next, err := updates.ListUpdates(key, p)
+	if err != nil {
+		return apiResponse(http.StatusBadRequest, ErrorBody{aws.String(err.Error())})
+	}
+	return apiResponse(http.StatusOK, page.NewList(result, next))
 }
 
-func CreateUpdate(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+func CreateUpdate(req events.APIGatewayProxyRequest, updates update.UpdateRepository, fundraisers fundraiser.FundraiserRepository, ngos ngo.NgoRepository) (
 	*events.APIGatewayProxyResponse,
 	error,
 ) {
-	result, err := update.CreateUpdate(req, tableName, dynaClient)
+	result, err := update.CreateUpdate(req, updates, fundraisers, ngos)
 	if err != nil {
-		return apiResponse(http.StatusBadRequest, ErrorBody{
-			aws.String(err.Error()),
-		})
+		return updateErrorResponse(err)
 	}
-	return apiResponse(http.StatusCreated, result)
+	return versionedResponse(http.StatusCreated, result, result.Version)
 }
 
-func UpdateUpdate(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+func UpdateUpdate(req events.APIGatewayProxyRequest, updates update.UpdateRepository, fundraisers fundraiser.FundraiserRepository, ngos ngo.NgoRepository) (
 	*events.APIGatewayProxyResponse,
 	error,
 ) {
-	result, err := update.UpdateUpdate(req, tableName, dynaClient)
+	result, err := update.UpdateUpdate(req, updates, fundraisers, ngos)
 	if err != nil {
-		return apiResponse(http.StatusBadRequest, ErrorBody{
-			aws.String(err.Error()),
-		})
+		return updateErrorResponse(err)
 	}
-	return apiResponse(http.StatusOK, result)
+	return versionedResponse(http.StatusOK, result, result.Version)
 }
 
-func DeleteUpdate(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+func PatchUpdate(req events.APIGatewayProxyRequest, updates update.UpdateRepository, fundraisers fundraiser.FundraiserRepository, ngos ngo.NgoRepository) (
 	*events.APIGatewayProxyResponse,
 	error,
 ) {
-	err := update.DeleteUpdate(req, tableName, dynaClient)
+	result, err := update.PatchUpdate(req, updates, fundraisers, ngos)
 	if err != nil {
-		return apiResponse(http.StatusBadRequest, ErrorBody{
-			aws.String(err.Error()),
-		})
+		return updateErrorResponse(err)
+	}
+	return versionedResponse(http.StatusOK, result, result.Version)
+}
+
+func DeleteUpdate(req events.APIGatewayProxyRequest, updates update.UpdateRepository, fundraisers fundraiser.FundraiserRepository, ngos ngo.NgoRepository) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	err := update.DeleteUpdate(req, updates, fundraisers, ngos)
+	if err != nil {
+		return updateErrorResponse(err)
 	}
 	return apiResponse(http.StatusOK, nil)
 }
+
+func updateErrorResponse(err error) (*events.APIGatewayProxyResponse, error) {
+	switch err.Error() {
+	case update.ErrorUserDoesNotExists, fundraiser.ErrorFundraiserDoesNotExist:
+		return apiResponse(http.StatusNotFound, ErrorBody{aws.String(err.Error())})
+	case update.ErrorUserAlreadyExists:
+		return apiResponse(http.StatusConflict, ErrorBody{aws.String(err.Error())})
+	}
+	return errorResponse(err)
+}
@@ -0,0 +1,274 @@
+package idempotency
+
+import (
+	"aws-lambda-api/pkg/pii"
+	"aws-lambda-api/pkg/ratelimit"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+var (
+	ErrorFailedToUnmarshalRecord = "failed to unmarshal record"
+	ErrorFailedToFetchRecord     = "failed to fetch record"
+	ErrorCouldNotMarshalItem     = "could not marshal item"
+	ErrorCouldNotDynamoPutItem   = "could not dynamo put item error"
+	ErrorInvalidKey              = "idempotency key must be 1 to 255 characters"
+	ErrorKeyReused               = "idempotency key was already used for a different request"
+	ErrorRequestInProgress       = "a request with this idempotency key is still in progress"
+)
+
+// Header names the key clients send to make retries of a POST safe
+const Header = "Idempotency-Key"
+
+const (
+	StatusPending = "pending"
+	StatusDone    = "done"
+)
+
+const (
+	// Lifetime is how long a response is kept for replay
+	Lifetime = 24 * time.Hour
+	// lockTimeout outlasts API Gateway's 29 second timeout, so a pending
+	// request older than that has died and a retry may take it over
+	lockTimeout  = 30 * time.Second
+	maxKeyLength = 255
+)
+
+// Record is the outcome of the first request sent with a key. ExpiresAt
+// is the table's TTL attribute. Responses carry secrets and personal data
+// on most routes, so they are only kept sealed, and not kept at all if
+// that is not possible. Response is only read from records stored before
+// responses were sealed.
+type Record struct {
+	RecordId    string        `json:"pk"`
+	Kind        string        `json:"sk"`
+	RequestHash string        `json:"requestHash"`
+	Status      string        `json:"status"`
+	Response    *Response     `json:"response,omitempty"`
+	Sealed      *pii.Envelope `json:"sealedResponse,omitempty"`
+	CreatedAt   string        `json:"createdAt"`
+	LockedUntil int64         `json:"lockedUntil"`
+	ExpiresAt   int64         `json:"ttl"`
+}
+
+// Response is a stored response, replayed as it was first sent
+type Response struct {
+	StatusCode      int               `json:"statusCode"`
+	Headers         map[string]string `json:"headers"`
+	Body            string            `json:"body"`
+	IsBase64Encoded bool              `json:"isBase64Encoded"`
+}
+
+func RecordKey(recordId string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"pk": {
+			S: aws.String(recordId),
+		},
+		"sk": {
+			S: aws.String("Response"),
+		},
+	}
+}
+
+// Key is the idempotency key of req, if it has one. Only POST requests
+// take part.
+func Key(req events.APIGatewayProxyRequest) string {
+	if req.HTTPMethod != "POST" {
+		return ""
+	}
+	if key := req.Headers[Header]; key != "" {
+		return key
+	}
+	return req.Headers[strings.ToLower(Header)]
+}
+
+// recordId scopes key to the client and route, so clients cannot replay
+// each other's responses
+func recordId(req events.APIGatewayProxyRequest, route string, key string) string {
+	return "Idempotency" + ratelimit.Client(req) + "#" + route + "#" + key
+}
+
+func requestHash(req events.APIGatewayProxyRequest, route string) string {
+	sum := sha256.Sum256([]byte(req.HTTPMethod + "|" + route + "|" + req.Body))
+	return hex.EncodeToString(sum[:])
+}
+
+// Begin claims the key of req for route. It returns the stored response
+// when the key was already used for the same request, and nil when the
+// request should go on and be finished with Complete.
+func Begin(req events.APIGatewayProxyRequest, route string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Response, error) {
+	key := Key(req)
+	if len(key) > maxKeyLength {
+		return nil, errors.New(ErrorInvalidKey)
+	}
+	id := recordId(req, route, key)
+	hash := requestHash(req, route)
+	now := time.Now().UTC()
+	record := Record{
+		RecordId:    id,
+		Kind:        "Response",
+		RequestHash: hash,
+		Status:      StatusPending,
+		CreatedAt:   now.Format(time.RFC3339),
+		LockedUntil: now.Add(lockTimeout).Unix(),
+		ExpiresAt:   now.Add(Lifetime).Unix(),
+	}
+	av, err := dynamodbattribute.MarshalMap(record)
+	if err != nil {
+		return nil, errors.New(ErrorCouldNotMarshalItem)
+	}
+
+	//A key is free when unused, expired but not yet removed, or held by a
+	//dead attempt at the same request
+	nowValue := &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(now.Unix(), 10))}
+	input := &dynamodb.PutItemInput{
+		Item:                av,
+		TableName:           aws.String(tableName),
+		ConditionExpression: aws.String("attribute_not_exists(sk) OR #ttl < :now OR (#status = :pending AND lockedUntil < :now AND requestHash = :hash)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#ttl":    aws.String("ttl"),
+			"#status": aws.String("status"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now":     nowValue,
+			":pending": {S: aws.String(StatusPending)},
+			":hash":    {S: aws.String(hash)},
+		},
+	}
+	_, err = dynaClient.PutItem(input)
+	if err == nil {
+		return nil, nil
+	}
+	if _, ok := err.(*dynamodb.ConditionalCheckFailedException); !ok {
+		return nil, errors.New(ErrorCouldNotDynamoPutItem)
+	}
+
+	current, err := fetchRecord(id, tableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	//Released by a failed attempt in the meantime
+	if len(current.RecordId) == 0 {
+		return nil, errors.New(ErrorRequestInProgress)
+	}
+	if current.RequestHash != hash {
+		return nil, errors.New(ErrorKeyReused)
+	}
+	if current.Status != StatusDone {
+		return nil, errors.New(ErrorRequestInProgress)
+	}
+	if current.Sealed != nil {
+		return openResponse(current.Sealed, id)
+	}
+	return current.Response, nil
+}
+
+// Complete stores resp as the outcome of the request claimed by Begin.
+// Server errors are not stored, so a retry runs the request again.
+func Complete(req events.APIGatewayProxyRequest, route string, resp *events.APIGatewayProxyResponse, tableName string, dynaClient dynamodbiface.DynamoDBAPI) {
+	id := recordId(req, route, Key(req))
+	hash := requestHash(req, route)
+	condition := map[string]*dynamodb.AttributeValue{
+		":pending": {S: aws.String(StatusPending)},
+		":hash":    {S: aws.String(hash)},
+	}
+	names := map[string]*string{
+		"#status": aws.String("status"),
+	}
+
+	record, err := completedRecord(id, hash, resp)
+	if err != nil {
+		//Release the key so the request can be retried
+		dynaClient.DeleteItem(&dynamodb.DeleteItemInput{
+			Key:                       RecordKey(id),
+			TableName:                 aws.String(tableName),
+			ConditionExpression:       aws.String("#status = :pending AND requestHash = :hash"),
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: condition,
+		})
+		return
+	}
+	av, err := dynamodbattribute.MarshalMap(record)
+	if err != nil {
+		return
+	}
+	dynaClient.PutItem(&dynamodb.PutItemInput{
+		Item:                      av,
+		TableName:                 aws.String(tableName),
+		ConditionExpression:       aws.String("#status = :pending AND requestHash = :hash"),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: condition,
+	})
+}
+
+func completedRecord(id string, hash string, resp *events.APIGatewayProxyResponse) (*Record, error) {
+	if resp == nil || resp.StatusCode >= 500 {
+		return nil, errors.New(ErrorCouldNotDynamoPutItem)
+	}
+	now := time.Now().UTC()
+	record := &Record{
+		RecordId:    id,
+		Kind:        "Response",
+		RequestHash: hash,
+		Status:      StatusDone,
+		CreatedAt:   now.Format(time.RFC3339),
+		ExpiresAt:   now.Add(Lifetime).Unix(),
+	}
+	response := &Response{
+		StatusCode:      resp.StatusCode,
+		Headers:         resp.Headers,
+		Body:            resp.Body,
+		IsBase64Encoded: resp.IsBase64Encoded,
+	}
+	raw, err := json.Marshal(response)
+	if err != nil {
+		return nil, err
+	}
+	record.Sealed, err = pii.Seal(raw, id)
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func openResponse(sealed *pii.Envelope, id string) (*Response, error) {
+	raw, err := pii.Open(sealed, id)
+	if err != nil {
+		return nil, err
+	}
+	response := new(Response)
+	if err := json.Unmarshal(raw, response); err != nil {
+		return nil, errors.New(ErrorFailedToUnmarshalRecord)
+	}
+	return response, nil
+}
+
+func fetchRecord(id string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Record, error) {
+	//Macking Call for DynamoDB
+	input := &dynamodb.GetItemInput{
+		Key:            RecordKey(id),
+		TableName:      aws.String(tableName),
+		ConsistentRead: aws.Bool(true),
+	}
+	result, err := dynaClient.GetItem(input)
+	if err != nil {
+		return nil, errors.New(ErrorFailedToFetchRecord)
+	}
+	item := new(Record)
+	err = dynamodbattribute.UnmarshalMap(result.Item, item)
+	if err != nil {
+		return nil, errors.New(ErrorFailedToUnmarshalRecord)
+	}
+	return item, nil
+}
@@ -0,0 +1,48 @@
+package idempotency
+
+import (
+	"aws-lambda-api/pkg/pii"
+	"encoding/base64"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestCompletedRecordSealsResponse(t *testing.T) {
+	resp := &events.APIGatewayProxyResponse{StatusCode: 201, Body: `{"donorEmail": "dana@example.org"}`}
+
+	//Without a key the response is not kept at all
+	if _, err := completedRecord("Idempotency1", "hash", resp); err == nil {
+		t.Fatalf("completedRecord kept a response it could not seal")
+	}
+
+	path := filepath.Join(t.TempDir(), "keys.json")
+	key := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	if err := ioutil.WriteFile(path, []byte(`{"current": "k1", "keys": {"k1": "`+key+`"}}`), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	provider, err := pii.LoadLocalKeyFile(path)
+	if err != nil {
+		t.Fatalf("LoadLocalKeyFile: %v", err)
+	}
+	pii.SetKeyProvider(provider)
+	t.Cleanup(func() { pii.SetKeyProvider(nil) })
+
+	record, err := completedRecord("Idempotency1", "hash", resp)
+	if err != nil {
+		t.Fatalf("completedRecord: %v", err)
+	}
+	if record.Response != nil || record.Sealed == nil || strings.Contains(string(record.Sealed.Ciphertext), "dana@") {
+		t.Fatalf("completedRecord stored the response in the clear: %+v", record)
+	}
+	replayed, err := openResponse(record.Sealed, "Idempotency1")
+	if err != nil || replayed.StatusCode != 201 || replayed.Body != resp.Body {
+		t.Fatalf("openResponse = %+v, %v", replayed, err)
+	}
+	if _, err := openResponse(record.Sealed, "Idempotency2"); err == nil {
+		t.Fatalf("openResponse opened the response of another key")
+	}
+}
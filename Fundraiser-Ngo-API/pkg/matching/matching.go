@@ -0,0 +1,410 @@
+package matching
+
+import (
+	"aws-lambda-api/pkg/audit"
+	"aws-lambda-api/pkg/auth"
+	"aws-lambda-api/pkg/fundraiser"
+	"aws-lambda-api/pkg/money"
+	"aws-lambda-api/pkg/ngo"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+var (
+	ErrorFailedToUnmarshalRecord = "failed to unmarshal record"
+	ErrorFailedToFetchRecord     = "failed to fetch record"
+	ErrorInvalidUserData         = "invalid user data"
+	ErrorCouldNotMarshalItem     = "could not marshal item"
+	ErrorCouldNotDynamoPutItem   = "could not dynamo put item error"
+	ErrorPoolAlreadyExists       = "matching pool already exists"
+	ErrorPoolDoesNotExist        = "matching pool does not exist"
+	ErrorInvalidSponsorType      = "sponsorType must be ngo or corporate"
+	ErrorInvalidMatchRatio       = "matchRatio must be a positive number such as 1 or 0.5"
+	ErrorInvalidCap              = "cap must be positive"
+	ErrorInvalidMaxMatch         = "maxMatchPerDonation must be positive and in the cap's currency"
+	ErrorNoFundraisers           = "a matching pool needs at least one fundraiser"
+	ErrorTooManyFundraisers      = "too many fundraisers for one request"
+	ErrorCurrencyMismatch        = "fundraiser currency does not match the pool's currency"
+	ErrorPoolAlreadyAttached     = "matching pool is already attached to the fundraiser"
+	ErrorPoolChanged             = "matching pool or fundraiser was changed concurrently"
+	ErrorSponsorNgoRequired      = "sponsorNgoId is required for ngo sponsors"
+)
+
+const (
+	StatusActive    = "active"
+	StatusExhausted = "exhausted"
+)
+
+const (
+	SponsorNgo       = "ngo"
+	SponsorCorporate = "corporate"
+)
+
+// A transaction holds at most 25 items; one is the pool and one the audit entry
+const maxFundraisersPerRequest = 23
+
+// MatchingPool is money a sponsor commits to match donations to the
+// attached fundraisers. Each qualifying donation is matched at
+// MatchRatio, up to MaxMatchPerDonation, until Remaining runs out.
+type MatchingPool struct {
+	PoolId              string          `json:"pk"`
+	Pool                string          `json:"sk"`
+	SponsorName         string          `json:"sponsorName"`
+	SponsorType         string          `json:"sponsorType"`
+	SponsorNgoId        string          `json:"sponsorNgoId,omitempty"`
+	Cap                 money.Money     `json:"cap"`
+	Remaining           money.Money     `json:"remaining"`
+	MatchRatio          string          `json:"matchRatio"`
+	MaxMatchPerDonation *money.Money    `json:"maxMatchPerDonation,omitempty"`
+	Fundraisers         []FundraiserRef `json:"fundraisers"`
+	Status              string          `json:"status"`
+	CreatedAt           string          `json:"createdAt"`
+	UpdatedAt           string          `json:"updatedAt"`
+}
+
+// FundraiserRef names a fundraiser owned by exactly one of NgoId or EmailId
+type FundraiserRef struct {
+	NgoId        string `json:"ngoId,omitempty"`
+	EmailId      string `json:"emailId,omitempty"`
+	FundraiserId string `json:"fundraiserId"`
+}
+
+type AttachRequest struct {
+	PoolId      string          `json:"poolId"`
+	Fundraisers []FundraiserRef `json:"fundraisers"`
+}
+
+func PoolKey(poolId string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"pk": {
+			S: aws.String("MatchingPool" + poolId),
+		},
+		"sk": {
+			S: aws.String("Pool"),
+		},
+	}
+}
+
+func FetchPool(poolId string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*MatchingPool, error) {
+	//Macking Call for DynamoDB
+	input := &dynamodb.GetItemInput{
+		Key:       PoolKey(poolId),
+		TableName: aws.String(tableName),
+	}
+	result, err := dynaClient.GetItem(input)
+	if err != nil {
+		return nil, errors.New(ErrorFailedToFetchRecord)
+	}
+
+	//Sending the Get Request
+	item := new(MatchingPool)
+	err = dynamodbattribute.UnmarshalMap(result.Item, item)
+	if err != nil {
+		return nil, errors.New(ErrorFailedToUnmarshalRecord)
+	}
+	return item, nil
+}
+
+// FetchPools loads the pools with the given ids, skipping any that no
+// longer exist.
+func FetchPools(poolIds []string, repository PoolRepository) ([]MatchingPool, error) {
+	pools := []MatchingPool{}
+	for _, poolId := range poolIds {
+		pool, err := repository.GetPool(poolId)
+		if err != nil {
+			return nil, err
+		}
+		if len(pool.PoolId) != 0 {
+			pools = append(pools, *pool)
+		}
+	}
+	return pools, nil
+}
+
+func CreatePool(req events.APIGatewayProxyRequest, fundraisers fundraiser.FundraiserRepository, ngos ngo.NgoRepository, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*MatchingPool,
+	error,
+) {
+	//Checking if the correct request
+	var u MatchingPool
+	if err := json.Unmarshal([]byte(req.Body), &u); err != nil {
+		return nil, errors.New(ErrorInvalidUserData)
+	}
+	if u.PoolId == "" {
+		return nil, errors.New(ErrorInvalidUserData)
+	}
+	if u.SponsorType != SponsorNgo && u.SponsorType != SponsorCorporate {
+		return nil, errors.New(ErrorInvalidSponsorType)
+	}
+	if u.SponsorType == SponsorNgo && u.SponsorNgoId == "" {
+		return nil, errors.New(ErrorSponsorNgoRequired)
+	}
+	if u.SponsorType == SponsorCorporate {
+		u.SponsorNgoId = ""
+	}
+	if err := checkSponsor(req, &u, ngos); err != nil {
+		return nil, err
+	}
+	if _, err := parseRatio(u.MatchRatio); err != nil {
+		return nil, err
+	}
+	if err := u.Cap.Normalize(); err != nil {
+		return nil, err
+	}
+	if u.Cap.Amount == 0 {
+		return nil, errors.New(ErrorInvalidCap)
+	}
+	if u.MaxMatchPerDonation != nil {
+		if err := u.MaxMatchPerDonation.Normalize(); err != nil {
+			return nil, err
+		}
+		if u.MaxMatchPerDonation.Amount == 0 || u.MaxMatchPerDonation.Currency != u.Cap.Currency {
+			return nil, errors.New(ErrorInvalidMaxMatch)
+		}
+	}
+	if len(u.Fundraisers) == 0 {
+		return nil, errors.New(ErrorNoFundraisers)
+	}
+
+	//Modifying the key for DynamoDB Storage
+	poolId := u.PoolId
+	refs := u.Fundraisers
+	if len(refs) > maxFundraisersPerRequest {
+		return nil, errors.New(ErrorTooManyFundraisers)
+	}
+	key := PoolKey(poolId)
+	u.PoolId = aws.StringValue(key["pk"].S)
+	u.Pool = aws.StringValue(key["sk"].S)
+	u.Remaining = u.Cap
+	u.Status = StatusActive
+	u.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	u.UpdatedAt = u.CreatedAt
+
+	av, err := dynamodbattribute.MarshalMap(u)
+	if err != nil {
+		return nil, errors.New(ErrorCouldNotMarshalItem)
+	}
+	pool := &dynamodb.TransactWriteItem{
+		Put: &dynamodb.Put{
+			Item:                av,
+			TableName:           aws.String(tableName),
+			ConditionExpression: aws.String("attribute_not_exists(sk)"),
+		},
+	}
+	return attach(req, poolId, nil, &u, pool, refs, "createMatchingPool", fundraisers, ngos, tableName, dynaClient)
+}
+
+func AttachPool(req events.APIGatewayProxyRequest, fundraisers fundraiser.FundraiserRepository, ngos ngo.NgoRepository, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*MatchingPool,
+	error,
+) {
+	//Checking if the correct request
+	var u AttachRequest
+	if err := json.Unmarshal([]byte(req.Body), &u); err != nil {
+		return nil, errors.New(ErrorInvalidUserData)
+	}
+	if len(u.Fundraisers) == 0 {
+		return nil, errors.New(ErrorNoFundraisers)
+	}
+	if len(u.Fundraisers) > maxFundraisersPerRequest {
+		return nil, errors.New(ErrorTooManyFundraisers)
+	}
+	current, err := FetchPool(u.PoolId, tableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	if len(current.PoolId) == 0 {
+		return nil, errors.New(ErrorPoolDoesNotExist)
+	}
+	//The sponsor's money is only promised to fundraisers the sponsor picks
+	if err := checkSponsor(req, current, ngos); err != nil {
+		return nil, err
+	}
+	for _, f := range u.Fundraisers {
+		for _, attached := range current.Fundraisers {
+			if f == attached {
+				return nil, errors.New(ErrorPoolAlreadyAttached)
+			}
+		}
+	}
+
+	//Appending only to the list we read
+	updatedAt := time.Now().UTC().Format(time.RFC3339)
+	previous, err := dynamodbattribute.Marshal(current.Fundraisers)
+	if err != nil {
+		return nil, errors.New(ErrorCouldNotMarshalItem)
+	}
+	added, err := dynamodbattribute.Marshal(u.Fundraisers)
+	if err != nil {
+		return nil, errors.New(ErrorCouldNotMarshalItem)
+	}
+	pool := &dynamodb.TransactWriteItem{
+		Update: &dynamodb.Update{
+			Key:                 PoolKey(u.PoolId),
+			TableName:           aws.String(tableName),
+			ConditionExpression: aws.String("fundraisers = :previous"),
+			UpdateExpression:    aws.String("SET fundraisers = list_append(fundraisers, :added), updatedAt = :at"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":previous": previous,
+				":added":    added,
+				":at":       {S: aws.String(updatedAt)},
+			},
+		},
+	}
+	updated := *current
+	updated.Fundraisers = append(append([]FundraiserRef{}, current.Fundraisers...), u.Fundraisers...)
+	updated.UpdatedAt = updatedAt
+	return attach(req, u.PoolId, *current, &updated, pool, u.Fundraisers, "attachMatchingPool", fundraisers, ngos, tableName, dynaClient)
+}
+
+// checkSponsor lets admins and the money managers of the sponsoring NGO
+// at a pool. Corporate pools are only set up by admins.
+func checkSponsor(req events.APIGatewayProxyRequest, u *MatchingPool, ngos ngo.NgoRepository) error {
+	if u.SponsorType == SponsorNgo && u.SponsorNgoId != "" {
+		_, _, err := ngo.CheckPermission(req, u.SponsorNgoId, ngo.PermissionManageMoney, ngos)
+		return err
+	}
+	_, err := auth.RequireAdmin(req)
+	return err
+}
+
+// attach writes the pool item together with the pool id on every
+// fundraiser in refs, so a pool is never listed on a fundraiser it cannot
+// match, or the other way round. The caller must also be allowed to move
+// the money of every fundraiser in refs.
+func attach(req events.APIGatewayProxyRequest, poolId string, before interface{}, u *MatchingPool, pool *dynamodb.TransactWriteItem, refs []FundraiserRef, action string, fundraisers fundraiser.FundraiserRepository, ngos ngo.NgoRepository, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*MatchingPool,
+	error,
+) {
+	items := []*dynamodb.TransactWriteItem{pool}
+	for _, ref := range refs {
+		t, err := fundraiser.CheckPermission(req, ref.NgoId, ref.EmailId, ref.FundraiserId, ngo.PermissionManageMoney, false, fundraisers, ngos)
+		if err != nil {
+			return nil, err
+		}
+		if t.Currency != u.Cap.Currency {
+			return nil, errors.New(ErrorCurrencyMismatch)
+		}
+		items = append(items, &dynamodb.TransactWriteItem{
+			Update: &dynamodb.Update{
+				Key:                 t.Key,
+				TableName:           aws.String(tableName),
+				ConditionExpression: aws.String("attribute_exists(sk) AND raisedAmount.currency = :currency"),
+				UpdateExpression:    aws.String("SET matchingPoolIds = list_append(if_not_exists(matchingPoolIds, :empty), :poolIds)"),
+				ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+					":currency": {S: aws.String(u.Cap.Currency)},
+					":empty":    {L: []*dynamodb.AttributeValue{}},
+					":poolIds":  {L: []*dynamodb.AttributeValue{{S: aws.String(poolId)}}},
+				},
+			},
+		})
+	}
+
+	entry := audit.NewEntry("MatchingPool", poolId, action, audit.ActorFromRequest(req), before, *u)
+	auditItem, err := audit.TransactItem(entry, tableName)
+	if err != nil {
+		return nil, err
+	}
+	items = append(items, auditItem)
+
+	_, err = dynaClient.TransactWriteItems(&dynamodb.TransactWriteItemsInput{TransactItems: items})
+	if err != nil {
+		if tce, ok := err.(*dynamodb.TransactionCanceledException); ok {
+			reasons := tce.CancellationReasons
+			if pool.Put != nil && len(reasons) > 0 && aws.StringValue(reasons[0].Code) == "ConditionalCheckFailed" {
+				return nil, errors.New(ErrorPoolAlreadyExists)
+			}
+			return nil, errors.New(ErrorPoolChanged)
+		}
+		return nil, errors.New(ErrorCouldNotDynamoPutItem)
+	}
+	return u, nil
+}
+
+// Plan picks the first of poolIds that can still match a donation of
+// amount, which must be in the fundraiser's currency, and returns it with
+// the amount it would match. A nil pool means the donation is not matched.
+// Only donations verified on chain may be planned, as a match is money
+// the sponsor pays out.
+func Plan(poolIds []string, amount money.Money, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*MatchingPool, money.Money, error) {
+	for _, poolId := range poolIds {
+		pool, err := FetchPool(poolId, tableName, dynaClient)
+		if err != nil {
+			return nil, money.Money{}, err
+		}
+		if len(pool.PoolId) == 0 || pool.Status != StatusActive || pool.Remaining.Currency != amount.Currency {
+			continue
+		}
+		match, err := pool.matchFor(amount)
+		if err != nil {
+			return nil, money.Money{}, err
+		}
+		if match.Amount > 0 {
+			return pool, match, nil
+		}
+	}
+	return nil, money.Money{}, nil
+}
+
+func (p *MatchingPool) matchFor(amount money.Money) (money.Money, error) {
+	ratio, err := parseRatio(p.MatchRatio)
+	if err != nil {
+		return money.Money{}, err
+	}
+	match, err := money.Convert(amount, amount.Currency, ratio)
+	if err != nil {
+		return money.Money{}, err
+	}
+	if p.MaxMatchPerDonation != nil && match.Amount > p.MaxMatchPerDonation.Amount {
+		match.Amount = p.MaxMatchPerDonation.Amount
+	}
+	if match.Amount > p.Remaining.Amount {
+		match.Amount = p.Remaining.Amount
+	}
+	return match, nil
+}
+
+// ReserveItem takes match out of the pool, only if the pool still has the
+// remaining balance it was planned against. This is what keeps
+// concurrent donations from matching more than the cap.
+func ReserveItem(pool *MatchingPool, match money.Money, tableName string) *dynamodb.TransactWriteItem {
+	status := StatusActive
+	if match.Amount == pool.Remaining.Amount {
+		status = StatusExhausted
+	}
+	return &dynamodb.TransactWriteItem{
+		Update: &dynamodb.Update{
+			Key:                 map[string]*dynamodb.AttributeValue{"pk": {S: aws.String(pool.PoolId)}, "sk": {S: aws.String(pool.Pool)}},
+			TableName:           aws.String(tableName),
+			ConditionExpression: aws.String("#status = :active AND remaining.amount = :before"),
+			UpdateExpression:    aws.String("SET remaining.amount = remaining.amount - :match, #status = :status, updatedAt = :at"),
+			ExpressionAttributeNames: map[string]*string{
+				"#status": aws.String("status"),
+			},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":active": {S: aws.String(StatusActive)},
+				":before": {N: aws.String(strconv.FormatInt(pool.Remaining.Amount, 10))},
+				":match":  {N: aws.String(strconv.FormatInt(match.Amount, 10))},
+				":status": {S: aws.String(status)},
+				":at":     {S: aws.String(time.Now().UTC().Format(time.RFC3339))},
+			},
+		},
+	}
+}
+
+func parseRatio(s string) (*big.Rat, error) {
+	ratio, ok := new(big.Rat).SetString(s)
+	if !ok || ratio.Sign() <= 0 {
+		return nil, errors.New(ErrorInvalidMatchRatio)
+	}
+	return ratio, nil
+}
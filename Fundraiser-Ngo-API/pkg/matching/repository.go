@@ -0,0 +1,46 @@
+package matching
+
+import (
+	"aws-lambda-api/pkg/storage"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// PoolRepository reads matching pools for the fundraisers they match.
+// Pools are still written with their fundraisers in one transaction, so
+// writes stay with CreatePool and AttachPool.
+type PoolRepository interface {
+	// GetPool returns an empty pool when there is none with poolId
+	GetPool(poolId string) (*MatchingPool, error)
+}
+
+// DynamoPoolRepository reads pools from the table
+type DynamoPoolRepository struct {
+	tableName  string
+	dynaClient dynamodbiface.DynamoDBAPI
+}
+
+func NewDynamoPoolRepository(tableName string, dynaClient dynamodbiface.DynamoDBAPI) *DynamoPoolRepository {
+	return &DynamoPoolRepository{tableName: tableName, dynaClient: dynaClient}
+}
+
+func (r *DynamoPoolRepository) GetPool(poolId string) (*MatchingPool, error) {
+	return FetchPool(poolId, r.tableName, r.dynaClient)
+}
+
+// MemoryPoolRepository reads pools from a storage.MemoryTable
+type MemoryPoolRepository struct {
+	table *storage.MemoryTable
+}
+
+func NewMemoryPoolRepository(table *storage.MemoryTable) *MemoryPoolRepository {
+	return &MemoryPoolRepository{table: table}
+}
+
+func (r *MemoryPoolRepository) GetPool(poolId string) (*MatchingPool, error) {
+	item := new(MatchingPool)
+	if err := r.table.Get(storage.Key{PK: "MatchingPool" + poolId, SK: "Pool"}, item); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
@@ -0,0 +1,73 @@
+package money
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+var ErrorInvalidLegacyAmount = "invalid legacy amount"
+
+// LegacyCurrency is the currency of amounts stored as plain strings,
+// before amounts carried their own currency
+const LegacyCurrency = "USD"
+
+// UnmarshalDynamoDBAttributeValue reads Money stored as a map, and also
+// the plain strings fundraiser targets were stored as before, e.g.
+// "5000", "$5,000.50" or "5000 EUR"
+func (m *Money) UnmarshalDynamoDBAttributeValue(av *dynamodb.AttributeValue) error {
+	if av.S != nil {
+		legacy, err := ParseLegacy(*av.S)
+		if err != nil {
+			return err
+		}
+		*m = legacy
+		return nil
+	}
+	//Decoding through another type so this method is not called again
+	type stored Money
+	var s stored
+	if err := dynamodbattribute.Unmarshal(av, &s); err != nil {
+		return err
+	}
+	*m = Money(s)
+	return nil
+}
+
+// ParseLegacy reads an amount in major units, with an optional currency
+// code before or after it, and LegacyCurrency when there is none.
+// Precision below the minor unit is dropped.
+func ParseLegacy(s string) (Money, error) {
+	m := Money{Currency: LegacyCurrency}
+	fields := strings.Fields(strings.NewReplacer(",", "", "$", "").Replace(s))
+	switch len(fields) {
+	case 0:
+		return Money{Amount: 0, Currency: LegacyCurrency}, nil
+	case 1:
+	case 2:
+		if _, ok := currencies[strings.ToUpper(fields[0])]; ok {
+			fields[0], fields[1] = fields[1], fields[0]
+		}
+		m.Currency = fields[1]
+	default:
+		return Money{}, errors.New(ErrorInvalidLegacyAmount)
+	}
+	if err := m.Normalize(); err != nil {
+		return Money{}, errors.New(ErrorInvalidLegacyAmount)
+	}
+
+	amount, ok := new(big.Rat).SetString(fields[0])
+	if !ok || amount.Sign() < 0 {
+		return Money{}, errors.New(ErrorInvalidLegacyAmount)
+	}
+	amount.Mul(amount, new(big.Rat).SetInt(pow10(currencies[m.Currency])))
+	minor := new(big.Int).Quo(amount.Num(), amount.Denom())
+	if !minor.IsInt64() {
+		return Money{}, errors.New(ErrorAmountOverflow)
+	}
+	m.Amount = minor.Int64()
+	return m, nil
+}
@@ -0,0 +1,128 @@
+package money
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+)
+
+var (
+	ErrorUnknownCurrency  = "unknown currency"
+	ErrorNegativeAmount   = "amount must not be negative"
+	ErrorCurrencyMismatch = "currencies do not match"
+	ErrorAmountOverflow   = "amount is too large"
+)
+
+// Money is an amount in the currency's minor units, e.g. cents for USD.
+type Money struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// currencies maps the supported ISO 4217 and crypto asset codes to the
+// number of decimals in their minor unit. EVM coins are tracked in gwei
+// and SOL in lamports, since smaller units would overflow int64.
+var currencies = map[string]int{
+	"USD": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"CHF": 2,
+	"CAD": 2,
+	"AUD": 2,
+	"INR": 2,
+	"BRL": 2,
+	"NGN": 2,
+	"KES": 2,
+	"JPY": 0,
+
+	"BTC":   8,
+	"ETH":   9,
+	"MATIC": 9,
+	"BNB":   9,
+	"SOL":   9,
+	"USDC":  6,
+	"USDT":  6,
+}
+
+var cryptoCurrencies = map[string]bool{
+	"BTC":   true,
+	"ETH":   true,
+	"MATIC": true,
+	"BNB":   true,
+	"SOL":   true,
+	"USDC":  true,
+	"USDT":  true,
+}
+
+func Decimals(currency string) (int, bool) {
+	d, ok := currencies[currency]
+	return d, ok
+}
+
+func IsCrypto(currency string) bool {
+	return cryptoCurrencies[currency]
+}
+
+// Normalize upper-cases the currency code and checks m is usable.
+func (m *Money) Normalize() error {
+	m.Currency = strings.ToUpper(strings.TrimSpace(m.Currency))
+	if _, ok := currencies[m.Currency]; !ok {
+		return errors.New(ErrorUnknownCurrency)
+	}
+	if m.Amount < 0 {
+		return errors.New(ErrorNegativeAmount)
+	}
+	return nil
+}
+
+// String formats m in major units, e.g. "12.50 USD".
+func (m Money) String() string {
+	decimals := currencies[m.Currency]
+	amount := new(big.Rat).SetFrac(big.NewInt(m.Amount), pow10(decimals))
+	return amount.FloatString(decimals) + " " + m.Currency
+}
+
+// FromBaseUnits converts an on-chain value with baseDecimals decimals,
+// e.g. wei with 18, to Money. Precision below the minor unit is dropped.
+func FromBaseUnits(value *big.Int, baseDecimals int, currency string) (Money, error) {
+	decimals, ok := currencies[currency]
+	if !ok {
+		return Money{}, errors.New(ErrorUnknownCurrency)
+	}
+	amount := new(big.Int).Set(value)
+	if baseDecimals > decimals {
+		amount.Quo(amount, pow10(baseDecimals-decimals))
+	} else {
+		amount.Mul(amount, pow10(decimals-baseDecimals))
+	}
+	if !amount.IsInt64() {
+		return Money{}, errors.New(ErrorAmountOverflow)
+	}
+	return Money{Amount: amount.Int64(), Currency: currency}, nil
+}
+
+// Convert expresses m in currency to using rate, the price of one major
+// unit of m.Currency in major units of to. The result is rounded down.
+func Convert(m Money, to string, rate *big.Rat) (Money, error) {
+	fromDecimals, ok := currencies[m.Currency]
+	if !ok {
+		return Money{}, errors.New(ErrorUnknownCurrency)
+	}
+	toDecimals, ok := currencies[to]
+	if !ok {
+		return Money{}, errors.New(ErrorUnknownCurrency)
+	}
+
+	amount := new(big.Rat).SetInt64(m.Amount)
+	amount.Mul(amount, rate)
+	amount.Mul(amount, new(big.Rat).SetFrac(pow10(toDecimals), pow10(fromDecimals)))
+	converted := new(big.Int).Quo(amount.Num(), amount.Denom())
+	if !converted.IsInt64() {
+		return Money{}, errors.New(ErrorAmountOverflow)
+	}
+	return Money{Amount: converted.Int64(), Currency: to}, nil
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
@@ -0,0 +1,107 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"strings"
+)
+
+var (
+	ErrorRateNotAvailable = "no conversion rate available"
+	ErrorInvalidPriceFile = "invalid price file"
+)
+
+// Rate is the price of one major unit of From in major units of To.
+type Rate struct {
+	From  string   `json:"from"`
+	To    string   `json:"to"`
+	Value *big.Rat `json:"-"`
+	AsOf  string   `json:"asOf"`
+}
+
+// String renders the rate as a decimal for storing with a donation.
+func (r *Rate) String() string {
+	s := r.Value.FloatString(12)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+type PriceOracle interface {
+	Rate(from string, to string) (*Rate, error)
+}
+
+// StaticOracle serves rates from a JSON file of the form
+//
+//	{"asOf": "2021-06-01T00:00:00Z", "rates": {"ETH": {"USD": "2700.15"}}}
+//
+// Inverse rates are derived when only one direction is listed, and other
+// pairs are crossed through USD.
+type StaticOracle struct {
+	asOf  string
+	rates map[string]map[string]*big.Rat
+}
+
+type priceFile struct {
+	AsOf  string                       `json:"asOf"`
+	Rates map[string]map[string]string `json:"rates"`
+}
+
+func LoadStaticOracle(path string) (*StaticOracle, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f priceFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, errors.New(ErrorInvalidPriceFile)
+	}
+
+	o := &StaticOracle{asOf: f.AsOf, rates: map[string]map[string]*big.Rat{}}
+	for from, quotes := range f.Rates {
+		for to, value := range quotes {
+			rate, ok := new(big.Rat).SetString(value)
+			if !ok || rate.Sign() <= 0 {
+				return nil, errors.New(ErrorInvalidPriceFile)
+			}
+			o.set(from, to, rate)
+		}
+	}
+	return o, nil
+}
+
+func (o *StaticOracle) set(from string, to string, rate *big.Rat) {
+	if o.rates[from] == nil {
+		o.rates[from] = map[string]*big.Rat{}
+	}
+	o.rates[from][to] = rate
+}
+
+func (o *StaticOracle) Rate(from string, to string) (*Rate, error) {
+	rate, ok := o.direct(from, to)
+	if !ok {
+		toPivot, ok1 := o.direct(from, pivotCurrency)
+		fromPivot, ok2 := o.direct(pivotCurrency, to)
+		if !ok1 || !ok2 {
+			return nil, errors.New(ErrorRateNotAvailable)
+		}
+		rate = new(big.Rat).Mul(toPivot, fromPivot)
+	}
+	return &Rate{From: from, To: to, Value: rate, AsOf: o.asOf}, nil
+}
+
+const pivotCurrency = "USD"
+
+func (o *StaticOracle) direct(from string, to string) (*big.Rat, bool) {
+	if from == to {
+		return big.NewRat(1, 1), true
+	}
+	if rate, ok := o.rates[from][to]; ok {
+		return new(big.Rat).Set(rate), true
+	}
+	if rate, ok := o.rates[to][from]; ok {
+		return new(big.Rat).Inv(rate), true
+	}
+	return nil, false
+}
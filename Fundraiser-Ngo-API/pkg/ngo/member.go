@@ -0,0 +1,268 @@
+package ngo
+
+import (
+	"aws-lambda-api/pkg/audit"
+	"aws-lambda-api/pkg/auth"
+	"aws-lambda-api/pkg/page"
+	"aws-lambda-api/pkg/storage"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+var (
+	ErrorInvalidRole         = "role must be one of owner, admin, editor or viewer"
+	ErrorMemberAlreadyExists = "user is already a member of the ngo"
+	ErrorMemberDoesNotExist  = "user is not a member of the ngo"
+	ErrorMemberIsNgoOwner    = "user is the ngo's owner"
+	ErrorMemberChanged       = "member was changed concurrently"
+	ErrorCannotManageRole    = "only owners may manage owners and admins"
+	ErrorUserRequired        = "user is required"
+)
+
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+const (
+	PermissionViewMembers       = "viewMembers"
+	PermissionManageMembers     = "manageMembers"
+	PermissionEditNgo           = "editNgo"
+	PermissionDeleteNgo         = "deleteNgo"
+	PermissionManageWallets     = "manageWallets"
+	PermissionEditFundraisers   = "editFundraisers"
+	PermissionDeleteFundraisers = "deleteFundraisers"
+	PermissionPostUpdates       = "postUpdates"
+	PermissionManageMoney       = "manageMoney"
+)
+
+// rolePermissions lists what each member role may do. Owners may do
+// everything; admins everything but deleting the NGO.
+var rolePermissions = map[string][]string{
+	RoleAdmin: {
+		PermissionViewMembers, PermissionManageMembers, PermissionEditNgo, PermissionManageWallets,
+		PermissionEditFundraisers, PermissionDeleteFundraisers, PermissionPostUpdates, PermissionManageMoney,
+	},
+	RoleEditor: {PermissionViewMembers, PermissionEditFundraisers, PermissionPostUpdates},
+	RoleViewer: {PermissionViewMembers},
+}
+
+// Member gives a user a role in an NGO. The NGO's creator, NgoOwner, is
+// always an owner and has no member item.
+type Member struct {
+	NgoId     string `json:"pk"`
+	MemberId  string `json:"sk"`
+	User      string `json:"user"`
+	Role      string `json:"role"`
+	AddedBy   string `json:"addedBy"`
+	AddedAt   string `json:"addedAt"`
+	UpdatedAt string `json:"updatedAt"`
+}
+
+type MemberRequest struct {
+	NgoId string `json:"ngoId"`
+	User  string `json:"user"`
+	Role  string `json:"role"`
+}
+
+func MemberKey(ngoId string, user string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"pk": {
+			S: aws.String("Ngo" + ngoId),
+		},
+		"sk": {
+			S: aws.String("Member" + strings.ToLower(user)),
+		},
+	}
+}
+
+// storedMemberKey is the key of a member read from the table
+func storedMemberKey(m *Member) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"pk": {
+			S: aws.String(m.NgoId),
+		},
+		"sk": {
+			S: aws.String(m.MemberId),
+		},
+	}
+}
+
+func FetchMembers(req events.APIGatewayProxyRequest, ngoId string, p page.Request, ngos NgoRepository) (*[]Member, *storage.Key, error) {
+	if _, _, err := CheckPermission(req, ngoId, PermissionViewMembers, ngos); err != nil {
+		return nil, nil, err
+	}
+	return ngos.ListMembers(ngoId, p)
+}
+
+// CheckPermission loads the NGO if the caller of req has permission in
+// it, and returns the caller's role. Platform admins and the NGO's
+// creator act as owners. Money only moves for verified NGOs, whoever
+// the caller is.
+func CheckPermission(req events.APIGatewayProxyRequest, ngoId string, permission string, ngos NgoRepository) (*Ngo, string, error) {
+	caller, err := auth.FromRequest(req)
+	if err != nil {
+		return nil, "", err
+	}
+	currentNgo, err := ngos.GetNgo(ngoId)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(currentNgo.NgoId) == 0 {
+		return nil, "", errors.New(ErrorUserDoesNotExists)
+	}
+	role := RoleOwner
+	if !caller.Owns(currentNgo.NgoOwner) {
+		member, err := ngos.GetMember(ngoId, caller.Name())
+		if err != nil {
+			return nil, "", err
+		}
+		if !Allowed(member.Role, permission) {
+			return nil, "", errors.New(auth.ErrorForbidden)
+		}
+		role = member.Role
+	}
+	if verifiedPermissions[permission] && currentNgo.Status() != VerificationVerified {
+		return nil, "", errors.New(ErrorNgoNotVerified)
+	}
+	return currentNgo, role, nil
+}
+
+// Allowed reports whether role grants permission
+func Allowed(role string, permission string) bool {
+	if role == RoleOwner {
+		return true
+	}
+	for _, p := range rolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+func AddMember(req events.APIGatewayProxyRequest, ngos NgoRepository) (
+	*Member,
+	error,
+) {
+	//Checking if the correct request
+	var u MemberRequest
+	if err := json.Unmarshal([]byte(req.Body), &u); err != nil {
+		return nil, errors.New(ErrorInvalidUserData)
+	}
+	if _, err := checkMemberRequest(req, &u, u.Role, ngos); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	key := MemberKey(u.NgoId, u.User)
+	m := Member{
+		NgoId:     aws.StringValue(key["pk"].S),
+		MemberId:  aws.StringValue(key["sk"].S),
+		User:      u.User,
+		Role:      u.Role,
+		AddedBy:   audit.ActorFromRequest(req),
+		AddedAt:   now,
+		UpdatedAt: now,
+	}
+	entry := audit.NewEntry("Ngo", u.NgoId, "addMember", m.AddedBy, nil, m)
+	if err := ngos.AddMember(&m, entry); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func UpdateMember(req events.APIGatewayProxyRequest, ngos NgoRepository) (
+	*Member,
+	error,
+) {
+	//Checking if the correct request
+	var u MemberRequest
+	if err := json.Unmarshal([]byte(req.Body), &u); err != nil {
+		return nil, errors.New(ErrorInvalidUserData)
+	}
+	callerRole, err := checkMemberRequest(req, &u, u.Role, ngos)
+	if err != nil {
+		return nil, err
+	}
+	current, err := currentMember(u.NgoId, u.User, callerRole, ngos)
+	if err != nil {
+		return nil, err
+	}
+
+	updated := *current
+	updated.Role = u.Role
+	updated.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	entry := audit.NewEntry("Ngo", u.NgoId, "updateMember", audit.ActorFromRequest(req), *current, updated)
+	if err := ngos.UpdateMember(&updated, current, entry); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+func RemoveMember(req events.APIGatewayProxyRequest, ngos NgoRepository) error {
+	//ngoId and user from req
+	u := MemberRequest{
+		NgoId: req.QueryStringParameters["ngoId"],
+		User:  req.QueryStringParameters["user"],
+	}
+	callerRole, err := checkMemberRequest(req, &u, RoleViewer, ngos)
+	if err != nil {
+		return err
+	}
+	current, err := currentMember(u.NgoId, u.User, callerRole, ngos)
+	if err != nil {
+		return err
+	}
+
+	entry := audit.NewEntry("Ngo", u.NgoId, "removeMember", audit.ActorFromRequest(req), *current, nil)
+	return ngos.RemoveMember(current, entry)
+}
+
+// checkMemberRequest validates u and makes sure the caller may give role
+// to a member, returning the caller's role. Admins only manage editors
+// and viewers.
+func checkMemberRequest(req events.APIGatewayProxyRequest, u *MemberRequest, role string, ngos NgoRepository) (string, error) {
+	u.User = strings.ToLower(strings.TrimSpace(u.User))
+	if u.User == "" {
+		return "", errors.New(ErrorUserRequired)
+	}
+	if role != RoleOwner && rolePermissions[role] == nil {
+		return "", errors.New(ErrorInvalidRole)
+	}
+	currentNgo, callerRole, err := CheckPermission(req, u.NgoId, PermissionManageMembers, ngos)
+	if err != nil {
+		return "", err
+	}
+	if strings.EqualFold(currentNgo.NgoOwner, u.User) {
+		return "", errors.New(ErrorMemberIsNgoOwner)
+	}
+	if callerRole != RoleOwner && (role == RoleOwner || role == RoleAdmin) {
+		return "", errors.New(ErrorCannotManageRole)
+	}
+	return callerRole, nil
+}
+
+// currentMember loads the member being changed, which admins may only do
+// for editors and viewers
+func currentMember(ngoId string, user string, callerRole string, ngos NgoRepository) (*Member, error) {
+	current, err := ngos.GetMember(ngoId, user)
+	if err != nil {
+		return nil, err
+	}
+	if len(current.MemberId) == 0 {
+		return nil, errors.New(ErrorMemberDoesNotExist)
+	}
+	if callerRole != RoleOwner && (current.Role == RoleOwner || current.Role == RoleAdmin) {
+		return nil, errors.New(ErrorCannotManageRole)
+	}
+	return current, nil
+}
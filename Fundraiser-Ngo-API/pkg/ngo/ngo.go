@@ -1,15 +1,18 @@
 package ngo
 
 import (
+	"aws-lambda-api/pkg/audit"
+	"aws-lambda-api/pkg/auth"
+	"aws-lambda-api/pkg/etag"
+	"aws-lambda-api/pkg/patch"
+	"aws-lambda-api/pkg/validate"
+	"aws-lambda-api/pkg/wallet"
 	"encoding/json"
 	"errors"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
-	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
-	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
-	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
 )
 
 var (
@@ -25,181 +28,232 @@ var (
 
 type Ngo struct {
 	PK             string `json:"pk"`
-	NgoId          string `json:"sk"`
-	NgoName        string `json:"ngoName"`
-	NgoAdress      string `json:"ngoAdress"`
-	NgoCountry     string `json:"ngoCountry"`
-	NgoDescription string `json:"ngoDescription"`
-	NgoPhoto       string `json:"ngoPhoto"`
-	NgoCategory    string `json:"ngoCategory"`
+	NgoId          string `json:"sk" validate:"required,id,max=64"`
+	NgoName        string `json:"ngoName" validate:"required,max=200"`
+	NgoAdress      string `json:"ngoAdress" validate:"max=500"`
+	NgoCountry     string `json:"ngoCountry" validate:"required,max=100"`
+	NgoDescription string `json:"ngoDescription" validate:"max=5000"`
+	NgoPhoto       string `json:"ngoPhoto" validate:"url,max=2048"`
+	NgoCategory    string `json:"ngoCategory" validate:"required,max=100"`
+	//Legal details printed on donation receipts
+	NgoLegalName          string `json:"ngoLegalName" validate:"max=200"`
+	NgoRegistrationNumber string `json:"ngoRegistrationNumber" validate:"max=100"`
+	NgoTaxId              string `json:"ngoTaxId" validate:"max=100"`
+	//Wallets are only changed through AddNgoWallet and RemoveNgoWallet
+	NgoWallets []wallet.Wallet `json:"ngoWallets,omitempty"`
+	//Owner is whoever created the NGO; only they or an admin may change it
+	NgoOwner string `json:"ngoOwner"`
+	//Verification is only changed through the verification endpoints;
+	//NGOs from before verification have no status and count as unverified
+	VerificationStatus string `json:"verificationStatus"`
+	VerifiedAt         string `json:"verifiedAt,omitempty"`
+	//Version counts every change to the NGO and is sent as its ETag
+	Version int64 `json:"version"`
 }
 
-func FetchNgo(ngoId string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Ngo, error) {
-	//Modifying the key for DynamoDB Storage
-	ngoId = "Ngo" + ngoId
-
-	//Macking Call for DynamoDB
-	input := &dynamodb.GetItemInput{
-		Key: map[string]*dynamodb.AttributeValue{
-			"pk": {
-				S: aws.String("DetailsNGO"),
-			},
-			"sk": {
-				S: aws.String(ngoId),
-			},
+func NgoKey(ngoId string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"pk": {
+			S: aws.String("DetailsNGO"),
+		},
+		"sk": {
+			S: aws.String("Ngo" + ngoId),
 		},
-		TableName: aws.String(tableName),
-	}
-
-	result, err := dynaClient.GetItem(input)
-	if err != nil {
-		return nil, errors.New(ErrorFailedToFetchRecord)
-
 	}
+}
 
-	//Sending the Get Request
-	item := new(Ngo)
-	err = dynamodbattribute.UnmarshalMap(result.Item, item)
-	if err != nil {
-		return nil, errors.New(ErrorFailedToUnmarshalRecord)
+func CreateNgo(req events.APIGatewayProxyRequest, ngos NgoRepository) (
+	*Ngo,
+	error,
+) {
+	//Checking if the correct request
+	var u Ngo
+	if err := json.Unmarshal([]byte(req.Body), &u); err != nil {
+		return nil, errors.New(ErrorInvalidUserData)
 	}
-	return item, nil
-}
-func FetchNgos(countries string, categories string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*[]Ngo, error) {
-	//For FetchNgos :-
-	//  (1) query for all Ngos 
-	//  (2) then filter out required data by filtering attribute
-	//Macking Key Condition for QueryInput
-	keyCond := expression.KeyAnd(
-		expression.Key("pk").Equal(expression.Value("DetailsNGO")),
-		expression.Key("sk").BeginsWith("Ngo"),
-	)
-
-	//Macking filter for QueryInput
-	filt := expression.And(expression.Name("ngoCountry").Contains(countries), expression.Name("ngoCategory").Contains(categories))
-
-	expr, err := expression.NewBuilder().
-		WithKeyCondition(keyCond).
-		WithFilter(filt).
-		Build()
-	if err != nil {
+	if err := validate.Struct(u); err != nil {
 		return nil, err
 	}
-	//Macking Call for DynamoDB
-	input := &dynamodb.QueryInput{
-		TableName:                 aws.String(tableName),
-		ExpressionAttributeNames:  expr.Names(),
-		ExpressionAttributeValues: expr.Values(),
-		KeyConditionExpression:    expr.KeyCondition(),
-		FilterExpression:          expr.Filter(),
-	}
 
-	result, err := dynaClient.Query(input)
+	//The caller owns the NGO they create
+	caller, err := auth.FromRequest(req)
 	if err != nil {
-		return nil, errors.New(ErrorFailedToFetchRecord)
+		return nil, err
 	}
 
-	//Sending Get Request
-	var items *[]Ngo
-	err = dynamodbattribute.UnmarshalListOfMaps(result.Items, &items)
+	//Modifying the key for DynamoDB Storage
+	ngoId := u.NgoId
+	u.PK = "DetailsNGO"
+	u.NgoId = "Ngo" + u.NgoId
+	u.NgoWallets = nil
+	u.NgoOwner = caller.Name()
+	u.VerificationStatus = VerificationUnverified
+	u.VerifiedAt = ""
+	u.Version = 1
+
+	//Puting it to DynamoDB, unless the NGO already exists
+	entry := audit.NewEntry("Ngo", ngoId, "createNgo", caller.Name(), nil, u)
+	err = ngos.CreateNgo(&u, entry)
 	if err != nil {
-		return nil, errors.New(ErrorFailedToUnmarshalRecord)
+		return nil, err
 	}
-	return items, nil
+	return &u, nil
 }
-func CreateNgo(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+
+func UpdateNgo(req events.APIGatewayProxyRequest, ngos NgoRepository) (
 	*Ngo,
 	error,
 ) {
-	//Checking if the correct request
 	var u Ngo
+	//Checking if the correct request
 	if err := json.Unmarshal([]byte(req.Body), &u); err != nil {
 		return nil, errors.New(ErrorInvalidUserData)
 	}
-
-	//Modifying the key for DynamoDB Storage
-	u.PK = "DetailsNGO"
-	u.NgoId = "Ngo" + u.NgoId
-
-	//Marshaling the data
-	av, err := dynamodbattribute.MarshalMap(u)
-	if err != nil {
-		println("err2")
-		println(err)
-		return nil, errors.New(ErrorCouldNotMarshalItem)
+	if err := validate.Struct(u); err != nil {
+		return nil, err
 	}
 
-	//Puting it to DynamoDB
-	input := &dynamodb.PutItemInput{
-		Item:      av,
-		TableName: aws.String(tableName),
-	}
-	_, err = dynaClient.PutItem(input)
+	// Check if ngo exists and the caller may change it
+	currentNgo, _, err := CheckPermission(req, u.NgoId, PermissionEditNgo, ngos)
 	if err != nil {
-		println("err3")
-		println(err)
-		return nil, errors.New(ErrorCouldNotDynamoPutItem)
+		return nil, err
 	}
-	return &u, nil
+	return saveNgo(req, u, currentNgo, ngos)
 }
 
-func UpdateNgo(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+// PatchNgo changes only the fields of the NGO given by the ngoId query
+// parameter that the JSON Merge Patch body of req names
+func PatchNgo(req events.APIGatewayProxyRequest, ngos NgoRepository) (
 	*Ngo,
 	error,
 ) {
+	ngoId := req.QueryStringParameters["ngoId"]
+	currentNgo, _, err := CheckPermission(req, ngoId, PermissionEditNgo, ngos)
+	if err != nil {
+		return nil, err
+	}
+
+	//Patching the NGO as clients send it, whose key is not theirs to change
 	var u Ngo
-	//Checking if the correct request
-	if err := json.Unmarshal([]byte(req.Body), &u); err != nil {
-		return nil, errors.New(ErrorInvalidUserData)
+	target := *currentNgo
+	target.NgoId = ngoId
+	if err := patch.Merge(target, req.Body, &u); err != nil {
+		return nil, err
 	}
+	u.NgoId = ngoId
+	if err := validate.Struct(u); err != nil {
+		return nil, err
+	}
+	return saveNgo(req, u, currentNgo, ngos)
+}
 
-	// Check if ngo exists
-	currentNgo, _ := FetchNgo(u.NgoId, tableName, dynaClient)
-	if currentNgo != nil && len(currentNgo.NgoId) == 0 {
-		return nil, errors.New(ErrorUserDoesNotExists)
+// saveNgo stores u over currentNgo, keeping the fields only other
+// endpoints may change
+func saveNgo(req events.APIGatewayProxyRequest, u Ngo, currentNgo *Ngo, ngos NgoRepository) (*Ngo, error) {
+	if err := etag.Check(req, currentNgo.Version); err != nil {
+		return nil, err
 	}
 
 	// Save ngo
+	ngoId := u.NgoId
 	u.PK = "DetailsNGO"
 	u.NgoId = "Ngo" + u.NgoId
-	av, err := dynamodbattribute.MarshalMap(u)
-	if err != nil {
-		return nil, errors.New(ErrorCouldNotMarshalItem)
-	}
-	input := &dynamodb.PutItemInput{
-		Item:      av,
-		TableName: aws.String(tableName),
+	u.NgoWallets = currentNgo.NgoWallets
+	u.NgoOwner = currentNgo.NgoOwner
+	u.VerificationStatus = currentNgo.VerificationStatus
+	u.VerifiedAt = currentNgo.VerifiedAt
+	u.Version = currentNgo.Version + 1
+	//Changing the legal identity of a verified NGO needs a new review
+	if u.VerificationStatus == VerificationVerified && currentNgo.legalIdentityChanged(&u) {
+		u.VerificationStatus = VerificationPending
+		u.VerifiedAt = ""
 	}
-	_, err = dynaClient.PutItem(input)
+	entry := audit.NewEntry("Ngo", ngoId, "updateNgo", audit.ActorFromRequest(req), *currentNgo, u)
+	err := ngos.UpdateNgo(&u, currentNgo, entry)
 	if err != nil {
-		return nil, errors.New(ErrorCouldNotDynamoPutItem)
+		return nil, err
 	}
 	return &u, nil
 }
 
-func DeleteNgo(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+func DeleteNgo(req events.APIGatewayProxyRequest, ngos NgoRepository) error {
 	//ngoId from req
 	ngoId := req.QueryStringParameters["ngoId"]
-
-	//Modifying the key for DynamoDB Storage
-	ngoId = "Ngo" + ngoId
-	input := &dynamodb.DeleteItemInput{
-		Key: map[string]*dynamodb.AttributeValue{
-			"pk": {
-				S: aws.String("DetailsNGO"),
-			},
-			"sk": {
-				S: aws.String(ngoId),
-			},
-		},
-		TableName: aws.String(tableName),
+	currentNgo, _, err := CheckPermission(req, ngoId, PermissionDeleteNgo, ngos)
+	if err != nil {
+		return err
+	}
+	if err := etag.Check(req, currentNgo.Version); err != nil {
+		return err
 	}
+	entry := audit.NewEntry("Ngo", ngoId, "deleteNgo", audit.ActorFromRequest(req), *currentNgo, nil)
 
 	//Deleting the NGO
-	_, err := dynaClient.DeleteItem(input)
+	err = ngos.DeleteNgo(ngoId, currentNgo.Version, entry)
 	if err != nil {
-		return errors.New(ErrorCouldNotDeleteItem)
+		return err
 	}
 
 	return nil
 }
+
+type NgoWalletRequest struct {
+	NgoId  string        `json:"ngoId"`
+	Wallet wallet.Wallet `json:"wallet"`
+}
+
+func AddNgoWallet(req events.APIGatewayProxyRequest, ngos NgoRepository) (
+	*Ngo,
+	error,
+) {
+	//Checking if the correct request
+	var u NgoWalletRequest
+	if err := json.Unmarshal([]byte(req.Body), &u); err != nil {
+		return nil, errors.New(ErrorInvalidUserData)
+	}
+	return changeNgoWallets(req, u, "addWallet", wallet.Add, ngos)
+}
+
+func RemoveNgoWallet(req events.APIGatewayProxyRequest, ngos NgoRepository) (
+	*Ngo,
+	error,
+) {
+	//ngoId and wallet from req
+	u := NgoWalletRequest{
+		NgoId: req.QueryStringParameters["ngoId"],
+		Wallet: wallet.Wallet{
+			Chain:   req.QueryStringParameters["chain"],
+			Asset:   req.QueryStringParameters["asset"],
+			Address: req.QueryStringParameters["address"],
+		},
+	}
+	return changeNgoWallets(req, u, "removeWallet", wallet.Remove, ngos)
+}
+
+func changeNgoWallets(req events.APIGatewayProxyRequest, u NgoWalletRequest, action string, change func([]wallet.Wallet, wallet.Wallet) ([]wallet.Wallet, error), ngos NgoRepository) (
+	*Ngo,
+	error,
+) {
+	if err := u.Wallet.Validate(); err != nil {
+		return nil, err
+	}
+
+	// Check if ngo exists and the caller may change it
+	currentNgo, _, err := CheckPermission(req, u.NgoId, PermissionManageWallets, ngos)
+	if err != nil {
+		return nil, err
+	}
+
+	// Save wallets together with their audit entry
+	wallets, err := change(currentNgo.NgoWallets, u.Wallet)
+	if err != nil {
+		return nil, err
+	}
+	entry := audit.NewEntry("Ngo", u.NgoId, action, audit.ActorFromRequest(req), currentNgo.NgoWallets, wallets)
+	err = ngos.SaveWallets(u.NgoId, currentNgo.NgoWallets, wallets, entry)
+	if err != nil {
+		return nil, err
+	}
+	currentNgo.NgoWallets = wallets
+	return currentNgo, nil
+}
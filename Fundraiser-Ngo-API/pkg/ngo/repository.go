@@ -0,0 +1,557 @@
+package ngo
+
+import (
+	"aws-lambda-api/pkg/audit"
+	"aws-lambda-api/pkg/etag"
+	"aws-lambda-api/pkg/page"
+	"aws-lambda-api/pkg/patch"
+	"aws-lambda-api/pkg/storage"
+	"aws-lambda-api/pkg/wallet"
+	"errors"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+// NgoRepository stores NGOs with their members, verification and
+// wallets. NGOs and members are passed with their stored keys, and each
+// write records its audit entry together with the change. Creating an NGO
+// that exists fails with ErrorUserAlreadyExists, and changing one that
+// does not with ErrorUserDoesNotExists. Changes are only made to an NGO
+// still at the version of current, and fail with
+// etag.ErrorPreconditionFailed otherwise. Updates only write the
+// attributes that differ from current, so attributes the Ngo type does
+// not know are kept. Lists return the page asked for and the key the next
+// page starts after, nil on the last page.
+//
+// Members, verification and wallets are only changed while they are as
+// they were read, in current or previous. Otherwise member writes fail
+// with ErrorMemberChanged, or ErrorMemberAlreadyExists for a member that
+// was added, verification writes with ErrorVerificationChanged and wallet
+// writes with wallet.ErrorWalletsChangedOrGone.
+type NgoRepository interface {
+	// GetNgo returns an empty NGO when there is none with ngoId
+	GetNgo(ngoId string) (*Ngo, error)
+	ListNgos(filter NgoFilter, p page.Request) (*[]Ngo, *storage.Key, error)
+	CreateNgo(u *Ngo, entry *audit.Entry) error
+	UpdateNgo(u *Ngo, current *Ngo, entry *audit.Entry) error
+	DeleteNgo(ngoId string, previous int64, entry *audit.Entry) error
+	// SaveWallets replaces the wallets of the NGO and counts its version up
+	SaveWallets(ngoId string, previous []wallet.Wallet, wallets []wallet.Wallet, entry *audit.Entry) error
+
+	// GetMember returns an empty member when user is not one
+	GetMember(ngoId string, user string) (*Member, error)
+	ListMembers(ngoId string, p page.Request) (*[]Member, *storage.Key, error)
+	AddMember(m *Member, entry *audit.Entry) error
+	// UpdateMember changes the role of the member
+	UpdateMember(m *Member, current *Member, entry *audit.Entry) error
+	RemoveMember(current *Member, entry *audit.Entry) error
+
+	// GetVerification returns an empty verification when the NGO has
+	// submitted none
+	GetVerification(ngoId string) (*Verification, error)
+	// SaveVerification saves u and moves the NGO from one status to
+	// another, counting its version up. verifiedAt is kept on the NGO when
+	// given and removed otherwise.
+	SaveVerification(ngoId string, from string, to string, verifiedAt string, u *Verification, current *Verification, entry *audit.Entry) error
+}
+
+// NgoFilter narrows ListNgos to NGOs whose country and category contain
+// the given strings. Only verified NGOs are listed unless
+// IncludeUnverified is set.
+type NgoFilter struct {
+	Countries         string
+	Categories        string
+	IncludeUnverified bool
+}
+
+func (f NgoFilter) matches(u *Ngo) bool {
+	if !strings.Contains(u.NgoCountry, f.Countries) || !strings.Contains(u.NgoCategory, f.Categories) {
+		return false
+	}
+	return f.IncludeUnverified || u.VerificationStatus == VerificationVerified
+}
+
+// DynamoNgoRepository keeps NGOs in the table
+type DynamoNgoRepository struct {
+	tableName  string
+	dynaClient dynamodbiface.DynamoDBAPI
+}
+
+func NewDynamoNgoRepository(tableName string, dynaClient dynamodbiface.DynamoDBAPI) *DynamoNgoRepository {
+	return &DynamoNgoRepository{tableName: tableName, dynaClient: dynaClient}
+}
+
+func (r *DynamoNgoRepository) GetNgo(ngoId string) (*Ngo, error) {
+	item := new(Ngo)
+	if err := r.get(NgoKey(ngoId), item); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func (r *DynamoNgoRepository) ListNgos(filter NgoFilter, p page.Request) (*[]Ngo, *storage.Key, error) {
+	//For ListNgos :-
+	//  (1) query for Ngos, from where the page starts
+	//  (2) then filter out required data by filtering attribute
+	//  (3) only verified Ngos are listed unless IncludeUnverified is set
+	//  (4) repeat until the page is full, as the filter runs after the read
+	//Macking Key Condition for QueryInput
+	keyCond := expression.KeyAnd(
+		expression.Key("pk").Equal(expression.Value("DetailsNGO")),
+		expression.Key("sk").BeginsWith("Ngo"),
+	)
+
+	//Macking filter for QueryInput
+	filt := expression.And(expression.Name("ngoCountry").Contains(filter.Countries), expression.Name("ngoCategory").Contains(filter.Categories))
+	if !filter.IncludeUnverified {
+		filt = filt.And(expression.Name("verificationStatus").Equal(expression.Value(VerificationVerified)))
+	}
+
+	expr, err := expression.NewBuilder().
+		WithKeyCondition(keyCond).
+		WithFilter(filt).
+		Build()
+	if err != nil {
+		return nil, nil, err
+	}
+	//Macking Call for DynamoDB
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(r.tableName),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+	}
+
+	//Sending Query Request
+	items := []Ngo{}
+	next, err := page.Query(input, "DetailsNGO", p, &items, r.dynaClient)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &items, next, nil
+}
+
+func (r *DynamoNgoRepository) CreateNgo(u *Ngo, entry *audit.Entry) error {
+	//Marshaling the data
+	av, err := dynamodbattribute.MarshalMap(u)
+	if err != nil {
+		return errors.New(ErrorCouldNotMarshalItem)
+	}
+
+	//Puting it to DynamoDB
+	input := &dynamodb.PutItemInput{
+		Item:                av,
+		TableName:           aws.String(r.tableName),
+		ConditionExpression: aws.String("attribute_not_exists(sk)"),
+	}
+	err = audit.PutItem(input, entry, r.dynaClient)
+	if audit.ConditionFailed(err) {
+		return conditionError(audit.ItemExisted(err), ErrorUserAlreadyExists)
+	}
+	if err != nil {
+		return errors.New(ErrorCouldNotDynamoPutItem)
+	}
+	return nil
+}
+
+func (r *DynamoNgoRepository) UpdateNgo(u *Ngo, current *Ngo, entry *audit.Entry) error {
+	changes, err := patch.Diff(current, u)
+	if err != nil {
+		return err
+	}
+	condition, values := etag.Condition(current.Version)
+	err = audit.UpdateItem(changes.Input(r.tableName, condition, values), entry, r.dynaClient)
+	if audit.ConditionFailed(err) {
+		return conditionError(audit.ItemExisted(err), etag.ErrorPreconditionFailed)
+	}
+	if err != nil {
+		return errors.New(ErrorCouldNotDynamoPutItem)
+	}
+	return nil
+}
+
+func (r *DynamoNgoRepository) DeleteNgo(ngoId string, previous int64, entry *audit.Entry) error {
+	condition, values := etag.Condition(previous)
+	input := &dynamodb.DeleteItemInput{
+		Key:                       NgoKey(ngoId),
+		TableName:                 aws.String(r.tableName),
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeValues: values,
+	}
+	err := audit.DeleteItem(input, entry, r.dynaClient)
+	if audit.ConditionFailed(err) {
+		return conditionError(audit.ItemExisted(err), etag.ErrorPreconditionFailed)
+	}
+	if err != nil {
+		return errors.New(ErrorCouldNotDeleteItem)
+	}
+	return nil
+}
+
+func (r *DynamoNgoRepository) SaveWallets(ngoId string, previous []wallet.Wallet, wallets []wallet.Wallet, entry *audit.Entry) error {
+	return wallet.Save(NgoKey(ngoId), "ngoWallets", previous, wallets, entry, r.tableName, r.dynaClient)
+}
+
+func (r *DynamoNgoRepository) GetMember(ngoId string, user string) (*Member, error) {
+	item := new(Member)
+	if err := r.get(MemberKey(ngoId, user), item); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func (r *DynamoNgoRepository) ListMembers(ngoId string, p page.Request) (*[]Member, *storage.Key, error) {
+	//Macking Call for DynamoDB
+	input := &dynamodb.QueryInput{
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {
+				S: aws.String("Ngo" + ngoId),
+			},
+			":sk": {
+				S: aws.String("Member"),
+			},
+		},
+		KeyConditionExpression: aws.String("pk = :pk AND begins_with(sk, :sk)"),
+		TableName:              aws.String(r.tableName),
+	}
+	items := []Member{}
+	next, err := page.Query(input, "Ngo"+ngoId, p, &items, r.dynaClient)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &items, next, nil
+}
+
+func (r *DynamoNgoRepository) AddMember(m *Member, entry *audit.Entry) error {
+	av, err := dynamodbattribute.MarshalMap(m)
+	if err != nil {
+		return errors.New(ErrorCouldNotMarshalItem)
+	}
+	write := &dynamodb.TransactWriteItem{
+		Put: &dynamodb.Put{
+			Item:                av,
+			TableName:           aws.String(r.tableName),
+			ConditionExpression: aws.String("attribute_not_exists(sk)"),
+		},
+	}
+	return r.transact(ErrorMemberAlreadyExists, ErrorCouldNotDynamoPutItem, entry, write)
+}
+
+func (r *DynamoNgoRepository) UpdateMember(m *Member, current *Member, entry *audit.Entry) error {
+	write := &dynamodb.TransactWriteItem{
+		Update: &dynamodb.Update{
+			Key:                 storedMemberKey(current),
+			TableName:           aws.String(r.tableName),
+			ConditionExpression: aws.String("#role = :from"),
+			UpdateExpression:    aws.String("SET #role = :to, updatedAt = :at"),
+			ExpressionAttributeNames: map[string]*string{
+				"#role": aws.String("role"),
+			},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":from": {S: aws.String(current.Role)},
+				":to":   {S: aws.String(m.Role)},
+				":at":   {S: aws.String(m.UpdatedAt)},
+			},
+		},
+	}
+	return r.transact(ErrorMemberChanged, ErrorCouldNotDynamoPutItem, entry, write)
+}
+
+func (r *DynamoNgoRepository) RemoveMember(current *Member, entry *audit.Entry) error {
+	write := &dynamodb.TransactWriteItem{
+		Delete: &dynamodb.Delete{
+			Key:                 storedMemberKey(current),
+			TableName:           aws.String(r.tableName),
+			ConditionExpression: aws.String("#role = :from"),
+			ExpressionAttributeNames: map[string]*string{
+				"#role": aws.String("role"),
+			},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":from": {S: aws.String(current.Role)},
+			},
+		},
+	}
+	return r.transact(ErrorMemberChanged, ErrorCouldNotDeleteItem, entry, write)
+}
+
+func (r *DynamoNgoRepository) GetVerification(ngoId string) (*Verification, error) {
+	item := new(Verification)
+	if err := r.get(VerificationKey(ngoId), item); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func (r *DynamoNgoRepository) SaveVerification(ngoId string, from string, to string, verifiedAt string, u *Verification, current *Verification, entry *audit.Entry) error {
+	//Ngos from before verification have no status
+	statusCondition := "verificationStatus = :from"
+	if from == VerificationUnverified {
+		statusCondition = "(attribute_not_exists(verificationStatus) OR verificationStatus = :from)"
+	}
+	values := map[string]*dynamodb.AttributeValue{
+		":from": {S: aws.String(from)},
+		":to":   {S: aws.String(to)},
+		":one":  {N: aws.String("1")},
+	}
+	updateExpression := "SET verificationStatus = :to REMOVE verifiedAt ADD version :one"
+	if verifiedAt != "" {
+		updateExpression = "SET verificationStatus = :to, verifiedAt = :at ADD version :one"
+		values[":at"] = &dynamodb.AttributeValue{S: aws.String(verifiedAt)}
+	}
+	ngoUpdate := &dynamodb.TransactWriteItem{
+		Update: &dynamodb.Update{
+			Key:                       NgoKey(ngoId),
+			TableName:                 aws.String(r.tableName),
+			ConditionExpression:       aws.String("attribute_exists(sk) AND " + statusCondition),
+			UpdateExpression:          aws.String(updateExpression),
+			ExpressionAttributeValues: values,
+		},
+	}
+
+	av, err := dynamodbattribute.MarshalMap(u)
+	if err != nil {
+		return errors.New(ErrorCouldNotMarshalItem)
+	}
+	put := &dynamodb.Put{
+		Item:                av,
+		TableName:           aws.String(r.tableName),
+		ConditionExpression: aws.String("attribute_not_exists(sk)"),
+	}
+	if len(current.NgoId) != 0 {
+		put.ConditionExpression = aws.String("updatedAt = :previous")
+		put.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":previous": {S: aws.String(current.UpdatedAt)},
+		}
+	}
+	return r.transact(ErrorVerificationChanged, ErrorFailedToSaveVerification, entry, ngoUpdate, &dynamodb.TransactWriteItem{Put: put})
+}
+
+func (r *DynamoNgoRepository) get(key map[string]*dynamodb.AttributeValue, item interface{}) error {
+	//Macking Call for DynamoDB
+	input := &dynamodb.GetItemInput{
+		Key:       key,
+		TableName: aws.String(r.tableName),
+	}
+	result, err := r.dynaClient.GetItem(input)
+	if err != nil {
+		return errors.New(ErrorFailedToFetchRecord)
+	}
+
+	//Sending the Get Request
+	err = dynamodbattribute.UnmarshalMap(result.Item, item)
+	if err != nil {
+		return errors.New(ErrorFailedToUnmarshalRecord)
+	}
+	return nil
+}
+
+// transact makes writes together with the audit entry, failing with
+// conflict when a condition does not hold and with failed otherwise
+func (r *DynamoNgoRepository) transact(conflict string, failed string, entry *audit.Entry, writes ...*dynamodb.TransactWriteItem) error {
+	auditItem, err := audit.TransactItem(entry, r.tableName)
+	if err != nil {
+		return err
+	}
+	input := &dynamodb.TransactWriteItemsInput{
+		TransactItems: append(writes, auditItem),
+	}
+	_, err = r.dynaClient.TransactWriteItems(input)
+	if err != nil {
+		if _, ok := err.(*dynamodb.TransactionCanceledException); ok {
+			return errors.New(conflict)
+		}
+		return errors.New(failed)
+	}
+	return nil
+}
+
+// MemoryNgoRepository keeps NGOs in a storage.MemoryTable
+type MemoryNgoRepository struct {
+	table *storage.MemoryTable
+}
+
+func NewMemoryNgoRepository(table *storage.MemoryTable) *MemoryNgoRepository {
+	return &MemoryNgoRepository{table: table}
+}
+
+func (r *MemoryNgoRepository) GetNgo(ngoId string) (*Ngo, error) {
+	item := new(Ngo)
+	err := r.table.Get(storage.Key{PK: "DetailsNGO", SK: "Ngo" + ngoId}, item)
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func (r *MemoryNgoRepository) ListNgos(filter NgoFilter, p page.Request) (*[]Ngo, *storage.Key, error) {
+	after, err := p.Start("DetailsNGO")
+	if err != nil {
+		return nil, nil, err
+	}
+	//Filtering after the read, like the filter expression does
+	items := []Ngo{}
+	for {
+		var read []Ngo
+		next, err := r.table.QueryPage("DetailsNGO", "Ngo", after, p.Limit-int64(len(items)), &read)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i := range read {
+			if filter.matches(&read[i]) {
+				items = append(items, read[i])
+			}
+		}
+		if next == nil || int64(len(items)) >= p.Limit {
+			return &items, next, nil
+		}
+		after = next.SK
+	}
+}
+
+func (r *MemoryNgoRepository) CreateNgo(u *Ngo, entry *audit.Entry) error {
+	return r.write(storage.Write{Put: u, Condition: storage.NotExists}, entry, ErrorUserAlreadyExists)
+}
+
+func (r *MemoryNgoRepository) UpdateNgo(u *Ngo, current *Ngo, entry *audit.Entry) error {
+	changes, err := patch.Diff(current, u)
+	if err != nil {
+		return err
+	}
+	key := storage.Key{PK: u.PK, SK: u.NgoId}
+	write := storage.Write{Update: &key, Set: changes.Set, Remove: changes.Remove, Condition: storage.Version(current.Version)}
+	return r.write(write, entry, etag.ErrorPreconditionFailed)
+}
+
+func (r *MemoryNgoRepository) DeleteNgo(ngoId string, previous int64, entry *audit.Entry) error {
+	key := storage.Key{PK: "DetailsNGO", SK: "Ngo" + ngoId}
+	return r.write(storage.Write{Delete: &key, Condition: storage.Version(previous)}, entry, etag.ErrorPreconditionFailed)
+}
+
+func (r *MemoryNgoRepository) write(write storage.Write, entry *audit.Entry, conflict string) error {
+	err := r.table.Transact(write, storage.Write{Put: entry, Condition: storage.NotExists})
+	if cerr, ok := err.(*storage.ConditionError); ok {
+		return conditionError(cerr.Item != nil, conflict)
+	}
+	return err
+}
+
+// conditionError is conflict when a write was refused for the item that is
+// there, and ErrorUserDoesNotExists when there is none
+func conditionError(existed bool, conflict string) error {
+	if !existed {
+		return errors.New(ErrorUserDoesNotExists)
+	}
+	return errors.New(conflict)
+}
+
+func (r *MemoryNgoRepository) SaveWallets(ngoId string, previous []wallet.Wallet, wallets []wallet.Wallet, entry *audit.Entry) error {
+	write, err := wallet.MemoryWrite(storage.Key{PK: "DetailsNGO", SK: "Ngo" + ngoId}, "ngoWallets", previous, wallets)
+	if err != nil {
+		return err
+	}
+	return r.transact(wallet.ErrorWalletsChangedOrGone, entry, write)
+}
+
+func (r *MemoryNgoRepository) GetMember(ngoId string, user string) (*Member, error) {
+	item := new(Member)
+	err := r.table.Get(storage.Key{PK: "Ngo" + ngoId, SK: "Member" + strings.ToLower(user)}, item)
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func (r *MemoryNgoRepository) ListMembers(ngoId string, p page.Request) (*[]Member, *storage.Key, error) {
+	after, err := p.Start("Ngo" + ngoId)
+	if err != nil {
+		return nil, nil, err
+	}
+	items := []Member{}
+	next, err := r.table.QueryPage("Ngo"+ngoId, "Member", after, p.Limit, &items)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &items, next, nil
+}
+
+func (r *MemoryNgoRepository) AddMember(m *Member, entry *audit.Entry) error {
+	return r.transact(ErrorMemberAlreadyExists, entry, storage.Write{Put: m, Condition: storage.NotExists})
+}
+
+func (r *MemoryNgoRepository) UpdateMember(m *Member, current *Member, entry *audit.Entry) error {
+	key := storage.Key{PK: current.NgoId, SK: current.MemberId}
+	set := map[string]*dynamodb.AttributeValue{
+		"role":      {S: aws.String(m.Role)},
+		"updatedAt": {S: aws.String(m.UpdatedAt)},
+	}
+	return r.transact(ErrorMemberChanged, entry, storage.Write{Update: &key, Set: set, Condition: hasRole(current.Role)})
+}
+
+func (r *MemoryNgoRepository) RemoveMember(current *Member, entry *audit.Entry) error {
+	key := storage.Key{PK: current.NgoId, SK: current.MemberId}
+	return r.transact(ErrorMemberChanged, entry, storage.Write{Delete: &key, Condition: hasRole(current.Role)})
+}
+
+// hasRole holds for a member that still has role
+func hasRole(role string) storage.Condition {
+	return func(item map[string]*dynamodb.AttributeValue) bool {
+		return item != nil && item["role"] != nil && aws.StringValue(item["role"].S) == role
+	}
+}
+
+func (r *MemoryNgoRepository) GetVerification(ngoId string) (*Verification, error) {
+	item := new(Verification)
+	err := r.table.Get(storage.Key{PK: "Ngo" + ngoId, SK: "Verification"}, item)
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func (r *MemoryNgoRepository) SaveVerification(ngoId string, from string, to string, verifiedAt string, u *Verification, current *Verification, entry *audit.Entry) error {
+	ngoKey := storage.Key{PK: "DetailsNGO", SK: "Ngo" + ngoId}
+	ngoUpdate := storage.Write{
+		Update: &ngoKey,
+		Set:    map[string]*dynamodb.AttributeValue{"verificationStatus": {S: aws.String(to)}},
+		Remove: []string{"verifiedAt"},
+		Add:    map[string]int64{"version": 1},
+		Condition: func(item map[string]*dynamodb.AttributeValue) bool {
+			if item == nil {
+				return false
+			}
+			//Ngos from before verification have no status
+			if item["verificationStatus"] == nil {
+				return from == VerificationUnverified
+			}
+			return aws.StringValue(item["verificationStatus"].S) == from
+		},
+	}
+	if verifiedAt != "" {
+		ngoUpdate.Set["verifiedAt"] = &dynamodb.AttributeValue{S: aws.String(verifiedAt)}
+		ngoUpdate.Remove = nil
+	}
+
+	put := storage.Write{Put: u, Condition: storage.NotExists}
+	if len(current.NgoId) != 0 {
+		put.Condition = func(item map[string]*dynamodb.AttributeValue) bool {
+			return item != nil && item["updatedAt"] != nil && aws.StringValue(item["updatedAt"].S) == current.UpdatedAt
+		}
+	}
+	return r.transact(ErrorVerificationChanged, entry, ngoUpdate, put)
+}
+
+// transact makes writes together with the audit entry, failing with
+// conflict when a condition does not hold
+func (r *MemoryNgoRepository) transact(conflict string, entry *audit.Entry, writes ...storage.Write) error {
+	err := r.table.Transact(append(writes, storage.Write{Put: entry, Condition: storage.NotExists})...)
+	if _, ok := err.(*storage.ConditionError); ok {
+		return errors.New(conflict)
+	}
+	return err
+}
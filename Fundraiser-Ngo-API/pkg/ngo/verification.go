@@ -0,0 +1,274 @@
+package ngo
+
+import (
+	"aws-lambda-api/pkg/audit"
+	"aws-lambda-api/pkg/auth"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+var (
+	ErrorInvalidDocument          = "documents need a known documentType and a documentUrl"
+	ErrorDocumentsRequired        = "at least one document is required"
+	ErrorTooManyDocuments         = "too many documents"
+	ErrorInvalidDecision          = "decision must be verify, reject, suspend or reinstate"
+	ErrorNoteRequired             = "a note is required for this decision"
+	ErrorInvalidVerification      = "ngo verification status does not allow this"
+	ErrorVerificationChanged      = "ngo verification was changed by another request, try again"
+	ErrorFailedToSaveVerification = "could not save verification"
+	ErrorNgoNotVerified           = "ngo must be verified to move money"
+)
+
+const (
+	VerificationUnverified = "unverified"
+	VerificationPending    = "pending"
+	VerificationVerified   = "verified"
+	VerificationRejected   = "rejected"
+	VerificationSuspended  = "suspended"
+)
+
+// maxDocuments bounds the documents kept for one NGO
+const maxDocuments = 20
+
+var documentTypes = map[string]bool{
+	"registration_certificate": true,
+	"tax_exemption":            true,
+	"governing_document":       true,
+	"bank_statement":           true,
+	"director_identity":        true,
+	"annual_report":            true,
+	"other":                    true,
+}
+
+// verifiedPermissions are only granted in NGOs that are verified
+var verifiedPermissions = map[string]bool{
+	PermissionManageMoney: true,
+}
+
+// reviewDecisions are the status changes a reviewer can make
+var reviewDecisions = map[string]struct {
+	from         string
+	to           string
+	noteRequired bool
+}{
+	"verify":    {VerificationPending, VerificationVerified, false},
+	"reject":    {VerificationPending, VerificationRejected, true},
+	"suspend":   {VerificationVerified, VerificationSuspended, true},
+	"reinstate": {VerificationSuspended, VerificationVerified, false},
+}
+
+// Verification holds the documents an NGO submitted and the reviewers'
+// notes. It is kept apart from the NGO, which only carries its status,
+// so that neither is listed publicly. Status is read from the NGO.
+type Verification struct {
+	NgoId     string     `json:"pk"`
+	Kind      string     `json:"sk"`
+	Status    string     `json:"status" dynamodbav:"-"`
+	Documents []Document `json:"documents"`
+	Notes     []Note     `json:"notes"`
+	UpdatedAt string     `json:"updatedAt"`
+}
+
+type Document struct {
+	DocumentType string `json:"documentType"`
+	DocumentUrl  string `json:"documentUrl"`
+	Description  string `json:"description"`
+	SubmittedBy  string `json:"submittedBy"`
+	SubmittedAt  string `json:"submittedAt"`
+}
+
+// Note records a change of status and why it was made
+type Note struct {
+	Author   string `json:"author"`
+	Decision string `json:"decision"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Note     string `json:"note"`
+	At       string `json:"at"`
+}
+
+type VerificationRequest struct {
+	NgoId     string     `json:"ngoId"`
+	Documents []Document `json:"documents"`
+	Decision  string     `json:"decision"`
+	Note      string     `json:"note"`
+}
+
+func VerificationKey(ngoId string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"pk": {
+			S: aws.String("Ngo" + ngoId),
+		},
+		"sk": {
+			S: aws.String("Verification"),
+		},
+	}
+}
+
+// Status of the NGO's verification, counting a missing status as
+// unverified
+func (u *Ngo) Status() string {
+	if u.VerificationStatus == "" {
+		return VerificationUnverified
+	}
+	return u.VerificationStatus
+}
+
+func (u *Ngo) legalIdentityChanged(updated *Ngo) bool {
+	return u.NgoLegalName != updated.NgoLegalName ||
+		u.NgoRegistrationNumber != updated.NgoRegistrationNumber ||
+		u.NgoTaxId != updated.NgoTaxId ||
+		u.NgoCountry != updated.NgoCountry
+}
+
+// FetchVerification returns the documents and notes of an NGO to those
+// who may edit it and to platform admins
+func FetchVerification(req events.APIGatewayProxyRequest, ngoId string, ngos NgoRepository) (*Verification, error) {
+	currentNgo, _, err := CheckPermission(req, ngoId, PermissionEditNgo, ngos)
+	if err != nil {
+		return nil, err
+	}
+	v, err := ngos.GetVerification(ngoId)
+	if err != nil {
+		return nil, err
+	}
+	v.Status = currentNgo.Status()
+	return v, nil
+}
+
+// SubmitVerification adds documents to an NGO and puts it up for review.
+// NGOs already under review may add more documents.
+func SubmitVerification(req events.APIGatewayProxyRequest, ngos NgoRepository) (
+	*Verification,
+	error,
+) {
+	//Checking if the correct request
+	var u VerificationRequest
+	if err := json.Unmarshal([]byte(req.Body), &u); err != nil {
+		return nil, errors.New(ErrorInvalidUserData)
+	}
+	if len(u.Documents) == 0 {
+		return nil, errors.New(ErrorDocumentsRequired)
+	}
+	currentNgo, _, err := CheckPermission(req, u.NgoId, PermissionEditNgo, ngos)
+	if err != nil {
+		return nil, err
+	}
+	from := currentNgo.Status()
+	if from != VerificationUnverified && from != VerificationRejected && from != VerificationPending {
+		return nil, errors.New(ErrorInvalidVerification)
+	}
+
+	current, err := ngos.GetVerification(u.NgoId)
+	if err != nil {
+		return nil, err
+	}
+	if len(current.Documents)+len(u.Documents) > maxDocuments {
+		return nil, errors.New(ErrorTooManyDocuments)
+	}
+	actor := audit.ActorFromRequest(req)
+	now := time.Now().UTC().Format(time.RFC3339)
+	updated := *current
+	updated.Documents = append([]Document{}, current.Documents...)
+	for _, d := range u.Documents {
+		d.DocumentType = strings.TrimSpace(d.DocumentType)
+		d.DocumentUrl = strings.TrimSpace(d.DocumentUrl)
+		if !documentTypes[d.DocumentType] || d.DocumentUrl == "" {
+			return nil, errors.New(ErrorInvalidDocument)
+		}
+		d.SubmittedBy = actor
+		d.SubmittedAt = now
+		updated.Documents = append(updated.Documents, d)
+	}
+	if from != VerificationPending {
+		updated.Notes = append(append([]Note{}, current.Notes...), Note{
+			Author:   actor,
+			Decision: "submit",
+			From:     from,
+			To:       VerificationPending,
+			Note:     strings.TrimSpace(u.Note),
+			At:       now,
+		})
+	}
+	return saveVerification(u.NgoId, from, VerificationPending, "", current, &updated, "submitVerification", actor, ngos)
+}
+
+// ReviewVerification lets a platform admin verify, reject, suspend or
+// reinstate an NGO
+func ReviewVerification(req events.APIGatewayProxyRequest, ngos NgoRepository) (
+	*Verification,
+	error,
+) {
+	reviewer, err := auth.RequireAdmin(req)
+	if err != nil {
+		return nil, err
+	}
+	//Checking if the correct request
+	var u VerificationRequest
+	if err := json.Unmarshal([]byte(req.Body), &u); err != nil {
+		return nil, errors.New(ErrorInvalidUserData)
+	}
+	decision, ok := reviewDecisions[u.Decision]
+	if !ok {
+		return nil, errors.New(ErrorInvalidDecision)
+	}
+	u.Note = strings.TrimSpace(u.Note)
+	if decision.noteRequired && u.Note == "" {
+		return nil, errors.New(ErrorNoteRequired)
+	}
+	currentNgo, err := ngos.GetNgo(u.NgoId)
+	if err != nil {
+		return nil, err
+	}
+	if len(currentNgo.NgoId) == 0 {
+		return nil, errors.New(ErrorUserDoesNotExists)
+	}
+	if currentNgo.Status() != decision.from {
+		return nil, errors.New(ErrorInvalidVerification)
+	}
+
+	current, err := ngos.GetVerification(u.NgoId)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	updated := *current
+	updated.Notes = append(append([]Note{}, current.Notes...), Note{
+		Author:   reviewer.Name(),
+		Decision: u.Decision,
+		From:     decision.from,
+		To:       decision.to,
+		Note:     u.Note,
+		At:       now,
+	})
+	verifiedAt := ""
+	if decision.to == VerificationVerified {
+		verifiedAt = now
+	}
+	return saveVerification(u.NgoId, decision.from, decision.to, verifiedAt, current, &updated, "reviewVerification", reviewer.Name(), ngos)
+}
+
+// saveVerification moves the NGO from one status to another and saves its
+// documents and notes, failing if either changed since they were read
+func saveVerification(ngoId string, from string, to string, verifiedAt string, current *Verification, updated *Verification, action string, actor string, ngos NgoRepository) (
+	*Verification,
+	error,
+) {
+	key := VerificationKey(ngoId)
+	updated.NgoId = aws.StringValue(key["pk"].S)
+	updated.Kind = aws.StringValue(key["sk"].S)
+	updated.UpdatedAt = time.Now().UTC().Format(time.RFC3339Nano)
+
+	entry := audit.NewEntry("Ngo", ngoId, action, actor, map[string]string{"verificationStatus": from}, updated)
+	if err := ngos.SaveVerification(ngoId, from, to, verifiedAt, updated, current, entry); err != nil {
+		return nil, err
+	}
+	updated.Status = to
+	return updated, nil
+}
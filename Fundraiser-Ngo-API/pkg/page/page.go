@@ -0,0 +1,181 @@
+package page
+
+import (
+	"aws-lambda-api/pkg/storage"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+var (
+	ErrorFailedToUnmarshalRecord = "failed to unmarshal record"
+	ErrorFailedToFetchRecord     = "failed to fetch record"
+	ErrorInvalidLimit            = "limit must be between 1 and 100"
+	ErrorInvalidCursor           = "cursor is not valid for this list"
+	ErrorCursorSecretRequired    = "cursor secret is required"
+)
+
+const (
+	DefaultLimit = 25
+	MaxLimit     = 100
+)
+
+// cursorSecret signs cursors so clients cannot make up where a page
+// starts. Until SetCursorSecret is called it is random, which only suits
+// tests, as cursors then only work with the process that made them.
+var cursorSecret = randomSecret()
+
+// SetCursorSecret sets the secret shared by every instance. An empty
+// secret is refused rather than falling back to a random one.
+func SetCursorSecret(secret []byte) error {
+	if len(secret) == 0 {
+		return errors.New(ErrorCursorSecretRequired)
+	}
+	cursorSecret = secret
+	return nil
+}
+
+func randomSecret() []byte {
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	return secret
+}
+
+// Request is a page of at most Limit items coming after the item at
+// After, or from the start of the list when After is nil
+type Request struct {
+	Limit int64
+	After *storage.Key
+}
+
+// List is how list routes answer. NextCursor asks for the page after
+// Items, and is left out on the last page.
+type List struct {
+	Items      interface{} `json:"items"`
+	NextCursor string      `json:"nextCursor,omitempty"`
+}
+
+// FromRequest reads the page asked for by the limit and cursor query
+// parameters of req
+func FromRequest(req events.APIGatewayProxyRequest) (Request, error) {
+	p := Request{Limit: DefaultLimit}
+	if s := req.QueryStringParameters["limit"]; s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n < 1 || n > MaxLimit {
+			return p, errors.New(ErrorInvalidLimit)
+		}
+		p.Limit = n
+	}
+	if s := req.QueryStringParameters["cursor"]; s != "" {
+		after, err := parseCursor(s)
+		if err != nil {
+			return p, err
+		}
+		p.After = after
+	}
+	return p, nil
+}
+
+// NewList is the list of items, followed by the page starting after next
+func NewList(items interface{}, next *storage.Key) *List {
+	l := &List{Items: items}
+	if next != nil {
+		l.NextCursor = Cursor(*next)
+	}
+	return l
+}
+
+// Cursor is the signed cursor of the page starting after the item at key
+func Cursor(key storage.Key) string {
+	payload, _ := json.Marshal(map[string]string{"pk": key.PK, "sk": key.SK})
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + sign(encoded)
+}
+
+func parseCursor(cursor string) (*storage.Key, error) {
+	parts := strings.Split(cursor, ".")
+	if len(parts) != 2 || !hmac.Equal([]byte(sign(parts[0])), []byte(parts[1])) {
+		return nil, errors.New(ErrorInvalidCursor)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errors.New(ErrorInvalidCursor)
+	}
+	var key map[string]string
+	if err := json.Unmarshal(payload, &key); err != nil || key["pk"] == "" || key["sk"] == "" {
+		return nil, errors.New(ErrorInvalidCursor)
+	}
+	return &storage.Key{PK: key["pk"], SK: key["sk"]}, nil
+}
+
+func sign(payload string) string {
+	mac := hmac.New(sha256.New, cursorSecret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Start is the sort key the page of partition pk starts after, "" for
+// the first page. A cursor of another list does not fit.
+func (p Request) Start(pk string) (string, error) {
+	if p.After == nil {
+		return "", nil
+	}
+	if p.After.PK != pk {
+		return "", errors.New(ErrorInvalidCursor)
+	}
+	return p.After.SK, nil
+}
+
+// Query runs input, a query of partition pk, for the page p and
+// unmarshals its items into out, a pointer to a slice. A filter
+// expression drops items after they count against a query's limit, so
+// the query is repeated until the page is full or the partition is read
+// to its end. It returns the key the next page starts after, nil when
+// there is none.
+func Query(input *dynamodb.QueryInput, pk string, p Request, out interface{}, dynaClient dynamodbiface.DynamoDBAPI) (*storage.Key, error) {
+	after, err := p.Start(pk)
+	if err != nil {
+		return nil, err
+	}
+	if after != "" {
+		input.ExclusiveStartKey = map[string]*dynamodb.AttributeValue{
+			"pk": {S: aws.String(pk)},
+			"sk": {S: aws.String(after)},
+		}
+	}
+
+	items := []map[string]*dynamodb.AttributeValue{}
+	var next *storage.Key
+	for int64(len(items)) < p.Limit {
+		input.Limit = aws.Int64(p.Limit - int64(len(items)))
+		result, err := dynaClient.Query(input)
+		if err != nil {
+			return nil, errors.New(ErrorFailedToFetchRecord)
+		}
+		items = append(items, result.Items...)
+		if len(result.LastEvaluatedKey) == 0 {
+			next = nil
+			break
+		}
+		next = &storage.Key{
+			PK: aws.StringValue(result.LastEvaluatedKey["pk"].S),
+			SK: aws.StringValue(result.LastEvaluatedKey["sk"].S),
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+	if err := dynamodbattribute.UnmarshalListOfMaps(items, out); err != nil {
+		return nil, errors.New(ErrorFailedToUnmarshalRecord)
+	}
+	return next, nil
+}
@@ -0,0 +1,155 @@
+package patch
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+var (
+	ErrorInvalidPatch        = "patch must be a JSON object"
+	ErrorCouldNotMarshalItem = "could not marshal item"
+)
+
+// Merge applies the JSON Merge Patch (RFC 7396) patch to the JSON object
+// target into out. Members of patch replace those of target, objects are
+// merged member by member, and null removes a member.
+func Merge(target interface{}, patch string, out interface{}) error {
+	var changes map[string]interface{}
+	if err := json.Unmarshal([]byte(patch), &changes); err != nil || changes == nil {
+		return errors.New(ErrorInvalidPatch)
+	}
+	raw, err := json.Marshal(target)
+	if err != nil {
+		return errors.New(ErrorCouldNotMarshalItem)
+	}
+	var document map[string]interface{}
+	if err := json.Unmarshal(raw, &document); err != nil {
+		return errors.New(ErrorCouldNotMarshalItem)
+	}
+	merged, err := json.Marshal(mergeValue(document, changes))
+	if err != nil {
+		return errors.New(ErrorCouldNotMarshalItem)
+	}
+	if err := json.Unmarshal(merged, out); err != nil {
+		return errors.New(ErrorInvalidPatch)
+	}
+	return nil
+}
+
+func mergeValue(target interface{}, patch interface{}) interface{} {
+	changes, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	document, ok := target.(map[string]interface{})
+	if !ok {
+		document = map[string]interface{}{}
+	}
+	for name, value := range changes {
+		if value == nil {
+			delete(document, name)
+			continue
+		}
+		document[name] = mergeValue(document[name], value)
+	}
+	return document
+}
+
+// Changes are the attributes an UpdateItem of the item at Key sets and
+// removes
+type Changes struct {
+	Key    map[string]*dynamodb.AttributeValue
+	Set    map[string]*dynamodb.AttributeValue
+	Remove []string
+}
+
+// Diff is what turns the stored form of before into that of after.
+// Attributes neither of them knows are left alone.
+func Diff(before interface{}, after interface{}) (*Changes, error) {
+	old, err := dynamodbattribute.MarshalMap(before)
+	if err != nil {
+		return nil, errors.New(ErrorCouldNotMarshalItem)
+	}
+	updated, err := dynamodbattribute.MarshalMap(after)
+	if err != nil {
+		return nil, errors.New(ErrorCouldNotMarshalItem)
+	}
+	c := &Changes{
+		Key: map[string]*dynamodb.AttributeValue{"pk": updated["pk"], "sk": updated["sk"]},
+		Set: map[string]*dynamodb.AttributeValue{},
+	}
+	for name, value := range updated {
+		if !reflect.DeepEqual(old[name], value) {
+			c.Set[name] = value
+		}
+	}
+	for name := range old {
+		if _, ok := updated[name]; !ok {
+			c.Remove = append(c.Remove, name)
+		}
+	}
+	sort.Strings(c.Remove)
+	return c, nil
+}
+
+// Expression is the update expression of c, with its names and values.
+// Every attribute goes by a placeholder, so reserved words need no care.
+func (c *Changes) Expression() (string, map[string]*string, map[string]*dynamodb.AttributeValue) {
+	names := map[string]*string{}
+	values := map[string]*dynamodb.AttributeValue{}
+	setNames := make([]string, 0, len(c.Set))
+	for name := range c.Set {
+		setNames = append(setNames, name)
+	}
+	sort.Strings(setNames)
+
+	var set, remove []string
+	for i, name := range setNames {
+		placeholder := "a" + strconv.Itoa(i)
+		names["#"+placeholder] = aws.String(name)
+		values[":"+placeholder] = c.Set[name]
+		set = append(set, "#"+placeholder+" = :"+placeholder)
+	}
+	for i, name := range c.Remove {
+		placeholder := "#r" + strconv.Itoa(i)
+		names[placeholder] = aws.String(name)
+		remove = append(remove, placeholder)
+	}
+
+	var expression []string
+	if len(set) > 0 {
+		expression = append(expression, "SET "+strings.Join(set, ", "))
+	}
+	if len(remove) > 0 {
+		expression = append(expression, "REMOVE "+strings.Join(remove, ", "))
+	}
+	return strings.Join(expression, " "), names, values
+}
+
+// Input is the UpdateItem making c, only if condition holds with the
+// extra values given
+func (c *Changes) Input(tableName string, condition string, conditionValues map[string]*dynamodb.AttributeValue) *dynamodb.UpdateItemInput {
+	expression, names, values := c.Expression()
+	for name, value := range conditionValues {
+		values[name] = value
+	}
+	if len(values) == 0 {
+		values = nil
+	}
+	return &dynamodb.UpdateItemInput{
+		Key:                       c.Key,
+		TableName:                 aws.String(tableName),
+		UpdateExpression:          aws.String(expression),
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	}
+}
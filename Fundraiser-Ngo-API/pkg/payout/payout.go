@@ -0,0 +1,374 @@
+package payout
+
+import (
+	"aws-lambda-api/pkg/audit"
+	"aws-lambda-api/pkg/auth"
+	"aws-lambda-api/pkg/fundraiser"
+	"aws-lambda-api/pkg/money"
+	"aws-lambda-api/pkg/ngo"
+	"aws-lambda-api/pkg/page"
+	"aws-lambda-api/pkg/storage"
+	"aws-lambda-api/pkg/wallet"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+var (
+	ErrorFailedToUnmarshalRecord = "failed to unmarshal record"
+	ErrorFailedToFetchRecord     = "failed to fetch record"
+	ErrorInvalidUserData         = "invalid user data"
+	ErrorCouldNotMarshalItem     = "could not marshal item"
+	ErrorCouldNotDynamoPutItem   = "could not dynamo put item error"
+	ErrorPayoutAlreadyExists     = "payout already exists"
+	ErrorPayoutDoesNotExist      = "payout does not exist"
+	ErrorInvalidPayoutAmount     = "payout amount must be positive and in the fundraiser's currency"
+	ErrorInsufficientBalance     = "payout amount exceeds the available balance"
+	ErrorUnknownDestination      = "destination is not a wallet registered for the fundraiser"
+	ErrorInvalidTransition       = "payout cannot move to the requested status"
+	ErrorPayoutChanged           = "payout was changed concurrently"
+	ErrorTxHashRequired          = "txHash is required when marking a payout as sent"
+)
+
+const (
+	StatusRequested   = "requested"
+	StatusUnderReview = "under_review"
+	StatusApproved    = "approved"
+	StatusSent        = "sent"
+	StatusRejected    = "rejected"
+)
+
+// transitions lists the statuses a payout may move to from each status.
+// Sent and rejected payouts are final.
+var transitions = map[string][]string{
+	StatusRequested:   {StatusUnderReview, StatusApproved, StatusRejected},
+	StatusUnderReview: {StatusApproved, StatusRejected},
+	StatusApproved:    {StatusSent, StatusRejected},
+}
+
+// Payout is a request to withdraw raised money to one of the fundraiser's
+// wallets. The amount is reserved from availableAmount when requested and
+// released again if the payout is rejected. Payouts are kept in the
+// ledger of their fundraiser, see fundraiser.LedgerKey.
+type Payout struct {
+	FundraiserId string `json:"pk"`
+	PayoutId     string `json:"sk"`
+	//Exactly one of NgoId or IndividualEmailId identifies the fundraiser's owner
+	NgoId             string        `json:"ngoId,omitempty"`
+	IndividualEmailId string        `json:"emailId,omitempty"`
+	Amount            money.Money   `json:"amount"`
+	Destination       wallet.Wallet `json:"destination"`
+	Status            string        `json:"status"`
+	RequestedBy       string        `json:"requestedBy"`
+	RequestedAt       string        `json:"requestedAt"`
+	ReviewedBy        string        `json:"reviewedBy,omitempty"`
+	ReviewNote        string        `json:"reviewNote,omitempty"`
+	UpdatedAt         string        `json:"updatedAt"`
+	TxHash            string        `json:"txHash,omitempty"`
+}
+
+type ReviewRequest struct {
+	NgoId        string `json:"ngoId"`
+	EmailId      string `json:"emailId"`
+	FundraiserId string `json:"fundraiserId"`
+	PayoutId     string `json:"payoutId"`
+	Status       string `json:"status"`
+	Note         string `json:"note"`
+	TxHash       string `json:"txHash"`
+}
+
+func payoutKey(ledger string, payoutId string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"pk": {
+			S: aws.String(ledger),
+		},
+		"sk": {
+			S: aws.String("Payout" + payoutId),
+		},
+	}
+}
+
+// FetchPayout loads a payout of the fundraiser owned by exactly one of
+// ngoId or emailId. Only the NGO's money managers, the individual who
+// owns the fundraiser and admins may read it.
+func FetchPayout(req events.APIGatewayProxyRequest, ngoId string, emailId string, fundraiserId string, payoutId string, fundraisers fundraiser.FundraiserRepository, ngos ngo.NgoRepository, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Payout, error) {
+	if _, err := fundraiser.CheckPermission(req, ngoId, emailId, fundraiserId, ngo.PermissionManageMoney, false, fundraisers, ngos); err != nil {
+		return nil, err
+	}
+	item, err := fetchPayout(ngoId, emailId, fundraiserId, payoutId, tableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	if len(item.PayoutId) == 0 {
+		return nil, errors.New(ErrorPayoutDoesNotExist)
+	}
+	return item, nil
+}
+
+func fetchPayout(ngoId string, emailId string, fundraiserId string, payoutId string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Payout, error) {
+	ledger, err := fundraiser.LedgerKey(ngoId, emailId, fundraiserId)
+	if err != nil || fundraiserId == "" {
+		return nil, errors.New(fundraiser.ErrorFundraiserNotSpecified)
+	}
+
+	//Macking Call for DynamoDB
+	input := &dynamodb.GetItemInput{
+		Key:       payoutKey(ledger, payoutId),
+		TableName: aws.String(tableName),
+	}
+	result, err := dynaClient.GetItem(input)
+	if err != nil {
+		return nil, errors.New(ErrorFailedToFetchRecord)
+	}
+
+	//Sending the Get Request
+	item := new(Payout)
+	err = dynamodbattribute.UnmarshalMap(result.Item, item)
+	if err != nil {
+		return nil, errors.New(ErrorFailedToUnmarshalRecord)
+	}
+	return item, nil
+}
+
+// FetchPayouts lists the payouts of the fundraiser owned by exactly one
+// of ngoId or emailId, for the same callers as FetchPayout
+func FetchPayouts(req events.APIGatewayProxyRequest, ngoId string, emailId string, fundraiserId string, p page.Request, fundraisers fundraiser.FundraiserRepository, ngos ngo.NgoRepository, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*[]Payout, *storage.Key, error) {
+	t, err := fundraiser.CheckPermission(req, ngoId, emailId, fundraiserId, ngo.PermissionManageMoney, false, fundraisers, ngos)
+	if err != nil {
+		return nil, nil, err
+	}
+	ledger := t.Ledger
+
+	//Macking Call for DynamoDB
+	input := &dynamodb.QueryInput{
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {
+				S: aws.String(ledger),
+			},
+			":sk": {
+				S: aws.String("Payout"),
+			},
+		},
+		KeyConditionExpression: aws.String("pk = :pk AND begins_with(sk, :sk)"),
+		TableName:              aws.String(tableName),
+	}
+	items := []Payout{}
+	next, err := page.Query(input, ledger, p, &items, dynaClient)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &items, next, nil
+}
+
+func RequestPayout(req events.APIGatewayProxyRequest, fundraisers fundraiser.FundraiserRepository, ngos ngo.NgoRepository, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*Payout,
+	error,
+) {
+	//Checking if the correct request
+	var u Payout
+	if err := json.Unmarshal([]byte(req.Body), &u); err != nil {
+		return nil, errors.New(ErrorInvalidUserData)
+	}
+	if u.PayoutId == "" {
+		return nil, errors.New(ErrorInvalidUserData)
+	}
+	if err := u.Destination.Validate(); err != nil {
+		return nil, err
+	}
+
+	//Checking the amount and destination against the fundraiser
+	t, err := fundraiser.CheckPermission(req, u.NgoId, u.IndividualEmailId, u.FundraiserId, ngo.PermissionManageMoney, true, fundraisers, ngos)
+	if err != nil {
+		return nil, err
+	}
+	if u.Amount.Currency == "" {
+		u.Amount.Currency = t.Currency
+	}
+	if err := u.Amount.Normalize(); err != nil {
+		return nil, err
+	}
+	if u.Amount.Amount == 0 || u.Amount.Currency != t.Currency {
+		return nil, errors.New(ErrorInvalidPayoutAmount)
+	}
+	if !wallet.Contains(t.Wallets, u.Destination) {
+		return nil, errors.New(ErrorUnknownDestination)
+	}
+
+	//Modifying the key for DynamoDB Storage
+	entityId := u.FundraiserId + "#" + u.PayoutId
+	now := time.Now().UTC().Format(time.RFC3339)
+	u.FundraiserId = t.Ledger
+	u.PayoutId = "Payout" + u.PayoutId
+	u.Status = StatusRequested
+	u.RequestedBy = audit.ActorFromRequest(req)
+	u.RequestedAt = now
+	u.UpdatedAt = now
+	u.ReviewedBy = ""
+	u.ReviewNote = ""
+	u.TxHash = ""
+
+	av, err := dynamodbattribute.MarshalMap(u)
+	if err != nil {
+		return nil, errors.New(ErrorCouldNotMarshalItem)
+	}
+	entry := audit.NewEntry("Payout", entityId, "requestPayout", u.RequestedBy, nil, u)
+	auditItem, err := audit.TransactItem(entry, tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	//Reserving the amount only if the balance covers it
+	input := &dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{
+				Put: &dynamodb.Put{
+					Item:                av,
+					TableName:           aws.String(tableName),
+					ConditionExpression: aws.String("attribute_not_exists(sk)"),
+				},
+			},
+			{
+				Update: balanceUpdate(t.Key, u.Amount, "-", tableName),
+			},
+			auditItem,
+		},
+	}
+	_, err = dynaClient.TransactWriteItems(input)
+	if err != nil {
+		if tce, ok := err.(*dynamodb.TransactionCanceledException); ok {
+			reasons := tce.CancellationReasons
+			if len(reasons) > 0 && aws.StringValue(reasons[0].Code) == "ConditionalCheckFailed" {
+				return nil, errors.New(ErrorPayoutAlreadyExists)
+			}
+			if len(reasons) > 1 && aws.StringValue(reasons[1].Code) == "ConditionalCheckFailed" {
+				return nil, errors.New(ErrorInsufficientBalance)
+			}
+		}
+		return nil, errors.New(ErrorCouldNotDynamoPutItem)
+	}
+	return &u, nil
+}
+
+func ReviewPayout(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*Payout,
+	error,
+) {
+	//Checking if the correct request
+	var u ReviewRequest
+	if err := json.Unmarshal([]byte(req.Body), &u); err != nil {
+		return nil, errors.New(ErrorInvalidUserData)
+	}
+
+	//Only admins review payouts
+	if _, err := auth.RequireAdmin(req); err != nil {
+		return nil, err
+	}
+	current, err := fetchPayout(u.NgoId, u.EmailId, u.FundraiserId, u.PayoutId, tableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	if len(current.PayoutId) == 0 {
+		return nil, errors.New(ErrorPayoutDoesNotExist)
+	}
+	if !allowed(current.Status, u.Status) {
+		return nil, errors.New(ErrorInvalidTransition)
+	}
+	if u.Status == StatusSent && u.TxHash == "" {
+		return nil, errors.New(ErrorTxHashRequired)
+	}
+
+	updated := *current
+	updated.Status = u.Status
+	updated.ReviewedBy = audit.ActorFromRequest(req)
+	updated.ReviewNote = u.Note
+	updated.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	if u.Status == StatusSent {
+		updated.TxHash = u.TxHash
+	}
+
+	//Moving the status only from the one we read
+	entry := audit.NewEntry("Payout", u.FundraiserId+"#"+u.PayoutId, "reviewPayout", updated.ReviewedBy, *current, updated)
+	auditItem, err := audit.TransactItem(entry, tableName)
+	if err != nil {
+		return nil, err
+	}
+	items := []*dynamodb.TransactWriteItem{
+		{
+			Update: &dynamodb.Update{
+				Key:                 payoutKey(current.FundraiserId, u.PayoutId),
+				TableName:           aws.String(tableName),
+				ConditionExpression: aws.String("#status = :from"),
+				UpdateExpression:    aws.String("SET #status = :to, reviewedBy = :by, reviewNote = :note, updatedAt = :at, txHash = :txHash"),
+				ExpressionAttributeNames: map[string]*string{
+					"#status": aws.String("status"),
+				},
+				ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+					":from":   {S: aws.String(current.Status)},
+					":to":     {S: aws.String(updated.Status)},
+					":by":     {S: aws.String(updated.ReviewedBy)},
+					":note":   {S: aws.String(updated.ReviewNote)},
+					":at":     {S: aws.String(updated.UpdatedAt)},
+					":txHash": {S: aws.String(updated.TxHash)},
+				},
+			},
+		},
+		auditItem,
+	}
+
+	//Rejected payouts give the reserved amount back
+	if u.Status == StatusRejected {
+		var key map[string]*dynamodb.AttributeValue
+		if current.NgoId != "" {
+			key = fundraiser.NgoFundraiserKey(current.NgoId, u.FundraiserId)
+		} else {
+			key = fundraiser.IndividualFundraiserKey(current.IndividualEmailId, u.FundraiserId)
+		}
+		items = append(items, &dynamodb.TransactWriteItem{
+			Update: balanceUpdate(key, current.Amount, "+", tableName),
+		})
+	}
+
+	_, err = dynaClient.TransactWriteItems(&dynamodb.TransactWriteItemsInput{TransactItems: items})
+	if err != nil {
+		if _, ok := err.(*dynamodb.TransactionCanceledException); ok {
+			return nil, errors.New(ErrorPayoutChanged)
+		}
+		return nil, errors.New(ErrorCouldNotDynamoPutItem)
+	}
+	return &updated, nil
+}
+
+func allowed(from string, to string) bool {
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// balanceUpdate reserves (op "-") or releases (op "+") amount from the
+// fundraiser's available balance, never letting it go negative.
+func balanceUpdate(key map[string]*dynamodb.AttributeValue, amount money.Money, op string, tableName string) *dynamodb.Update {
+	condition := "attribute_exists(sk) AND availableAmount.currency = :currency"
+	if op == "-" {
+		condition += " AND availableAmount.amount >= :amount"
+	}
+	return &dynamodb.Update{
+		Key:                 key,
+		TableName:           aws.String(tableName),
+		ConditionExpression: aws.String(condition),
+		UpdateExpression:    aws.String("SET availableAmount.amount = availableAmount.amount " + op + " :amount"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":amount":   {N: aws.String(strconv.FormatInt(amount.Amount, 10))},
+			":currency": {S: aws.String(amount.Currency)},
+		},
+	}
+}
@@ -0,0 +1,217 @@
+package pii
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	ErrorEncryptionNotConfigured = "pii encryption is not configured"
+	ErrorUnknownKey              = "pii key is unknown"
+	ErrorCouldNotEncrypt         = "could not encrypt personal data"
+	ErrorCouldNotDecrypt         = "could not decrypt personal data"
+	ErrorInvalidKeyFile          = "invalid pii key file"
+)
+
+// KeyProvider holds the master keys that wrap the data key of each
+// envelope. Only wrapped data keys are ever stored.
+type KeyProvider interface {
+	// GenerateDataKey returns a new data key, in plaintext and wrapped
+	// under the current master key, and the id of that master key
+	GenerateDataKey() (plaintext []byte, wrapped []byte, keyId string, err error)
+	// DecryptDataKey unwraps a data key wrapped under master key keyId
+	DecryptDataKey(keyId string, wrapped []byte) ([]byte, error)
+}
+
+// Envelope is a value encrypted with its own data key
+type Envelope struct {
+	KeyId      string `json:"keyId"`
+	WrappedKey []byte `json:"wrappedKey"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+var provider KeyProvider
+
+// SetKeyProvider sets the provider used by Seal and Open. Without one
+// personal data can be neither stored nor read.
+func SetKeyProvider(p KeyProvider) {
+	provider = p
+}
+
+// Seal encrypts plaintext under a fresh data key. context is bound to the
+// ciphertext, so an envelope copied to another item will not open.
+func Seal(plaintext []byte, context string) (*Envelope, error) {
+	if provider == nil {
+		return nil, errors.New(ErrorEncryptionNotConfigured)
+	}
+	dataKey, wrapped, keyId, err := provider.GenerateDataKey()
+	if err != nil {
+		return nil, errors.New(ErrorCouldNotEncrypt)
+	}
+	nonce, ciphertext, err := encrypt(dataKey, plaintext, []byte(context))
+	if err != nil {
+		return nil, errors.New(ErrorCouldNotEncrypt)
+	}
+	return &Envelope{KeyId: keyId, WrappedKey: wrapped, Nonce: nonce, Ciphertext: ciphertext}, nil
+}
+
+// Open decrypts an envelope sealed with the same context
+func Open(e *Envelope, context string) ([]byte, error) {
+	if provider == nil {
+		return nil, errors.New(ErrorEncryptionNotConfigured)
+	}
+	dataKey, err := provider.DecryptDataKey(e.KeyId, e.WrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := decrypt(dataKey, e.Nonce, e.Ciphertext, []byte(context))
+	if err != nil {
+		return nil, errors.New(ErrorCouldNotDecrypt)
+	}
+	return plaintext, nil
+}
+
+// LocalKeyProvider wraps data keys with AES-256 master keys read from a
+// file. Old keys stay in the file so envelopes sealed under them still
+// open after the current key is rotated.
+type LocalKeyProvider struct {
+	current string
+	keys    map[string][]byte
+}
+
+type keyFile struct {
+	Current string            `json:"current"`
+	Keys    map[string]string `json:"keys"`
+}
+
+// LoadLocalKeyFile reads a key file formatted as
+// {"current": "k2", "keys": {"k1": "<base64 32 bytes>", "k2": "..."}}
+func LoadLocalKeyFile(path string) (*LocalKeyProvider, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f keyFile
+	if err := json.Unmarshal(raw, &f); err != nil {
+		return nil, errors.New(ErrorInvalidKeyFile)
+	}
+	p := &LocalKeyProvider{current: f.Current, keys: map[string][]byte{}}
+	for id, encoded := range f.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return nil, errors.New(ErrorInvalidKeyFile)
+		}
+		p.keys[id] = key
+	}
+	if p.keys[p.current] == nil {
+		return nil, errors.New(ErrorInvalidKeyFile)
+	}
+	return p, nil
+}
+
+func (p *LocalKeyProvider) GenerateDataKey() ([]byte, []byte, string, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, "", err
+	}
+	nonce, ciphertext, err := encrypt(p.keys[p.current], dataKey, []byte(p.current))
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return dataKey, append(nonce, ciphertext...), p.current, nil
+}
+
+func (p *LocalKeyProvider) DecryptDataKey(keyId string, wrapped []byte) ([]byte, error) {
+	master := p.keys[keyId]
+	if master == nil {
+		return nil, errors.New(ErrorUnknownKey)
+	}
+	gcm, err := newGCM(master)
+	if err != nil || len(wrapped) < gcm.NonceSize() {
+		return nil, errors.New(ErrorCouldNotDecrypt)
+	}
+	dataKey, err := gcm.Open(nil, wrapped[:gcm.NonceSize()], wrapped[gcm.NonceSize():], []byte(keyId))
+	if err != nil {
+		return nil, errors.New(ErrorCouldNotDecrypt)
+	}
+	return dataKey, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func encrypt(key []byte, plaintext []byte, aad []byte) ([]byte, []byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+	return nonce, gcm.Seal(nil, nonce, plaintext, aad), nil
+}
+
+func decrypt(key []byte, nonce []byte, ciphertext []byte, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, errors.New(ErrorCouldNotDecrypt)
+	}
+	return gcm.Open(nil, nonce, ciphertext, aad)
+}
+
+// MaskEmail keeps the first letter of the name and the domain's top level,
+// as in "j***@g***.com"
+func MaskEmail(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 1 {
+		return mask(email, 0)
+	}
+	name, domain := email[:at], email[at+1:]
+	tld := ""
+	if dot := strings.LastIndex(domain, "."); dot > 0 {
+		domain, tld = domain[:dot], domain[dot:]
+	}
+	return firstRune(name) + "***@" + firstRune(domain) + "***" + tld
+}
+
+// MaskPhone keeps the last two digits of a phone number
+func MaskPhone(phone string) string {
+	return mask(phone, 2)
+}
+
+func mask(s string, keep int) string {
+	n := utf8.RuneCountInString(s)
+	if n == 0 {
+		return ""
+	}
+	if n <= keep*2 {
+		keep = 0
+	}
+	runes := []rune(s)
+	return strings.Repeat("*", n-keep) + string(runes[n-keep:])
+}
+
+func firstRune(s string) string {
+	r, _ := utf8.DecodeRuneInString(s)
+	if r == utf8.RuneError {
+		return ""
+	}
+	return string(r)
+}
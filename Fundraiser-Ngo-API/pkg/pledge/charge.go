@@ -0,0 +1,195 @@
+package pledge
+
+import (
+	"aws-lambda-api/pkg/audit"
+	"aws-lambda-api/pkg/chain"
+	"aws-lambda-api/pkg/donation"
+	"aws-lambda-api/pkg/fundraiser"
+	"aws-lambda-api/pkg/money"
+	"aws-lambda-api/pkg/ngo"
+	"aws-lambda-api/pkg/page"
+	"aws-lambda-api/pkg/storage"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+var (
+	ErrorChargeDoesNotExist = "charge does not exist"
+	ErrorChargeNotPending   = "charge is not pending"
+)
+
+const (
+	ChargePending = "pending"
+	ChargePaid    = "paid"
+)
+
+// Charge is one period of a pledge that fell due. Charges do not move
+// any money: they stay pending until the donor pays them with a transfer
+// that is verified on chain, which is then recorded as the donation.
+type Charge struct {
+	NgoId    string `json:"pk"`
+	ChargeId string `json:"sk"`
+	PledgeId string `json:"pledgeId"`
+	//Empty for pledges to the NGO's general fund
+	FundraiserId string      `json:"fundraiserId,omitempty"`
+	DonorName    string      `json:"donorName"`
+	DonorEmail   string      `json:"donorEmail"`
+	Amount       money.Money `json:"amount"`
+	DueAt        string      `json:"dueAt"`
+	Status       string      `json:"status"`
+	DonationId   string      `json:"donationId,omitempty"`
+	PaidAt       string      `json:"paidAt,omitempty"`
+}
+
+type PayRequest struct {
+	NgoId    string `json:"ngoId"`
+	ChargeId string `json:"chargeId"`
+	ChainId  string `json:"chainId"`
+	TxHash   string `json:"txHash"`
+}
+
+// ChargeKey is the key of a charge, whose id is the pledge id followed
+// by the day it fell due
+func ChargeKey(ngoId string, chargeId string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"pk": {
+			S: aws.String("Ngo" + ngoId),
+		},
+		"sk": {
+			S: aws.String("Charge" + chargeId),
+		},
+	}
+}
+
+func chargeId(pledgeId string, due time.Time) string {
+	return pledgeId + "-" + due.Format("20060102")
+}
+
+// FetchCharges lists the charges of a pledge, for the same callers as
+// FetchPledge
+func FetchCharges(req events.APIGatewayProxyRequest, ngoId string, pledgeId string, p page.Request, ngos ngo.NgoRepository, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*[]Charge, *storage.Key, error) {
+	if _, err := FetchPledge(req, ngoId, pledgeId, ngos, tableName, dynaClient); err != nil {
+		return nil, nil, err
+	}
+
+	//Macking Call for DynamoDB
+	input := &dynamodb.QueryInput{
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {
+				S: aws.String("Ngo" + ngoId),
+			},
+			":sk": {
+				S: aws.String("Charge" + pledgeId + "-"),
+			},
+		},
+		KeyConditionExpression: aws.String("pk = :pk AND begins_with(sk, :sk)"),
+		TableName:              aws.String(tableName),
+	}
+	items := []Charge{}
+	next, err := page.Query(input, "Ngo"+ngoId, p, &items, dynaClient)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &items, next, nil
+}
+
+// PayCharge records the on-chain transfer paying a pending charge as the
+// pledge's donation, and marks the charge paid in the same transaction.
+// The transfer must be worth at least the charge.
+func PayCharge(req events.APIGatewayProxyRequest, fundraisers fundraiser.FundraiserRepository, ngos ngo.NgoRepository, tableName string, dynaClient dynamodbiface.DynamoDBAPI, verifier chain.Verifier, oracle money.PriceOracle) (
+	*Charge,
+	error,
+) {
+	//Checking if the correct request
+	var u PayRequest
+	if err := json.Unmarshal([]byte(req.Body), &u); err != nil {
+		return nil, errors.New(ErrorInvalidUserData)
+	}
+	current, err := fetchCharge(u.NgoId, u.ChargeId, tableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkAccess(req, u.NgoId, current.DonorEmail, ngos); err != nil {
+		return nil, err
+	}
+	if current.Status != ChargePending {
+		return nil, errors.New(ErrorChargeNotPending)
+	}
+
+	//Verified donations are keyed by the lowercase transaction hash
+	donationId := u.ChainId + "-" + strings.ToLower(u.TxHash)
+	now := time.Now().UTC().Format(time.RFC3339)
+	d := &donation.Donation{
+		FundraiserId: current.FundraiserId,
+		NgoId:        u.NgoId,
+		DonorName:    current.DonorName,
+		DonorEmail:   current.DonorEmail,
+		ChainId:      u.ChainId,
+		TxHash:       u.TxHash,
+		PledgeId:     current.PledgeId,
+	}
+
+	//The charge must still be pending when the donation is written
+	paid := &dynamodb.TransactWriteItem{
+		Update: &dynamodb.Update{
+			Key:                 ChargeKey(u.NgoId, u.ChargeId),
+			TableName:           aws.String(tableName),
+			ConditionExpression: aws.String("#status = :pending"),
+			UpdateExpression:    aws.String("SET #status = :paid, donationId = :donation, paidAt = :at"),
+			ExpressionAttributeNames: map[string]*string{
+				"#status": aws.String("status"),
+			},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":pending":  {S: aws.String(ChargePending)},
+				":paid":     {S: aws.String(ChargePaid)},
+				":donation": {S: aws.String(donationId)},
+				":at":       {S: aws.String(now)},
+			},
+		},
+	}
+	updated := *current
+	updated.Status = ChargePaid
+	updated.PaidAt = now
+	updated.DonationId = donationId
+	entry := audit.NewEntry("PledgeCharge", u.NgoId+"#"+u.ChargeId, "payPledgeCharge", audit.ActorFromRequest(req), *current, updated)
+	auditItem, err := audit.TransactItem(entry, tableName)
+	if err != nil {
+		return nil, err
+	}
+	extra := []*dynamodb.TransactWriteItem{paid, auditItem}
+	if _, err := donation.RecordTransfer(d, &current.Amount, fundraisers, ngos, tableName, dynaClient, verifier, oracle, extra, []string{ErrorChargeNotPending, ErrorPledgeChanged}); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+func fetchCharge(ngoId string, chargeId string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Charge, error) {
+	//Macking Call for DynamoDB
+	input := &dynamodb.GetItemInput{
+		Key:       ChargeKey(ngoId, chargeId),
+		TableName: aws.String(tableName),
+	}
+	result, err := dynaClient.GetItem(input)
+	if err != nil {
+		return nil, errors.New(ErrorFailedToFetchRecord)
+	}
+
+	//Sending the Get Request
+	item := new(Charge)
+	err = dynamodbattribute.UnmarshalMap(result.Item, item)
+	if err != nil {
+		return nil, errors.New(ErrorFailedToUnmarshalRecord)
+	}
+	if len(item.ChargeId) == 0 {
+		return nil, errors.New(ErrorChargeDoesNotExist)
+	}
+	return item, nil
+}
@@ -0,0 +1,167 @@
+package pledge
+
+import (
+	"aws-lambda-api/pkg/audit"
+	"aws-lambda-api/pkg/chain"
+	"aws-lambda-api/pkg/donation"
+	"aws-lambda-api/pkg/fundraiser"
+	"aws-lambda-api/pkg/money"
+	"aws-lambda-api/pkg/ngo"
+	"aws-lambda-api/pkg/storage"
+	"aws-lambda-api/pkg/wallet"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+const (
+	ngoWallet   = "0x7E5F4552091A69125d5DfCb7b8C2659029395Bdf"
+	donorWallet = "0x70997970C51812dc3A010C7d01b50e0d17dc79C8"
+	donor       = "donor@example.org"
+)
+
+// chargeTable serves one charge and records the transaction that would
+// pay it. Any other call panics on the nil DynamoDBAPI.
+type chargeTable struct {
+	dynamodbiface.DynamoDBAPI
+	charge  map[string]*dynamodb.AttributeValue
+	written bool
+}
+
+func (t *chargeTable) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	return &dynamodb.GetItemOutput{Item: t.charge}, nil
+}
+
+func (t *chargeTable) TransactWriteItems(input *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+	t.written = true
+	return &dynamodb.TransactWriteItemsOutput{}, nil
+}
+
+func txHash(n int) string {
+	return "0x" + strings.Repeat("0", 63) + string(rune('0'+n))
+}
+
+func gwei(n int64) *big.Int {
+	return new(big.Int).Mul(big.NewInt(n), big.NewInt(1000000000))
+}
+
+type chargeFixture struct {
+	table    *chargeTable
+	ngos     ngo.NgoRepository
+	verifier *chain.EVMVerifier
+	oracle   money.PriceOracle
+}
+
+// newChargeFixture has a pending charge of due to the general fund of a
+// NGO with an ETH wallet, and a chain where 0.01, 0.05 and 1 ETH were
+// sent to it in transactions 1, 2 and 3. ETH is worth 2000 USD.
+func newChargeFixture(t *testing.T, due money.Money) *chargeFixture {
+	memory := storage.NewMemoryTable()
+	ngos := ngo.NewMemoryNgoRepository(memory)
+	n := &ngo.Ngo{
+		PK:                 "DetailsNGO",
+		NgoId:              "Ngohelpers",
+		NgoName:            "Helpers",
+		NgoWallets:         []wallet.Wallet{{Chain: "ethereum", Asset: "ETH", Address: ngoWallet}},
+		VerificationStatus: ngo.VerificationVerified,
+		Version:            1,
+	}
+	if err := ngos.CreateNgo(n, audit.NewEntry("Ngo", "helpers", "createNgo", "admin", nil, n)); err != nil {
+		t.Fatalf("CreateNgo: %v", err)
+	}
+
+	charge, err := dynamodbattribute.MarshalMap(Charge{
+		NgoId:      "Ngohelpers",
+		ChargeId:   "Chargemonthly-20260101",
+		PledgeId:   "monthly",
+		DonorName:  "Dana",
+		DonorEmail: donor,
+		Amount:     due,
+		DueAt:      "2026-01-01T00:00:00Z",
+		Status:     ChargePending,
+	})
+	if err != nil {
+		t.Fatalf("MarshalMap: %v", err)
+	}
+
+	node := chain.NewFakeNode()
+	node.AddTransfer(txHash(1), donorWallet, ngoWallet, gwei(10000000), true)
+	node.AddTransfer(txHash(2), donorWallet, ngoWallet, gwei(50000000), true)
+	node.AddTransfer(txHash(3), donorWallet, ngoWallet, gwei(1000000000), true)
+	node.Mine(3)
+	verifier := chain.NewEVMVerifier(1)
+	verifier.AddChain("ethereum", "ETH", node)
+
+	path := filepath.Join(t.TempDir(), "prices.json")
+	prices := `{"asOf": "2026-01-01T00:00:00Z", "rates": {"ETH": {"USD": "2000"}}}`
+	if err := ioutil.WriteFile(path, []byte(prices), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	oracle, err := money.LoadStaticOracle(path)
+	if err != nil {
+		t.Fatalf("LoadStaticOracle: %v", err)
+	}
+	return &chargeFixture{table: &chargeTable{charge: charge}, ngos: ngos, verifier: verifier, oracle: oracle}
+}
+
+func (f *chargeFixture) pay(t *testing.T, tx string) error {
+	body, _ := json.Marshal(PayRequest{NgoId: "helpers", ChargeId: "monthly-20260101", ChainId: "ethereum", TxHash: tx})
+	req := events.APIGatewayProxyRequest{Body: string(body)}
+	req.RequestContext.Authorizer = map[string]interface{}{
+		"claims": map[string]interface{}{"sub": donor, "email": donor},
+	}
+	fundraisers := fundraiser.NewMemoryFundraiserRepository(storage.NewMemoryTable())
+	_, err := PayCharge(req, fundraisers, f.ngos, "NGOdetails", f.table, f.verifier, f.oracle)
+	return err
+}
+
+func TestPayChargeUnderpaid(t *testing.T) {
+	f := newChargeFixture(t, money.Money{Amount: 10000, Currency: "USD"})
+
+	//0.01 ETH is only worth 20 USD
+	err := f.pay(t, txHash(1))
+	if err == nil || err.Error() != donation.ErrorTransferTooSmall {
+		t.Fatalf("PayCharge error = %v, want %s", err, donation.ErrorTransferTooSmall)
+	}
+	if f.table.written {
+		t.Fatalf("PayCharge wrote an underpaid charge")
+	}
+
+	//0.05 ETH is worth the 100 USD due
+	if err := f.pay(t, txHash(2)); err != nil {
+		t.Fatalf("PayCharge: %v", err)
+	}
+	if !f.table.written {
+		t.Fatalf("PayCharge did not write the paid charge")
+	}
+}
+
+func TestPayChargeCrypto(t *testing.T) {
+	//Charges in ETH are compared without conversion
+	f := newChargeFixture(t, money.Money{Amount: 1000000000, Currency: "ETH"})
+	err := f.pay(t, txHash(2))
+	if err == nil || err.Error() != donation.ErrorTransferTooSmall {
+		t.Fatalf("PayCharge error = %v, want %s", err, donation.ErrorTransferTooSmall)
+	}
+	if err := f.pay(t, txHash(3)); err != nil {
+		t.Fatalf("PayCharge: %v", err)
+	}
+
+	//Charges in another asset cannot be paid in ETH, whatever it is worth
+	f = newChargeFixture(t, money.Money{Amount: 1, Currency: "MATIC"})
+	err = f.pay(t, txHash(3))
+	if err == nil || err.Error() != donation.ErrorTransferWrongAsset {
+		t.Fatalf("PayCharge error = %v, want %s", err, donation.ErrorTransferWrongAsset)
+	}
+	if f.table.written {
+		t.Fatalf("PayCharge wrote a charge paid in the wrong asset")
+	}
+}
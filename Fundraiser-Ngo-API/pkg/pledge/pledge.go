@@ -0,0 +1,419 @@
+package pledge
+
+import (
+	"aws-lambda-api/pkg/audit"
+	"aws-lambda-api/pkg/auth"
+	"aws-lambda-api/pkg/fundraiser"
+	"aws-lambda-api/pkg/money"
+	"aws-lambda-api/pkg/ngo"
+	"aws-lambda-api/pkg/page"
+	"aws-lambda-api/pkg/pii"
+	"aws-lambda-api/pkg/storage"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+var (
+	ErrorFailedToUnmarshalRecord = "failed to unmarshal record"
+	ErrorFailedToFetchRecord     = "failed to fetch record"
+	ErrorInvalidUserData         = "invalid user data"
+	ErrorCouldNotMarshalItem     = "could not marshal item"
+	ErrorCouldNotDynamoPutItem   = "could not dynamo put item error"
+	ErrorPledgeAlreadyExists     = "pledge already exists"
+	ErrorPledgeDoesNotExist      = "pledge does not exist"
+	ErrorNgoDoesNotExist         = "ngo does not exist"
+	ErrorInvalidInterval         = "interval must be one of weekly, monthly, quarterly or yearly"
+	ErrorInvalidPledgeAmount     = "pledge amount must be positive"
+	ErrorInvalidStartAt          = "startAt must be an RFC3339 timestamp"
+	ErrorInvalidTransition       = "pledge cannot move to the requested status"
+	ErrorPledgeChanged           = "pledge was changed concurrently"
+)
+
+const (
+	StatusActive    = "active"
+	StatusPaused    = "paused"
+	StatusCancelled = "cancelled"
+)
+
+const (
+	IntervalWeekly    = "weekly"
+	IntervalMonthly   = "monthly"
+	IntervalQuarterly = "quarterly"
+	IntervalYearly    = "yearly"
+)
+
+// Pledges that are due are found through the sparse gsi1 index. Only
+// active pledges carry the gsi1 attributes.
+const (
+	dueIndex = "gsi1"
+	dueKey   = "PledgeDue"
+)
+
+// Pledge is a donor's promise to give the same amount to an NGO, or to
+// one of its fundraisers, on a fixed interval. Charges happen at
+// StartAt plus a whole number of intervals so monthly pledges do not
+// drift after short months.
+type Pledge struct {
+	NgoId    string `json:"pk"`
+	PledgeId string `json:"sk"`
+	//Empty for pledges to the NGO's general fund
+	FundraiserId string      `json:"fundraiserId,omitempty"`
+	DonorName    string      `json:"donorName"`
+	DonorEmail   string      `json:"donorEmail"`
+	Amount       money.Money `json:"amount"`
+	Interval     string      `json:"interval"`
+	StartAt      string      `json:"startAt"`
+	NextChargeAt string      `json:"nextChargeAt"`
+	//Period is the number of intervals between StartAt and NextChargeAt
+	Period      int64  `json:"period"`
+	ChargeCount int64  `json:"chargeCount"`
+	Status      string `json:"status"`
+	CreatedAt   string `json:"createdAt"`
+	UpdatedAt   string `json:"updatedAt"`
+	DueKey      string `json:"gsi1pk,omitempty"`
+	DueAt       string `json:"gsi1sk,omitempty"`
+}
+
+type StatusRequest struct {
+	NgoId    string `json:"ngoId"`
+	PledgeId string `json:"pledgeId"`
+}
+
+func PledgeKey(ngoId string, pledgeId string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"pk": {
+			S: aws.String("Ngo" + ngoId),
+		},
+		"sk": {
+			S: aws.String("Pledge" + pledgeId),
+		},
+	}
+}
+
+// FetchPledge loads a pledge for its donor, the NGO's money managers and
+// admins
+func FetchPledge(req events.APIGatewayProxyRequest, ngoId string, pledgeId string, ngos ngo.NgoRepository, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Pledge, error) {
+	item, err := fetchPledge(ngoId, pledgeId, tableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkAccess(req, ngoId, item.DonorEmail, ngos); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func fetchPledge(ngoId string, pledgeId string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Pledge, error) {
+	//Macking Call for DynamoDB
+	input := &dynamodb.GetItemInput{
+		Key:       PledgeKey(ngoId, pledgeId),
+		TableName: aws.String(tableName),
+	}
+	result, err := dynaClient.GetItem(input)
+	if err != nil {
+		return nil, errors.New(ErrorFailedToFetchRecord)
+	}
+
+	//Sending the Get Request
+	item := new(Pledge)
+	err = dynamodbattribute.UnmarshalMap(result.Item, item)
+	if err != nil {
+		return nil, errors.New(ErrorFailedToUnmarshalRecord)
+	}
+	if len(item.PledgeId) == 0 {
+		return nil, errors.New(ErrorPledgeDoesNotExist)
+	}
+	return item, nil
+}
+
+// FetchPledges lists the pledges to an NGO for its money managers and
+// admins, with the donors' emails masked
+func FetchPledges(req events.APIGatewayProxyRequest, ngoId string, p page.Request, ngos ngo.NgoRepository, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*[]Pledge, *storage.Key, error) {
+	if _, _, err := ngo.CheckPermission(req, ngoId, ngo.PermissionManageMoney, ngos); err != nil {
+		return nil, nil, err
+	}
+
+	//Modifying the key for DynamoDB Storage
+	ngoId = "Ngo" + ngoId
+
+	//Macking Call for DynamoDB
+	input := &dynamodb.QueryInput{
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {
+				S: aws.String(ngoId),
+			},
+			":sk": {
+				S: aws.String("Pledge"),
+			},
+		},
+		KeyConditionExpression: aws.String("pk = :pk AND begins_with(sk, :sk)"),
+		TableName:              aws.String(tableName),
+	}
+	items := []Pledge{}
+	next, err := page.Query(input, ngoId, p, &items, dynaClient)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range items {
+		items[i].DonorEmail = pii.MaskEmail(items[i].DonorEmail)
+	}
+	return &items, next, nil
+}
+
+// checkAccess lets the donor of a pledge, the NGO's money managers and
+// admins at it
+func checkAccess(req events.APIGatewayProxyRequest, ngoId string, donorEmail string, ngos ngo.NgoRepository) error {
+	caller, err := auth.FromRequest(req)
+	if err != nil {
+		return err
+	}
+	if caller.Owns(donorEmail) {
+		return nil
+	}
+	_, _, err = ngo.CheckPermission(req, ngoId, ngo.PermissionManageMoney, ngos)
+	return err
+}
+
+func CreatePledge(req events.APIGatewayProxyRequest, fundraisers fundraiser.FundraiserRepository, ngos ngo.NgoRepository, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*Pledge,
+	error,
+) {
+	//Checking if the correct request
+	var u Pledge
+	if err := json.Unmarshal([]byte(req.Body), &u); err != nil {
+		return nil, errors.New(ErrorInvalidUserData)
+	}
+	if u.NgoId == "" || u.PledgeId == "" {
+		return nil, errors.New(ErrorInvalidUserData)
+	}
+
+	//Donors pledge for themselves; admins may enter pledges for others
+	caller, err := auth.FromRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	if !caller.IsAdmin() || u.DonorEmail == "" {
+		u.DonorEmail = caller.Name()
+	}
+	if _, ok := intervalMonths[u.Interval]; !ok && u.Interval != IntervalWeekly {
+		return nil, errors.New(ErrorInvalidInterval)
+	}
+	if err := u.Amount.Normalize(); err != nil {
+		return nil, err
+	}
+	if u.Amount.Amount == 0 {
+		return nil, errors.New(ErrorInvalidPledgeAmount)
+	}
+
+	//The pledge must go to an existing NGO or NGO fundraiser
+	n, err := ngos.GetNgo(u.NgoId)
+	if err != nil {
+		return nil, err
+	}
+	if len(n.NgoId) == 0 {
+		return nil, errors.New(ErrorNgoDoesNotExist)
+	}
+	if u.FundraiserId != "" {
+		if _, err := fundraiser.FetchTarget(u.NgoId, "", u.FundraiserId, false, fundraisers, ngos); err != nil {
+			return nil, err
+		}
+	}
+
+	//The first charge happens at startAt, which defaults to now
+	now := time.Now().UTC()
+	start := now
+	if u.StartAt != "" {
+		start, err = time.Parse(time.RFC3339, u.StartAt)
+		if err != nil {
+			return nil, errors.New(ErrorInvalidStartAt)
+		}
+		start = start.UTC()
+	}
+	u.StartAt = start.Format(time.RFC3339)
+	u.NextChargeAt = u.StartAt
+	u.Period = 0
+	u.ChargeCount = 0
+	u.Status = StatusActive
+	u.CreatedAt = now.Format(time.RFC3339)
+	u.UpdatedAt = u.CreatedAt
+	u.DueKey = dueKey
+	u.DueAt = u.NextChargeAt
+
+	//Modifying the key for DynamoDB Storage
+	entityId := u.NgoId + "#" + u.PledgeId
+	u.NgoId = "Ngo" + u.NgoId
+	u.PledgeId = "Pledge" + u.PledgeId
+
+	av, err := dynamodbattribute.MarshalMap(u)
+	if err != nil {
+		return nil, errors.New(ErrorCouldNotMarshalItem)
+	}
+	entry := audit.NewEntry("Pledge", entityId, "createPledge", audit.ActorFromRequest(req), nil, u)
+	auditItem, err := audit.TransactItem(entry, tableName)
+	if err != nil {
+		return nil, err
+	}
+	input := &dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{
+				Put: &dynamodb.Put{
+					Item:                av,
+					TableName:           aws.String(tableName),
+					ConditionExpression: aws.String("attribute_not_exists(sk)"),
+				},
+			},
+			auditItem,
+		},
+	}
+	_, err = dynaClient.TransactWriteItems(input)
+	if err != nil {
+		if tce, ok := err.(*dynamodb.TransactionCanceledException); ok {
+			reasons := tce.CancellationReasons
+			if len(reasons) > 0 && aws.StringValue(reasons[0].Code) == "ConditionalCheckFailed" {
+				return nil, errors.New(ErrorPledgeAlreadyExists)
+			}
+		}
+		return nil, errors.New(ErrorCouldNotDynamoPutItem)
+	}
+	return &u, nil
+}
+
+func PausePledge(req events.APIGatewayProxyRequest, ngos ngo.NgoRepository, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*Pledge,
+	error,
+) {
+	return changeStatus(req, "pausePledge", ngos, []string{StatusActive}, StatusPaused, tableName, dynaClient)
+}
+
+func ResumePledge(req events.APIGatewayProxyRequest, ngos ngo.NgoRepository, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*Pledge,
+	error,
+) {
+	return changeStatus(req, "resumePledge", ngos, []string{StatusPaused}, StatusActive, tableName, dynaClient)
+}
+
+func CancelPledge(req events.APIGatewayProxyRequest, ngos ngo.NgoRepository, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*Pledge,
+	error,
+) {
+	return changeStatus(req, "cancelPledge", ngos, []string{StatusActive, StatusPaused}, StatusCancelled, tableName, dynaClient)
+}
+
+func changeStatus(req events.APIGatewayProxyRequest, action string, ngos ngo.NgoRepository, from []string, to string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*Pledge,
+	error,
+) {
+	//Checking if the correct request
+	var u StatusRequest
+	if err := json.Unmarshal([]byte(req.Body), &u); err != nil {
+		return nil, errors.New(ErrorInvalidUserData)
+	}
+	current, err := FetchPledge(req, u.NgoId, u.PledgeId, ngos, tableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	if !contains(from, current.Status) {
+		return nil, errors.New(ErrorInvalidTransition)
+	}
+
+	now := time.Now().UTC()
+	updated := *current
+	updated.Status = to
+	updated.UpdatedAt = now.Format(time.RFC3339)
+	updated.DueKey = ""
+	updated.DueAt = ""
+
+	update := &dynamodb.Update{
+		Key:                 PledgeKey(u.NgoId, u.PledgeId),
+		TableName:           aws.String(tableName),
+		ConditionExpression: aws.String("#status = :from"),
+		UpdateExpression:    aws.String("SET #status = :to, updatedAt = :at REMOVE gsi1pk, gsi1sk"),
+		ExpressionAttributeNames: map[string]*string{
+			"#status": aws.String("status"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":from": {S: aws.String(current.Status)},
+			":to":   {S: aws.String(to)},
+			":at":   {S: aws.String(updated.UpdatedAt)},
+		},
+	}
+
+	//Resumed pledges skip the periods missed while paused
+	if to == StatusActive {
+		start, err := time.Parse(time.RFC3339, current.StartAt)
+		if err != nil {
+			return nil, errors.New(ErrorFailedToUnmarshalRecord)
+		}
+		period := current.Period
+		for occurrence(start, current.Interval, period).Before(now) {
+			period++
+		}
+		updated.Period = period
+		updated.NextChargeAt = occurrence(start, current.Interval, period).Format(time.RFC3339)
+		updated.DueKey = dueKey
+		updated.DueAt = updated.NextChargeAt
+		update.UpdateExpression = aws.String("SET #status = :to, updatedAt = :at, nextChargeAt = :next, period = :period, gsi1pk = :due, gsi1sk = :next")
+		update.ExpressionAttributeValues[":next"] = &dynamodb.AttributeValue{S: aws.String(updated.NextChargeAt)}
+		update.ExpressionAttributeValues[":period"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(period, 10))}
+		update.ExpressionAttributeValues[":due"] = &dynamodb.AttributeValue{S: aws.String(dueKey)}
+	}
+
+	entry := audit.NewEntry("Pledge", u.NgoId+"#"+u.PledgeId, action, audit.ActorFromRequest(req), *current, updated)
+	auditItem, err := audit.TransactItem(entry, tableName)
+	if err != nil {
+		return nil, err
+	}
+	input := &dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{Update: update},
+			auditItem,
+		},
+	}
+	_, err = dynaClient.TransactWriteItems(input)
+	if err != nil {
+		if _, ok := err.(*dynamodb.TransactionCanceledException); ok {
+			return nil, errors.New(ErrorPledgeChanged)
+		}
+		return nil, errors.New(ErrorCouldNotDynamoPutItem)
+	}
+	return &updated, nil
+}
+
+var intervalMonths = map[string]int{
+	IntervalMonthly:   1,
+	IntervalQuarterly: 3,
+	IntervalYearly:    12,
+}
+
+// occurrence returns the charge date period intervals after start.
+// Month based intervals keep the day of month of start, clamped to the
+// last day of shorter months.
+func occurrence(start time.Time, interval string, period int64) time.Time {
+	if interval == IntervalWeekly {
+		return start.AddDate(0, 0, 7*int(period))
+	}
+	months := intervalMonths[interval] * int(period)
+	first := time.Date(start.Year(), start.Month()+time.Month(months), 1, start.Hour(), start.Minute(), start.Second(), 0, time.UTC)
+	day := start.Day()
+	if last := first.AddDate(0, 1, -1).Day(); day > last {
+		day = last
+	}
+	return first.AddDate(0, 0, day-1)
+}
+
+func contains(statuses []string, status string) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+package pledge
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// ChargeDuePledges raises a pending charge for every active pledge whose
+// next charge is at or before now, and returns how many were charged. No
+// money moves until the donor pays the charge with PayCharge. Each charge
+// is written together with the pledge moving to its next period, so
+// running it twice never charges the same period twice. Pledges more than
+// one period behind are charged one period per run.
+func ChargeDuePledges(now time.Time, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (int, error) {
+	var firstErr error
+	charged := 0
+	input := &dynamodb.QueryInput{
+		IndexName: aws.String(dueIndex),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {
+				S: aws.String(dueKey),
+			},
+			":now": {
+				S: aws.String(now.UTC().Format(time.RFC3339)),
+			},
+		},
+		KeyConditionExpression: aws.String("gsi1pk = :pk AND gsi1sk <= :now"),
+		TableName:              aws.String(tableName),
+	}
+	for {
+		result, err := dynaClient.Query(input)
+		if err != nil {
+			return charged, errors.New(ErrorFailedToFetchRecord)
+		}
+		var items []Pledge
+		err = dynamodbattribute.UnmarshalListOfMaps(result.Items, &items)
+		if err != nil {
+			return charged, errors.New(ErrorFailedToUnmarshalRecord)
+		}
+
+		//One failing pledge must not hold up the others
+		for i := range items {
+			if err := charge(&items[i], now, tableName, dynaClient); err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			charged++
+		}
+
+		if len(result.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+	return charged, firstErr
+}
+
+func charge(p *Pledge, now time.Time, tableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	ngoId := strings.TrimPrefix(p.NgoId, "Ngo")
+	pledgeId := strings.TrimPrefix(p.PledgeId, "Pledge")
+	start, err := time.Parse(time.RFC3339, p.StartAt)
+	if err != nil {
+		return errors.New(ErrorFailedToUnmarshalRecord)
+	}
+	due, err := time.Parse(time.RFC3339, p.NextChargeAt)
+	if err != nil {
+		return errors.New(ErrorFailedToUnmarshalRecord)
+	}
+	next := occurrence(start, p.Interval, p.Period+1).Format(time.RFC3339)
+
+	//Moving the pledge on only from the period being charged
+	advance := &dynamodb.TransactWriteItem{
+		Update: &dynamodb.Update{
+			Key:                 PledgeKey(ngoId, pledgeId),
+			TableName:           aws.String(tableName),
+			ConditionExpression: aws.String("#status = :active AND nextChargeAt = :due"),
+			UpdateExpression:    aws.String("SET nextChargeAt = :next, gsi1sk = :next, period = :period, updatedAt = :at ADD chargeCount :one"),
+			ExpressionAttributeNames: map[string]*string{
+				"#status": aws.String("status"),
+			},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":active": {S: aws.String(StatusActive)},
+				":due":    {S: aws.String(p.NextChargeAt)},
+				":next":   {S: aws.String(next)},
+				":period": {N: aws.String(strconv.FormatInt(p.Period+1, 10))},
+				":at":     {S: aws.String(now.UTC().Format(time.RFC3339))},
+				":one":    {N: aws.String("1")},
+			},
+		},
+	}
+
+	c := Charge{
+		NgoId:        p.NgoId,
+		ChargeId:     "Charge" + chargeId(pledgeId, due),
+		PledgeId:     pledgeId,
+		FundraiserId: p.FundraiserId,
+		DonorName:    p.DonorName,
+		DonorEmail:   p.DonorEmail,
+		Amount:       p.Amount,
+		DueAt:        p.NextChargeAt,
+		Status:       ChargePending,
+	}
+	av, err := dynamodbattribute.MarshalMap(c)
+	if err != nil {
+		return errors.New(ErrorCouldNotMarshalItem)
+	}
+	input := &dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{
+				Put: &dynamodb.Put{
+					Item:                av,
+					TableName:           aws.String(tableName),
+					ConditionExpression: aws.String("attribute_not_exists(sk)"),
+				},
+			},
+			advance,
+		},
+	}
+	_, err = dynaClient.TransactWriteItems(input)
+	if err != nil {
+		if _, ok := err.(*dynamodb.TransactionCanceledException); ok {
+			return errors.New(ErrorPledgeChanged)
+		}
+		return errors.New(ErrorCouldNotDynamoPutItem)
+	}
+	return nil
+}
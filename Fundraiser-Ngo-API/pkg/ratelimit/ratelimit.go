@@ -0,0 +1,269 @@
+package ratelimit
+
+import (
+	"aws-lambda-api/pkg/apikey"
+	"aws-lambda-api/pkg/auth"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+var (
+	ErrorInvalidLimits = "invalid rate limits"
+)
+
+// Limit is a token bucket holding up to Burst calls, refilled at Rate
+// calls per second. A zero Burst means no limit.
+type Limit struct {
+	Burst float64
+	Rate  float64
+}
+
+// PerMinute is a bucket of n calls refilled over a minute
+func PerMinute(n float64) Limit {
+	return Limit{Burst: n, Rate: n / 60}
+}
+
+// Limits are the limit of each route. Routes without their own limit use
+// DefaultRead for GET calls and DefaultWrite for the rest.
+type Limits struct {
+	DefaultRead  Limit
+	DefaultWrite Limit
+	Routes       map[string]Limit
+}
+
+// FailedApiKeyRoute is the route whose limit bounds the calls with an
+// invalid API key from one source IP, across all routes
+const FailedApiKeyRoute = "failedApiKey"
+
+// DefaultLimits apply unless RATE_LIMITS overrides them
+func DefaultLimits() *Limits {
+	return &Limits{
+		DefaultRead:  PerMinute(120),
+		DefaultWrite: PerMinute(30),
+		Routes: map[string]Limit{
+			"createFundraiserIndividual": PerMinute(5),
+			"createNgo":                  PerMinute(5),
+			FailedApiKeyRoute:            PerMinute(10),
+			"getSiweNonce":               PerMinute(20),
+			"verifySiwe":                 PerMinute(10),
+		},
+	}
+}
+
+// Parse overrides limits from a list formatted as "route=calls/seconds",
+// such as "getNgos=60/60,createDonation=10/60". A route of "read" or
+// "write" sets the defaults and "route=0" lifts the limit of a route.
+func (l *Limits) Parse(s string) error {
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return errors.New(ErrorInvalidLimits)
+		}
+		limit, err := parseLimit(parts[1])
+		if err != nil {
+			return err
+		}
+		switch parts[0] {
+		case "read":
+			l.DefaultRead = limit
+		case "write":
+			l.DefaultWrite = limit
+		default:
+			l.Routes[parts[0]] = limit
+		}
+	}
+	return nil
+}
+
+func parseLimit(s string) (Limit, error) {
+	if s == "0" {
+		return Limit{}, nil
+	}
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 {
+		return Limit{}, errors.New(ErrorInvalidLimits)
+	}
+	calls, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil || calls < 1 {
+		return Limit{}, errors.New(ErrorInvalidLimits)
+	}
+	seconds, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil || seconds <= 0 {
+		return Limit{}, errors.New(ErrorInvalidLimits)
+	}
+	return Limit{Burst: calls, Rate: calls / seconds}, nil
+}
+
+func (l *Limits) For(method string, route string) Limit {
+	if limit, ok := l.Routes[route]; ok {
+		return limit
+	}
+	if method == "GET" {
+		return l.DefaultRead
+	}
+	return l.DefaultWrite
+}
+
+// Bucket is the state of one client's bucket for one route. ExpiresAt is
+// the table's TTL attribute; a bucket left alone that long is full again,
+// so DynamoDB may remove it.
+type Bucket struct {
+	BucketId  string  `json:"pk"`
+	Kind      string  `json:"sk"`
+	Tokens    float64 `json:"tokens"`
+	UpdatedAt int64   `json:"updatedAt"`
+	ExpiresAt int64   `json:"ttl"`
+}
+
+// maxAttempts bounds the retries when concurrent calls race on a bucket
+const maxAttempts = 3
+
+func BucketKey(client string, route string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"pk": {
+			S: aws.String("RateLimit" + client + "#" + route),
+		},
+		"sk": {
+			S: aws.String("Bucket"),
+		},
+	}
+}
+
+// Client identifies the caller of req: their API key, else the user they
+// are signed in as, else their source IP. The key is only trusted once
+// AllowKeyCheck and apikey.Authorize have passed it.
+func Client(req events.APIGatewayProxyRequest) string {
+	if id := apikey.KeyId(req); id != "" {
+		return "ApiKey" + id
+	}
+	if caller, err := auth.FromRequest(req); err == nil {
+		return "User" + strings.ToLower(caller.Name())
+	}
+	return sourceIp(req)
+}
+
+// Allow takes a token from the caller's bucket for route. When the bucket
+// is empty it returns false and how long until a token is available.
+// Errors reaching the table let the call through rather than fail it.
+func Allow(req events.APIGatewayProxyRequest, route string, limits *Limits, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (bool, time.Duration) {
+	return take(Client(req), route, limits.For(req.HTTPMethod, route), true, tableName, dynaClient)
+}
+
+// AllowKeyCheck reports whether the API key of req may be looked up,
+// which it may until its source IP has used up its failed key checks. No
+// token is taken: only FailKeyCheck takes one.
+func AllowKeyCheck(req events.APIGatewayProxyRequest, limits *Limits, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (bool, time.Duration) {
+	return take(sourceIp(req), FailedApiKeyRoute, limits.For(req.HTTPMethod, FailedApiKeyRoute), false, tableName, dynaClient)
+}
+
+// FailKeyCheck takes a token from the failed key checks of the source IP
+// of req
+func FailKeyCheck(req events.APIGatewayProxyRequest, limits *Limits, tableName string, dynaClient dynamodbiface.DynamoDBAPI) {
+	take(sourceIp(req), FailedApiKeyRoute, limits.For(req.HTTPMethod, FailedApiKeyRoute), true, tableName, dynaClient)
+}
+
+func sourceIp(req events.APIGatewayProxyRequest) string {
+	return "Ip" + req.RequestContext.Identity.SourceIP
+}
+
+// take takes a token from the bucket of client for route, or when consume
+// is false only checks that one is available
+func take(client string, route string, limit Limit, consume bool, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (bool, time.Duration) {
+	if limit.Burst == 0 {
+		return true, 0
+	}
+	key := BucketKey(client, route)
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		current, err := fetchBucket(key, tableName, dynaClient)
+		if err != nil {
+			return true, 0
+		}
+		now := time.Now()
+		tokens := limit.Burst
+		if current != nil {
+			elapsed := float64(now.UnixNano()/int64(time.Millisecond)-current.UpdatedAt) / 1000
+			tokens = math.Min(limit.Burst, current.Tokens+math.Max(0, elapsed)*limit.Rate)
+		}
+		if tokens < 1 {
+			wait := (1 - tokens) / limit.Rate
+			return false, time.Duration(math.Ceil(wait)) * time.Second
+		}
+		if !consume {
+			return true, 0
+		}
+
+		refill := time.Duration(limit.Burst / limit.Rate * float64(time.Second))
+		next := Bucket{
+			BucketId:  aws.StringValue(key["pk"].S),
+			Kind:      aws.StringValue(key["sk"].S),
+			Tokens:    tokens - 1,
+			UpdatedAt: now.UnixNano() / int64(time.Millisecond),
+			ExpiresAt: now.Add(refill).Add(time.Minute).Unix(),
+		}
+		err = saveBucket(&next, current, tableName, dynaClient)
+		if err == nil {
+			return true, 0
+		}
+		if _, ok := err.(*dynamodb.ConditionalCheckFailedException); !ok {
+			return true, 0
+		}
+	}
+	//Too many calls at once to take a token
+	return false, time.Second
+}
+
+func fetchBucket(key map[string]*dynamodb.AttributeValue, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Bucket, error) {
+	//Macking Call for DynamoDB
+	input := &dynamodb.GetItemInput{
+		Key:            key,
+		TableName:      aws.String(tableName),
+		ConsistentRead: aws.Bool(true),
+	}
+	result, err := dynaClient.GetItem(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Item) == 0 {
+		return nil, nil
+	}
+	item := new(Bucket)
+	err = dynamodbattribute.UnmarshalMap(result.Item, item)
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// saveBucket writes next if the bucket is still as it was read
+func saveBucket(next *Bucket, current *Bucket, tableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	av, err := dynamodbattribute.MarshalMap(next)
+	if err != nil {
+		return err
+	}
+	input := &dynamodb.PutItemInput{
+		Item:                av,
+		TableName:           aws.String(tableName),
+		ConditionExpression: aws.String("attribute_not_exists(sk)"),
+	}
+	if current != nil {
+		input.ConditionExpression = aws.String("updatedAt = :previous")
+		input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":previous": {N: aws.String(strconv.FormatInt(current.UpdatedAt, 10))},
+		}
+	}
+	_, err = dynaClient.PutItem(input)
+	return err
+}
@@ -0,0 +1,90 @@
+package receipt
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PDF renders the receipt as a single page PDF using the standard
+// Helvetica font, so no font files need to be bundled.
+func (r *Receipt) PDF() []byte {
+	lines := []string{
+		"Donation Receipt No. " + strconv.FormatInt(r.ReceiptNumber, 10),
+		"",
+		r.NgoLegalName,
+		r.NgoAddress + ", " + r.NgoCountry,
+		"Registration No.: " + r.NgoRegistrationNumber,
+		"Tax Id: " + r.NgoTaxId,
+		"",
+		"Issued: " + r.IssuedAt,
+		"Donor: " + r.DonorName,
+		"Donor email: " + r.DonorEmail,
+		"Donor address: " + r.DonorAddress,
+		"Date of donation: " + r.DonatedAt,
+		"Donation reference: " + r.FundraiserId + "/" + r.DonationId,
+		"",
+		"Amount received: " + r.DonationAmount.String(),
+		"Fair market value of goods or services provided: " + r.FairMarketValue.String(),
+		"Eligible amount of gift: " + r.EligibleAmount.String(),
+	}
+	if r.ReplacesReceipt != 0 {
+		lines = append(lines, "", "This receipt replaces receipt No. "+strconv.FormatInt(r.ReplacesReceipt, 10))
+	}
+	if r.Status != StatusIssued {
+		lines = append(lines, "", "STATUS: "+strings.ToUpper(r.Status)+" - "+r.VoidReason)
+	}
+
+	//Content stream, one text line every 18 points from the top
+	var content bytes.Buffer
+	content.WriteString("BT\n/F1 16 Tf\n50 790 Td\n")
+	for i, line := range lines {
+		if i == 1 {
+			content.WriteString("/F1 11 Tf\n")
+		}
+		fmt.Fprintf(&content, "(%s) Tj\n0 -18 Td\n", pdfEscape(line))
+	}
+	content.WriteString("ET\n")
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] /Resources << /Font << /F1 4 0 R >> >> /Contents 5 0 R >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()),
+	}
+
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return out.Bytes()
+}
+
+// pdfEscape escapes a PDF string literal, replacing characters the
+// standard font encoding cannot show.
+func pdfEscape(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		switch {
+		case c == '(' || c == ')' || c == '\\':
+			b.WriteRune('\\')
+			b.WriteRune(c)
+		case c < 32 || c > 126:
+			b.WriteRune('?')
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
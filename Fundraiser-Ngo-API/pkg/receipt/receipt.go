@@ -0,0 +1,517 @@
+package receipt
+
+import (
+	"aws-lambda-api/pkg/audit"
+	"aws-lambda-api/pkg/donation"
+	"aws-lambda-api/pkg/fundraiser"
+	"aws-lambda-api/pkg/money"
+	"aws-lambda-api/pkg/ngo"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+var (
+	ErrorFailedToUnmarshalRecord  = "failed to unmarshal record"
+	ErrorFailedToFetchRecord      = "failed to fetch record"
+	ErrorInvalidUserData          = "invalid user data"
+	ErrorCouldNotMarshalItem      = "could not marshal item"
+	ErrorCouldNotDynamoPutItem    = "could not dynamo put item error"
+	ErrorReceiptDoesNotExist      = "receipt does not exist"
+	ErrorDonationDoesNotExist     = donation.ErrorDonationDoesNotExist
+	ErrorNgoDoesNotExist          = "ngo does not exist"
+	ErrorNotNgoDonation           = "donation was not made to a fundraiser of this ngo"
+	ErrorReceiptAlreadyIssued     = "a receipt has already been issued for this donation"
+	ErrorDonationNotVerified      = "receipts are only issued for verified donations"
+	ErrorReceiptNotIssued         = "receipt is not in issued state"
+	ErrorFairMarketValueCurrency  = "fair market value must be in the donation's currency"
+	ErrorReceiptNumberingConflict = "could not allocate a receipt number, try again"
+	ErrorReasonRequired           = "a reason is required"
+	ErrorUnsupportedReceiptFormat = "unsupported receipt format"
+)
+
+const (
+	StatusIssued   = "issued"
+	StatusVoided   = "voided"
+	StatusReissued = "reissued"
+)
+
+// Receipt is a donation receipt issued by an NGO. Receipts are numbered
+// sequentially per NGO and are never deleted; voided and reissued
+// receipts stay on record.
+type Receipt struct {
+	NgoId         string `json:"pk"`
+	ReceiptId     string `json:"sk"`
+	ReceiptNumber int64  `json:"receiptNumber"`
+	Status        string `json:"status"`
+	IssuedAt      string `json:"issuedAt"`
+
+	NgoName               string `json:"ngoName"`
+	NgoLegalName          string `json:"ngoLegalName"`
+	NgoRegistrationNumber string `json:"ngoRegistrationNumber"`
+	NgoTaxId              string `json:"ngoTaxId"`
+	NgoAddress            string `json:"ngoAddress"`
+	NgoCountry            string `json:"ngoCountry"`
+
+	FundraiserId string `json:"fundraiserId"`
+	DonationId   string `json:"donationId"`
+	DonorName    string `json:"donorName"`
+	DonorEmail   string `json:"donorEmail"`
+	DonorAddress string `json:"donorAddress"`
+	DonatedAt    string `json:"donatedAt"`
+
+	//Eligible amount is the donation less the fair market value of
+	//anything the donor received in return
+	DonationAmount  money.Money `json:"donationAmount"`
+	FairMarketValue money.Money `json:"fairMarketValue"`
+	EligibleAmount  money.Money `json:"eligibleAmount"`
+
+	ReplacesReceipt int64  `json:"replacesReceipt,omitempty"`
+	ReplacedBy      int64  `json:"replacedBy,omitempty"`
+	VoidReason      string `json:"voidReason,omitempty"`
+	VoidedAt        string `json:"voidedAt,omitempty"`
+}
+
+type IssueRequest struct {
+	NgoId           string      `json:"ngoId"`
+	FundraiserId    string      `json:"fundraiserId"`
+	DonationId      string      `json:"donationId"`
+	DonorAddress    string      `json:"donorAddress"`
+	FairMarketValue money.Money `json:"fairMarketValue"`
+}
+
+type ReissueRequest struct {
+	NgoId           string       `json:"ngoId"`
+	ReceiptNumber   int64        `json:"receiptNumber"`
+	Reason          string       `json:"reason"`
+	DonorName       string       `json:"donorName"`
+	DonorAddress    string       `json:"donorAddress"`
+	FairMarketValue *money.Money `json:"fairMarketValue"`
+}
+
+type VoidRequest struct {
+	NgoId         string `json:"ngoId"`
+	ReceiptNumber int64  `json:"receiptNumber"`
+	Reason        string `json:"reason"`
+}
+
+func receiptId(number int64) string {
+	return fmt.Sprintf("Receipt%010d", number)
+}
+
+func receiptKey(ngoId string, number int64) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"pk": {
+			S: aws.String("Ngo" + ngoId),
+		},
+		"sk": {
+			S: aws.String(receiptId(number)),
+		},
+	}
+}
+
+func counterKey(ngoId string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"pk": {
+			S: aws.String("Ngo" + ngoId),
+		},
+		"sk": {
+			S: aws.String("ReceiptCounter"),
+		},
+	}
+}
+
+func FetchReceipt(ngoId string, receiptNumber string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Receipt, error) {
+	number, err := strconv.ParseInt(receiptNumber, 10, 64)
+	if err != nil {
+		return nil, errors.New(ErrorInvalidUserData)
+	}
+
+	//Macking Call for DynamoDB
+	input := &dynamodb.GetItemInput{
+		Key:       receiptKey(ngoId, number),
+		TableName: aws.String(tableName),
+	}
+	result, err := dynaClient.GetItem(input)
+	if err != nil {
+		return nil, errors.New(ErrorFailedToFetchRecord)
+	}
+	if len(result.Item) == 0 {
+		return nil, errors.New(ErrorReceiptDoesNotExist)
+	}
+
+	//Sending the Get Request
+	item := new(Receipt)
+	err = dynamodbattribute.UnmarshalMap(result.Item, item)
+	if err != nil {
+		return nil, errors.New(ErrorFailedToUnmarshalRecord)
+	}
+	return item, nil
+}
+
+func IssueReceipt(req events.APIGatewayProxyRequest, ngos ngo.NgoRepository, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*Receipt,
+	error,
+) {
+	//Checking if the correct request
+	var u IssueRequest
+	if err := json.Unmarshal([]byte(req.Body), &u); err != nil {
+		return nil, errors.New(ErrorInvalidUserData)
+	}
+
+	//Receipts are only issued for donations to the NGO's own fundraisers
+	if u.NgoId == "" {
+		return nil, errors.New(ErrorNotNgoDonation)
+	}
+	//Only verified NGOs may issue receipts, which checkNgoOwner enforces
+	n, err := checkNgoOwner(req, u.NgoId, ngos)
+	if err != nil {
+		return nil, err
+	}
+	d, err := donation.FetchDonation(u.NgoId, "", u.FundraiserId, u.DonationId, tableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	//A receipt states what the donor gave, so it needs money seen on chain
+	if !d.Verified() {
+		return nil, errors.New(ErrorDonationNotVerified)
+	}
+	if d.ReceiptNumber != 0 {
+		return nil, errors.New(ErrorReceiptAlreadyIssued)
+	}
+
+	r := &Receipt{
+		Status:                StatusIssued,
+		NgoName:               n.NgoName,
+		NgoLegalName:          n.NgoLegalName,
+		NgoRegistrationNumber: n.NgoRegistrationNumber,
+		NgoTaxId:              n.NgoTaxId,
+		NgoAddress:            n.NgoAdress,
+		NgoCountry:            n.NgoCountry,
+		FundraiserId:          u.FundraiserId,
+		DonationId:            u.DonationId,
+		DonorName:             d.DonorName,
+		DonorEmail:            d.DonorEmail,
+		DonorAddress:          u.DonorAddress,
+		DonatedAt:             d.DonatedAt,
+		DonationAmount:        d.DonationAmount,
+	}
+	if err := r.setFairMarketValue(u.FairMarketValue); err != nil {
+		return nil, err
+	}
+
+	//The donation must not have gained a receipt in the meantime
+	link := &dynamodb.Update{
+		Key:                 donation.DonationKey(d.FundraiserId, u.DonationId),
+		TableName:           aws.String(tableName),
+		ConditionExpression: aws.String("attribute_exists(sk) AND attribute_not_exists(receiptNumber)"),
+		UpdateExpression:    aws.String("SET receiptNumber = :number"),
+	}
+	err = issue(req, u.NgoId, r, nil, "", link, "issueReceipt", tableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func ReissueReceipt(req events.APIGatewayProxyRequest, ngos ngo.NgoRepository, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*Receipt,
+	error,
+) {
+	//Checking if the correct request
+	var u ReissueRequest
+	if err := json.Unmarshal([]byte(req.Body), &u); err != nil {
+		return nil, errors.New(ErrorInvalidUserData)
+	}
+	if u.Reason == "" {
+		return nil, errors.New(ErrorReasonRequired)
+	}
+	if _, err := checkNgoOwner(req, u.NgoId, ngos); err != nil {
+		return nil, err
+	}
+	previous, err := FetchReceipt(u.NgoId, strconv.FormatInt(u.ReceiptNumber, 10), tableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	if previous.Status != StatusIssued {
+		return nil, errors.New(ErrorReceiptNotIssued)
+	}
+
+	//The new receipt carries over everything but the corrected fields
+	r := *previous
+	r.ReplacesReceipt = previous.ReceiptNumber
+	r.ReplacedBy = 0
+	if u.DonorName != "" {
+		r.DonorName = u.DonorName
+	}
+	if u.DonorAddress != "" {
+		r.DonorAddress = u.DonorAddress
+	}
+	if u.FairMarketValue != nil {
+		if err := r.setFairMarketValue(*u.FairMarketValue); err != nil {
+			return nil, err
+		}
+	}
+
+	//The donation must still point at the receipt being replaced
+	ledger, err := fundraiser.LedgerKey(u.NgoId, "", previous.FundraiserId)
+	if err != nil {
+		return nil, err
+	}
+	link := &dynamodb.Update{
+		Key:                 donation.DonationKey(ledger, previous.DonationId),
+		TableName:           aws.String(tableName),
+		ConditionExpression: aws.String("receiptNumber = :previous"),
+		UpdateExpression:    aws.String("SET receiptNumber = :number"),
+	}
+	err = issue(req, u.NgoId, &r, previous, u.Reason, link, "reissueReceipt", tableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func VoidReceipt(req events.APIGatewayProxyRequest, ngos ngo.NgoRepository, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*Receipt,
+	error,
+) {
+	//Checking if the correct request
+	var u VoidRequest
+	if err := json.Unmarshal([]byte(req.Body), &u); err != nil {
+		return nil, errors.New(ErrorInvalidUserData)
+	}
+	if u.Reason == "" {
+		return nil, errors.New(ErrorReasonRequired)
+	}
+	if _, err := checkNgoOwner(req, u.NgoId, ngos); err != nil {
+		return nil, err
+	}
+	r, err := FetchReceipt(u.NgoId, strconv.FormatInt(u.ReceiptNumber, 10), tableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	if r.Status != StatusIssued {
+		return nil, errors.New(ErrorReceiptNotIssued)
+	}
+	before := *r
+	r.Status = StatusVoided
+	r.VoidReason = u.Reason
+	r.VoidedAt = time.Now().UTC().Format(time.RFC3339)
+
+	entry := audit.NewEntry("Receipt", receiptEntityId(u.NgoId, r.ReceiptNumber), "voidReceipt", audit.ActorFromRequest(req), before, *r)
+	auditItem, err := audit.TransactItem(entry, tableName)
+	if err != nil {
+		return nil, err
+	}
+	input := &dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{
+				Update: voidUpdate(u.NgoId, r, tableName),
+			},
+			auditItem,
+		},
+	}
+	_, err = dynaClient.TransactWriteItems(input)
+	if err != nil {
+		if _, ok := err.(*dynamodb.TransactionCanceledException); ok {
+			return nil, errors.New(ErrorReceiptNotIssued)
+		}
+		return nil, errors.New(ErrorCouldNotDynamoPutItem)
+	}
+	return r, nil
+}
+
+func (r *Receipt) setFairMarketValue(fmv money.Money) error {
+	if fmv.Currency == "" {
+		fmv.Currency = r.DonationAmount.Currency
+	}
+	if err := fmv.Normalize(); err != nil {
+		return err
+	}
+	if fmv.Currency != r.DonationAmount.Currency {
+		return errors.New(ErrorFairMarketValueCurrency)
+	}
+	r.FairMarketValue = fmv
+	r.EligibleAmount = money.Money{Amount: r.DonationAmount.Amount - fmv.Amount, Currency: fmv.Currency}
+	if r.EligibleAmount.Amount < 0 {
+		r.EligibleAmount.Amount = 0
+	}
+	return nil
+}
+
+// checkNgoOwner loads the NGO if the caller of req may issue its receipts
+func checkNgoOwner(req events.APIGatewayProxyRequest, ngoId string, ngos ngo.NgoRepository) (*ngo.Ngo, error) {
+	n, _, err := ngo.CheckPermission(req, ngoId, ngo.PermissionManageMoney, ngos)
+	if err != nil {
+		if err.Error() == ngo.ErrorUserDoesNotExists {
+			return nil, errors.New(ErrorNgoDoesNotExist)
+		}
+		return nil, err
+	}
+	return n, nil
+}
+
+func receiptEntityId(ngoId string, number int64) string {
+	return ngoId + "#" + strconv.FormatInt(number, 10)
+}
+
+func voidUpdate(ngoId string, r *Receipt, tableName string) *dynamodb.Update {
+	update := &dynamodb.Update{
+		Key:                 receiptKey(ngoId, r.ReceiptNumber),
+		TableName:           aws.String(tableName),
+		ConditionExpression: aws.String("#status = :issued"),
+		UpdateExpression:    aws.String("SET #status = :status, voidReason = :reason, voidedAt = :voidedAt"),
+		ExpressionAttributeNames: map[string]*string{
+			"#status": aws.String("status"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":issued":   {S: aws.String(StatusIssued)},
+			":status":   {S: aws.String(r.Status)},
+			":reason":   {S: aws.String(r.VoidReason)},
+			":voidedAt": {S: aws.String(r.VoidedAt)},
+		},
+	}
+	if r.ReplacedBy != 0 {
+		update.UpdateExpression = aws.String(*update.UpdateExpression + ", replacedBy = :replacedBy")
+		update.ExpressionAttributeValues[":replacedBy"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(r.ReplacedBy, 10))}
+	}
+	return update
+}
+
+// issue allocates the next receipt number for ngoId and writes r, the
+// donation link, the replaced receipt (if any) and the audit entry in a
+// single transaction. Numbers are only consumed by successful writes, so
+// the sequence has no gaps.
+func issue(req events.APIGatewayProxyRequest, ngoId string, r *Receipt, previous *Receipt, reason string, link *dynamodb.Update, action string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	for attempt := 0; attempt < 3; attempt++ {
+		last, err := lastReceiptNumber(ngoId, tableName, dynaClient)
+		if err != nil {
+			return err
+		}
+		now := time.Now().UTC().Format(time.RFC3339)
+		r.NgoId = "Ngo" + ngoId
+		r.ReceiptNumber = last + 1
+		r.ReceiptId = receiptId(r.ReceiptNumber)
+		r.Status = StatusIssued
+		r.IssuedAt = now
+
+		items, err := issueItems(req, ngoId, last, r, previous, reason, link, action, now, tableName)
+		if err != nil {
+			return err
+		}
+		_, err = dynaClient.TransactWriteItems(&dynamodb.TransactWriteItemsInput{TransactItems: items})
+		if err == nil {
+			return nil
+		}
+		tce, ok := err.(*dynamodb.TransactionCanceledException)
+		if !ok {
+			return errors.New(ErrorCouldNotDynamoPutItem)
+		}
+
+		//Another receipt took the number, so try again with the next one
+		reasons := tce.CancellationReasons
+		if len(reasons) > 1 && (aws.StringValue(reasons[0].Code) == "ConditionalCheckFailed" || aws.StringValue(reasons[1].Code) == "ConditionalCheckFailed") {
+			continue
+		}
+		if len(reasons) > 2 && aws.StringValue(reasons[2].Code) == "ConditionalCheckFailed" {
+			if previous != nil {
+				return errors.New(ErrorReceiptNotIssued)
+			}
+			return errors.New(ErrorReceiptAlreadyIssued)
+		}
+		if len(reasons) > 3 && previous != nil && aws.StringValue(reasons[3].Code) == "ConditionalCheckFailed" {
+			return errors.New(ErrorReceiptNotIssued)
+		}
+		return errors.New(ErrorCouldNotDynamoPutItem)
+	}
+	return errors.New(ErrorReceiptNumberingConflict)
+}
+
+func issueItems(req events.APIGatewayProxyRequest, ngoId string, last int64, r *Receipt, previous *Receipt, reason string, link *dynamodb.Update, action string, now string, tableName string) ([]*dynamodb.TransactWriteItem, error) {
+	av, err := dynamodbattribute.MarshalMap(r)
+	if err != nil {
+		return nil, errors.New(ErrorCouldNotMarshalItem)
+	}
+	number := strconv.FormatInt(r.ReceiptNumber, 10)
+
+	//Advancing the counter only if nobody else has
+	counter := &dynamodb.Update{
+		Key:              counterKey(ngoId),
+		TableName:        aws.String(tableName),
+		UpdateExpression: aws.String("SET lastNumber = :next"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":next": {N: aws.String(number)},
+		},
+	}
+	if last == 0 {
+		counter.ConditionExpression = aws.String("attribute_not_exists(lastNumber)")
+	} else {
+		counter.ConditionExpression = aws.String("lastNumber = :last")
+		counter.ExpressionAttributeValues[":last"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(last, 10))}
+	}
+
+	linkUpdate := *link
+	linkUpdate.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+		":number": {N: aws.String(number)},
+	}
+	if previous != nil {
+		linkUpdate.ExpressionAttributeValues[":previous"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(previous.ReceiptNumber, 10))}
+	}
+
+	items := []*dynamodb.TransactWriteItem{
+		{Update: counter},
+		{
+			Put: &dynamodb.Put{
+				Item:                av,
+				TableName:           aws.String(tableName),
+				ConditionExpression: aws.String("attribute_not_exists(sk)"),
+			},
+		},
+		{Update: &linkUpdate},
+	}
+
+	var before interface{}
+	if previous != nil {
+		replaced := *previous
+		replaced.Status = StatusReissued
+		replaced.ReplacedBy = r.ReceiptNumber
+		replaced.VoidReason = reason
+		replaced.VoidedAt = now
+		items = append(items, &dynamodb.TransactWriteItem{Update: voidUpdate(ngoId, &replaced, tableName)})
+		before = *previous
+	}
+
+	entry := audit.NewEntry("Receipt", receiptEntityId(ngoId, r.ReceiptNumber), action, audit.ActorFromRequest(req), before, *r)
+	auditItem, err := audit.TransactItem(entry, tableName)
+	if err != nil {
+		return nil, err
+	}
+	return append(items, auditItem), nil
+}
+
+func lastReceiptNumber(ngoId string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (int64, error) {
+	result, err := dynaClient.GetItem(&dynamodb.GetItemInput{
+		Key:            counterKey(ngoId),
+		TableName:      aws.String(tableName),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return 0, errors.New(ErrorFailedToFetchRecord)
+	}
+	var counter struct {
+		LastNumber int64 `json:"lastNumber"`
+	}
+	err = dynamodbattribute.UnmarshalMap(result.Item, &counter)
+	if err != nil {
+		return 0, errors.New(ErrorFailedToUnmarshalRecord)
+	}
+	return counter.LastNumber, nil
+}
@@ -0,0 +1,315 @@
+package siwe
+
+import (
+	"aws-lambda-api/pkg/auth"
+	"aws-lambda-api/pkg/wallet"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+var (
+	ErrorInvalidUserData       = "invalid user data"
+	ErrorCouldNotMarshalItem   = "could not marshal item"
+	ErrorCouldNotDynamoPutItem = "could not dynamo put item error"
+	ErrorFailedToDeleteItem    = "failed to delete item"
+	ErrorSignInNotConfigured   = "sign-in is not configured"
+	ErrorInvalidMessage        = "invalid sign-in message"
+	ErrorWrongDomain           = "sign-in message is for another domain"
+	ErrorMessageExpired        = "sign-in message has expired"
+	ErrorMessageNotYetValid    = "sign-in message is not valid yet"
+	ErrorSignerMismatch        = "signature does not match the message address"
+	ErrorInvalidNonce          = "nonce is unknown, expired or already used"
+)
+
+const (
+	// NonceLifetime is how long a nonce can be used before it expires
+	NonceLifetime = 10 * time.Minute
+	// SessionLifetime is the longest session a sign-in grants
+	SessionLifetime = 24 * time.Hour
+)
+
+const preamble = " wants you to sign in with your Ethereum account:"
+
+// Nonce is a single-use sign-in nonce. ExpiresAt is the table's TTL
+// attribute, so DynamoDB removes nonces that were never used.
+type Nonce struct {
+	NonceId   string `json:"pk"`
+	Kind      string `json:"sk"`
+	Nonce     string `json:"nonce"`
+	ExpiresAt int64  `json:"ttl"`
+}
+
+// Message is an EIP-4361 sign-in message
+type Message struct {
+	Domain         string
+	Address        string
+	Statement      string
+	URI            string
+	Version        string
+	ChainId        string
+	Nonce          string
+	IssuedAt       time.Time
+	ExpirationTime *time.Time
+	NotBefore      *time.Time
+	RequestId      string
+	Resources      []string
+}
+
+// SignInRequest is the signed message sent to verify a sign-in
+type SignInRequest struct {
+	Message   string `json:"message"`
+	Signature string `json:"signature"`
+}
+
+// SignInResult is the session granted by a verified sign-in
+type SignInResult struct {
+	Address   string `json:"address"`
+	Token     string `json:"token"`
+	ExpiresAt string `json:"expiresAt"`
+}
+
+func NonceKey(nonce string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"pk": {
+			S: aws.String("SiweNonce" + nonce),
+		},
+		"sk": {
+			S: aws.String("Nonce"),
+		},
+	}
+}
+
+// CreateNonce stores a fresh nonce for a sign-in message
+func CreateNonce(tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Nonce, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+	u := Nonce{Nonce: hex.EncodeToString(random)}
+	key := NonceKey(u.Nonce)
+	u.NonceId = aws.StringValue(key["pk"].S)
+	u.Kind = aws.StringValue(key["sk"].S)
+	u.ExpiresAt = time.Now().Add(NonceLifetime).Unix()
+
+	//Marshaling the data
+	av, err := dynamodbattribute.MarshalMap(u)
+	if err != nil {
+		return nil, errors.New(ErrorCouldNotMarshalItem)
+	}
+	//Puting it to DynamoDB
+	input := &dynamodb.PutItemInput{
+		Item:                av,
+		TableName:           aws.String(tableName),
+		ConditionExpression: aws.String("attribute_not_exists(sk)"),
+	}
+	_, err = dynaClient.PutItem(input)
+	if err != nil {
+		return nil, errors.New(ErrorCouldNotDynamoPutItem)
+	}
+	return &u, nil
+}
+
+// SignIn verifies a message signed with personal_sign for domain, uses up
+// its nonce and issues a session for the signing address
+func SignIn(req events.APIGatewayProxyRequest, domain string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+	*SignInResult,
+	error,
+) {
+	if domain == "" {
+		return nil, errors.New(ErrorSignInNotConfigured)
+	}
+	//Checking if the correct request
+	var u SignInRequest
+	if err := json.Unmarshal([]byte(req.Body), &u); err != nil {
+		return nil, errors.New(ErrorInvalidUserData)
+	}
+	m, err := ParseMessage(u.Message)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if err := m.validate(domain, now); err != nil {
+		return nil, err
+	}
+
+	//The signer must be the account the message names
+	signature, err := wallet.DecodeSignature(u.Signature)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := wallet.RecoverAddress(wallet.PersonalSignHash([]byte(u.Message)), signature)
+	if err != nil {
+		return nil, err
+	}
+	if signer != m.Address {
+		return nil, errors.New(ErrorSignerMismatch)
+	}
+
+	if err := useNonce(m.Nonce, now, tableName, dynaClient); err != nil {
+		return nil, err
+	}
+
+	expiresAt := now.Add(SessionLifetime)
+	if m.ExpirationTime != nil && m.ExpirationTime.Before(expiresAt) {
+		expiresAt = *m.ExpirationTime
+	}
+	subject := strings.ToLower(m.Address)
+	token, err := auth.IssueSession(subject, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &SignInResult{
+		Address:   subject,
+		Token:     token,
+		ExpiresAt: expiresAt.UTC().Format(time.RFC3339),
+	}, nil
+}
+
+// useNonce deletes the nonce, failing if it was already used. TTL
+// deletion can lag, so the expiry is checked as well.
+func useNonce(nonce string, now time.Time, tableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	input := &dynamodb.DeleteItemInput{
+		Key:                 NonceKey(nonce),
+		TableName:           aws.String(tableName),
+		ConditionExpression: aws.String("attribute_exists(sk) AND #ttl > :now"),
+		ExpressionAttributeNames: map[string]*string{
+			"#ttl": aws.String("ttl"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now": {
+				N: aws.String(strconv.FormatInt(now.Unix(), 10)),
+			},
+		},
+	}
+	_, err := dynaClient.DeleteItem(input)
+	if err != nil {
+		if _, ok := err.(*dynamodb.ConditionalCheckFailedException); ok {
+			return errors.New(ErrorInvalidNonce)
+		}
+		return errors.New(ErrorFailedToDeleteItem)
+	}
+	return nil
+}
+
+func (m *Message) validate(domain string, now time.Time) error {
+	if !strings.EqualFold(m.Domain, domain) {
+		return errors.New(ErrorWrongDomain)
+	}
+	if m.ExpirationTime != nil && !now.Before(*m.ExpirationTime) {
+		return errors.New(ErrorMessageExpired)
+	}
+	if m.NotBefore != nil && now.Before(*m.NotBefore) {
+		return errors.New(ErrorMessageNotYetValid)
+	}
+	return nil
+}
+
+// ParseMessage parses the EIP-4361 text of a sign-in message
+func ParseMessage(text string) (*Message, error) {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	if len(lines) < 2 || !strings.HasSuffix(lines[0], preamble) {
+		return nil, errors.New(ErrorInvalidMessage)
+	}
+	m := &Message{Domain: strings.TrimSuffix(lines[0], preamble)}
+	if i := strings.Index(m.Domain, "://"); i >= 0 {
+		m.Domain = m.Domain[i+3:]
+	}
+	m.Address = lines[1]
+	if !wallet.ValidEIP55(m.Address) {
+		return nil, errors.New(ErrorInvalidMessage)
+	}
+
+	var issuedAt, expirationTime, notBefore string
+	fields := map[string]*string{
+		"URI":             &m.URI,
+		"Version":         &m.Version,
+		"Chain ID":        &m.ChainId,
+		"Nonce":           &m.Nonce,
+		"Issued At":       &issuedAt,
+		"Expiration Time": &expirationTime,
+		"Not Before":      &notBefore,
+		"Request ID":      &m.RequestId,
+	}
+
+	//The optional statement sits between the address and the fields
+	inFields, inResources := false, false
+	for _, line := range lines[2:] {
+		if inResources {
+			if !strings.HasPrefix(line, "- ") {
+				return nil, errors.New(ErrorInvalidMessage)
+			}
+			m.Resources = append(m.Resources, strings.TrimPrefix(line, "- "))
+			continue
+		}
+		if line == "Resources:" {
+			inResources = true
+			continue
+		}
+		parts := strings.SplitN(line, ": ", 2)
+		if len(parts) == 2 && fields[parts[0]] != nil {
+			if *fields[parts[0]] != "" {
+				return nil, errors.New(ErrorInvalidMessage)
+			}
+			*fields[parts[0]] = parts[1]
+			inFields = true
+			continue
+		}
+		if line == "" {
+			continue
+		}
+		if inFields || m.Statement != "" {
+			return nil, errors.New(ErrorInvalidMessage)
+		}
+		m.Statement = line
+	}
+
+	if m.URI == "" || m.Version != "1" || len(m.Nonce) < 8 || !alphanumeric(m.Nonce) {
+		return nil, errors.New(ErrorInvalidMessage)
+	}
+	if _, err := strconv.ParseUint(m.ChainId, 10, 64); err != nil {
+		return nil, errors.New(ErrorInvalidMessage)
+	}
+	t, err := time.Parse(time.RFC3339, issuedAt)
+	if err != nil {
+		return nil, errors.New(ErrorInvalidMessage)
+	}
+	m.IssuedAt = t
+	if m.ExpirationTime, err = optionalTime(expirationTime); err != nil {
+		return nil, err
+	}
+	if m.NotBefore, err = optionalTime(notBefore); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func optionalTime(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, errors.New(ErrorInvalidMessage)
+	}
+	return &t, nil
+}
+
+func alphanumeric(s string) bool {
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,198 @@
+package storage
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+var (
+	ErrorCouldNotMarshalItem     = "could not marshal item"
+	ErrorFailedToUnmarshalRecord = "failed to unmarshal record"
+	ErrorMissingKey              = "item has no pk or sk"
+	ErrorConditionFailed         = "condition of the write does not hold"
+)
+
+// Key addresses one item by its partition and sort key
+type Key struct {
+	PK string
+	SK string
+}
+
+// MemoryTable is an in-memory stand-in for the DynamoDB table, safe for
+// concurrent use. Items are kept marshalled as they would be stored, so
+// fields hidden from the table are dropped here too. The memory
+// repositories of each package share one, as they share the table.
+type MemoryTable struct {
+	mu    sync.RWMutex
+	items map[Key]map[string]*dynamodb.AttributeValue
+}
+
+func NewMemoryTable() *MemoryTable {
+	return &MemoryTable{items: map[Key]map[string]*dynamodb.AttributeValue{}}
+}
+
+// Get unmarshals the item at key into out, leaving out empty when there
+// is no such item, like an empty GetItem result
+func (t *MemoryTable) Get(key Key, out interface{}) error {
+	t.mu.RLock()
+	item := t.items[key]
+	t.mu.RUnlock()
+	if err := dynamodbattribute.UnmarshalMap(item, out); err != nil {
+		return errors.New(ErrorFailedToUnmarshalRecord)
+	}
+	return nil
+}
+
+// Query unmarshals the items of partition pk whose sort key begins with
+// prefix into out, a pointer to a slice, in sort key order
+func (t *MemoryTable) Query(pk string, prefix string, out interface{}) error {
+	_, err := t.QueryPage(pk, prefix, "", 0, out)
+	return err
+}
+
+// QueryPage is Query of at most limit items, or all of them when limit
+// is 0, whose sort key comes after after. Like LastEvaluatedKey, it
+// returns the key of the last item when more follow it.
+func (t *MemoryTable) QueryPage(pk string, prefix string, after string, limit int64, out interface{}) (*Key, error) {
+	t.mu.RLock()
+	var keys []Key
+	for key := range t.items {
+		if key.PK == pk && strings.HasPrefix(key.SK, prefix) && key.SK > after {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].SK < keys[j].SK })
+	var next *Key
+	if limit > 0 && int64(len(keys)) > limit {
+		keys = keys[:limit]
+		next = &keys[limit-1]
+	}
+	items := make([]map[string]*dynamodb.AttributeValue, 0, len(keys))
+	for _, key := range keys {
+		items = append(items, t.items[key])
+	}
+	t.mu.RUnlock()
+	if err := dynamodbattribute.UnmarshalListOfMaps(items, out); err != nil {
+		return nil, errors.New(ErrorFailedToUnmarshalRecord)
+	}
+	return next, nil
+}
+
+// Condition is checked against the stored form of an item before a
+// write, and gets nil when there is no item
+type Condition func(item map[string]*dynamodb.AttributeValue) bool
+
+// Exists and NotExists stand for attribute_exists and attribute_not_exists
+func Exists(item map[string]*dynamodb.AttributeValue) bool {
+	return item != nil
+}
+
+func NotExists(item map[string]*dynamodb.AttributeValue) bool {
+	return item == nil
+}
+
+// Version holds for an item still at previous, like etag.Condition
+func Version(previous int64) Condition {
+	return func(item map[string]*dynamodb.AttributeValue) bool {
+		if item == nil {
+			return false
+		}
+		if previous == 0 {
+			return item["version"] == nil
+		}
+		return item["version"] != nil && aws.StringValue(item["version"].N) == strconv.FormatInt(previous, 10)
+	}
+}
+
+// ConditionError is returned by Transact with the stored form of the item
+// whose condition did not hold, nil when there was none
+type ConditionError struct {
+	Item map[string]*dynamodb.AttributeValue
+}
+
+func (e *ConditionError) Error() string {
+	return ErrorConditionFailed
+}
+
+// Write is one put, update or delete of a Transact, made only if
+// Condition holds. An update sets, removes and adds to number attributes
+// of the item at Update, like UpdateItem.
+type Write struct {
+	Put       interface{}
+	Delete    *Key
+	Update    *Key
+	Set       map[string]*dynamodb.AttributeValue
+	Remove    []string
+	Add       map[string]int64
+	Condition Condition
+}
+
+// Transact makes every write at once, or none of them with a
+// ConditionError if a condition does not hold, as a transaction would
+func (t *MemoryTable) Transact(writes ...Write) error {
+	keys := make([]Key, len(writes))
+	marshalled := make([]map[string]*dynamodb.AttributeValue, len(writes))
+	for i, write := range writes {
+		if write.Delete != nil {
+			keys[i] = *write.Delete
+			continue
+		}
+		if write.Update != nil {
+			keys[i] = *write.Update
+			continue
+		}
+		av, err := dynamodbattribute.MarshalMap(write.Put)
+		if err != nil {
+			return errors.New(ErrorCouldNotMarshalItem)
+		}
+		if av["pk"] == nil || av["sk"] == nil {
+			return errors.New(ErrorMissingKey)
+		}
+		keys[i] = Key{aws.StringValue(av["pk"].S), aws.StringValue(av["sk"].S)}
+		marshalled[i] = av
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, write := range writes {
+		if write.Condition != nil && !write.Condition(t.items[keys[i]]) {
+			return &ConditionError{Item: t.items[keys[i]]}
+		}
+	}
+	for i, write := range writes {
+		switch {
+		case write.Delete != nil:
+			delete(t.items, keys[i])
+		case write.Update != nil:
+			item := map[string]*dynamodb.AttributeValue{}
+			for name, value := range t.items[keys[i]] {
+				item[name] = value
+			}
+			for name, value := range write.Set {
+				item[name] = value
+			}
+			for _, name := range write.Remove {
+				delete(item, name)
+			}
+			for name, n := range write.Add {
+				//A missing number counts as 0, as with ADD
+				var current int64
+				if item[name] != nil {
+					current, _ = strconv.ParseInt(aws.StringValue(item[name].N), 10, 64)
+				}
+				item[name] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(current+n, 10))}
+			}
+			t.items[keys[i]] = item
+		default:
+			t.items[keys[i]] = marshalled[i]
+		}
+	}
+	return nil
+}
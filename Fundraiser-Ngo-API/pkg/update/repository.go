@@ -0,0 +1,224 @@
+package update
+
+import (
+	"aws-lambda-api/pkg/audit"
+	"aws-lambda-api/pkg/etag"
+	"aws-lambda-api/pkg/page"
+	"aws-lambda-api/pkg/patch"
+	"aws-lambda-api/pkg/storage"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// UpdateRepository stores the updates posted on fundraisers, in the
+// partition given by FundraiserKey. Updates are passed with their stored
+// keys, and each write records its audit entry
+// together with the change. Creating an update that exists fails with
+// ErrorUserAlreadyExists, and changing one that does not with
+// ErrorUserDoesNotExists. Changes are only made to an update still at the
+// version of current, and fail with etag.ErrorPreconditionFailed
+// otherwise. Changes only write the attributes that differ from current,
+// so attributes the Update type does not know are kept. ListUpdates
+// returns the page asked for and the key the next page starts after, nil
+// on the last page.
+type UpdateRepository interface {
+	// GetUpdate returns an empty update when there is none
+	GetUpdate(fundraiserKey string, updateId string) (*Update, error)
+	ListUpdates(fundraiserKey string, p page.Request) (*[]Update, *storage.Key, error)
+	CreateUpdate(u *Update, entry *audit.Entry) error
+	ChangeUpdate(u *Update, current *Update, entry *audit.Entry) error
+	DeleteUpdate(fundraiserKey string, updateId string, previous int64, entry *audit.Entry) error
+}
+
+func UpdateKey(fundraiserKey string, updateId string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"pk": {
+			S: aws.String(fundraiserKey),
+		},
+		"sk": {
+			S: aws.String("Update" + updateId),
+		},
+	}
+}
+
+// DynamoUpdateRepository keeps updates in the table
+type DynamoUpdateRepository struct {
+	tableName  string
+	dynaClient dynamodbiface.DynamoDBAPI
+}
+
+func NewDynamoUpdateRepository(tableName string, dynaClient dynamodbiface.DynamoDBAPI) *DynamoUpdateRepository {
+	return &DynamoUpdateRepository{tableName: tableName, dynaClient: dynaClient}
+}
+
+func (r *DynamoUpdateRepository) GetUpdate(fundraiserKey string, updateId string) (*Update, error) {
+	//Macking Call for DynamoDB
+	input := &dynamodb.GetItemInput{
+		Key:       UpdateKey(fundraiserKey, updateId),
+		TableName: aws.String(r.tableName),
+	}
+
+	result, err := r.dynaClient.GetItem(input)
+	if err != nil {
+		return nil, errors.New(ErrorFailedToFetchRecord)
+
+	}
+
+	//Sending the Get Request
+	item := new(Update)
+	err = dynamodbattribute.UnmarshalMap(result.Item, item)
+	if err != nil {
+		return nil, errors.New(ErrorFailedToUnmarshalRecord)
+	}
+	return item, nil
+}
+
+func (r *DynamoUpdateRepository) ListUpdates(fundraiserKey string, p page.Request) (*[]Update, *storage.Key, error) {
+	//Macking Call for DynamoDB
+	input := &dynamodb.QueryInput{
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {
+				S: aws.String(fundraiserKey),
+			},
+			":sk": {
+				S: aws.String("Update"),
+			},
+		},
+		KeyConditionExpression: aws.String("pk = :pk AND begins_with(sk, :sk)"),
+		TableName:              aws.String(r.tableName),
+	}
+
+	//Sending the Query Request, page by page
+	items := []Update{}
+	next, err := page.Query(input, fundraiserKey, p, &items, r.dynaClient)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &items, next, nil
+}
+
+func (r *DynamoUpdateRepository) CreateUpdate(u *Update, entry *audit.Entry) error {
+	//Marshaling the data
+	av, err := dynamodbattribute.MarshalMap(u)
+	if err != nil {
+		return errors.New(ErrorCouldNotMarshalItem)
+	}
+	//Puting it to DynamoDB
+	input := &dynamodb.PutItemInput{
+		Item:                av,
+		TableName:           aws.String(r.tableName),
+		ConditionExpression: aws.String("attribute_not_exists(sk)"),
+	}
+	err = audit.PutItem(input, entry, r.dynaClient)
+	if audit.ConditionFailed(err) {
+		return conditionError(audit.ItemExisted(err), ErrorUserAlreadyExists)
+	}
+	if err != nil {
+		return errors.New(ErrorCouldNotDynamoPutItem)
+	}
+	return nil
+}
+
+func (r *DynamoUpdateRepository) ChangeUpdate(u *Update, current *Update, entry *audit.Entry) error {
+	changes, err := patch.Diff(current, u)
+	if err != nil {
+		return err
+	}
+	condition, values := etag.Condition(current.Version)
+	err = audit.UpdateItem(changes.Input(r.tableName, condition, values), entry, r.dynaClient)
+	if audit.ConditionFailed(err) {
+		return conditionError(audit.ItemExisted(err), etag.ErrorPreconditionFailed)
+	}
+	if err != nil {
+		return errors.New(ErrorCouldNotDynamoPutItem)
+	}
+	return nil
+}
+
+func (r *DynamoUpdateRepository) DeleteUpdate(fundraiserKey string, updateId string, previous int64, entry *audit.Entry) error {
+	condition, values := etag.Condition(previous)
+	input := &dynamodb.DeleteItemInput{
+		Key:                       UpdateKey(fundraiserKey, updateId),
+		TableName:                 aws.String(r.tableName),
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeValues: values,
+	}
+	err := audit.DeleteItem(input, entry, r.dynaClient)
+	if audit.ConditionFailed(err) {
+		return conditionError(audit.ItemExisted(err), etag.ErrorPreconditionFailed)
+	}
+	if err != nil {
+		return errors.New(ErrorCouldNotDeleteItem)
+	}
+	return nil
+}
+
+// MemoryUpdateRepository keeps updates in a storage.MemoryTable
+type MemoryUpdateRepository struct {
+	table *storage.MemoryTable
+}
+
+func NewMemoryUpdateRepository(table *storage.MemoryTable) *MemoryUpdateRepository {
+	return &MemoryUpdateRepository{table: table}
+}
+
+func (r *MemoryUpdateRepository) GetUpdate(fundraiserKey string, updateId string) (*Update, error) {
+	item := new(Update)
+	if err := r.table.Get(storage.Key{PK: fundraiserKey, SK: "Update" + updateId}, item); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func (r *MemoryUpdateRepository) ListUpdates(fundraiserKey string, p page.Request) (*[]Update, *storage.Key, error) {
+	after, err := p.Start(fundraiserKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	items := []Update{}
+	next, err := r.table.QueryPage(fundraiserKey, "Update", after, p.Limit, &items)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &items, next, nil
+}
+
+func (r *MemoryUpdateRepository) CreateUpdate(u *Update, entry *audit.Entry) error {
+	return r.write(storage.Write{Put: u, Condition: storage.NotExists}, entry, ErrorUserAlreadyExists)
+}
+
+func (r *MemoryUpdateRepository) ChangeUpdate(u *Update, current *Update, entry *audit.Entry) error {
+	changes, err := patch.Diff(current, u)
+	if err != nil {
+		return err
+	}
+	key := storage.Key{PK: u.FundraiserId, SK: u.UpdateId}
+	write := storage.Write{Update: &key, Set: changes.Set, Remove: changes.Remove, Condition: storage.Version(current.Version)}
+	return r.write(write, entry, etag.ErrorPreconditionFailed)
+}
+
+func (r *MemoryUpdateRepository) DeleteUpdate(fundraiserKey string, updateId string, previous int64, entry *audit.Entry) error {
+	key := storage.Key{PK: fundraiserKey, SK: "Update" + updateId}
+	return r.write(storage.Write{Delete: &key, Condition: storage.Version(previous)}, entry, etag.ErrorPreconditionFailed)
+}
+
+func (r *MemoryUpdateRepository) write(write storage.Write, entry *audit.Entry, conflict string) error {
+	err := r.table.Transact(write, storage.Write{Put: entry, Condition: storage.NotExists})
+	if cerr, ok := err.(*storage.ConditionError); ok {
+		return conditionError(cerr.Item != nil, conflict)
+	}
+	return err
+}
+
+// conditionError is conflict when a write was refused for the item that is
+// there, and ErrorUserDoesNotExists when there is none
+func conditionError(existed bool, conflict string) error {
+	if !existed {
+		return errors.New(ErrorUserDoesNotExists)
+	}
+	return errors.New(conflict)
+}
@@ -1,14 +1,17 @@
 package update
 
 import (
+	"aws-lambda-api/pkg/audit"
+	"aws-lambda-api/pkg/auth"
+	"aws-lambda-api/pkg/etag"
+	"aws-lambda-api/pkg/fundraiser"
+	"aws-lambda-api/pkg/ngo"
+	"aws-lambda-api/pkg/patch"
+	"aws-lambda-api/pkg/validate"
 	"encoding/json"
 	"errors"
 
 	"github.com/aws/aws-lambda-go/events"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/dynamodb"
-	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
-	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 )
 var (
 	ErrorFailedToUnmarshalRecord = "failed to unmarshal record"
@@ -22,79 +25,42 @@ var (
 )
 
 type Update struct {
-	FundraiserId      string `json:"pk"`
-	UpdateId          string `json:"sk"`
-	UpdateTitle       string `json:"updateTitle"`
-	UpdateDescription string `json:"updateDescription"`
-	UpdatePhoto       string `json:"updatePhoto"`
+	FundraiserId      string `json:"pk" validate:"required,id,max=64"`
+	UpdateId          string `json:"sk" validate:"required,id,max=64"`
+	UpdateTitle       string `json:"updateTitle" validate:"required,max=200"`
+	UpdateDescription string `json:"updateDescription" validate:"max=5000"`
+	UpdatePhoto       string `json:"updatePhoto" validate:"url,max=2048"`
+	//Exactly one of NgoId or IndividualEmailId identifies the fundraiser's
+	//owner, who is the only one allowed to post and change updates
+	NgoId             string `json:"ngoId,omitempty" validate:"id,max=64"`
+	IndividualEmailId string `json:"emailId,omitempty" validate:"email,max=254"`
+	//Version counts every change to the update and is sent as its ETag
+	Version int64 `json:"version"`
 }
 
-func FetchUpdate(fundraiserId string, updateId string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Update, error) {
-	//Modifying the key for DynamoDB
-	//FundraiserId as partition key and UpdateId as sort key
-	fundraiserId = "Fundraiser" + fundraiserId
-	updateId = "Update" + updateId
-
-	//Macking Call for DynamoDB
-	input := &dynamodb.GetItemInput{
-		Key: map[string]*dynamodb.AttributeValue{
-			"pk": {
-				S: aws.String(fundraiserId),
-			},
-			"sk": {
-				S: aws.String(updateId),
-			},
-		},
-		TableName: aws.String(tableName),
-	}
-
-	result, err := dynaClient.GetItem(input)
-	if err != nil {
-		return nil, errors.New(ErrorFailedToFetchRecord)
-
-	}
-
-	//Sending the Get Request
-	item := new(Update)
-	err = dynamodbattribute.UnmarshalMap(result.Item, item)
-	if err != nil {
-		return nil, errors.New(ErrorFailedToUnmarshalRecord)
+// FundraiserKey is the partition of the updates of the fundraiser owned by
+// exactly one of ngoId or emailId, which is the fundraiser's ledger. Ids
+// of fundraisers are only unique to their owner.
+func FundraiserKey(ngoId string, emailId string, fundraiserId string) (string, error) {
+	if fundraiserId == "" {
+		return "", errors.New(fundraiser.ErrorFundraiserNotSpecified)
 	}
-	return item, nil
+	return fundraiser.LedgerKey(ngoId, emailId, fundraiserId)
 }
-func FetchUpdates(fundraiserId string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*[]Update, error) {
-	//Modifying the key for DynamoDB Storage
-	fundraiserId = "Fundraiser" + fundraiserId
-
-	//Macking Call for DynamoDB
-	input := &dynamodb.QueryInput{
-		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-			":pk": {
-				S: aws.String(fundraiserId),
-			},
-			":sk": {
-				S: aws.String("Update"),
-			},
-		},
-		KeyConditionExpression: aws.String("pk = :pk AND begins_with(sk, :sk)"),
-		TableName:              aws.String(tableName),
-	}
-
-	result, err := dynaClient.Query(input)
-	if err != nil {
-		return nil, errors.New(ErrorFailedToFetchRecord)
-
-	}
 
-	//Sending the Get Request
-	var items *[]Update
-	err = dynamodbattribute.UnmarshalListOfMaps(result.Items, &items)
-	if err != nil {
-		return nil, errors.New(ErrorFailedToUnmarshalRecord)
+// checkOwner makes sure the caller of req may post updates for the
+// fundraiser u belongs to. Updates from before owners were recorded can
+// only be changed by an admin.
+func checkOwner(req events.APIGatewayProxyRequest, u *Update, fundraiserId string, fundraisers fundraiser.FundraiserRepository, ngos ngo.NgoRepository) error {
+	if u.NgoId == "" && u.IndividualEmailId == "" {
+		_, err := auth.RequireAdmin(req)
+		return err
 	}
-	return items, nil
+	_, err := fundraiser.CheckPermission(req, u.NgoId, u.IndividualEmailId, fundraiserId, ngo.PermissionPostUpdates, false, fundraisers, ngos)
+	return err
 }
-func CreateUpdate(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+
+func CreateUpdate(req events.APIGatewayProxyRequest, updates UpdateRepository, fundraisers fundraiser.FundraiserRepository, ngos ngo.NgoRepository) (
 	*Update,
 	error,
 ) {
@@ -103,29 +69,36 @@ func CreateUpdate(req events.APIGatewayProxyRequest, tableName string, dynaClien
 	if err := json.Unmarshal([]byte(req.Body), &u); err != nil {
 		return nil, errors.New(ErrorInvalidUserData)
 	}
+	if err := validate.Struct(u); err != nil {
+		return nil, err
+	}
+	if u.NgoId == "" && u.IndividualEmailId == "" {
+		return nil, errors.New(fundraiser.ErrorFundraiserNotSpecified)
+	}
+	if err := checkOwner(req, &u, u.FundraiserId, fundraisers, ngos); err != nil {
+		return nil, err
+	}
+	key, err := FundraiserKey(u.NgoId, u.IndividualEmailId, u.FundraiserId)
+	if err != nil {
+		return nil, err
+	}
 
 	//Modifying the key for DynamoDB Storage
-	u.FundraiserId= "Fundraiser" + u.FundraiserId
+	entityId := key + "#" + u.UpdateId
+	u.FundraiserId = key
 	u.UpdateId = "Update" + u.UpdateId
+	u.Version = 1
 
-	//Marshaling the data
-	av, err := dynamodbattribute.MarshalMap(u)
-	if err != nil {
-		return nil, errors.New(ErrorCouldNotMarshalItem)
-	}
 	//Puting it to DynamoDB
-	input := &dynamodb.PutItemInput{
-		Item:      av,
-		TableName: aws.String(tableName),
-	}
-	_, err = dynaClient.PutItem(input)
+	entry := audit.NewEntry("Update", entityId, "createUpdate", audit.ActorFromRequest(req), nil, u)
+	err = updates.CreateUpdate(&u, entry)
 	if err != nil {
-		return nil, errors.New(ErrorCouldNotDynamoPutItem)
+		return nil, err
 	}
 	return &u, nil
 }
 
-func UpdateUpdate(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (
+func UpdateUpdate(req events.APIGatewayProxyRequest, updates UpdateRepository, fundraisers fundraiser.FundraiserRepository, ngos ngo.NgoRepository) (
 	*Update,
 	error,
 ) {
@@ -134,55 +107,111 @@ func UpdateUpdate(req events.APIGatewayProxyRequest, tableName string, dynaClien
 	if err := json.Unmarshal([]byte(req.Body), &u); err != nil {
 		return nil, errors.New(ErrorInvalidUserData)
 	}
+	if err := validate.Struct(u); err != nil {
+		return nil, err
+	}
+	key, currentUpdate, err := changeableUpdate(req, u.NgoId, u.IndividualEmailId, u.FundraiserId, u.UpdateId, updates, fundraisers, ngos)
+	if err != nil {
+		return nil, err
+	}
+	return saveUpdate(req, u, key, currentUpdate, updates)
+}
 
-	// Check if Update exists
-	currentUpdate, _ := FetchUpdate(u.FundraiserId, u.UpdateId, tableName, dynaClient)
-	if currentUpdate != nil && len(currentUpdate.UpdateId) == 0 {
-		return nil, errors.New(ErrorUserDoesNotExists)
+// PatchUpdate changes only the fields of the update given by the ngoId or
+// emailId, fundraiserId and updateId query parameters that the JSON Merge
+// Patch body of req names
+func PatchUpdate(req events.APIGatewayProxyRequest, updates UpdateRepository, fundraisers fundraiser.FundraiserRepository, ngos ngo.NgoRepository) (
+	*Update,
+	error,
+) {
+	ngoId := req.QueryStringParameters["ngoId"]
+	emailId := req.QueryStringParameters["emailId"]
+	fundraiserId := req.QueryStringParameters["fundraiserId"]
+	updateId := req.QueryStringParameters["updateId"]
+	key, currentUpdate, err := changeableUpdate(req, ngoId, emailId, fundraiserId, updateId, updates, fundraisers, ngos)
+	if err != nil {
+		return nil, err
 	}
-	u.FundraiserId = "Fundraiser" + u.FundraiserId
-	u.UpdateId = "Update" + u.UpdateId
 
-	// Save Fundraiser
-	av, err := dynamodbattribute.MarshalMap(u)
+	//Patching the update as clients send it, whose key is not theirs to change
+	var u Update
+	target := *currentUpdate
+	target.FundraiserId = fundraiserId
+	target.UpdateId = updateId
+	if err := patch.Merge(target, req.Body, &u); err != nil {
+		return nil, err
+	}
+	u.FundraiserId = fundraiserId
+	u.UpdateId = updateId
+	if err := validate.Struct(u); err != nil {
+		return nil, err
+	}
+	return saveUpdate(req, u, key, currentUpdate, updates)
+}
+
+// changeableUpdate loads the update of the fundraiser owned by exactly
+// one of ngoId or emailId if the caller of req may change it, along with
+// the fundraiser's key
+func changeableUpdate(req events.APIGatewayProxyRequest, ngoId string, emailId string, fundraiserId string, updateId string, updates UpdateRepository, fundraisers fundraiser.FundraiserRepository, ngos ngo.NgoRepository) (string, *Update, error) {
+	key, err := FundraiserKey(ngoId, emailId, fundraiserId)
+	if err != nil {
+		return "", nil, err
+	}
+	// Check if Update exists and belongs to the caller
+	currentUpdate, err := updates.GetUpdate(key, updateId)
 	if err != nil {
-		return nil, errors.New(ErrorCouldNotMarshalItem)
+		return "", nil, err
 	}
-	input := &dynamodb.PutItemInput{
-		Item:      av,
-		TableName: aws.String(tableName),
+	if len(currentUpdate.UpdateId) == 0 {
+		return "", nil, errors.New(ErrorUserDoesNotExists)
 	}
-	_, err = dynaClient.PutItem(input)
+	if err := checkOwner(req, currentUpdate, fundraiserId, fundraisers, ngos); err != nil {
+		return "", nil, err
+	}
+	return key, currentUpdate, nil
+}
+
+// saveUpdate stores u over currentUpdate, which keeps its owner and its
+// fundraiser's key
+func saveUpdate(req events.APIGatewayProxyRequest, u Update, key string, currentUpdate *Update, updates UpdateRepository) (*Update, error) {
+	if err := etag.Check(req, currentUpdate.Version); err != nil {
+		return nil, err
+	}
+	u.NgoId = currentUpdate.NgoId
+	u.IndividualEmailId = currentUpdate.IndividualEmailId
+	entityId := key + "#" + u.UpdateId
+	u.FundraiserId = key
+	u.UpdateId = "Update" + u.UpdateId
+	u.Version = currentUpdate.Version + 1
+
+	// Save Fundraiser
+	entry := audit.NewEntry("Update", entityId, "updateUpdate", audit.ActorFromRequest(req), *currentUpdate, u)
+	err := updates.ChangeUpdate(&u, currentUpdate, entry)
 	if err != nil {
-		return nil, errors.New(ErrorCouldNotDynamoPutItem)
+		return nil, err
 	}
 	return &u, nil
 }
 
-func DeleteUpdate(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
-	//fundraiserId and updateId from req
+func DeleteUpdate(req events.APIGatewayProxyRequest, updates UpdateRepository, fundraisers fundraiser.FundraiserRepository, ngos ngo.NgoRepository) error {
+	//ngoId or emailId, fundraiserId and updateId from req
+	ngoId := req.QueryStringParameters["ngoId"]
+	emailId := req.QueryStringParameters["emailId"]
 	fundraiserId := req.QueryStringParameters["fundraiserId"]
 	updateId := req.QueryStringParameters["updateId"]
-
-	//Modifying the key for DynamoDB
-	fundraiserId = "Fundraiser" + fundraiserId
-	updateId = "Update" + updateId
+	key, currentUpdate, err := changeableUpdate(req, ngoId, emailId, fundraiserId, updateId, updates, fundraisers, ngos)
+	if err != nil {
+		return err
+	}
+	if err := etag.Check(req, currentUpdate.Version); err != nil {
+		return err
+	}
+	entry := audit.NewEntry("Update", key+"#"+updateId, "deleteUpdate", audit.ActorFromRequest(req), *currentUpdate, nil)
 
 	//Deleting the Fundraiser
-	input := &dynamodb.DeleteItemInput{
-		Key: map[string]*dynamodb.AttributeValue{
-			"pk": {
-				S: aws.String(fundraiserId),
-			},
-			"sk": {
-				S: aws.String(updateId),
-			},
-		},
-		TableName: aws.String(tableName),
-	}
-	_, err := dynaClient.DeleteItem(input)
+	err = updates.DeleteUpdate(key, updateId, currentUpdate.Version, entry)
 	if err != nil {
-		return errors.New(ErrorCouldNotDeleteItem)
+		return err
 	}
 
 	return nil
@@ -0,0 +1,212 @@
+package validate
+
+import (
+	"aws-lambda-api/pkg/money"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	ErrorValidationFailed = "validation failed"
+)
+
+// Codes of the rules, for clients to tell failures apart
+const (
+	CodeRequired        = "required"
+	CodeTooShort        = "too_short"
+	CodeTooLong         = "too_long"
+	CodeTooSmall        = "too_small"
+	CodeTooLarge        = "too_large"
+	CodeInvalidUrl      = "invalid_url"
+	CodeInvalidEmail    = "invalid_email"
+	CodeInvalidPhone    = "invalid_phone"
+	CodeInvalidId       = "invalid_id"
+	CodeNotPositive     = "not_positive"
+	CodeUnknownCurrency = "unknown_currency"
+)
+
+// FieldError is a rule a field of the request body broke. Field is the
+// field's JSON name.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Errors lists every rule a request broke
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	return ErrorValidationFailed
+}
+
+// Struct checks the fields of v against the rules in their validate tag,
+// such as `validate:"required,max=200,url"`. Rules other than required
+// pass on empty values, so optional fields are only checked when given.
+//
+//	required   not blank, or for money a currency is given
+//	min=N      at least N characters, or for numbers at least N
+//	max=N      at most N characters, or for numbers at most N
+//	url        an absolute http or https URL
+//	email      an email address
+//	phone      digits with an optional leading +, spaces, dashes and brackets
+//	id         letters, digits, dashes, underscores and dots
+//	positive   a number or amount of money above zero
+//	currency   money in a supported currency
+func Struct(v interface{}) error {
+	value := reflect.Indirect(reflect.ValueOf(v))
+	var errs Errors
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		tag := field.Tag.Get("validate")
+		if tag == "" {
+			continue
+		}
+		name := jsonName(field)
+		for _, rule := range strings.Split(tag, ",") {
+			if err := check(value.Field(i), name, rule); err != nil {
+				errs = append(errs, *err)
+				break
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func jsonName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
+
+func check(value reflect.Value, field string, rule string) *FieldError {
+	parts := strings.SplitN(rule, "=", 2)
+	switch parts[0] {
+	case "required":
+		if isEmpty(value) {
+			return &FieldError{field, CodeRequired, field + " is required"}
+		}
+		return nil
+	}
+	if isEmpty(value) {
+		return nil
+	}
+
+	switch parts[0] {
+	case "min", "max":
+		n, _ := strconv.ParseInt(parts[1], 10, 64)
+		return checkBound(value, field, parts[0], n)
+	case "url":
+		u, err := url.Parse(value.String())
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return &FieldError{field, CodeInvalidUrl, field + " must be an http or https URL"}
+		}
+	case "email":
+		address, err := mail.ParseAddress(value.String())
+		if err != nil || address.Address != value.String() {
+			return &FieldError{field, CodeInvalidEmail, field + " must be an email address"}
+		}
+	case "phone":
+		if !isPhone(value.String()) {
+			return &FieldError{field, CodeInvalidPhone, field + " must be a phone number"}
+		}
+	case "id":
+		if !isId(value.String()) {
+			return &FieldError{field, CodeInvalidId, field + " may only hold letters, digits, '-', '_' and '.'"}
+		}
+	case "positive":
+		if m, ok := value.Interface().(money.Money); ok && m.Amount <= 0 {
+			return &FieldError{field, CodeNotPositive, field + " must be above zero"}
+		}
+		if isInt(value) && value.Int() <= 0 {
+			return &FieldError{field, CodeNotPositive, field + " must be above zero"}
+		}
+	case "currency":
+		m, _ := value.Interface().(money.Money)
+		if _, ok := money.Decimals(strings.ToUpper(strings.TrimSpace(m.Currency))); !ok {
+			return &FieldError{field, CodeUnknownCurrency, field + " must be in a supported currency"}
+		}
+	}
+	return nil
+}
+
+func checkBound(value reflect.Value, field string, bound string, n int64) *FieldError {
+	limit := strconv.FormatInt(n, 10)
+	if value.Kind() == reflect.String {
+		length := int64(utf8.RuneCountInString(value.String()))
+		if bound == "min" && length < n {
+			return &FieldError{field, CodeTooShort, field + " must be at least " + limit + " characters"}
+		}
+		if bound == "max" && length > n {
+			return &FieldError{field, CodeTooLong, field + " must be at most " + limit + " characters"}
+		}
+	}
+	if isInt(value) {
+		if bound == "min" && value.Int() < n {
+			return &FieldError{field, CodeTooSmall, field + " must be at least " + limit}
+		}
+		if bound == "max" && value.Int() > n {
+			return &FieldError{field, CodeTooLarge, field + " must be at most " + limit}
+		}
+	}
+	return nil
+}
+
+func isEmpty(value reflect.Value) bool {
+	if m, ok := value.Interface().(money.Money); ok {
+		return strings.TrimSpace(m.Currency) == ""
+	}
+	switch value.Kind() {
+	case reflect.String:
+		return strings.TrimSpace(value.String()) == ""
+	case reflect.Slice, reflect.Map:
+		return value.Len() == 0
+	case reflect.Ptr:
+		return value.IsNil()
+	}
+	return false
+}
+
+func isInt(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return true
+	}
+	return false
+}
+
+func isId(s string) bool {
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '_', r == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func isPhone(s string) bool {
+	digits := 0
+	for i, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			digits++
+		case r == '+' && i == 0:
+		case r == ' ', r == '-', r == '(', r == ')':
+		default:
+			return false
+		}
+	}
+	return digits >= 6 && digits <= 15
+}
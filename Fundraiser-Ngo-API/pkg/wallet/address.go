@@ -0,0 +1,187 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// ValidEIP55 reports whether address is a 0x-prefixed EVM address in its
+// EIP-55 mixed-case checksum form.
+func ValidEIP55(address string) bool {
+	if len(address) != 42 || !strings.HasPrefix(address, "0x") {
+		return false
+	}
+	if _, err := hex.DecodeString(address[2:]); err != nil {
+		return false
+	}
+	return address == ChecksumAddress(address)
+}
+
+// ChecksumAddress returns the EIP-55 form of a hex EVM address.
+func ChecksumAddress(address string) string {
+	lower := strings.ToLower(strings.TrimPrefix(address, "0x"))
+	h := sha3.NewLegacyKeccak256()
+	h.Write([]byte(lower))
+	hash := hex.EncodeToString(h.Sum(nil))
+
+	out := []byte(lower)
+	for i, c := range out {
+		if c >= 'a' && c <= 'f' && hash[i] >= '8' {
+			out[i] = c - 'a' + 'A'
+		}
+	}
+	return "0x" + string(out)
+}
+
+// ValidBitcoinAddress accepts mainnet P2PKH/P2SH base58check addresses and
+// segwit bech32 (v0) or bech32m (v1+) addresses.
+func ValidBitcoinAddress(address string) bool {
+	if strings.HasPrefix(strings.ToLower(address), "bc1") {
+		return validSegwitAddress(address)
+	}
+	decoded, ok := base58Decode(address)
+	if !ok || len(decoded) != 25 {
+		return false
+	}
+	if decoded[0] != 0x00 && decoded[0] != 0x05 {
+		return false
+	}
+	first := sha256.Sum256(decoded[:21])
+	second := sha256.Sum256(first[:])
+	return bytes.Equal(second[:4], decoded[21:])
+}
+
+// ValidSolanaAddress accepts a base58 encoded 32 byte public key.
+func ValidSolanaAddress(address string) bool {
+	decoded, ok := base58Decode(address)
+	return ok && len(decoded) == 32
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+func base58Decode(s string) ([]byte, bool) {
+	if s == "" {
+		return nil, false
+	}
+	n := new(big.Int)
+	radix := big.NewInt(58)
+	for _, c := range s {
+		i := strings.IndexRune(base58Alphabet, c)
+		if i < 0 {
+			return nil, false
+		}
+		n.Mul(n, radix)
+		n.Add(n, big.NewInt(int64(i)))
+	}
+
+	//Leading '1's encode leading zero bytes
+	zeros := 0
+	for zeros < len(s) && s[zeros] == '1' {
+		zeros++
+	}
+	return append(make([]byte, zeros), n.Bytes()...), true
+}
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+const (
+	bech32Const  = 1
+	bech32mConst = 0x2bc830a3
+)
+
+func bech32Polymod(values []byte) uint32 {
+	gen := []uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= gen[i]
+			}
+		}
+	}
+	return chk
+}
+
+func bech32HrpExpand(hrp string) []byte {
+	out := make([]byte, 0, len(hrp)*2+1)
+	for _, c := range hrp {
+		out = append(out, byte(c>>5))
+	}
+	out = append(out, 0)
+	for _, c := range hrp {
+		out = append(out, byte(c&31))
+	}
+	return out
+}
+
+func validSegwitAddress(address string) bool {
+	if strings.ToLower(address) != address && strings.ToUpper(address) != address {
+		return false
+	}
+	address = strings.ToLower(address)
+	sep := strings.LastIndexByte(address, '1')
+	if sep < 1 || sep+7 > len(address) || len(address) > 90 {
+		return false
+	}
+	hrp := address[:sep]
+	if hrp != "bc" {
+		return false
+	}
+	data := make([]byte, 0, len(address)-sep-1)
+	for _, c := range address[sep+1:] {
+		i := strings.IndexRune(bech32Charset, c)
+		if i < 0 {
+			return false
+		}
+		data = append(data, byte(i))
+	}
+
+	//Witness v0 uses bech32, later versions use bech32m
+	version := data[0]
+	if version > 16 {
+		return false
+	}
+	want := uint32(bech32Const)
+	if version > 0 {
+		want = bech32mConst
+	}
+	if bech32Polymod(append(bech32HrpExpand(hrp), data...)) != want {
+		return false
+	}
+
+	program, ok := convertBits(data[1:len(data)-6], 5, 8)
+	if !ok || len(program) < 2 || len(program) > 40 {
+		return false
+	}
+	if version == 0 && len(program) != 20 && len(program) != 32 {
+		return false
+	}
+	return true
+}
+
+func convertBits(data []byte, from uint, to uint) ([]byte, bool) {
+	acc := 0
+	bits := uint(0)
+	maxv := (1 << to) - 1
+	var out []byte
+	for _, v := range data {
+		acc = acc<<from | int(v)
+		bits += from
+		for bits >= to {
+			bits -= to
+			out = append(out, byte((acc>>bits)&maxv))
+		}
+	}
+	//Leftover bits must be zero padding
+	if bits >= from || (acc<<(to-bits))&maxv != 0 {
+		return nil, false
+	}
+	return out, true
+}
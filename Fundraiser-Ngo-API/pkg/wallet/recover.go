@@ -0,0 +1,154 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"golang.org/x/crypto/sha3"
+)
+
+var (
+	ErrorInvalidSignature = "invalid signature"
+)
+
+// secp256k1 domain parameters
+var (
+	curveP, _  = new(big.Int).SetString("fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2f", 16)
+	curveN, _  = new(big.Int).SetString("fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141", 16)
+	curveGx, _ = new(big.Int).SetString("79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798", 16)
+	curveGy, _ = new(big.Int).SetString("483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8", 16)
+	curveB     = big.NewInt(7)
+)
+
+// point is an affine secp256k1 point; nil is the point at infinity
+type point struct {
+	x, y *big.Int
+}
+
+// PersonalSignHash is the EIP-191 hash that personal_sign signs for message.
+func PersonalSignHash(message []byte) []byte {
+	h := sha3.NewLegacyKeccak256()
+	fmt.Fprintf(h, "\x19Ethereum Signed Message:\n%d", len(message))
+	h.Write(message)
+	return h.Sum(nil)
+}
+
+// RecoverAddress returns the EIP-55 address of the key that produced the
+// 65 byte r || s || v signature over hash. v may be 0/1 or 27/28.
+func RecoverAddress(hash []byte, signature []byte) (string, error) {
+	if len(hash) != 32 || len(signature) != 65 {
+		return "", errors.New(ErrorInvalidSignature)
+	}
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:64])
+	v := signature[64]
+	if v >= 27 {
+		v -= 27
+	}
+	if v > 1 || r.Sign() == 0 || s.Sign() == 0 || r.Cmp(curveN) >= 0 || s.Cmp(curveN) >= 0 {
+		return "", errors.New(ErrorInvalidSignature)
+	}
+
+	//R is the curve point with x = r whose y has the parity given by v
+	R := liftX(r, v == 1)
+	if R == nil {
+		return "", errors.New(ErrorInvalidSignature)
+	}
+
+	//Q = r^-1 (sR - eG)
+	rInv := new(big.Int).ModInverse(r, curveN)
+	e := new(big.Int).SetBytes(hash)
+	u1 := new(big.Int).Mul(e, rInv)
+	u1.Neg(u1).Mod(u1, curveN)
+	u2 := new(big.Int).Mul(s, rInv)
+	u2.Mod(u2, curveN)
+	Q := add(scalarMult(&point{curveGx, curveGy}, u1), scalarMult(R, u2))
+	if Q == nil {
+		return "", errors.New(ErrorInvalidSignature)
+	}
+	return publicKeyAddress(Q), nil
+}
+
+// DecodeSignature parses a 0x-prefixed hex signature
+func DecodeSignature(signature string) ([]byte, error) {
+	if len(signature) > 2 && signature[:2] == "0x" {
+		signature = signature[2:]
+	}
+	b, err := hex.DecodeString(signature)
+	if err != nil || len(b) != 65 {
+		return nil, errors.New(ErrorInvalidSignature)
+	}
+	return b, nil
+}
+
+func publicKeyAddress(q *point) string {
+	pub := make([]byte, 64)
+	q.x.FillBytes(pub[:32])
+	q.y.FillBytes(pub[32:])
+	h := sha3.NewLegacyKeccak256()
+	h.Write(pub)
+	return ChecksumAddress(hex.EncodeToString(h.Sum(nil)[12:]))
+}
+
+func liftX(x *big.Int, odd bool) *point {
+	//y^2 = x^3 + 7; p = 3 mod 4 so the root is (y^2)^((p+1)/4)
+	y2 := new(big.Int).Exp(x, big.NewInt(3), curveP)
+	y2.Add(y2, curveB).Mod(y2, curveP)
+	exp := new(big.Int).Add(curveP, big.NewInt(1))
+	exp.Rsh(exp, 2)
+	y := new(big.Int).Exp(y2, exp, curveP)
+	if new(big.Int).Exp(y, big.NewInt(2), curveP).Cmp(y2) != 0 {
+		return nil
+	}
+	if (y.Bit(0) == 1) != odd {
+		y.Sub(curveP, y)
+	}
+	return &point{x, y}
+}
+
+func add(a, b *point) *point {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	var slope *big.Int
+	if a.x.Cmp(b.x) == 0 {
+		sum := new(big.Int).Add(a.y, b.y)
+		if sum.Mod(sum, curveP).Sign() == 0 {
+			return nil
+		}
+		//Tangent: 3x^2 / 2y
+		num := new(big.Int).Mul(a.x, a.x)
+		num.Mul(num, big.NewInt(3))
+		den := new(big.Int).Lsh(a.y, 1)
+		den.Mod(den, curveP)
+		slope = num.Mul(num, den.ModInverse(den, curveP))
+	} else {
+		num := new(big.Int).Sub(b.y, a.y)
+		den := new(big.Int).Sub(b.x, a.x)
+		den.Mod(den, curveP)
+		slope = num.Mul(num, den.ModInverse(den, curveP))
+	}
+	slope.Mod(slope, curveP)
+
+	x := new(big.Int).Mul(slope, slope)
+	x.Sub(x, a.x).Sub(x, b.x).Mod(x, curveP)
+	y := new(big.Int).Sub(a.x, x)
+	y.Mul(y, slope).Sub(y, a.y).Mod(y, curveP)
+	return &point{x, y}
+}
+
+func scalarMult(p *point, k *big.Int) *point {
+	var result *point
+	for i := k.BitLen() - 1; i >= 0; i-- {
+		result = add(result, result)
+		if k.Bit(i) == 1 {
+			result = add(result, p)
+		}
+	}
+	return result
+}
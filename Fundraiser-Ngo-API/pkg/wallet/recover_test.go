@@ -0,0 +1,114 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// Signatures made with private key 1 and with the first Hardhat dev key,
+// whose addresses are well known
+const (
+	keyOneAddress     = "0x7E5F4552091A69125d5DfCb7b8C2659029395Bdf"
+	hardhatKeyAddress = "0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266"
+)
+
+func TestPersonalSignHash(t *testing.T) {
+	got := hex.EncodeToString(PersonalSignHash([]byte("hello world")))
+	want := "d9eba16ed0ecae432b71fe008c98cc872bb4cc214d3220a36f365326cf807d68"
+	if got != want {
+		t.Fatalf("PersonalSignHash = %s, want %s", got, want)
+	}
+}
+
+func TestRecoverAddress(t *testing.T) {
+	tests := []struct {
+		name      string
+		message   string
+		signature string
+		want      string
+	}{
+		{
+			name:      "key one, v = 28",
+			message:   "hello world",
+			signature: "0x286af74d25caecc381cbe662f49b15d096bb1952ce95006099dcb18d36cd2d19320d1b481b2a16c069ae9bc8b07f6889299a48cd761e0af1d3dc558216ab81f91c",
+			want:      keyOneAddress,
+		},
+		{
+			name:      "key one, other message",
+			message:   "Example `personal_sign` message",
+			signature: "0xac66d304466309b7196ec89ffe5f26e595c9926365f8af5775c284184ea351f67115802686c68992d3bb308ab98c0bfee4190d6c07dc5aa569423f5bd795376d1c",
+			want:      keyOneAddress,
+		},
+		{
+			name:      "hardhat key, v = 27",
+			message:   "hello world",
+			signature: "0x7f6f86062a380e304192ddf56bd3b84e5a488cd50ba8046114e10a755901768073ded177d9fb30305b673ee3c1afae386fbc25c4343d95b6977ce039ce5020621b",
+			want:      hardhatKeyAddress,
+		},
+		{
+			name:      "hardhat key, v = 0",
+			message:   "hello world",
+			signature: "0x7f6f86062a380e304192ddf56bd3b84e5a488cd50ba8046114e10a755901768073ded177d9fb30305b673ee3c1afae386fbc25c4343d95b6977ce039ce50206200",
+			want:      hardhatKeyAddress,
+		},
+		{
+			name:      "hardhat key, other message",
+			message:   "Example `personal_sign` message",
+			signature: "0xcd53fffa67d875a720028091acc151b1a7d93f8ed29ca3722bb5017c420711b272d422489e879106923555f4317bdce2bff894edd9259d1d474eb408c8105d151c",
+			want:      hardhatKeyAddress,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signature, err := DecodeSignature(tt.signature)
+			if err != nil {
+				t.Fatalf("DecodeSignature: %v", err)
+			}
+			got, err := RecoverAddress(PersonalSignHash([]byte(tt.message)), signature)
+			if err != nil {
+				t.Fatalf("RecoverAddress: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("RecoverAddress = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecoverAddressOtherMessage(t *testing.T) {
+	//A signature over another message recovers some other key
+	signature, _ := DecodeSignature("0x286af74d25caecc381cbe662f49b15d096bb1952ce95006099dcb18d36cd2d19320d1b481b2a16c069ae9bc8b07f6889299a48cd761e0af1d3dc558216ab81f91c")
+	got, err := RecoverAddress(PersonalSignHash([]byte("hello world!")), signature)
+	if err == nil && got == keyOneAddress {
+		t.Fatalf("RecoverAddress recovered the signer for a different message")
+	}
+}
+
+func TestRecoverAddressInvalid(t *testing.T) {
+	hash := PersonalSignHash([]byte("hello world"))
+	valid, _ := DecodeSignature("0x286af74d25caecc381cbe662f49b15d096bb1952ce95006099dcb18d36cd2d19320d1b481b2a16c069ae9bc8b07f6889299a48cd761e0af1d3dc558216ab81f91c")
+
+	badV := append([]byte{}, valid...)
+	badV[64] = 29
+	zeroR := append([]byte{}, valid...)
+	for i := 0; i < 32; i++ {
+		zeroR[i] = 0
+	}
+	tests := []struct {
+		name      string
+		hash      []byte
+		signature []byte
+	}{
+		{"short signature", hash, valid[:64]},
+		{"short hash", hash[:31], valid},
+		{"v out of range", hash, badV},
+		{"zero r", hash, zeroR},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := RecoverAddress(tt.hash, tt.signature); err == nil || err.Error() != ErrorInvalidSignature {
+				t.Fatalf("RecoverAddress error = %v, want %s", err, ErrorInvalidSignature)
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package wallet
+
+import (
+	"aws-lambda-api/pkg/audit"
+	"aws-lambda-api/pkg/chain"
+	"aws-lambda-api/pkg/storage"
+	"errors"
+	"reflect"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+var (
+	ErrorUnsupportedChain     = "unsupported wallet chain"
+	ErrorInvalidAddress       = "invalid wallet address for chain"
+	ErrorInvalidAsset         = "invalid wallet asset"
+	ErrorInvalidLabel         = "wallet label is too long"
+	ErrorWalletAlreadyExists  = "wallet already registered"
+	ErrorWalletDoesNotExist   = "wallet is not registered"
+	ErrorCouldNotMarshalItem  = "could not marshal item"
+	ErrorCouldNotSaveWallets  = "could not save wallets"
+	ErrorWalletsChangedOrGone = "wallets were changed concurrently or owner does not exist"
+)
+
+// Wallet is a receiving address registered by an NGO or fundraiser.
+type Wallet struct {
+	Chain   string `json:"chain"`
+	Asset   string `json:"asset"`
+	Address string `json:"address"`
+	Label   string `json:"label"`
+}
+
+// Validate normalizes w and checks its address against the chain's format.
+func (w *Wallet) Validate() error {
+	w.Chain = strings.ToLower(strings.TrimSpace(w.Chain))
+	w.Asset = strings.ToUpper(strings.TrimSpace(w.Asset))
+	w.Address = strings.TrimSpace(w.Address)
+	w.Label = strings.TrimSpace(w.Label)
+
+	native := nativeAsset(w.Chain)
+	if native == "" {
+		return errors.New(ErrorUnsupportedChain)
+	}
+	if w.Asset == "" {
+		w.Asset = native
+	}
+	if len(w.Asset) > 10 {
+		return errors.New(ErrorInvalidAsset)
+	}
+	for _, c := range w.Asset {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			return errors.New(ErrorInvalidAsset)
+		}
+	}
+	if len(w.Label) > 64 {
+		return errors.New(ErrorInvalidLabel)
+	}
+
+	var ok bool
+	switch w.Chain {
+	case "bitcoin":
+		ok = ValidBitcoinAddress(w.Address)
+		//bech32 addresses are case-insensitive, store them lower-case
+		if ok && strings.HasPrefix(strings.ToLower(w.Address), "bc1") {
+			w.Address = strings.ToLower(w.Address)
+		}
+	case "solana":
+		ok = ValidSolanaAddress(w.Address)
+	default:
+		ok = ValidEIP55(w.Address)
+	}
+	if !ok {
+		return errors.New(ErrorInvalidAddress)
+	}
+	return nil
+}
+
+func nativeAsset(chainId string) string {
+	switch chainId {
+	case "bitcoin":
+		return "BTC"
+	case "solana":
+		return "SOL"
+	}
+	return chain.NativeAssets[chainId]
+}
+
+func sameWallet(a Wallet, b Wallet) bool {
+	return a.Chain == b.Chain && a.Asset == b.Asset && strings.EqualFold(a.Address, b.Address)
+}
+
+// Add returns wallets with w appended, rejecting duplicates.
+func Add(wallets []Wallet, w Wallet) ([]Wallet, error) {
+	for _, existing := range wallets {
+		if sameWallet(existing, w) {
+			return nil, errors.New(ErrorWalletAlreadyExists)
+		}
+	}
+	out := append([]Wallet{}, wallets...)
+	return append(out, w), nil
+}
+
+// Remove returns wallets without w.
+func Remove(wallets []Wallet, w Wallet) ([]Wallet, error) {
+	out := []Wallet{}
+	for _, existing := range wallets {
+		if !sameWallet(existing, w) {
+			out = append(out, existing)
+		}
+	}
+	if len(out) == len(wallets) {
+		return nil, errors.New(ErrorWalletDoesNotExist)
+	}
+	return out, nil
+}
+
+// Contains reports whether w is one of wallets.
+func Contains(wallets []Wallet, w Wallet) bool {
+	for _, existing := range wallets {
+		if sameWallet(existing, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// ForChain returns the address receiving chainId's native asset.
+func ForChain(wallets []Wallet, chainId string) string {
+	for _, w := range wallets {
+		if w.Chain == chainId && w.Asset == nativeAsset(chainId) {
+			return w.Address
+		}
+	}
+	return ""
+}
+
+// Save replaces the wallets list stored in attribute of the item at key,
+// provided it still holds previous, and records the change in the audit
+// log within the same transaction. The version of the item is counted up
+// like any other change.
+func Save(key map[string]*dynamodb.AttributeValue, attribute string, previous []Wallet, wallets []Wallet, entry *audit.Entry, tableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	newList, err := dynamodbattribute.Marshal(wallets)
+	if err != nil {
+		return errors.New(ErrorCouldNotMarshalItem)
+	}
+
+	//Guarding against a concurrent change to the same list
+	condition := "attribute_exists(sk) AND (attribute_not_exists(#wallets) OR size(#wallets) = :zero)"
+	values := map[string]*dynamodb.AttributeValue{
+		":wallets": newList,
+		":zero":    {N: aws.String("0")},
+		":one":     {N: aws.String("1")},
+	}
+	if len(previous) > 0 {
+		oldList, err := dynamodbattribute.Marshal(previous)
+		if err != nil {
+			return errors.New(ErrorCouldNotMarshalItem)
+		}
+		condition = "attribute_exists(sk) AND #wallets = :previous"
+		values[":previous"] = oldList
+		delete(values, ":zero")
+	}
+
+	auditItem, err := audit.TransactItem(entry, tableName)
+	if err != nil {
+		return err
+	}
+	input := &dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{
+				Update: &dynamodb.Update{
+					Key:                       key,
+					TableName:                 aws.String(tableName),
+					ConditionExpression:       aws.String(condition),
+					UpdateExpression:          aws.String("SET #wallets = :wallets ADD version :one"),
+					ExpressionAttributeNames:  map[string]*string{"#wallets": aws.String(attribute)},
+					ExpressionAttributeValues: values,
+				},
+			},
+			auditItem,
+		},
+	}
+	_, err = dynaClient.TransactWriteItems(input)
+	if err != nil {
+		if _, ok := err.(*dynamodb.TransactionCanceledException); ok {
+			return errors.New(ErrorWalletsChangedOrGone)
+		}
+		return errors.New(ErrorCouldNotSaveWallets)
+	}
+	return nil
+}
+
+// MemoryWrite is the write of Save to the item at key of a
+// storage.MemoryTable, with the same condition
+func MemoryWrite(key storage.Key, attribute string, previous []Wallet, wallets []Wallet) (storage.Write, error) {
+	newList, err := dynamodbattribute.Marshal(wallets)
+	if err != nil {
+		return storage.Write{}, errors.New(ErrorCouldNotMarshalItem)
+	}
+	unchanged := func(item map[string]*dynamodb.AttributeValue) bool {
+		if item == nil {
+			return false
+		}
+		var stored []Wallet
+		if item[attribute] != nil {
+			if err := dynamodbattribute.Unmarshal(item[attribute], &stored); err != nil {
+				return false
+			}
+		}
+		if len(previous) == 0 {
+			return len(stored) == 0
+		}
+		return reflect.DeepEqual(stored, previous)
+	}
+	return storage.Write{
+		Update:    &key,
+		Set:       map[string]*dynamodb.AttributeValue{attribute: newList},
+		Add:       map[string]int64{"version": 1},
+		Condition: unchanged,
+	}, nil
+}